@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qri-io/jsonschema"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// how the handler that adds events acknowledges them
+const (
+	// the response is sent once the event is in the database and has the id of the event (201 Created)
+	AckModeSync = "sync"
+	// the response is sent once the event is valid and it is inserted in the background (202 Accepted)
+	AckModeAsync = "async"
+)
+
+// the valid acknowledgment modes
+var AckModes = []string{AckModeSync, AckModeAsync}
+
+// AddedEvent is the response body sent when an event is added in AckModeSync
+type AddedEvent struct {
+	Id interface{} `json:"id"`
+}
+
+// an added event is sent with a 201
+func (self AddedEvent) StatusCode() int {
+	return http.StatusCreated
+}
+
+// get the acknowledgment mode of a request
+// the client can choose one with a Prefer: respond-sync or Prefer: respond-async header (RFC 7240)
+// otherwise the configured default is used
+// requested is true if the mode came from the header so the response can say the preference was applied
+func ackMode(request *http.Request, config InsertConfig) (mode string, requested bool) {
+	for _, header := range request.Header.Values("Prefer") {
+		for _, preference := range strings.Split(header, ",") {
+			// preferences can have parameters (i.e. respond-async; wait=10) which are ignored
+			var name = strings.ToLower(strings.TrimSpace(strings.SplitN(preference, ";", 2)[0]))
+			switch name {
+			case "respond-sync":
+				return AckModeSync, true
+			case "respond-async":
+				return AckModeAsync, true
+			}
+		}
+	}
+
+	return config.DefaultAckMode, false
+}
+
+// number of workers that insert the events added in AckModeAsync when none is configured
+const DefaultAsyncWorkers = 4
+
+// number of events added in AckModeAsync that can wait to be inserted when no queue size is configured
+const DefaultAsyncQueueSize = 1000
+
+// AsyncInserter inserts the events added in AckModeAsync in the background
+// a fixed number of workers insert the events from a bounded queue so a flood of async requests
+// can not start an unbounded number of inserts
+type AsyncInserter struct {
+	db      *mongo.Collection
+	config  InsertConfig
+	queue   chan map[string]interface{}
+	workers sync.WaitGroup
+
+	// held while adding to the queue so it is not closed at the same time
+	lock   sync.RWMutex
+	closed bool
+}
+
+// create an async inserter and start its workers
+// workers and queueSize less than 1 are replaced with the defaults
+func NewAsyncInserter(db *mongo.Collection, config InsertConfig, workers int, queueSize int) *AsyncInserter {
+	if workers < 1 {
+		workers = DefaultAsyncWorkers
+	}
+	if queueSize < 1 {
+		queueSize = DefaultAsyncQueueSize
+	}
+
+	var inserter = &AsyncInserter{
+		db:     db,
+		config: config,
+		queue:  make(chan map[string]interface{}, queueSize),
+	}
+
+	inserter.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer inserter.workers.Done()
+
+			for event := range inserter.queue {
+				insertEventInBackground(inserter.db, event, inserter.config)
+			}
+		}()
+	}
+
+	return inserter
+}
+
+// add an event to the queue
+// false is returned if the queue is full or the inserter has been closed
+func (self *AsyncInserter) enqueue(event map[string]interface{}) bool {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	if self.closed {
+		return false
+	}
+
+	select {
+	case self.queue <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops the inserter from taking more events and waits for the queued events to be inserted
+// the context error is returned if it is done first
+// events that were not inserted by then are still in the write ahead log if one is configured
+func (self *AsyncInserter) Close(ctx context.Context) error {
+	self.lock.Lock()
+	if !self.closed {
+		self.closed = true
+		close(self.queue)
+	}
+	self.lock.Unlock()
+
+	var done = make(chan struct{})
+	go func() {
+		self.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// validate an event and insert it into the database in the background
+// the event is written to the write ahead log first if one is configured so it is kept if the insert fails
+// otherwise an insert that fails is only logged
+// if there is no async inserter or its queue is full the event is inserted before the response is sent
+// which slows the client down rather than letting the queue grow
+func addEventAsync(request *http.Request, db *mongo.Collection, schema *jsonschema.Schema, d []byte, config InsertConfig) error {
+	var event, err = prepareEvent(request.Context(), schema, d, newRequestMetadata(request, time.Now()), config)
+
+	if err == nil && config.WriteAheadLog != nil {
+		err = config.WriteAheadLog.Append(event)
+	}
+
+	if err == nil && (config.AsyncInserter == nil || !config.AsyncInserter.enqueue(event)) {
+		insertEventInBackground(db, event, config)
+	}
+
+	return err
+}
+
+// insert an event added in AckModeAsync and log the error if the insert fails
+// the request context is not used since it is cancelled as soon as the response is sent
+func insertEventInBackground(db *mongo.Collection, event map[string]interface{}, config InsertConfig) {
+	var _, _, err = insertEvent(context.Background(), db, event, config)
+	if err != nil && config.Logger != nil {
+		config.Logger.Printf("An error occured while adding an event in the background: %s\n", err)
+	}
+}
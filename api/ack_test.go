@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestAckMode(t *testing.T) {
+	var tests = []struct {
+		prefer    string
+		config    InsertConfig
+		mode      string
+		requested bool
+	}{
+		{"", InsertConfig{}, "", false},
+		{"", InsertConfig{DefaultAckMode: AckModeAsync}, AckModeAsync, false},
+		{"respond-sync", InsertConfig{DefaultAckMode: AckModeAsync}, AckModeSync, true},
+		{"return=minimal, Respond-Async; wait=10", InsertConfig{}, AckModeAsync, true},
+		{"handling=lenient", InsertConfig{DefaultAckMode: AckModeSync}, AckModeSync, false},
+	}
+
+	for _, test := range tests {
+		var request = httptest.NewRequest(http.MethodPost, "/events", nil)
+		if len(test.prefer) > 0 {
+			request.Header.Set("Prefer", test.prefer)
+		}
+
+		var mode, requested = ackMode(request, test.config)
+		if mode != test.mode || requested != test.requested {
+			t.Errorf("Unexpected ack mode for the Prefer header %q Expected: %q %t, Got: %q %t",
+				test.prefer, test.mode, test.requested, mode, requested)
+		}
+	}
+}
+
+func TestEventsAddHandlerSyncAck(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("sync", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		var request = httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(validEventJson))
+		request.Header.Set("Prefer", "respond-sync")
+
+		var writer = httptest.NewRecorder()
+		EventsAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusCreated {
+			t.Fatalf("An unexpected status code was returned when adding an event Expected: %d, Got: %d", http.StatusCreated, writer.Code)
+		}
+
+		if writer.Header().Get("Preference-Applied") != "respond-sync" {
+			t.Errorf("Expected the respond-sync preference to be applied but got %q", writer.Header().Get("Preference-Applied"))
+		}
+
+		var added struct {
+			Id string `json:"id"`
+		}
+		var err = json.Unmarshal(writer.Body.Bytes(), &added)
+		if err != nil {
+			t.Fatalf("The response body could not be decoded: %s", err)
+		}
+
+		var insertedId = mt.GetStartedEvent().Command.Lookup("documents", "0", "_id").ObjectID()
+		if _, err = primitive.ObjectIDFromHex(added.Id); err != nil || added.Id != insertedId.Hex() {
+			t.Errorf("The id of the inserted event was not returned Expected: %s, Got: %s", insertedId.Hex(), writer.Body)
+		}
+	})
+}
+
+func TestEventsAddHandlerAsyncAck(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("async", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		// the sink receives the event once it has been inserted in the background
+		var sink = newFakeSink(nil)
+		var handler = EventsAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{
+			DefaultAckMode: AckModeAsync,
+			SinkWriter:     NewSinkWriter([]EventSink{sink}, nil, 0, 0, 0),
+		})
+
+		var writer = httptest.NewRecorder()
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(validEventJson)))
+
+		if writer.Code != http.StatusAccepted {
+			t.Fatalf("An unexpected status code was returned when adding an event Expected: %d, Got: %d", http.StatusAccepted, writer.Code)
+		}
+
+		if writer.Body.Len() != 0 {
+			t.Errorf("An asynchronously added event should not have a response body Got: %s", writer.Body)
+		}
+
+		var event = receiveEvent(t, sink)
+		if event["summary"] != "A customer was added" || event["_id"] == nil {
+			t.Errorf("The event was not inserted in the background Got: %v", event)
+		}
+	})
+}
+
+func TestEventsAddHandlerAsyncAckInvalidEvent(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("async invalid", func(mt *mtest.T) {
+		var request = httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(`{"summary":1}`))
+		request.Header.Set("Prefer", "respond-async")
+
+		var writer = httptest.NewRecorder()
+		EventsAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{}).ServeHTTP(writer, request)
+
+		// events are still validated before they are accepted
+		if writer.Code != http.StatusBadRequest {
+			t.Errorf("An invalid event was accepted Expected: %d, Got: %d", http.StatusBadRequest, writer.Code)
+		}
+	})
+}
+
+func TestAsyncInserterCloseInsertsQueuedEvents(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("close", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+		var config = InsertConfig{DefaultAckMode: AckModeAsync}
+		config.AsyncInserter = NewAsyncInserter(mt.Coll, config, 1, 2)
+		var handler = EventsAddHandler(mt.Coll, loadTestSchema(t), config)
+
+		for i := 0; i < 2; i++ {
+			var writer = httptest.NewRecorder()
+			handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(validEventJson)))
+
+			if writer.Code != http.StatusAccepted {
+				t.Fatalf("An unexpected status code was returned when adding an event Expected: %d, Got: %d", http.StatusAccepted, writer.Code)
+			}
+		}
+
+		var err = config.AsyncInserter.Close(context.Background())
+		if err != nil {
+			t.Fatalf("The async inserter could not be closed: %s", err)
+		}
+
+		if len(mt.GetAllStartedEvents()) != 2 {
+			t.Errorf("The queued events were not inserted before the async inserter was closed Got: %d inserts", len(mt.GetAllStartedEvents()))
+		}
+	})
+}
+
+func TestAsyncInserterFullQueueInsertsBeforeResponding(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("full", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		// a closed inserter does not take any more events the same way a full one does not
+		var config = InsertConfig{DefaultAckMode: AckModeAsync}
+		config.AsyncInserter = NewAsyncInserter(mt.Coll, config, 1, 1)
+		config.AsyncInserter.Close(context.Background())
+
+		var writer = httptest.NewRecorder()
+		EventsAddHandler(mt.Coll, loadTestSchema(t), config).ServeHTTP(writer,
+			httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(validEventJson)))
+
+		if writer.Code != http.StatusAccepted {
+			t.Fatalf("An unexpected status code was returned when adding an event Expected: %d, Got: %d", http.StatusAccepted, writer.Code)
+		}
+
+		if len(mt.GetAllStartedEvents()) != 1 {
+			t.Errorf("The event was not inserted before the response was sent Got: %d inserts", len(mt.GetAllStartedEvents()))
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AggregateResult is the number of events that share a value for the group_by field
+type AggregateResult struct {
+	Value interface{} `json:"value" bson:"_id"`
+	Count int64       `json:"count" bson:"count"`
+}
+
+// EventsAggregateHandler creates an http handler that counts the events matching the filter
+// grouped by the value of the field in the group_by query param
+// i.e. /events/aggregate?group_by=source.service_name returns [{"value":"billing-service","count":10},...]
+// groups are returned largest first and are streamed as they are read from the aggregation cursor
+// so memory use does not grow with the number of groups
+func EventsAggregateHandler(db *mongo.Collection, config QueryConfig) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var stream = mux.NewJsonArrayStream(writer)
+
+		var pipeline, err = createAggregatePipeline(request.Context(), request.URL.Query(), config)
+		if err != nil {
+			stream.Abort(err)
+			return
+		}
+
+		// create a timed context to use when making requests to the db
+		// the context is derived from the request context so if the client goes away
+		// the aggregation and any cursor reads are aborted as well
+		var timedContext, timedContextCancel = context.WithTimeout(request.Context(), config.aggregateMaxTime())
+		defer timedContextCancel()
+
+		// the server stops the aggregation itself after the max time so it does not keep running
+		// after the request has given up on it
+		var aggregateOptions = options.Aggregate().
+			SetAllowDiskUse(!config.DisallowAggregateDiskUse).
+			SetMaxTime(config.aggregateMaxTime())
+
+		// the groups are streamed from the cursor so it stays open until the response is written
+		err = config.CursorLimiter.acquire()
+		if err != nil {
+			stream.Abort(dbUnavailableError(writer, err, config))
+			return
+		}
+		defer config.CursorLimiter.release()
+
+		var cursor *mongo.Cursor
+		cursor, err = db.Aggregate(timedContext, pipeline, aggregateOptions)
+		if err != nil {
+			stream.Abort(dbUnavailableError(writer, err, config))
+			return
+		}
+
+		err = streamAggregateResults(timedContext, cursor, stream)
+		if err != nil {
+			if config.Logger != nil {
+				config.Logger.Printf("An error occured while streaming aggregation results: %s\n", err)
+			}
+
+			stream.Abort(dbUnavailableError(writer, err, config))
+			return
+		}
+
+		stream.Close()
+	})
+}
+
+// create the aggregation pipeline from the query params
+// every query param other than group_by is used to filter the events the same way GET /events does
+func createAggregatePipeline(ctx context.Context, queryParams url.Values, config QueryConfig) (mongo.Pipeline, error) {
+	var groupBy = queryParams.Get("group_by")
+	if len(groupBy) == 0 {
+		return nil, mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: "The group_by query parameter must name the field to group events by",
+		}
+	}
+
+	var err = checkGroupByField(groupBy, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// group_by is only used by this endpoint so it is not a reserved query param
+	var filterParams = make(url.Values, len(queryParams))
+	for key, values := range queryParams {
+		if key != "group_by" {
+			filterParams[key] = values
+		}
+	}
+
+	var filter map[string]interface{}
+	filter, err = createEventFilter(ctx, filterParams, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var pipeline = mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$" + groupBy},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+		// the group value breaks ties so the order is stable
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+
+	return pipeline, nil
+}
+
+// make sure the group_by query param is a plain field path that is allowed to be grouped by
+// so it can not be used to inject an expression (i.e. $$ROOT) into the group stage
+func checkGroupByField(groupBy string, config QueryConfig) error {
+	for _, part := range strings.Split(groupBy, ".") {
+		if len(part) == 0 || strings.Contains(part, "$") {
+			return mux.HttpError{
+				Code:        http.StatusBadRequest,
+				Description: "The group_by query parameter must be a field name",
+			}
+		}
+	}
+
+	if config.AggregateFields != nil && !containsField(config.AggregateFields, groupBy) {
+		return mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: fmt.Sprintf("Events can only be grouped by %s", strings.Join(config.AggregateFields, ", ")),
+		}
+	}
+
+	return nil
+}
+
+// write each result from the aggregation cursor to the stream and close the cursor
+// results are never buffered so only one is held in memory at a time
+func streamAggregateResults(ctx context.Context, cursor *mongo.Cursor, stream *mux.JsonStream) error {
+	defer cursor.Close(ctx)
+
+	var err error
+	for err == nil && cursor.Next(ctx) {
+		// the driver only checks the context when it needs to fetch another batch
+		// so we check it ourselves to stop reading as soon as the request is cancelled
+		err = ctx.Err()
+
+		var result AggregateResult
+		if err == nil {
+			err = cursor.Decode(&result)
+		}
+
+		if err == nil {
+			err = stream.Write(result)
+		}
+	}
+
+	// errors returned by the cursor itself (i.e. network errors) always fail the aggregation
+	if err == nil {
+		err = cursor.Err()
+	}
+
+	return err
+}
@@ -0,0 +1,292 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var aggregateInvalidStatusError = "An unexpected status code was returned when attempting to aggregate events " +
+	"Expected: %d, Got: %d"
+
+func TestEventsAggregateHandlerGroupBy(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("group by", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt,
+			bson.D{{Key: "_id", Value: "billing-service"}, {Key: "count", Value: 10}},
+			bson.D{{Key: "_id", Value: "shipping-service"}, {Key: "count", Value: 3}},
+		))
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/events/aggregate?group_by=source.service_name&summary=one", nil)
+		EventsAggregateHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(aggregateInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		var expectedBody = `[{"value":"billing-service","count":10},{"value":"shipping-service","count":3}]`
+		if writer.Body.String() != expectedBody {
+			t.Errorf("Unexpected aggregation results were returned Expected: %s, Got: %s", expectedBody, writer.Body.String())
+		}
+
+		var aggregateEvent = mt.GetStartedEvent()
+		var group, err = aggregateEvent.Command.LookupErr("pipeline", "1", "$group", "_id")
+		if err != nil || group.StringValue() != "$source.service_name" {
+			t.Errorf("The events were not grouped by the group_by field Got: %s", aggregateEvent.Command)
+		}
+
+		var match, _ = aggregateEvent.Command.LookupErr("pipeline", "0", "$match", "summary")
+		if match.StringValue() != "one" {
+			t.Errorf("The events were not filtered using the query params Got: %s", aggregateEvent.Command)
+		}
+	})
+}
+
+func TestEventsAggregateHandlerMissingGroupBy(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("missing group by", func(mt *mtest.T) {
+		var writer = httptest.NewRecorder()
+		EventsAggregateHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/events/aggregate", nil))
+
+		if writer.Code != http.StatusBadRequest {
+			t.Errorf(aggregateInvalidStatusError, http.StatusBadRequest, writer.Code)
+		}
+	})
+}
+
+func TestEventsAggregateHandlerCursorErrorMidStream(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("cursor error", func(mt *mtest.T) {
+		var namespace = mt.Coll.Database().Name() + "." + mt.Coll.Name()
+
+		mt.AddMockResponses(
+			// the first batch leaves the cursor open
+			mtest.CreateCursorResponse(1, namespace, mtest.FirstBatch, bson.D{{Key: "_id", Value: "billing-service"}, {Key: "count", Value: 10}}),
+			// fetching the next batch fails
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 43, Message: "cursor not found"}),
+		)
+
+		var server = httptest.NewServer(EventsAggregateHandler(mt.Coll, QueryConfig{}))
+		defer server.Close()
+
+		var response, err = http.Get(server.URL + "/events/aggregate?group_by=source.service_name")
+		if err != nil {
+			t.Fatalf("An unexpected error occured while making a request: %s", err)
+		}
+		defer response.Body.Close()
+
+		// the status was sent with the first result so the response is cut off instead
+		var body, readErr = ioutil.ReadAll(response.Body)
+		if readErr == nil {
+			t.Errorf("An aggregation that failed mid stream was finished as if it was complete Got: %s", body)
+		}
+	})
+}
+
+// response writer that throws away the body so benchmarks only measure the handler
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (self *discardResponseWriter) Header() http.Header {
+	return self.header
+}
+
+func (self *discardResponseWriter) Write(d []byte) (int, error) {
+	return len(d), nil
+}
+
+func (self *discardResponseWriter) WriteHeader(statusCode int) {
+}
+
+// create a cursor holding one result for each of many distinct groups
+func highCardinalityCursor(b *testing.B, groups int) *mongo.Cursor {
+	var documents = make([]interface{}, groups)
+	for i := range documents {
+		documents[i] = bson.D{{Key: "_id", Value: fmt.Sprintf("user-%d", i)}, {Key: "count", Value: i}}
+	}
+
+	var cursor, err = mongo.NewCursorFromDocuments(documents, nil, nil)
+	if err != nil {
+		b.Fatalf("An unexpected error occured while creating a cursor: %s", err)
+	}
+
+	return cursor
+}
+
+func BenchmarkStreamAggregateResults(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		var cursor = highCardinalityCursor(b, 50000)
+		b.StartTimer()
+
+		var stream = mux.NewJsonArrayStream(&discardResponseWriter{header: make(http.Header)})
+		streamAggregateResults(context.Background(), cursor, stream)
+		stream.Close()
+	}
+}
+
+// the same results read into memory before being written for comparison
+func BenchmarkBufferedAggregateResults(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		var cursor = highCardinalityCursor(b, 50000)
+		b.StartTimer()
+
+		var results []AggregateResult
+		cursor.All(context.Background(), &results)
+
+		var d, _ = json.Marshal(results)
+		(&discardResponseWriter{header: make(http.Header)}).Write(d)
+	}
+}
+
+func TestEventsAggregateHandlerGuardrailsAcceptSimple(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("simple", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt))
+
+		var config = QueryConfig{
+			AggregateFields:          []string{"source.service_name"},
+			DisallowAggregateDiskUse: true,
+			AggregateMaxTime:         2 * time.Second,
+		}
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/events/aggregate?group_by=source.service_name", nil)
+		EventsAggregateHandler(mt.Coll, config).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(aggregateInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		var command = mt.GetStartedEvent().Command
+		if command.Lookup("maxTimeMS").Int64() != 2000 {
+			t.Errorf("The aggregation was not limited to the max time Got: %s", command)
+		}
+		if command.Lookup("allowDiskUse").Boolean() {
+			t.Errorf("The aggregation was allowed to use the disk Got: %s", command)
+		}
+	})
+}
+
+func TestEventsAggregateHandlerGuardrailsRejectComplex(t *testing.T) {
+	var tests = []struct {
+		name   string
+		target string
+		config QueryConfig
+	}{
+		{"field not allowed", "/events/aggregate?group_by=attributes.ip", QueryConfig{AggregateFields: []string{"source.service_name"}}},
+		{"expression", "/events/aggregate?group_by=$$ROOT", QueryConfig{}},
+	}
+
+	for _, test := range tests {
+		var mt = newMockDb(t)
+
+		mt.Run(test.name, func(mt *mtest.T) {
+			var writer = httptest.NewRecorder()
+			EventsAggregateHandler(mt.Coll, test.config).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, test.target, nil))
+
+			if writer.Code != http.StatusBadRequest {
+				t.Errorf(aggregateInvalidStatusError, http.StatusBadRequest, writer.Code)
+			}
+			if len(mt.GetAllStartedEvents()) != 0 {
+				t.Error("The rejected aggregation was sent to the db")
+			}
+		})
+
+		mt.Close()
+	}
+}
+
+func TestEventsAggregateHandlerSharedFilters(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("last", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt))
+
+		var before = time.Now().Add(-24 * time.Hour).UnixNano()
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/events/aggregate?group_by=summary&last=24h", nil)
+		EventsAggregateHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(aggregateInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		var command = mt.GetStartedEvent().Command
+		var bound, err = command.LookupErr("pipeline", "0", "$match", "$and", "0", "timestamp", "$gte")
+		if err != nil || bound.Int64() < before {
+			t.Errorf("The events were not limited to the last 24 hours Got: %s", command)
+		}
+	})
+
+	mt.Run("tags", func(mt *mtest.T) {
+		var tagged = primitive.NewObjectID()
+		mt.AddMockResponses(mockCursorResponse(mt, bson.D{{Key: "_id", Value: tagged}}), mockCursorResponse(mt))
+
+		var config = QueryConfig{TagCollection: mt.DB.Collection("tag")}
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/events/aggregate?group_by=summary&tags=incident-123", nil)
+		EventsAggregateHandler(mt.Coll, config).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(aggregateInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		// the first command searches the tag collection
+		mt.GetStartedEvent()
+
+		var command = mt.GetStartedEvent().Command
+		var id, err = command.LookupErr("pipeline", "0", "$match", "$and", "0", "_id", "$in", "0")
+		if err != nil || id.ObjectID() != tagged {
+			t.Errorf("The events were not filtered on the ids of the tagged events Got: %s", command)
+		}
+	})
+
+	mt.Run("encrypted", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt))
+
+		var config = QueryConfig{Encryption: newTestEncryption(t, true, nil)}
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/events/aggregate?group_by=summary&attributes.customer_name=mitchell", nil)
+		EventsAggregateHandler(mt.Coll, config).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(aggregateInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		// the stored value is encrypted so the plain text value would never match
+		var command = mt.GetStartedEvent().Command
+		var value, err = command.LookupErr("pipeline", "0", "$match", "attributes.customer_name")
+		if err != nil || value.Type == bson.TypeString && value.StringValue() == "mitchell" {
+			t.Errorf("The filter on an encrypted field was not encrypted Got: %s", command)
+		}
+	})
+}
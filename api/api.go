@@ -1,19 +1,26 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/mitchellkelly/auditlog/mux"
 	"github.com/qri-io/jsonschema"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type ValidationError []jsonschema.KeyError
@@ -52,120 +59,919 @@ func (self ValidationError) Error() string {
 	return validationErrorString
 }
 
+// ValidationErrorDetail describes one reason an event did not match the json schema
+type ValidationErrorDetail struct {
+	// json pointer to the field that failed validation
+	Field string `json:"field"`
+	// description of why the field failed validation
+	Message string `json:"message"`
+}
+
+// create a structured representation of the json schema errors
+// that clients can use without having to parse the error string
+func (self ValidationError) Details() []ValidationErrorDetail {
+	var details = make([]ValidationErrorDetail, 0, len(self))
+
+	for _, ve := range self {
+		// the PropertyPath is not always set so we use / to point at the whole event
+		var field = ve.PropertyPath
+		if len(field) == 0 {
+			field = "/"
+		}
+
+		details = append(details, ValidationErrorDetail{
+			Field:   field,
+			Message: ve.Message,
+		})
+	}
+
+	return details
+}
+
+// schemaError is returned by validateEventBody when the json schema library fails
+// rather than reporting that an event is invalid, which means the schema itself is broken
+type schemaError struct {
+	cause interface{}
+}
+
+func (self schemaError) Error() string {
+	return fmt.Sprintf("The event json schema could not be used to validate an event: %v", self.cause)
+}
+
+// the error returned when a json body has more data after the first value
+// (i.e. a valid event followed by garbage or a second event)
+var errTrailingData = mux.HttpError{
+	Code:        http.StatusBadRequest,
+	Description: "unexpected trailing data",
+}
+
+// decode a json body that must hold a single value
+// errTrailingData is returned if anything other than whitespace follows the value
+func decodeJson(d []byte, v interface{}) error {
+	var decoder = json.NewDecoder(bytes.NewReader(d))
+
+	var err = decoder.Decode(v)
+	if err == nil {
+		if _, tokenErr := decoder.Token(); tokenErr != io.EOF {
+			err = errTrailingData
+		}
+	}
+
+	return err
+}
+
+// start of the message the json schema library uses when a $ref can not be resolved
+const unresolvedRefMessage = "failed to resolve schema for ref"
+
+// validate an event body using the json schema
+// validation failures are returned as a ValidationError which will be empty if the event is valid
+// the error is a 400 HttpError if the body is not json and a schemaError if the json schema library failed
+func validateEventBody(ctx context.Context, schema *jsonschema.Schema, d []byte) (validationError ValidationError, err error) {
+	// the body is checked before it is given to the library so that any error
+	// the library returns can be blamed on the schema rather than the user
+	var value json.RawMessage
+	if decodeJson(d, &value) == errTrailingData {
+		return nil, errTrailingData
+	}
+
+	if !json.Valid(d) {
+		return nil, mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: "The request body must be valid json",
+		}
+	}
+
+	// a panic in the library is treated the same way as an error it returns
+	defer func() {
+		var r = recover()
+		if r != nil {
+			validationError = nil
+			err = schemaError{r}
+		}
+	}()
+
+	var keyErrors []jsonschema.KeyError
+	keyErrors, err = schema.ValidateBytes(ctx, d)
+	if err != nil {
+		return nil, schemaError{err}
+	}
+
+	// the library reports a $ref that can not be resolved as a validation failure
+	// but it is a problem with the schema and every event would fail the same way
+	for _, keyError := range keyErrors {
+		if strings.HasPrefix(keyError.Message, unresolvedRefMessage) {
+			return nil, schemaError{keyError.Message}
+		}
+	}
+
+	return ValidationError(keyErrors), nil
+}
+
+// convert an error returned by validateEventBody to the error sent to the user
+// schema errors are not caused by the user so they are logged and sent as a 500
+func validationFailure(err error, logger *log.Logger) error {
+	if _, ok := err.(schemaError); ok {
+		if logger != nil {
+			logger.Println(err)
+		}
+
+		return mux.DefaultHttpError(http.StatusInternalServerError)
+	}
+
+	return err
+}
+
+// mongo rejects documents larger than 16MiB
+const DefaultMaxEventBytes = 16 * 1024 * 1024
+
+// largest request body that is read when adding events if no limit is configured
+const DefaultMaxBodyBytes = 1024 * 1024
+
+// InsertConfig holds the settings used by the handlers that add events to the database
+type InsertConfig struct {
+	// largest size in bytes an event can have once it is encoded as bson
+	// 0 means DefaultMaxEventBytes
+	MaxEventBytes int
+	// largest size in bytes of the body of a request that adds events
+	// bodies are read into memory so this keeps one request from using all of it
+	// 0 means DefaultMaxBodyBytes
+	MaxBodyBytes int64
+	// used to log each event that is added
+	// nothing is logged if Logger is nil
+	Logger *log.Logger
+	// field in the event (i.e. trace_id or metadata.correlation_id) that is included in the log line
+	// so added events can be matched up with the traces of the system that sent them
+	CorrelationField string
+	// how long a client has to send the whole request body
+	// 0 means there is no limit
+	BodyReadTimeout time.Duration
+	// writes every added event to the secondary destinations
+	// they are written to in the background and their failures are only logged
+	// nil means there are no secondary destinations
+	SinkWriter *SinkWriter
+	// status code sent when a well formed event does not match the json schema
+	// 0 means 400 but some clients prefer 422 so they can tell these apart from unparseable bodies
+	InvalidEventStatus int
+	// events in a batch that do not match the json schema are left out and reported on their own
+	// the same way as oversized events instead of the whole batch being rejected
+	// a batch where every event is invalid is still rejected
+	PartialBatches bool
+	// field (i.e. _meta) that RequestMetadata is added to in every event before it is inserted
+	// the json schema must permit the field
+	// no metadata is added if it is empty
+	MetadataField string
+	// field the time an event happened is added to as a bson date so a TTL index can expire events
+	// no field is added if it is empty
+	RetentionField string
+	// field that holds the time an event happened in nanoseconds since the Unix epoch
+	// an empty string means DefaultTimestampField
+	TimestampField string
+	// largest size in bytes of the field at a path (i.e. attributes.message)
+	// fields without a limit are not checked
+	FieldMaxBytes map[string]int
+	// largest size in bytes of any field that is not an object and does not have a limit in FieldMaxBytes
+	// 0 means there is no default limit
+	DefaultFieldMaxBytes int
+	// creates a pointer to a struct with json and bson tags that events are decoded into before they are added
+	// so their fields keep precise types (i.e. int64 or time.Time)
+	// the struct must have every field the events can have since any other field is dropped
+	// events are decoded into a map if NewEvent is nil
+	NewEvent func() interface{}
+	// how events are given an id in addition to their ObjectID (see IdStrategies)
+	// an empty string means IdStrategyObjectId
+	IdStrategy string
+	// field the generated id is stored in
+	// an empty string means DefaultIdField
+	IdField string
+	// encrypts fields of events before they are inserted
+	// nil means no fields are encrypted
+	Encryption *FieldEncryption
+	// how events added with EventsAddHandler are acknowledged when the request does not have a Prefer header
+	// (see AckModes)
+	// an empty string means the response is a 204 once the event is in the database
+	DefaultAckMode string
+	// events are added without being checked against the json schema (passthrough mode)
+	// they still have to be json and the responses have the X-Validation: disabled header
+	DisableValidation bool
+	// local file events are written to before they are inserted so they are kept while the database is unavailable
+	// events are inserted without one if WriteAheadLog is nil
+	WriteAheadLog *WriteAheadLog
+	// inserts the events added in AckModeAsync in the background
+	// nil means they are inserted before the response is sent
+	AsyncInserter *AsyncInserter
+}
+
+// get the status code sent when an event does not match the json schema
+func (self InsertConfig) invalidEventStatus() int {
+	if self.InvalidEventStatus == 0 {
+		return http.StatusBadRequest
+	}
+
+	return self.InvalidEventStatus
+}
+
+// get the largest request body that is read when adding events
+func (self InsertConfig) maxBodyBytes() int64 {
+	if self.MaxBodyBytes <= 0 {
+		return DefaultMaxBodyBytes
+	}
+
+	return self.MaxBodyBytes
+}
+
+// read the body of a request that adds events
+// a 413 is returned if the body is larger than the configured limit
+// and a 408 is returned if the body is not fully received before the timeout
+func readRequestBody(writer http.ResponseWriter, request *http.Request, config InsertConfig) ([]byte, error) {
+	var maxBodyBytes = config.maxBodyBytes()
+
+	// the server closes the connection once the limit is reached so the rest of the body is never read
+	request.Body = http.MaxBytesReader(writer, request.Body, maxBodyBytes)
+
+	var d, err = readBodyWithTimeout(writer, request, config.BodyReadTimeout)
+	// the reader only fails at the limit after every byte up to the limit has been read
+	if err != nil && int64(len(d)) == maxBodyBytes {
+		err = mux.HttpError{
+			Code:        http.StatusRequestEntityTooLarge,
+			Description: fmt.Sprintf("The request body is larger than the %d byte limit", maxBodyBytes),
+		}
+	}
+
+	return d, err
+}
+
+// read the request body
+// a 408 is returned if the body is not fully received before the timeout so a slow client
+// can not hold the handler open by trickling bytes
+// the body is read in the handler so nothing is left reading it once the handler has returned
+func readBodyWithTimeout(writer http.ResponseWriter, request *http.Request, timeout time.Duration) ([]byte, error) {
+	if timeout == 0 {
+		return ioutil.ReadAll(request.Body)
+	}
+
+	var deadline = time.Now().Add(timeout)
+
+	// the connection deadline stops a read that is blocked waiting on the client
+	// response writers that do not support it (i.e. testing writers) are still stopped between reads
+	var deadlineErr = mux.SetReadDeadline(writer, deadline)
+
+	var d, err = ioutil.ReadAll(&deadlineReader{Reader: request.Body, deadline: deadline})
+	// once the whole body has been read the deadline is cleared so it does not affect the connection
+	// it is left in place after a failed read so the server does not wait on the client for the rest of the body
+	if err == nil && deadlineErr == nil {
+		mux.SetReadDeadline(writer, time.Time{})
+	}
+
+	if err != nil && !time.Now().Before(deadline) {
+		err = mux.HttpError{
+			Code:        http.StatusRequestTimeout,
+			Description: fmt.Sprintf("The request body was not received within %s", timeout),
+		}
+	}
+
+	return d, err
+}
+
+// reader that fails once its deadline has passed
+type deadlineReader struct {
+	io.Reader
+	deadline time.Time
+}
+
+func (self *deadlineReader) Read(p []byte) (int, error) {
+	if !time.Now().Before(self.deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	return self.Reader.Read(p)
+}
+
+// log that an event was added along with its correlation id if it has one
+func logEventAdded(config InsertConfig, id interface{}, event map[string]interface{}) {
+	if config.Logger == nil {
+		return
+	}
+
+	var line = fmt.Sprintf("Event added id=%q", csvCell(id))
+
+	// events that do not have the correlation field are still logged without it
+	if len(config.CorrelationField) != 0 {
+		var correlationId = lookupField(event, config.CorrelationField)
+		if correlationId != nil {
+			line += fmt.Sprintf(" %s=%q", config.CorrelationField, csvCell(correlationId))
+		}
+	}
+
+	config.Logger.Println(line)
+}
+
+// make sure an event is small enough to be stored in the db
+func checkEventSize(event interface{}, config InsertConfig) error {
+	var maxEventBytes = config.MaxEventBytes
+	if maxEventBytes == 0 {
+		maxEventBytes = DefaultMaxEventBytes
+	}
+
+	var d, err = bson.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if len(d) > maxEventBytes {
+		return mux.HttpError{
+			Code:        http.StatusRequestEntityTooLarge,
+			Description: fmt.Sprintf("The event is %d bytes which is larger than the %d byte limit", len(d), maxEventBytes),
+		}
+	}
+
+	return nil
+}
+
 // EventsAddHandler creates an http handler that validates and adds events to the database
-func EventsAddHandler(db *mongo.Collection, schema *jsonschema.Schema) http.Handler {
+func EventsAddHandler(db *mongo.Collection, schema *jsonschema.Schema, config InsertConfig) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		setValidationHeader(writer, config)
+
 		// read the data from the request body
-		var d, err = ioutil.ReadAll(request.Body)
-		if err != nil {
+		var d, err = readRequestBody(writer, request, config)
+		if _, ok := err.(mux.HttpError); err != nil && !ok {
 			err = mux.DefaultHttpError(http.StatusBadRequest)
 		}
 
-		if err == nil {
-			var validationError ValidationError
-			// validate the request data using the json schema
-			validationError, err = schema.ValidateBytes(request.Context(), d)
-			// if something unexpected happened while validating the json we will just return a
-			// simple 400 error
-			// if the json body is invalid then we will return a 400 and a response body
-			// describing why the json is invalid
-			if err != nil {
-				err = mux.DefaultHttpError(http.StatusBadRequest)
+		var mode, requested = ackMode(request, config)
+		if requested {
+			writer.Header().Set("Preference-Applied", "respond-"+mode)
+		}
+
+		// the client does not want to wait for the event to be inserted
+		if mode == AckModeAsync {
+			if err == nil {
+				err = addEventAsync(request, db, schema, d, config)
+			}
+
+			if err == nil {
+				writer.WriteHeader(http.StatusAccepted)
 			} else {
-				if len(validationError) > 0 {
-					err = mux.HttpError{
-						Code:        http.StatusBadRequest,
-						Description: validationError.Error(),
-					}
-				}
+				mux.WriteJsonResponse(writer, err)
 			}
+			return
 		}
 
-		var event map[string]interface{}
+		var id interface{}
+		var queued bool
 		if err == nil {
-			err = json.Unmarshal(d, &event)
+			id, queued, err = addEvent(request.Context(), db, schema, d, newRequestMetadata(request, time.Now()), config)
 		}
 
-		if err == nil {
-			// create a timed context to use when making requests to the db
-			var timedContext, timedContextCancel = context.WithTimeout(request.Context(), 10*time.Second)
-
-			_, err = db.InsertOne(timedContext, event)
-			// close the context to release any resources associated with it
-			timedContextCancel()
+		// an event in the write ahead log has been accepted but is not in the database yet
+		if queued {
+			writer.WriteHeader(http.StatusAccepted)
+			return
 		}
 
-		mux.WriteJsonResponse(writer, err)
+		if err == nil && mode == AckModeSync {
+			mux.WriteJsonResponse(writer, AddedEvent{Id: id})
+		} else {
+			mux.WriteJsonResponse(writer, err)
+		}
 	})
 }
 
-func CreateFilterFromQuery(queryParams url.Values) map[string]interface{} {
-	// create a filter object
-	// we have to call make() because the collection.Find method assumes filter will be non nil
-	var filter = make(map[string]interface{})
+// validate an event and add it to the database
+// the id of the added event is returned
+// queued is true if the database was unavailable and the event was left in the write ahead log to be inserted later
+func addEvent(ctx context.Context, db *mongo.Collection, schema *jsonschema.Schema, d []byte,
+	metadata RequestMetadata, config InsertConfig) (id interface{}, queued bool, err error) {
+	var event map[string]interface{}
+	event, err = prepareEvent(ctx, schema, d, metadata, config)
 
-	for k, _ := range queryParams {
-		var v interface{}
+	if err == nil && config.WriteAheadLog != nil {
+		err = config.WriteAheadLog.Append(event)
+	}
+
+	if err == nil {
+		id, queued, err = insertEvent(ctx, db, event, config)
+	}
+
+	return id, queued, err
+}
 
-		// queryParams is a url.Values type which is map[string][]string
-		// we want url.Values map key but we will call the url.Values.Get(k) method
-		// since it returns a string
-		var queryValueString = queryParams.Get(k)
+// validate an event body and create the event that is inserted into the database from it
+func prepareEvent(ctx context.Context, schema *jsonschema.Schema, d []byte, metadata RequestMetadata,
+	config InsertConfig) (map[string]interface{}, error) {
+	var validationError, err = validateEvent(ctx, schema, d, config)
+	// if the body is not json we will return a 400 and if the schema is broken we will return a 500
+	// if the json body does not match the schema then we will return a 400 and a response body
+	// describing why the json is invalid
+	if err != nil {
+		err = validationFailure(err, config.Logger)
+	} else {
+		if len(validationError) > 0 {
+			err = mux.HttpError{
+				Code:        config.invalidEventStatus(),
+				Description: validationError.Error(),
+			}
+		}
+	}
 
-		// handle id values as a special case
-		// we want to query for a 24 character hex id
-		// but mongo assumes we are using the 12 byte format
-		if k == "_id" {
-			var objectId, _ = primitive.ObjectIDFromHex(queryValueString)
-			v = objectId
+	var event map[string]interface{}
+	if err == nil {
+		event, err = decodeEvent(d, config)
+	}
+
+	// the metadata is added by the service so it is not subject to the field limits
+	if err == nil {
+		err = checkFieldSizes(event, config)
+	}
+
+	if err == nil {
+		addRequestMetadata(event, metadata, config)
+		addRetentionDate(event, metadata.Received, config)
+		err = addEventId(event, metadata.Received, config)
+	}
+
+	// the size is checked after the fields are encrypted since that is what is stored
+	if err == nil {
+		err = config.Encryption.encryptEvent(event)
+	}
+
+	if err == nil {
+		err = checkEventSize(event, config)
+	}
+
+	return event, err
+}
+
+// insert a prepared event into the database
+// the event must already be in the write ahead log if one is configured
+func insertEvent(ctx context.Context, db *mongo.Collection, event map[string]interface{},
+	config InsertConfig) (id interface{}, queued bool, err error) {
+	// create a timed context to use when making requests to the db
+	var timedContext, timedContextCancel = context.WithTimeout(ctx, 10*time.Second)
+
+	var result *mongo.InsertOneResult
+	result, err = db.InsertOne(timedContext, event)
+	// close the context to release any resources associated with it
+	timedContextCancel()
+
+	var wal = config.WriteAheadLog
+	if wal != nil {
+		// the event is either in the database or can never be inserted (i.e. it is a duplicate)
+		if err == nil || isPermanentInsertError(err) {
+			wal.Ack(event)
 		} else {
-			v = queryValueString
+			// the event might not have been inserted (i.e. the request was cancelled or the write concern failed)
+			// so it stays in the log and Drain inserts it or finds it is already in the database
+			wal.Release(event)
+		}
+
+		// the event is inserted once the database is reachable again
+		if isDbUnavailable(err) {
+			return event["_id"], true, nil
+		}
+	}
+
+	// events can not be replaced so an event with the id of an existing event is a conflict
+	// rather than a problem with the service
+	if mongo.IsDuplicateKeyError(err) {
+		var description = "The event conflicts with an existing event"
+		if suppliedId, ok := event["_id"]; ok {
+			description = fmt.Sprintf("An event with the id %s already exists", csvCell(suppliedId))
 		}
 
-		// trying to pass a string filter value for a non string data type results in no match
-		// i.e. trying to filter for timestamp == "1648857887" will not match a row where timestamp == 1648857887
-		// TODO allow for filtering of values other than strings
-		// this could be done by using the jsonschema, checking the object type
-		// and parsing it appropriately before adding it to the filter
+		err = mux.HttpError{
+			Code:        http.StatusConflict,
+			Description: description,
+		}
+	}
 
-		filter[k] = v
+	if err == nil {
+		id = result.InsertedID
+		logEventAdded(config, id, event)
+
+		event["_id"] = id
+		config.SinkWriter.write([]map[string]interface{}{event})
 	}
 
-	return filter
+	return id, false, err
+}
+
+// QueryConfig holds the settings used by EventsQueryHandler when reading events from the database
+type QueryConfig struct {
+	// when StrictDecoding is true a stored event that cannot be decoded will fail the whole query
+	// when it is false the event will be logged and skipped so the rest of the results can still be returned
+	StrictDecoding bool
+	// logger used to report events that were skipped
+	Logger *log.Logger
+	// number of events returned when the user does not provide a limit
+	// 0 means no default limit
+	DefaultLimit int64
+	// largest limit a user is allowed to request
+	// 0 means there is no maximum
+	MaxLimit int64
+	// columns written when the user requests the results as csv
+	// these should come from CsvColumnsFromSchema so the columns are stable
+	CsvColumns []string
+	// map of stored field name to the name it should have in query results
+	// unmapped fields are returned unchanged
+	FieldAliases map[string]string
+	// fields the user is allowed to sort the results by
+	// this should be limited to indexed fields since sorting on other fields can exceed the db in memory sort limit
+	// nil means any field can be used
+	SortableFields []string
+	// how long a query can run before it is aborted
+	// 0 means DefaultQueryTimeout
+	QueryTimeout time.Duration
+	// when AllowPartialResults is true a query that runs out of time while reading results
+	// returns the events read so far with the X-Partial-Result header instead of failing
+	AllowPartialResults bool
+	// largest number of distinct fields a query can filter on
+	// 0 means there is no maximum
+	MaxFilterFields int64
+	// how the _id of each event is returned (see IdFormats)
+	// an empty string means IdFormatHex
+	IdFormat string
+	// when DisableSortTiebreaker is true _id is not added as the last sort key
+	// this avoids the extra sort key but events that share every sort value are returned in an unspecified order
+	// and paging with the after token can skip or repeat them
+	DisableSortTiebreaker bool
+	// what to do with a query that does not filter the events (see EmptyFilterPolicies)
+	// an empty string means EmptyFilterAllow
+	EmptyFilterPolicy string
+	// how long clients are told to wait before retrying when the database is unavailable
+	// 0 means DefaultRetryAfter
+	RetryAfter time.Duration
+	// read consistency used when the query does not have a consistency query param (see Consistencies)
+	// an empty string means the read preference of the db client is used
+	DefaultConsistency string
+	// transforms applied in order to every query result (i.e. to redact or mask fields)
+	// they are applied before the id format and aliases of the query
+	Transforms TransformPipeline
+	// when FlattenBsonTypes is true dates, object ids and decimals in the results are returned
+	// as plain json strings and numbers (see FlattenTransform)
+	FlattenBsonTypes bool
+	// decrypts the encrypted fields of events in query results and encrypts filters on them
+	// nil means no fields are encrypted
+	Encryption *FieldEncryption
+	// limits how many query cursors can be open at once
+	// nil means there is no limit
+	CursorLimiter *CursorLimiter
+	// event schema used to parse filter values into the types of their fields
+	// nil means every filter value is a string
+	Schema *jsonschema.Schema
+	// field holding the time an event happened that the last query param filters on
+	// an empty string means DefaultTimestampField
+	TimestampField string
+	// longest time window a query using from and to or last can cover
+	// 0 means there is no maximum
+	MaxTimeRange time.Duration
+	// names of the indexes the user can force a query to use with the hint query param
+	// nil means hints can not be provided by the user
+	IndexHints []string
+	// map of the fields a query filters on (in alphabetical order separated by commas) to the index
+	// the query is forced to use when the user does not provide a hint
+	// i.e. {"source.service_name": "service_timestamp"}
+	DefaultHints map[string]string
+	// fields the events can be grouped by in an aggregation
+	// nil means any field can be used
+	AggregateFields []string
+	// when DisallowAggregateDiskUse is true aggregations that need more memory than the db allows fail
+	// instead of using temporary files on disk
+	DisallowAggregateDiskUse bool
+	// how long an aggregation can run before the db stops it
+	// 0 means the query timeout is used
+	AggregateMaxTime time.Duration
+	// collection the tags of events are kept in when the events can not be changed (i.e. in append only mode)
+	// nil means tags are added to the tags array of the events
+	TagCollection *mongo.Collection
+}
+
+// how long a query can run when no timeout is configured
+const DefaultQueryTimeout = 10 * time.Second
+
+// get how long an aggregation can run
+func (self QueryConfig) aggregateMaxTime() time.Duration {
+	if self.AggregateMaxTime <= 0 {
+		return self.queryTimeout()
+	}
+
+	return self.AggregateMaxTime
+}
+
+// response header set when the results were cut short by the query timeout
+const partialResultHeader = "X-Partial-Result"
+
+// how many events are written to a newline delimited json stream between flushes
+const ndjsonFlushInterval = 100
+
+// get how long a query can run
+func (self QueryConfig) queryTimeout() time.Duration {
+	if self.QueryTimeout <= 0 {
+		return DefaultQueryTimeout
+	}
+
+	return self.QueryTimeout
 }
 
 // EventsQueryHandler creates an http handler that retrieves values from the database
 // optionally allowing to filter the vaules
-func EventsQueryHandler(db *mongo.Collection) http.Handler {
+func EventsQueryHandler(db *mongo.Collection, config QueryConfig) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		// get a filter using the url query params
-		var filter = CreateFilterFromQuery(request.URL.Query())
+		queryEvents(writer, request, db, config, request.URL.Query())
+	})
+}
+
+// create the filter that matches the events selected by the query params
+// this is shared by every endpoint that accepts the same filters as GET /events
+// so they all treat encrypted fields, tags and relative time windows the same way
+func createEventFilter(ctx context.Context, queryParams url.Values, config QueryConfig) (map[string]interface{}, error) {
+	var err = checkFilterFieldCount(queryParams, config)
 
-		// TODO allow the user to sort the response by providing a sort=<field> value in the query params
+	var filter map[string]interface{}
+	if err == nil {
+		filter, err = CreateFilterFromQuery(queryParams, config.Schema)
+	}
+
+	// filters on encrypted fields have to be compared with the stored values
+	if err == nil {
+		err = config.Encryption.encryptFilter(filter)
+	}
+
+	// tags kept in the tag collection are matched using the ids of the tagged events
+	if err == nil {
+		err = applyTagFilter(ctx, filter, config)
+	}
+
+	// only match recent events if a relative time window was requested
+	if err == nil {
+		err = addRelativeTimeFilter(filter, queryParams, config, time.Now())
+	}
+
+	// keep a single query from scanning too much history
+	if err == nil {
+		err = checkTimeRange(queryParams, config, time.Now())
+	}
+
+	return filter, err
+}
+
+// query the db for events using the query params and write the results to the user
+// this is shared by the handlers that accept queries in the url and in the request body
+func queryEvents(writer http.ResponseWriter, request *http.Request, db *mongo.Collection, config QueryConfig, queryParams url.Values) {
+	// get a filter using the url query params
+	var filter, err = createEventFilter(request.Context(), queryParams, config)
 
-		// create a timed context to use when making requests to the db
-		var timedContext, timedContextCancel = context.WithTimeout(request.Context(), 10*time.Second)
+	if err == nil {
+		err = checkEmptyFilter(filter, queryParams, config)
+	}
+
+	// get the find options (limit etc.) using the url query params
+	var findOptions *options.FindOptions
+	if err == nil {
+		findOptions, err = CreateFindOptionsFromQuery(queryParams, config)
+	}
 
-		// execute a find command against the db
-		// this will return a cursor that we can request values from
-		var cursor, err = db.Find(timedContext, filter, nil)
-		// close the context to release any resources associated with it
-		timedContextCancel()
+	// force the query to use an index if one was requested or is configured for the filter
+	if err == nil {
+		err = applyIndexHint(findOptions, queryParams, config)
+	}
 
-		// results will be all of the events in the db that match the filter
-		// if no filter is provided the all of the results will be returned
-		// we set results to an intially empty list so that if the db returns 0 values
-		// the endpoint will give the user an empty array instead of the nil json object
-		var results = make([]map[string]interface{}, 0)
+	// get the order of the results so the page token can be created and applied
+	var keys []sortKey
+	if err == nil {
+		keys, err = parseSortKeys(queryParams, config)
+	}
+
+	// only match events added before the export started if a snapshot was requested
+	if err == nil {
+		var snapshotId primitive.ObjectID
+		snapshotId, err = addSnapshotFilter(filter, queryParams)
+		if err == nil && !snapshotId.IsZero() {
+			writer.Header().Set(snapshotHeader, snapshotId.Hex())
+		}
+	}
+
+	// only match events after the page token if one was provided
+	if err == nil && queryParams.Has("after") {
+		err = addAfterFilter(filter, keys, queryParams.Get("after"))
+	}
+
+	// get how the event ids should be returned
+	var idFormat string
+	if err == nil {
+		idFormat, err = parseIdFormat(queryParams, config)
+	}
+
+	// get the field aliases to apply to the results
+	var aliases map[string]string
+	if err == nil {
+		aliases, err = queryFieldAliases(queryParams, config)
+	}
+
+	// read from the primary or a secondary depending on how fresh the results need to be
+	var collection *mongo.Collection
+	if err == nil {
+		collection, err = consistentCollection(db, queryParams, config)
+	}
+
+	// create a timed context to use when making requests to the db
+	// the context is derived from the request context so if the client goes away
+	// the query and any cursor reads are aborted as well
+	var timedContext, timedContextCancel = context.WithTimeout(request.Context(), config.queryTimeout())
+	// close the context to release any resources associated with it once the cursor has been read
+	defer timedContextCancel()
+
+	// each open cursor holds a connection so only a limited number of queries can read events at once
+	if err == nil {
+		err = config.CursorLimiter.acquire()
 		if err == nil {
-			// curse through all of the results and add them to the results list
-			err = cursor.All(request.Context(), &results)
+			defer config.CursorLimiter.release()
 		}
+	}
 
+	// execute a find command against the db
+	// this will return a cursor that we can request values from
+	var cursor *mongo.Cursor
+	if err == nil {
+		cursor, err = collection.Find(timedContext, filter, findOptions)
+	}
+
+	// newline delimited json is written as it is read from the cursor so large results are never held in memory
+	if err == nil && !mux.Accepts(request, csvMediaType) && mux.Accepts(request, mux.NdjsonMediaType) {
+		var transforms = config.resultTransforms(IdFormatTransform(idFormat), AliasTransform(aliases))
+		streamNdjsonResults(timedContext, writer, request, cursor, findOptions, keys, transforms, config)
+		return
+	}
+
+	// results will be all of the events in the db that match the filter
+	// if no filter is provided the all of the results will be returned
+	// we set results to an intially empty list so that if the db returns 0 values
+	// the endpoint will give the user an empty array instead of the nil json object
+	// events are decoded into maps (nested documents included) which encoding/json always
+	// marshals with their keys in sorted order so the response is stable without any extra work
+	var results = make([]map[string]interface{}, 0)
+	var partial bool
+	if err == nil {
+		// curse through all of the results and add them to the results list
+		results, err = decodeCursor(timedContext, cursor, config)
+
+		// if the query ran out of time (rather than the client going away) while the results were being read
+		// the events read so far are returned and marked as partial
+		partial = err != nil && config.AllowPartialResults &&
+			timedContext.Err() == context.DeadlineExceeded && request.Context().Err() == nil
+		if partial {
+			err = nil
+			writer.Header().Set(partialResultHeader, "true")
+		}
+	}
+
+	// if the page is full (or was cut short) there may be more results so tell the user how to get the next page
+	// this has to be done before the fields are aliased
+	var pageIsFull = findOptions != nil && findOptions.Limit != nil && int64(len(results)) == *findOptions.Limit
+	if err == nil && len(results) > 0 && (pageIsFull || partial) {
+		var token, tokenErr = encodeAfterToken(keys, results[len(results)-1])
+		if tokenErr == nil {
+			writer.Header().Set(nextPageHeader, token)
+		}
+	}
+
+	// decrypt the encrypted fields after the page token is created since it has to use the stored values
+	if err == nil {
+		err = decryptResults(request, results, config)
+	}
+
+	// apply the configured transforms and then the id format and aliases of the query before writing the results
+	// the stored field names are used by the configured transforms so the aliases are applied last
+	var transforms = config.resultTransforms(IdFormatTransform(idFormat), AliasTransform(aliases))
+	for i := 0; err == nil && i < len(results); i++ {
+		results[i] = transforms.Transform(results[i])
+	}
+
+	if err == nil && mux.Accepts(request, csvMediaType) {
+		writeCsvResponse(writer, aliasColumns(idFormatColumns(config.CsvColumns, idFormat), aliases), results)
+	} else if err == nil {
+		mux.WriteJsonResponse(writer, results)
+	} else {
+		mux.WriteJsonResponse(writer, dbUnavailableError(writer, err, config))
+	}
+}
+
+// write the events from the cursor as newline delimited json with one event per line and close the cursor
+// events are written as they are read so only one is held in memory at a time
+// the page token and partial result headers can only be known once the cursor has been read
+// so they are sent as trailers after the events
+func streamNdjsonResults(ctx context.Context, writer http.ResponseWriter, request *http.Request, cursor *mongo.Cursor,
+	findOptions *options.FindOptions, keys []sortKey, transforms TransformPipeline, config QueryConfig) {
+	defer cursor.Close(ctx)
+
+	writer.Header().Set("Trailer", nextPageHeader+", "+partialResultHeader)
+	var stream = mux.NewNdjsonStream(writer)
+
+	var count int64
+	var lastToken string
+	var err error
+	for err == nil && cursor.Next(ctx) {
+		// the driver only checks the context when it needs to fetch another batch
+		// so we check it ourselves to stop reading as soon as the request is cancelled
+		err = ctx.Err()
+		if err != nil {
+			break
+		}
+
+		var event map[string]interface{}
+
+		var decodeErr = cursor.Decode(&event)
+		if decodeErr != nil {
+			if config.StrictDecoding {
+				err = decodeErr
+			} else if config.Logger != nil {
+				config.Logger.Printf("Skipping a stored event that could not be decoded: %s\n", decodeErr)
+			}
+			continue
+		}
+
+		// the page token has to use the stored values so it is created before the event is decrypted
+		var token, tokenErr = encodeAfterToken(keys, event)
+		if tokenErr == nil {
+			lastToken = token
+		}
+
+		err = decryptResults(request, []map[string]interface{}{event}, config)
 		if err == nil {
-			mux.WriteJsonResponse(writer, results)
-		} else {
-			mux.WriteJsonResponse(writer, err)
+			err = stream.Write(transforms.Transform(event))
+			count++
 		}
-	})
+
+		// flush every so often so the user can start processing the events before the query is done
+		if err == nil && count%ndjsonFlushInterval == 0 {
+			stream.Flush()
+		}
+	}
+
+	// errors returned by the cursor itself (i.e. network errors) always fail the query
+	if err == nil {
+		err = cursor.Err()
+	}
+
+	// if the query ran out of time (rather than the client going away) the events written so far are marked as partial
+	var partial = err != nil && config.AllowPartialResults &&
+		ctx.Err() == context.DeadlineExceeded && request.Context().Err() == nil
+	if err != nil && !partial {
+		// trailers are only declared for a response that is finished
+		if count == 0 {
+			writer.Header().Del("Trailer")
+		}
+
+		stream.Abort(dbUnavailableError(writer, err, config))
+		return
+	}
+
+	if partial {
+		writer.Header().Set(partialResultHeader, "true")
+	}
+
+	// if the page is full (or was cut short) there may be more results so tell the user how to get the next page
+	var pageIsFull = findOptions.Limit != nil && count == *findOptions.Limit
+	if count > 0 && len(lastToken) > 0 && (pageIsFull || partial) {
+		writer.Header().Set(nextPageHeader, lastToken)
+	}
+
+	stream.Close()
+}
+
+// decodeCursor reads every event from the cursor and closes it
+// events that fail to decode are skipped and logged unless strict decoding is enabled
+func decodeCursor(ctx context.Context, cursor *mongo.Cursor, config QueryConfig) ([]map[string]interface{}, error) {
+	var results = make([]map[string]interface{}, 0)
+	var err error
+
+	for err == nil && cursor.Next(ctx) {
+		// the driver only checks the context when it needs to fetch another batch
+		// so we check it ourselves to stop reading as soon as the request is cancelled
+		err = ctx.Err()
+		if err != nil {
+			break
+		}
+
+		var event map[string]interface{}
+
+		var decodeErr = cursor.Decode(&event)
+		if decodeErr == nil {
+			results = append(results, event)
+		} else if config.StrictDecoding {
+			err = decodeErr
+		} else if config.Logger != nil {
+			// one malformed event should not hide all of the valid events around it
+			config.Logger.Printf("Skipping a stored event that could not be decoded: %s\n", decodeErr)
+		}
+	}
+
+	// errors returned by the cursor itself (i.e. network errors) always fail the query
+	if err == nil {
+		err = cursor.Err()
+	}
+
+	cursor.Close(ctx)
+
+	return results, err
 }
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"github.com/qri-io/jsonschema"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BatchItemError describes why one event in a batch was rejected
+type BatchItemError struct {
+	// position of the event in the batch
+	Index int `json:"index"`
+	// reasons the event did not match the json schema
+	Details []ValidationErrorDetail `json:"details"`
+}
+
+// BatchValidationError is returned when one or more events in a batch fail validation
+// each failure is mapped to the index of the event in the batch
+type BatchValidationError struct {
+	Description string           `json:"description"`
+	Errors      []BatchItemError `json:"errors"`
+	// status code the error is sent with
+	// 0 means 400
+	statusCode int
+}
+
+func (self BatchValidationError) Error() string {
+	return self.Description
+}
+
+// batch validation errors are always caused by the user
+func (self BatchValidationError) StatusCode() int {
+	if self.statusCode == 0 {
+		return http.StatusBadRequest
+	}
+
+	return self.statusCode
+}
+
+// BatchResult is returned when some of the events in a batch could not be added
+// the rest of the events in the batch are still added to the database
+type BatchResult struct {
+	// number of events that were added to the database
+	Inserted int `json:"inserted"`
+	// number of events that were left in the write ahead log to be added once the database is available
+	Queued int `json:"queued,omitempty"`
+	// events that were not added in the order they were in the batch
+	Errors []BatchItemError `json:"errors"`
+}
+
+// a partially added batch is reported as a 207 so the client knows to look at the body
+func (self BatchResult) StatusCode() int {
+	return http.StatusMultiStatus
+}
+
+// EventsBulkAddHandler creates an http handler that validates a json array of events
+// and adds them to the database
+// every event is validated individually and if any of them fail validation none of them are added
+// unless InsertConfig.PartialBatches is set in which case only the invalid events are left out
+// events that are too large to be stored or have an oversized field are rejected on their own
+// and the rest of the batch is still added
+func EventsBulkAddHandler(db *mongo.Collection, schema *jsonschema.Schema, config InsertConfig) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		setValidationHeader(writer, config)
+
+		// read the data from the request body
+		var d, err = readRequestBody(writer, request, config)
+		if _, ok := err.(mux.HttpError); err != nil && !ok {
+			err = mux.DefaultHttpError(http.StatusBadRequest)
+		}
+
+		// split the body into the individual events so each one can be validated on its own
+		var rawEvents []json.RawMessage
+		if err == nil {
+			err = decodeJson(d, &rawEvents)
+			if err != nil && err != errTrailingData {
+				err = mux.HttpError{
+					Code:        http.StatusBadRequest,
+					Description: "The request body must be a json array of events",
+				}
+			}
+		}
+
+		if err == nil && len(rawEvents) == 0 {
+			err = mux.HttpError{
+				Code:        http.StatusBadRequest,
+				Description: "The request body must contain at least one event",
+			}
+		}
+
+		if err == nil {
+			err = validationFailure(validateBatch(request.Context(), schema, rawEvents, config), config.Logger)
+		}
+
+		// the invalid events are reported in the response along with the events that were too large
+		var itemErrors []BatchItemError
+		if batchError, ok := err.(BatchValidationError); ok && config.PartialBatches && len(batchError.Errors) < len(rawEvents) {
+			itemErrors = batchError.Errors
+			err = nil
+		}
+
+		var invalid = make(map[int]bool, len(itemErrors))
+		for _, itemError := range itemErrors {
+			invalid[itemError.Index] = true
+		}
+
+		// every event in the batch was received in the same request
+		var metadata = newRequestMetadata(request, time.Now())
+
+		var events = make([]interface{}, 0, len(rawEvents))
+		for i := 0; err == nil && i < len(rawEvents); i++ {
+			if invalid[i] {
+				continue
+			}
+
+			var event map[string]interface{}
+			event, err = decodeEvent(rawEvents[i], config)
+			if err != nil {
+				break
+			}
+
+			// an oversized field is reported on its own the same way an oversized event is
+			var field, size, limit = oversizedField(event, config)
+			if len(field) > 0 {
+				itemErrors = append(itemErrors, BatchItemError{
+					Index: i,
+					Details: []ValidationErrorDetail{
+						{
+							Field:   "/" + strings.ReplaceAll(field, ".", "/"),
+							Message: fmt.Sprintf("The field is %d bytes which is larger than the %d byte limit", size, limit),
+						},
+					},
+				})
+				continue
+			}
+
+			addRequestMetadata(event, metadata, config)
+			addRetentionDate(event, metadata.Received, config)
+			err = addEventId(event, metadata.Received, config)
+			if err != nil {
+				break
+			}
+
+			// the size is checked after the fields are encrypted since that is what is stored
+			err = config.Encryption.encryptEvent(event)
+			if err != nil {
+				break
+			}
+
+			// an oversized event would make mongo reject the whole insert
+			// so it is left out and reported on its own
+			var sizeError = checkEventSize(event, config)
+			if httpError, ok := sizeError.(mux.HttpError); ok {
+				itemErrors = append(itemErrors, BatchItemError{
+					Index: i,
+					Details: []ValidationErrorDetail{
+						{
+							Field:   "/",
+							Message: httpError.Description,
+						},
+					},
+				})
+				continue
+			}
+			err = sizeError
+
+			events = append(events, event)
+		}
+
+		// every event is written to the write ahead log before any of them are inserted
+		var wal = config.WriteAheadLog
+		var logged = make([]map[string]interface{}, 0, len(events))
+		for i := 0; err == nil && wal != nil && i < len(events); i++ {
+			var event = events[i].(map[string]interface{})
+			err = wal.Append(event)
+			if err == nil {
+				logged = append(logged, event)
+			}
+		}
+		// the events already in the log are removed since the client is told none of them were added
+		if err != nil {
+			for _, event := range logged {
+				wal.Ack(event)
+			}
+		}
+
+		var queued bool
+		if err == nil && len(events) > 0 {
+			// create a timed context to use when making requests to the db
+			var timedContext, timedContextCancel = context.WithTimeout(request.Context(), 10*time.Second)
+
+			var result *mongo.InsertManyResult
+			result, err = db.InsertMany(timedContext, events)
+			// close the context to release any resources associated with it
+			timedContextCancel()
+
+			// when the insert fails for a reason that might not last some of the events might already be in the database
+			// so they all stay in the log and Drain inserts the rest and skips the ones that are already there
+			for _, event := range logged {
+				if err == nil || isPermanentInsertError(err) {
+					wal.Ack(event)
+				} else {
+					wal.Release(event)
+				}
+			}
+
+			// the events are inserted once the database is reachable again
+			if wal != nil && isDbUnavailable(err) {
+				queued = true
+				err = nil
+			}
+
+			if err == nil && !queued && config.SinkWriter != nil {
+				var insertedEvents = make([]map[string]interface{}, 0, len(events))
+				for i, event := range events {
+					var insertedEvent = event.(map[string]interface{})
+					insertedEvent["_id"] = result.InsertedIDs[i]
+					insertedEvents = append(insertedEvents, insertedEvent)
+				}
+				config.SinkWriter.write(insertedEvents)
+			}
+		}
+
+		// events in the write ahead log have been accepted but are not in the database yet
+		if queued && len(itemErrors) == 0 {
+			writer.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		if err == nil && len(itemErrors) > 0 {
+			sort.Slice(itemErrors, func(i, j int) bool {
+				return itemErrors[i].Index < itemErrors[j].Index
+			})
+
+			var result = BatchResult{
+				Inserted: len(events),
+				Errors:   itemErrors,
+			}
+			if queued {
+				result.Inserted, result.Queued = 0, len(events)
+			}
+
+			mux.WriteJsonResponse(writer, result)
+			return
+		}
+
+		mux.WriteJsonResponse(writer, err)
+	})
+}
+
+// validate each event in a batch using the json schema
+// a BatchValidationError is returned listing the index of every event that failed validation
+func validateBatch(ctx context.Context, schema *jsonschema.Schema, rawEvents []json.RawMessage, config InsertConfig) error {
+	var itemErrors []BatchItemError
+
+	for i, rawEvent := range rawEvents {
+		var validationError, err = validateEvent(ctx, schema, rawEvent, config)
+		if err != nil {
+			return err
+		}
+
+		if len(validationError) > 0 {
+			itemErrors = append(itemErrors, BatchItemError{
+				Index:   i,
+				Details: validationError.Details(),
+			})
+		}
+	}
+
+	if len(itemErrors) > 0 {
+		return BatchValidationError{
+			Description: fmt.Sprintf("%d of the %d events did not match the expected format", len(itemErrors), len(rawEvents)),
+			Errors:      itemErrors,
+			statusCode:  config.invalidEventStatus(),
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var batchInvalidStatusError = "An unexpected status code was returned when attempting to add a batch of events " +
+	"Expected: %d, Got: %d"
+
+// an event that matches the event json schema
+var validEventJson = `{"timestamp":1649445988,"summary":"A customer was added","source":{"service_name":"customer-management"},"attributes":{}}`
+
+func TestEventsBulkAddHandlerValidationFailuresAtKnownIndices(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("invalid", func(mt *mtest.T) {
+		// events 1 and 3 are missing required fields
+		var body = "[" + strings.Join([]string{
+			validEventJson,
+			`{"summary":"A customer was added","source":{},"attributes":{}}`,
+			validEventJson,
+			`{"timestamp":1649445988,"summary":"","source":{},"attributes":{}}`,
+		}, ",") + "]"
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodPost, "/events/batch", strings.NewReader(body))
+		EventsBulkAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusBadRequest {
+			t.Fatalf(batchInvalidStatusError, http.StatusBadRequest, writer.Code)
+		}
+
+		var batchError BatchValidationError
+		json.Unmarshal(writer.Body.Bytes(), &batchError)
+
+		if len(batchError.Errors) != 2 || batchError.Errors[0].Index != 1 || batchError.Errors[1].Index != 3 {
+			t.Fatalf("The batch errors were not mapped to the invalid event indices Got: %s", writer.Body.String())
+		}
+
+		for _, itemError := range batchError.Errors {
+			if len(itemError.Details) == 0 || len(itemError.Details[0].Message) == 0 {
+				t.Errorf("The batch error for index %d did not include any details", itemError.Index)
+			}
+		}
+
+		if len(mt.GetAllStartedEvents()) != 0 {
+			t.Error("Events were inserted even though the batch failed validation")
+		}
+	})
+}
+
+func TestEventsBulkAddHandlerValidBatch(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("valid", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		var body = "[" + validEventJson + "," + validEventJson + "]"
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodPost, "/events/batch", strings.NewReader(body))
+		EventsBulkAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusNoContent {
+			t.Errorf(batchInvalidStatusError, http.StatusNoContent, writer.Code)
+		}
+	})
+}
+
+func TestEventsBulkAddHandlerNotAnArray(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("object", func(mt *mtest.T) {
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodPost, "/events/batch", strings.NewReader(validEventJson))
+		EventsBulkAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusBadRequest {
+			t.Errorf(batchInvalidStatusError, http.StatusBadRequest, writer.Code)
+		}
+	})
+}
+
+func TestEventsBulkAddHandlerOversizedEvent(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("oversized", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		var largeEventJson = `{"timestamp":1649445988,"summary":"` + strings.Repeat("a", 500) +
+			`","source":{"service_name":"customer-management"},"attributes":{}}`
+		var body = "[" + validEventJson + "," + largeEventJson + "," + validEventJson + "]"
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodPost, "/events/batch", strings.NewReader(body))
+		EventsBulkAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{MaxEventBytes: 256}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusMultiStatus {
+			t.Fatalf(batchInvalidStatusError, http.StatusMultiStatus, writer.Code)
+		}
+
+		var result BatchResult
+		json.Unmarshal(writer.Body.Bytes(), &result)
+
+		if result.Inserted != 2 || len(result.Errors) != 1 || result.Errors[0].Index != 1 {
+			t.Fatalf("The oversized event was not reported on its own Got: %s", writer.Body.String())
+		}
+
+		var insertEvent = mt.GetStartedEvent()
+		if insertEvent == nil || insertEvent.CommandName != "insert" {
+			t.Fatal("The events that were small enough were not inserted")
+		}
+		var documents, _ = insertEvent.Command.Lookup("documents").Array().Values()
+		if len(documents) != 2 {
+			t.Errorf("Expected 2 events to be inserted, Got: %d", len(documents))
+		}
+	})
+}
+
+func TestEventsBulkAddHandlerAllOversized(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("all oversized", func(mt *mtest.T) {
+		var body = "[" + validEventJson + "]"
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodPost, "/events/batch", strings.NewReader(body))
+		EventsBulkAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{MaxEventBytes: 10}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusMultiStatus {
+			t.Fatalf(batchInvalidStatusError, http.StatusMultiStatus, writer.Code)
+		}
+
+		if len(mt.GetAllStartedEvents()) != 0 {
+			t.Error("The database was called even though no events could be inserted")
+		}
+	})
+}
+
+func TestEventsBulkAddHandlerUnprocessableEntityMode(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("schema invalid", func(mt *mtest.T) {
+		var body = "[" + validEventJson + `,{"summary":"","source":{},"attributes":{}}]`
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodPost, "/events/batch", strings.NewReader(body))
+		EventsBulkAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{InvalidEventStatus: http.StatusUnprocessableEntity}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusUnprocessableEntity {
+			t.Errorf(batchInvalidStatusError, http.StatusUnprocessableEntity, writer.Code)
+		}
+	})
+}
+
+func TestEventsBulkAddHandlerPartialBatch(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("partial", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		// event 1 is missing required fields and event 3 is too large
+		var largeEventJson = `{"timestamp":1649445988,"summary":"` + strings.Repeat("a", 500) +
+			`","source":{"service_name":"customer-management"},"attributes":{}}`
+		var body = "[" + strings.Join([]string{
+			validEventJson,
+			`{"summary":"A customer was added","source":{},"attributes":{}}`,
+			validEventJson,
+			largeEventJson,
+		}, ",") + "]"
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodPost, "/events/batch", strings.NewReader(body))
+		EventsBulkAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{PartialBatches: true, MaxEventBytes: 256}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusMultiStatus {
+			t.Fatalf(batchInvalidStatusError, http.StatusMultiStatus, writer.Code)
+		}
+
+		var result BatchResult
+		json.Unmarshal(writer.Body.Bytes(), &result)
+
+		if result.Inserted != 2 || len(result.Errors) != 2 || result.Errors[0].Index != 1 || result.Errors[1].Index != 3 {
+			t.Fatalf("The invalid and oversized events were not reported by index Got: %s", writer.Body.String())
+		}
+
+		var documents, _ = mt.GetStartedEvent().Command.Lookup("documents").Array().Values()
+		if len(documents) != 2 {
+			t.Errorf("Expected 2 events to be inserted, Got: %d", len(documents))
+		}
+	})
+
+	mt.Run("all invalid", func(mt *mtest.T) {
+		var body = `[{"summary":"","source":{},"attributes":{}}]`
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodPost, "/events/batch", strings.NewReader(body))
+		EventsBulkAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{PartialBatches: true}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusBadRequest {
+			t.Errorf(batchInvalidStatusError, http.StatusBadRequest, writer.Code)
+		}
+	})
+}
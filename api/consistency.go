@@ -0,0 +1,54 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// how fresh the results of a query have to be
+const (
+	// the query can read from a secondary so it may miss the latest events
+	// but it takes load off the primary (i.e. for dashboards)
+	ConsistencyEventual = "eventual"
+	// the query reads from the primary and only sees events that a majority of the replica set has
+	// (i.e. for investigations)
+	ConsistencyStrong = "strong"
+)
+
+// the valid read consistencies
+var Consistencies = []string{ConsistencyEventual, ConsistencyStrong}
+
+// get the collection a query reads from using the read consistency in the consistency query param
+// the configured default consistency is used if the param is not provided
+// the collection is returned unchanged (using the read preference of the client) if neither is set
+func consistentCollection(db *mongo.Collection, queryParams url.Values, config QueryConfig) (*mongo.Collection, error) {
+	var consistency = config.DefaultConsistency
+	if queryParams.Has("consistency") {
+		consistency = queryParams.Get("consistency")
+	}
+
+	var collectionOptions = options.Collection()
+	switch consistency {
+	case "":
+		return db, nil
+	case ConsistencyEventual:
+		collectionOptions.SetReadPreference(readpref.SecondaryPreferred()).SetReadConcern(readconcern.Local())
+	case ConsistencyStrong:
+		collectionOptions.SetReadPreference(readpref.Primary()).SetReadConcern(readconcern.Majority())
+	default:
+		return nil, mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: fmt.Sprintf("The consistency query parameter must be one of %s", strings.Join(Consistencies, ", ")),
+		}
+	}
+
+	return db.Clone(collectionOptions)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// run a query and get the command that was sent to the db
+func queryCommand(t *testing.T, config QueryConfig, target string) (int, bson.Raw) {
+	var command bson.Raw
+	var code int
+
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("consistency", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt))
+
+		var writer = httptest.NewRecorder()
+		EventsQueryHandler(mt.Coll, config).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, target, nil))
+		code = writer.Code
+
+		var started = mt.GetStartedEvent()
+		if started != nil {
+			command = started.Command
+		}
+	})
+
+	return code, command
+}
+
+func TestQueryConsistencyReadPreference(t *testing.T) {
+	var tests = []struct {
+		target         string
+		config         QueryConfig
+		readPreference string
+		readConcern    string
+	}{
+		{"/events?consistency=eventual", QueryConfig{}, "secondaryPreferred", "local"},
+		{"/events?consistency=strong", QueryConfig{DefaultConsistency: ConsistencyEventual}, "primary", "majority"},
+		{"/events", QueryConfig{DefaultConsistency: ConsistencyEventual}, "secondaryPreferred", "local"},
+	}
+
+	for _, test := range tests {
+		var code, command = queryCommand(t, test.config, test.target)
+		if code != http.StatusOK {
+			t.Fatalf(queryInvalidStatusError, http.StatusOK, code)
+		}
+
+		// the mock db is a single server so the driver sends primaryPreferred for a primary read preference
+		var readPreference = command.Lookup("$readPreference", "mode").StringValue()
+		if readPreference == "primaryPreferred" {
+			readPreference = "primary"
+		}
+		if readPreference != test.readPreference {
+			t.Errorf("Expected %s to read with the %s read preference but got %s", test.target, test.readPreference, readPreference)
+		}
+
+		var level, _ = command.LookupErr("readConcern", "level")
+		if level.StringValue() != test.readConcern {
+			t.Errorf("Expected %s to read with the %s read concern but got %s", test.target, test.readConcern, level)
+		}
+	}
+}
+
+func TestQueryConsistencyInvalid(t *testing.T) {
+	var code, _ = queryCommand(t, QueryConfig{}, "/events?consistency=immediate")
+	if code != http.StatusBadRequest {
+		t.Errorf(queryInvalidStatusError, http.StatusBadRequest, code)
+	}
+}
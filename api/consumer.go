@@ -0,0 +1,236 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ConsumerWatermark is the position of a named consumer in the audit log
+// the watermark is the id of the last event the consumer has processed
+type ConsumerWatermark struct {
+	Consumer  string             `json:"consumer" bson:"_id"`
+	Watermark primitive.ObjectID `json:"watermark" bson:"watermark"`
+}
+
+// ConsumersHandler creates an http handler for the /consumers/<consumer>/... endpoints
+// /consumers/<consumer>/watermark stores (PUT) and retrieves (GET) the watermark of a consumer
+// /consumers/<consumer>/events retrieves (GET) the events after the watermark of a consumer
+// watermarks are stored in the consumers collection and events are read from the events collection
+func ConsumersHandler(events *mongo.Collection, consumers *mongo.Collection, config QueryConfig) http.Handler {
+	var watermarkRouter = mux.NewMethodRouter()
+	watermarkRouter.Handle(http.MethodGet, consumerWatermarkGetHandler(consumers))
+	watermarkRouter.Handle(http.MethodPut, consumerWatermarkPutHandler(consumers))
+
+	var eventsRouter = mux.NewMethodRouter()
+	eventsRouter.Handle(http.MethodGet, consumerEventsHandler(events, consumers, config))
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var _, resource = parseConsumerPath(request.URL.Path)
+
+		switch resource {
+		case "watermark":
+			watermarkRouter.ServeHTTP(writer, request)
+		case "events":
+			eventsRouter.ServeHTTP(writer, request)
+		default:
+			mux.WriteJsonResponse(writer, mux.DefaultHttpError(http.StatusNotFound))
+		}
+	})
+}
+
+// split a /consumers/<consumer>/<resource> path into the consumer name and resource
+// empty strings are returned if the path does not have that form
+func parseConsumerPath(path string) (string, string) {
+	var parts = strings.Split(strings.TrimPrefix(path, "/consumers/"), "/")
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		return "", ""
+	}
+
+	return parts[0], parts[1]
+}
+
+// get the stored watermark for a consumer
+// mongo.ErrNoDocuments is returned if the consumer has never stored a watermark
+func findWatermark(ctx context.Context, consumers *mongo.Collection, consumer string) (ConsumerWatermark, error) {
+	var watermark ConsumerWatermark
+
+	var err = consumers.FindOne(ctx, bson.M{"_id": consumer}).Decode(&watermark)
+
+	return watermark, err
+}
+
+// move the watermark of a consumer forward
+// $max is used so a watermark is never moved backwards by a stale update
+func advanceWatermark(ctx context.Context, consumers *mongo.Collection, consumer string, watermark primitive.ObjectID) error {
+	var _, err = consumers.UpdateOne(ctx,
+		bson.M{"_id": consumer},
+		bson.M{"$max": bson.M{"watermark": watermark}},
+		options.Update().SetUpsert(true))
+
+	return err
+}
+
+// create an http handler that retrieves the watermark of a consumer
+func consumerWatermarkGetHandler(consumers *mongo.Collection) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var consumer, _ = parseConsumerPath(request.URL.Path)
+
+		// create a timed context to use when making requests to the db
+		var timedContext, timedContextCancel = context.WithTimeout(request.Context(), 10*time.Second)
+		defer timedContextCancel()
+
+		var watermark, err = findWatermark(timedContext, consumers, consumer)
+		if err == mongo.ErrNoDocuments {
+			err = mux.HttpError{
+				Code:        http.StatusNotFound,
+				Description: fmt.Sprintf("consumer %s has not stored a watermark", consumer),
+			}
+		}
+
+		if err == nil {
+			mux.WriteJsonResponse(writer, watermark)
+		} else {
+			mux.WriteJsonResponse(writer, err)
+		}
+	})
+}
+
+// create an http handler that stores the watermark of a consumer
+// the body is a json object with the id of the last processed event (i.e. {"watermark":"62508ea4c4f0f7e1b5a3e6d1"})
+func consumerWatermarkPutHandler(consumers *mongo.Collection) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var consumer, _ = parseConsumerPath(request.URL.Path)
+
+		var body struct {
+			Watermark string `json:"watermark"`
+		}
+		var watermark primitive.ObjectID
+
+		var err = json.NewDecoder(request.Body).Decode(&body)
+		if err == nil {
+			watermark, err = primitive.ObjectIDFromHex(body.Watermark)
+		}
+		if err != nil {
+			err = mux.HttpError{
+				Code:        http.StatusBadRequest,
+				Description: "The request body must be a json object with a watermark that is an event id, only object ids can be used as a watermark",
+			}
+		}
+
+		if err == nil {
+			// create a timed context to use when making requests to the db
+			var timedContext, timedContextCancel = context.WithTimeout(request.Context(), 10*time.Second)
+
+			err = advanceWatermark(timedContext, consumers, consumer, watermark)
+			// close the context to release any resources associated with it
+			timedContextCancel()
+		}
+
+		mux.WriteJsonResponse(writer, err)
+	})
+}
+
+// create an http handler that retrieves the events after the watermark of a consumer
+// the events are returned in the order they were added and accept the same filters and limit as GET /events
+// a consumer that has not stored a watermark gets events from the start of the audit log
+// the watermark is not changed by this handler, the consumer moves it with PUT /consumers/<consumer>/watermark
+// once it has processed the events
+func consumerEventsHandler(events *mongo.Collection, consumers *mongo.Collection, config QueryConfig) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var consumer, _ = parseConsumerPath(request.URL.Path)
+
+		var queryParams = request.URL.Query()
+
+		// a GET request does not change any state so the watermark is moved with its own request
+		var err error
+		if queryParams.Has("advance") {
+			err = mux.HttpError{
+				Code:        http.StatusBadRequest,
+				Description: "The advance query parameter is not supported, the watermark is moved using PUT /consumers/{consumer}/watermark",
+			}
+		}
+
+		// the watermark replaces the keyset pagination options
+		if err == nil && (queryParams.Has("order") || queryParams.Has("sort") || queryParams.Has("after")) {
+			err = mux.HttpError{
+				Code:        http.StatusBadRequest,
+				Description: "The order, sort and after query parameters can not be used with a consumer watermark",
+			}
+		}
+
+		var filter map[string]interface{}
+		if err == nil {
+			filter, err = createEventFilter(request.Context(), queryParams, config)
+		}
+
+		var limit int64
+		if err == nil {
+			limit, err = parseLimit(queryParams, config)
+		}
+
+		// create a timed context to use when making requests to the db
+		var timedContext, timedContextCancel = context.WithTimeout(request.Context(), 10*time.Second)
+		defer timedContextCancel()
+
+		// only match events after the watermark
+		if err == nil {
+			var watermark ConsumerWatermark
+			watermark, err = findWatermark(timedContext, consumers, consumer)
+			if err == nil {
+				filter = bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$gt": watermark.Watermark}}}}
+			} else if err == mongo.ErrNoDocuments {
+				err = nil
+			}
+		}
+
+		// event ids start with the time the event was added so sorting by id returns the oldest events first
+		// ids are not strictly increasing across instances (i.e. two instances adding events in the same second)
+		// so an event can be added with an id below a watermark that was already stored
+		var findOptions = options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+		if limit > 0 {
+			findOptions.SetLimit(limit)
+		}
+
+		if err == nil {
+			err = config.CursorLimiter.acquire()
+			if err == nil {
+				defer config.CursorLimiter.release()
+			}
+		}
+
+		var cursor *mongo.Cursor
+		if err == nil {
+			cursor, err = events.Find(timedContext, filter, findOptions)
+		}
+
+		var results = make([]map[string]interface{}, 0)
+		if err == nil {
+			results, err = decodeCursor(timedContext, cursor, config)
+		}
+
+		if err == nil {
+			err = decryptResults(request, results, config)
+		}
+
+		var transforms = config.resultTransforms()
+		for i := 0; err == nil && i < len(results); i++ {
+			results[i] = transforms.Transform(results[i])
+		}
+
+		if err == nil {
+			mux.WriteJsonResponse(writer, results)
+		} else {
+			mux.WriteJsonResponse(writer, dbUnavailableError(writer, err, config))
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var consumerInvalidStatusError = "An unexpected status code was returned when attempting to use a consumer watermark " +
+	"Expected: %d, Got: %d"
+
+func TestConsumerEventsAfterWatermark(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("after watermark", func(mt *mtest.T) {
+		var watermark = primitive.NewObjectID()
+
+		mt.AddMockResponses(
+			// the stored watermark
+			mockCursorResponse(mt, bson.D{{Key: "_id", Value: "billing"}, {Key: "watermark", Value: watermark}}),
+			// the events after the watermark
+			mockCursorResponse(mt, bson.D{{Key: "_id", Value: primitive.NewObjectID()}}, bson.D{{Key: "_id", Value: primitive.NewObjectID()}}),
+		)
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/consumers/billing/events", nil)
+		ConsumersHandler(mt.Coll, mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(consumerInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		var results []map[string]interface{}
+		json.Unmarshal(writer.Body.Bytes(), &results)
+		if len(results) != 2 {
+			t.Fatalf(queryInvalidResultCountError, 2, len(results))
+		}
+
+		// the watermark lookup
+		mt.GetStartedEvent()
+
+		// only events after the watermark are requested
+		var findEvent = mt.GetStartedEvent()
+		var gt, err = findEvent.Command.LookupErr("filter", "$and", "1", "_id", "$gt")
+		if err != nil || gt.ObjectID() != watermark {
+			t.Errorf("The events were not filtered using the consumer watermark Got: %s", findEvent.Command)
+		}
+
+		// reading events never moves the watermark
+		if mt.GetStartedEvent() != nil {
+			t.Error("The consumer watermark was changed by a GET request")
+		}
+	})
+}
+
+func TestConsumerEventsAdvanceRejected(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("advance", func(mt *mtest.T) {
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/consumers/billing/events?advance=true", nil)
+		ConsumersHandler(mt.Coll, mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusBadRequest {
+			t.Errorf(consumerInvalidStatusError, http.StatusBadRequest, writer.Code)
+		}
+	})
+}
+
+func TestConsumerEventsWithoutWatermark(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("no watermark", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			// the consumer has not stored a watermark
+			mockCursorResponse(mt),
+			mockCursorResponse(mt, bson.D{{Key: "_id", Value: primitive.NewObjectID()}}),
+		)
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/consumers/billing/events", nil)
+		ConsumersHandler(mt.Coll, mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(consumerInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		var results []map[string]interface{}
+		json.Unmarshal(writer.Body.Bytes(), &results)
+		if len(results) != 1 {
+			t.Errorf(queryInvalidResultCountError, 1, len(results))
+		}
+
+		// the watermark is not stored by reading events
+		mt.GetStartedEvent()
+		mt.GetStartedEvent()
+		if mt.GetStartedEvent() != nil {
+			t.Error("The consumer watermark was stored by a GET request")
+		}
+	})
+}
+
+func TestConsumerWatermarkPutAndGet(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("put", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		var watermark = primitive.NewObjectID()
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodPut, "/consumers/billing/watermark",
+			strings.NewReader(`{"watermark":"`+watermark.Hex()+`"}`))
+		ConsumersHandler(mt.Coll, mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusNoContent {
+			t.Errorf(consumerInvalidStatusError, http.StatusNoContent, writer.Code)
+		}
+	})
+
+	mt.Run("get", func(mt *mtest.T) {
+		var watermark = primitive.NewObjectID()
+		mt.AddMockResponses(mockCursorResponse(mt, bson.D{{Key: "_id", Value: "billing"}, {Key: "watermark", Value: watermark}}))
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/consumers/billing/watermark", nil)
+		ConsumersHandler(mt.Coll, mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(consumerInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		var expectedBody = `{"consumer":"billing","watermark":"` + watermark.Hex() + `"}`
+		if writer.Body.String() != expectedBody {
+			t.Errorf("An unexpected watermark was returned Expected: %s, Got: %s", expectedBody, writer.Body.String())
+		}
+	})
+
+	mt.Run("missing", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt))
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/consumers/billing/watermark", nil)
+		ConsumersHandler(mt.Coll, mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusNotFound {
+			t.Errorf(consumerInvalidStatusError, http.StatusNotFound, writer.Code)
+		}
+
+		if !strings.Contains(writer.Body.String(), "consumer billing") {
+			t.Errorf("The response body does not name the consumer: %s", writer.Body.String())
+		}
+	})
+
+	mt.Run("invalid", func(mt *mtest.T) {
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodPut, "/consumers/billing/watermark", strings.NewReader(`{"watermark":"nope"}`))
+		ConsumersHandler(mt.Coll, mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusBadRequest {
+			t.Errorf(consumerInvalidStatusError, http.StatusBadRequest, writer.Code)
+		}
+	})
+}
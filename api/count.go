@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CountResult is the number of events that match a filter
+type CountResult struct {
+	Count int64 `json:"count"`
+}
+
+// EventsCountHandler creates an http handler that counts the events matching the filter
+// the query params filter the events the same way GET /events does
+// i.e. /events/count?source.service_name=billing-service returns {"count":10}
+// reserved query params that only change the results (i.e. limit and sort) are ignored
+func EventsCountHandler(db *mongo.Collection, config QueryConfig) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var queryParams = request.URL.Query()
+
+		var filter, err = createEventFilter(request.Context(), queryParams, config)
+
+		// counting every event scans the whole collection just like querying every event
+		if err == nil {
+			err = checkEmptyFilter(filter, queryParams, config)
+		}
+
+		var collection *mongo.Collection
+		if err == nil {
+			collection, err = consistentCollection(db, queryParams, config)
+		}
+
+		var timedContext, timedContextCancel = context.WithTimeout(request.Context(), config.queryTimeout())
+		defer timedContextCancel()
+
+		// the count is 0 rather than an error if nothing matches
+		var result CountResult
+		if err == nil {
+			result.Count, err = collection.CountDocuments(timedContext, filter)
+		}
+
+		if err == nil {
+			mux.WriteJsonResponse(writer, result)
+		} else {
+			mux.WriteJsonResponse(writer, dbUnavailableError(writer, err, config))
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var countInvalidStatusError = "An unexpected status code was returned when attempting to count events " +
+	"Expected: %d, Got: %d"
+
+func TestEventsCountHandler(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("count", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt, bson.D{{Key: "n", Value: int32(10)}}))
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/events/count?source.service_name=billing-service&limit=5", nil)
+		EventsCountHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(countInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		if writer.Body.String() != `{"count":10}` {
+			t.Errorf("An unexpected count was returned Got: %s", writer.Body.String())
+		}
+
+		var countEvent = mt.GetStartedEvent()
+		var match, err = countEvent.Command.LookupErr("pipeline", "0", "$match", "source.service_name")
+		if err != nil || match.StringValue() != "billing-service" {
+			t.Errorf("The events were not filtered using the query params Got: %s", countEvent.Command)
+		}
+
+		_, err = countEvent.Command.LookupErr("pipeline", "0", "$match", "limit")
+		if err == nil {
+			t.Errorf("A reserved query param was used as a filter Got: %s", countEvent.Command)
+		}
+	})
+}
+
+func TestEventsCountHandlerNoMatches(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("no matches", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt))
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/events/count?summary=missing", nil)
+		EventsCountHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(countInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		if writer.Body.String() != `{"count":0}` {
+			t.Errorf("An unexpected count was returned Got: %s", writer.Body.String())
+		}
+	})
+}
+
+func TestEventsCountHandlerInvalidFilter(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("invalid filter", func(mt *mtest.T) {
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/events/count?_id[gt]=abc", nil)
+		EventsCountHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusBadRequest {
+			t.Errorf(countInvalidStatusError, http.StatusBadRequest, writer.Code)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/qri-io/jsonschema"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// media type used to request query results as csv
+const csvMediaType = "text/csv"
+
+// CsvColumnsFromSchema creates the ordered list of csv columns used when exporting events
+// the columns come from the properties declared in the event json schema (rather than the keys
+// of whichever events happen to be returned) so exports have the same columns across runs
+// nested objects that declare their own properties are flattened into dot separated columns
+// and the mongo _id is always the first column
+func CsvColumnsFromSchema(schema *jsonschema.Schema) ([]string, error) {
+	var description, err = describeSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var columns = []string{"_id"}
+	columns = appendSchemaColumns(columns, "", description)
+
+	return columns, nil
+}
+
+// add a column for each property of the schema
+// recursing into object properties that declare properties of their own
+func appendSchemaColumns(columns []string, prefix string, description schemaDescription) []string {
+	for _, name := range description.propertyNames() {
+		var property = description.Properties[name]
+
+		if len(property.Properties) > 0 {
+			columns = appendSchemaColumns(columns, prefix+name+".", property)
+		} else {
+			columns = append(columns, prefix+name)
+		}
+	}
+
+	return columns
+}
+
+// write the events as a csv document with one row per event
+// fields that an event does not have become empty cells
+func writeCsvResponse(writer http.ResponseWriter, columns []string, events []map[string]interface{}) {
+	var buf bytes.Buffer
+	var csvWriter = csv.NewWriter(&buf)
+
+	csvWriter.Write(columns)
+
+	for _, event := range events {
+		var row = make([]string, len(columns))
+		for i, column := range columns {
+			row[i] = csvCell(lookupField(event, column))
+		}
+
+		csvWriter.Write(row)
+	}
+
+	csvWriter.Flush()
+
+	writer.Header().Set("Content-Type", csvMediaType)
+	writer.Header().Set("Content-Length", fmt.Sprintf("%d", buf.Len()))
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(buf.Bytes())
+}
+
+// get the value of a dot separated field path from an event
+// nil is returned if the event does not have the field
+func lookupField(event map[string]interface{}, path string) interface{} {
+	var value interface{} = event
+
+	for _, name := range strings.Split(path, ".") {
+		var object, ok = value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		value = object[name]
+	}
+
+	return value
+}
+
+// format a field value as a csv cell
+// strings are written as is and everything else is written as json
+func csvCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case primitive.ObjectID:
+		return v.Hex()
+	}
+
+	var d, _ = json.Marshal(value)
+
+	return string(d)
+}
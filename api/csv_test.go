@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/qri-io/jsonschema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCsvColumnsFromSchema(t *testing.T) {
+	var columns, err = CsvColumnsFromSchema(loadTestSchema(t))
+	if err != nil {
+		t.Fatalf("An unexpected error occured while reading csv columns from the schema: %s", err)
+	}
+
+	// the event schema properties in sorted order after the _id column
+	var expectedColumns = []string{"_id", "attributes", "source", "summary", "timestamp"}
+	if strings.Join(columns, ",") != strings.Join(expectedColumns, ",") {
+		t.Errorf("The csv columns did not match the schema properties Expected: %v, Got: %v", expectedColumns, columns)
+	}
+}
+
+func TestCsvColumnsFromSchemaNestedProperties(t *testing.T) {
+	var schema jsonschema.Schema
+	json.Unmarshal([]byte(`{"type":"object","properties":{"summary":{"type":"string"},"actor":{"type":"object","properties":{"name":{"type":"string"},"id":{"type":"string"}}}}}`), &schema)
+
+	var columns, _ = CsvColumnsFromSchema(&schema)
+
+	var expectedColumns = []string{"_id", "actor.id", "actor.name", "summary"}
+	if strings.Join(columns, ",") != strings.Join(expectedColumns, ",") {
+		t.Errorf("The nested schema properties were not flattened into columns Expected: %v, Got: %v", expectedColumns, columns)
+	}
+}
+
+func TestEventsQueryHandlerCsv(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("csv", func(mt *mtest.T) {
+		// the second event is missing the source field
+		mt.AddMockResponses(mockCursorResponse(mt,
+			bson.D{{Key: "summary", Value: "one"}, {Key: "timestamp", Value: 1}, {Key: "source", Value: bson.D{{Key: "service_name", Value: "billing"}}}},
+			bson.D{{Key: "summary", Value: "two"}, {Key: "timestamp", Value: 2}},
+		))
+
+		var request = httptest.NewRequest(http.MethodGet, "/events", nil)
+		request.Header.Set("Accept", "text/csv")
+
+		var writer = httptest.NewRecorder()
+		EventsQueryHandler(mt.Coll, QueryConfig{
+			CsvColumns: []string{"source.service_name", "summary", "timestamp"},
+		}).ServeHTTP(writer, request)
+
+		if writer.Header().Get("Content-Type") != "text/csv" {
+			t.Errorf("An unexpected content type was returned Expected: text/csv, Got: %s", writer.Header().Get("Content-Type"))
+		}
+
+		var expectedBody = "source.service_name,summary,timestamp\nbilling,one,1\n,two,2\n"
+		if writer.Body.String() != expectedBody {
+			t.Errorf("An unexpected csv body was returned Expected: %q, Got: %q", expectedBody, writer.Body.String())
+		}
+	})
+}
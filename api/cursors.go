@@ -0,0 +1,64 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+)
+
+// the error returned when every cursor slot is in use
+// it is sent to the user as a 503 by dbUnavailableError
+var errCursorLimit = errors.New("Too many queries are reading from the database")
+
+// CursorLimiter limits how many query cursors can be open at once
+// each open cursor holds a connection from the db client pool for as long as its results are read
+// so too many long queries would leave no connections for anything else
+// a nil CursorLimiter does not limit the cursors
+type CursorLimiter struct {
+	slots chan struct{}
+}
+
+// create a cursor limiter that allows at most max cursors to be open at once
+func NewCursorLimiter(max int) *CursorLimiter {
+	return &CursorLimiter{
+		slots: make(chan struct{}, max),
+	}
+}
+
+// take a cursor slot without waiting for one
+// errCursorLimit is returned if every slot is in use
+// release must be called once the cursor is closed if no error is returned
+func (self *CursorLimiter) acquire() error {
+	if self == nil {
+		return nil
+	}
+
+	select {
+	case self.slots <- struct{}{}:
+		return nil
+	default:
+		return errCursorLimit
+	}
+}
+
+// give back a cursor slot taken by acquire
+func (self *CursorLimiter) release() {
+	if self == nil {
+		return
+	}
+
+	<-self.slots
+}
+
+// number of cursors that are open
+func (self *CursorLimiter) Open() int {
+	if self == nil {
+		return 0
+	}
+
+	return len(self.slots)
+}
+
+// the number of open cursors so the limiter can be published with expvar
+func (self *CursorLimiter) String() string {
+	return strconv.Itoa(self.Open())
+}
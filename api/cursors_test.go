@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCursorLimiterSaturated(t *testing.T) {
+	var limiter = NewCursorLimiter(2)
+
+	for i := 0; i < 2; i++ {
+		if limiter.acquire() != nil {
+			t.Fatalf("Cursor slot %d could not be taken", i+1)
+		}
+	}
+
+	if limiter.acquire() != errCursorLimit {
+		t.Error("A cursor slot was taken beyond the limit")
+	}
+
+	if limiter.String() != "2" {
+		t.Errorf("Expected 2 open cursors but got %s", limiter)
+	}
+
+	limiter.release()
+	if limiter.acquire() != nil {
+		t.Error("A released cursor slot could not be taken again")
+	}
+}
+
+func TestEventsQueryHandlerCursorLimit(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("cursor limit", func(mt *mtest.T) {
+		var limiter = NewCursorLimiter(1)
+		var handler = EventsQueryHandler(mt.Coll, QueryConfig{CursorLimiter: limiter})
+
+		// another query is holding the only cursor
+		limiter.acquire()
+
+		var writer = httptest.NewRecorder()
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/events", nil))
+
+		if writer.Code != http.StatusServiceUnavailable {
+			t.Errorf(queryInvalidStatusError, http.StatusServiceUnavailable, writer.Code)
+		}
+
+		if writer.Header().Get("Retry-After") != "5" {
+			t.Errorf("Expected a Retry-After of 5 but got %q", writer.Header().Get("Retry-After"))
+		}
+
+		if len(mt.GetAllStartedEvents()) != 0 {
+			t.Error("The query was sent to the database while every cursor slot was in use")
+		}
+
+		// the slot is free once the other query is done
+		limiter.release()
+		mt.AddMockResponses(mockCursorResponse(mt))
+
+		writer = httptest.NewRecorder()
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/events", nil))
+
+		if writer.Code != http.StatusOK {
+			t.Errorf(queryInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		if limiter.Open() != 0 {
+			t.Errorf("The cursor slot was not released after the query Got: %d open", limiter.Open())
+		}
+	})
+}
+
+func TestEventsAggregateHandlerCursorLimit(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("cursor limit", func(mt *mtest.T) {
+		var limiter = NewCursorLimiter(1)
+		limiter.acquire()
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/events/aggregate?group_by=summary", nil)
+		EventsAggregateHandler(mt.Coll, QueryConfig{CursorLimiter: limiter}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusServiceUnavailable {
+			t.Errorf(aggregateInvalidStatusError, http.StatusServiceUnavailable, writer.Code)
+		}
+	})
+}
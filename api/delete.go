@@ -0,0 +1,211 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"github.com/qri-io/jsonschema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// number of events deleted at a time when no batch size is configured
+const DefaultDeleteBatchSize = 1000
+
+// DeleteProgress reports how far a bulk delete has got
+// LastId is the id of the last event in the last chunk that was deleted
+// it is left out until a chunk has been deleted
+type DeleteProgress struct {
+	Deleted int64       `json:"deleted"`
+	Chunks  int         `json:"chunks"`
+	LastId  interface{} `json:"last_id,omitempty"`
+	Done    bool        `json:"done"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// the json body of a bulk delete request
+type bulkDeleteRequest struct {
+	// the same filter object that POST /events/query accepts
+	Filter json.RawMessage `json:"filter"`
+	// number of events deleted at a time
+	// 0 means the configured batch size
+	BatchSize int64 `json:"batch_size"`
+}
+
+// EventsBulkDeleteHandler creates an http handler that deletes every event matching a filter (i.e. to redact them)
+// the body is a json object with the filter and an optional batch size
+// i.e. {"filter":{"source.service_name":"billing-service"},"batch_size":500}
+// the events are deleted in chunks of ids in the order they were added so no single delete can lock the
+// collection or run into the timeout and the delete stops between chunks if the client goes away
+// progress is streamed back as newline delimited json after every chunk and the last line has done set to true
+// if the delete fails the last line holds the error and the events deleted so far stay deleted
+func EventsBulkDeleteHandler(db *mongo.Collection, batchSize int64, config QueryConfig) http.Handler {
+	if batchSize <= 0 {
+		batchSize = DefaultDeleteBatchSize
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var filter, requestBatchSize, err = parseBulkDeleteRequest(request, config.Schema)
+		if err != nil {
+			mux.WriteJsonResponse(writer, err)
+			return
+		}
+
+		if requestBatchSize <= 0 {
+			requestBatchSize = batchSize
+		}
+
+		// a bulk delete can take much longer than a query so it only stops if the client goes away
+		var ctx = request.Context()
+
+		var stream = mux.NewNdjsonStream(writer)
+		var progress DeleteProgress
+
+		// the id after which the next chunk is found
+		// nil finds the next chunk from the start of the matching events
+		var after interface{}
+		// if any events were deleted since the matching events were last searched from the start
+		var deletedSinceStart bool
+
+		for ctx.Err() == nil {
+			var ids []interface{}
+			ids, err = findDeleteChunk(ctx, db, filter, after, requestBatchSize, config)
+			if err != nil {
+				break
+			}
+
+			if len(ids) > 0 {
+				// the filter is applied again so an event that changed since the chunk was found is not deleted
+				var chunkFilter = map[string]interface{}{
+					"$and": []interface{}{
+						filter,
+						map[string]interface{}{"_id": map[string]interface{}{"$in": ids}},
+					},
+				}
+
+				var timedContext, timedContextCancel = context.WithTimeout(ctx, config.queryTimeout())
+				var result *mongo.DeleteResult
+				result, err = db.DeleteMany(timedContext, chunkFilter)
+				timedContextCancel()
+				if err != nil {
+					break
+				}
+
+				progress.Deleted += result.DeletedCount
+				progress.Chunks++
+				progress.LastId = ids[len(ids)-1]
+				deletedSinceStart = deletedSinceStart || result.DeletedCount > 0
+
+				stream.Write(progress)
+				stream.Flush()
+			}
+
+			if int64(len(ids)) == requestBatchSize {
+				after = ids[len(ids)-1]
+				continue
+			}
+
+			// a short chunk means there are no more matching events after the last id
+			// but ids are only compared with ids of the same type (i.e. client supplied string ids)
+			// so the events with another type of id are found by searching from the start again
+			// which only has the events that have not been deleted yet
+			if after == nil || !deletedSinceStart {
+				break
+			}
+
+			after = nil
+			deletedSinceStart = false
+		}
+
+		if err == nil {
+			err = ctx.Err()
+		}
+
+		if err == nil {
+			progress.Done = true
+		} else {
+			if config.Logger != nil {
+				config.Logger.Printf("An error occured while deleting events: %s\n", err)
+			}
+
+			progress.Error = err.Error()
+		}
+
+		stream.Write(progress)
+		stream.Close()
+	})
+}
+
+// find the ids of the next chunk of matching events after the last id that was deleted
+// the ids are decoded as they are stored so events with any type of id can be deleted
+func findDeleteChunk(ctx context.Context, db *mongo.Collection, filter map[string]interface{}, after interface{},
+	batchSize int64, config QueryConfig) ([]interface{}, error) {
+	var chunkFilter interface{} = filter
+	if after != nil {
+		chunkFilter = map[string]interface{}{
+			"$and": []interface{}{
+				filter,
+				map[string]interface{}{"_id": map[string]interface{}{"$gt": after}},
+			},
+		}
+	}
+
+	var findOptions = options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(batchSize).
+		SetProjection(bson.D{{Key: "_id", Value: 1}})
+
+	var timedContext, timedContextCancel = context.WithTimeout(ctx, config.queryTimeout())
+	defer timedContextCancel()
+
+	var cursor, err = db.Find(timedContext, chunkFilter, findOptions)
+
+	var documents []struct {
+		Id interface{} `bson:"_id"`
+	}
+	if err == nil {
+		err = cursor.All(timedContext, &documents)
+	}
+
+	var ids = make([]interface{}, 0, len(documents))
+	for _, document := range documents {
+		ids = append(ids, document.Id)
+	}
+
+	return ids, err
+}
+
+// read the bulk delete request body and get the filter and batch size
+// the filter has to match on at least one field so a missing filter can not delete every event
+func parseBulkDeleteRequest(request *http.Request, schema *jsonschema.Schema) (map[string]interface{}, int64, error) {
+	var d, err = ioutil.ReadAll(request.Body)
+	if err != nil {
+		return nil, 0, mux.DefaultHttpError(http.StatusBadRequest)
+	}
+
+	var body bulkDeleteRequest
+	err = json.Unmarshal(d, &body)
+	if err != nil {
+		return nil, 0, queryBodyError("The request body must be a json object")
+	}
+
+	if body.BatchSize < 0 {
+		return nil, 0, queryBodyError("The batch_size value must be a positive integer")
+	}
+
+	var filter map[string]interface{}
+	filter, err = filterFromJson(body.Filter, schema)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(filter) == 0 {
+		return nil, 0, queryBodyError("The filter must match on at least one field")
+	}
+
+	return filter, body.BatchSize, nil
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// mock the responses of deleting the seeded ids in chunks
+// each chunk is a find returning the ids in the chunk followed by a delete
+// and the last find searches from the start for events with another type of id
+func seedDeleteChunks(mt *mtest.T, count int, batchSize int) {
+	for start := 0; start < count; start += batchSize {
+		var size = batchSize
+		if count-start < size {
+			size = count - start
+		}
+
+		var documents = make([]bson.D, size)
+		for i := range documents {
+			documents[i] = bson.D{{Key: "_id", Value: primitive.NewObjectID()}}
+		}
+
+		mt.AddMockResponses(
+			mockCursorResponse(mt, documents...),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: size}),
+		)
+	}
+
+	mt.AddMockResponses(mockCursorResponse(mt))
+}
+
+// count the delete commands sent to the db
+func countDeletes(mt *mtest.T) int {
+	var deletes = 0
+	for _, event := range mt.GetAllStartedEvents() {
+		if event.CommandName == "delete" {
+			deletes++
+		}
+	}
+
+	return deletes
+}
+
+func TestEventsBulkDeleteHandlerDeletesInChunks(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("chunks", func(mt *mtest.T) {
+		seedDeleteChunks(mt, 250, 100)
+
+		var body = `{"filter":{"source.service_name":"billing"},"batch_size":100}`
+
+		var writer = httptest.NewRecorder()
+		var handler = EventsBulkDeleteHandler(mt.Coll, 0, QueryConfig{})
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/admin/delete", strings.NewReader(body)))
+
+		var reports = strings.Split(strings.TrimSpace(writer.Body.String()), "\n")
+		// one report for each chunk and the final report
+		if len(reports) != 4 {
+			t.Errorf("Expected 4 progress reports but got %d Got: %s", len(reports), writer.Body.String())
+		}
+
+		var last = reports[len(reports)-1]
+		if !strings.Contains(last, `"deleted":250`) || !strings.Contains(last, `"chunks":3`) || !strings.Contains(last, `"done":true`) {
+			t.Errorf("An unexpected final progress report was returned Got: %s", last)
+		}
+
+		if deletes := countDeletes(mt); deletes != 3 {
+			t.Errorf("Expected the events to be deleted in 3 chunks but %d deletes were sent", deletes)
+		}
+	})
+}
+
+// response writer that cancels the request once the first progress report is written
+type cancellingWriter struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (self cancellingWriter) Write(d []byte) (int, error) {
+	self.cancel()
+	return self.ResponseRecorder.Write(d)
+}
+
+func TestEventsBulkDeleteHandlerStopsWhenCancelled(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("cancelled", func(mt *mtest.T) {
+		seedDeleteChunks(mt, 300, 100)
+
+		var ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+
+		var body = `{"filter":{"source.service_name":"billing"},"batch_size":100}`
+		var request = httptest.NewRequest(http.MethodPost, "/admin/delete", strings.NewReader(body)).WithContext(ctx)
+
+		var writer = cancellingWriter{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}
+		EventsBulkDeleteHandler(mt.Coll, 0, QueryConfig{}).ServeHTTP(writer, request)
+
+		if deletes := countDeletes(mt); deletes != 1 {
+			t.Errorf("Expected the delete to stop after 1 chunk but %d deletes were sent", deletes)
+		}
+
+		var reports = strings.Split(strings.TrimSpace(writer.Body.String()), "\n")
+		var last = reports[len(reports)-1]
+		if strings.Contains(last, `"done":true`) || !strings.Contains(last, `"deleted":100`) {
+			t.Errorf("The final progress report did not show the delete stopped partway Got: %s", last)
+		}
+	})
+}
+
+func TestEventsBulkDeleteHandlerRequiresFilter(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("no filter", func(mt *mtest.T) {
+		var writer = httptest.NewRecorder()
+		EventsBulkDeleteHandler(mt.Coll, 0, QueryConfig{}).ServeHTTP(writer,
+			httptest.NewRequest(http.MethodPost, "/admin/delete", strings.NewReader(`{}`)))
+
+		if writer.Code != http.StatusBadRequest {
+			t.Errorf(queryInvalidStatusError, http.StatusBadRequest, writer.Code)
+		}
+		if len(mt.GetAllStartedEvents()) != 0 {
+			t.Error("The db was sent a command for a delete without a filter")
+		}
+	})
+}
+
+func TestEventsBulkDeleteHandlerMixedIdTypes(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("mixed ids", func(mt *mtest.T) {
+		var objectId = primitive.NewObjectID()
+
+		mt.AddMockResponses(
+			// a client supplied string id sorts before the object ids
+			mockCursorResponse(mt, bson.D{{Key: "_id", Value: "client-id-1"}}, bson.D{{Key: "_id", Value: "client-id-2"}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}),
+			// the string ids are not compared with the object ids so the object ids are only found from the start
+			mockCursorResponse(mt),
+			mockCursorResponse(mt, bson.D{{Key: "_id", Value: objectId}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		var body = `{"filter":{"source.service_name":"billing"},"batch_size":2}`
+
+		var writer = httptest.NewRecorder()
+		EventsBulkDeleteHandler(mt.Coll, 0, QueryConfig{}).ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/admin/delete", strings.NewReader(body)))
+
+		var reports = strings.Split(strings.TrimSpace(writer.Body.String()), "\n")
+		var last = reports[len(reports)-1]
+		if !strings.Contains(last, `"deleted":3`) || !strings.Contains(last, `"done":true`) {
+			t.Fatalf("Not every event was deleted Got: %s", writer.Body.String())
+		}
+
+		var finds []bson.Raw
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "find" {
+				finds = append(finds, event.Command)
+			}
+		}
+
+		// the next chunk is found after the raw string id
+		var after, err = finds[1].LookupErr("filter", "$and", "1", "_id", "$gt")
+		if err != nil || after.StringValue() != "client-id-2" {
+			t.Errorf("The next chunk was not found after the last string id Got: %s", finds[1])
+		}
+
+		if !strings.Contains(reports[0], `"last_id":"client-id-2"`) {
+			t.Errorf("The last string id was not reported Got: %s", reports[0])
+		}
+	})
+}
@@ -0,0 +1,308 @@
+package api
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mitchellkelly/auditlog/mux"
+)
+
+// start of every encrypted field value so encrypted values can be told apart from plain strings
+const encryptedValuePrefix = "enc:v1:"
+
+// FieldEncryption encrypts fields of events (i.e. ones holding PII) with AES-GCM before they are stored
+// and decrypts them in query results for the callers that are allowed to see them
+// a value is encrypted as its json encoding and stored as a string
+// so any type of value can be encrypted and it is decoded back to the same type
+type FieldEncryption struct {
+	// dot separated paths of the fields that are encrypted (i.e. attributes.customer_name)
+	Fields []string
+	// when Deterministic is true the same value is always encrypted to the same string
+	// so encrypted fields can still be filtered on for equality
+	// this reveals which events share a value so it is off by default
+	Deterministic bool
+	// names of the tokens (i.e. api) whose queries get decrypted values
+	// other callers get the encrypted values
+	// nil means no caller gets decrypted values
+	DecryptTokens []string
+	aead          cipher.AEAD
+	// key used to derive the nonce of deterministically encrypted values
+	nonceKey []byte
+}
+
+// create a field encryption using a 16, 24 or 32 byte AES key
+func NewFieldEncryption(key []byte, fields []string, deterministic bool, decryptTokens []string) (*FieldEncryption, error) {
+	var block, err = aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var aead cipher.AEAD
+	aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// the nonce key is derived from the key so a nonce never reveals the key itself
+	var mac = hmac.New(sha256.New, key)
+	mac.Write([]byte("auditlog field encryption nonce"))
+
+	return &FieldEncryption{
+		Fields:        fields,
+		Deterministic: deterministic,
+		DecryptTokens: decryptTokens,
+		aead:          aead,
+		nonceKey:      mac.Sum(nil),
+	}, nil
+}
+
+// encrypt a value as its json encoding
+func (self *FieldEncryption) encryptValue(value interface{}) (string, error) {
+	var plaintext, err = json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	var nonce = make([]byte, self.aead.NonceSize())
+	if self.Deterministic {
+		// the nonce is derived from the value so the same value always gives the same ciphertext
+		var mac = hmac.New(sha256.New, self.nonceKey)
+		mac.Write(plaintext)
+		copy(nonce, mac.Sum(nil))
+	} else {
+		_, err = rand.Read(nonce)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var sealed = self.aead.Seal(nonce, nonce, plaintext, nil)
+
+	return encryptedValuePrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt a value created by encryptValue
+func (self *FieldEncryption) decryptValue(encrypted string) (interface{}, error) {
+	var sealed, err = base64.RawURLEncoding.DecodeString(strings.TrimPrefix(encrypted, encryptedValuePrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < self.aead.NonceSize() {
+		return nil, fmt.Errorf("the encrypted value is too short")
+	}
+
+	var plaintext []byte
+	plaintext, err = self.aead.Open(nil, sealed[:self.aead.NonceSize()], sealed[self.aead.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	err = json.Unmarshal(plaintext, &value)
+
+	return value, err
+}
+
+// get the object holding the field at a dot separated path and the name of the field in it
+// nil is returned if the event does not have an object at the path
+func fieldParent(event map[string]interface{}, path string) (map[string]interface{}, string) {
+	var names = strings.Split(path, ".")
+
+	var parent = event
+	for _, name := range names[:len(names)-1] {
+		var object, ok = parent[name].(map[string]interface{})
+		if !ok {
+			return nil, ""
+		}
+
+		parent = object
+	}
+
+	return parent, names[len(names)-1]
+}
+
+// encrypt the configured fields of an event before it is stored
+// fields the event does not have (or that are null) are left out
+func (self *FieldEncryption) encryptEvent(event map[string]interface{}) error {
+	if self == nil {
+		return nil
+	}
+
+	for _, path := range self.Fields {
+		var parent, name = fieldParent(event, path)
+		if parent == nil || parent[name] == nil {
+			continue
+		}
+
+		var encrypted, err = self.encryptValue(parent[name])
+		if err != nil {
+			return err
+		}
+
+		parent[name] = encrypted
+	}
+
+	return nil
+}
+
+// decrypt the configured fields of a stored event
+// values that were not encrypted (i.e. events stored before the field was encrypted) are left as they are
+func (self *FieldEncryption) decryptEvent(event map[string]interface{}) error {
+	if self == nil {
+		return nil
+	}
+
+	for _, path := range self.Fields {
+		var parent, name = fieldParent(event, path)
+		if parent == nil {
+			continue
+		}
+
+		var encrypted, ok = parent[name].(string)
+		if !ok || !strings.HasPrefix(encrypted, encryptedValuePrefix) {
+			continue
+		}
+
+		var value, err = self.decryptValue(encrypted)
+		if err != nil {
+			return fmt.Errorf("The %s field could not be decrypted: %s", path, err)
+		}
+
+		parent[name] = value
+	}
+
+	return nil
+}
+
+// check if the caller of a request is allowed to see decrypted values
+func (self *FieldEncryption) canDecrypt(request *http.Request) bool {
+	return containsField(self.DecryptTokens, mux.TokenName(request))
+}
+
+// prepare a filter created from the query params for the encrypted fields
+// with deterministic encryption equality filters on encrypted fields are encrypted so they match the stored values
+// otherwise encrypted fields can not be filtered on and the query is rejected with a 400
+func (self *FieldEncryption) encryptFilter(filter map[string]interface{}) error {
+	if self == nil {
+		return nil
+	}
+
+	for _, path := range self.Fields {
+		// a field filtered on more than once has its range comparisons added with $and
+		var value, ok = filter[path]
+		var inAnd = filtersField(filter, path)
+		if !ok && !inAnd {
+			continue
+		}
+
+		if !self.Deterministic {
+			return mux.HttpError{
+				Code:        http.StatusBadRequest,
+				Description: fmt.Sprintf("The %s field is encrypted so it can not be filtered on", path),
+			}
+		}
+
+		if inAnd {
+			return encryptedFilterError(path)
+		}
+
+		var err error
+		switch v := value.(type) {
+		case map[string]interface{}:
+			// field__in=a,b,c
+			if values, isIn := v["$in"]; isIn {
+				filter[path], err = self.encryptInFilter(path, values)
+				break
+			}
+
+			// encrypted values do not keep the order of the plain values (i.e. timestamp[gte]=1648857887)
+			// the other operators (i.e. field__exists=true) do not compare values so they are left as they are
+			if isComparison(v) {
+				err = encryptedFilterError(path)
+			}
+		default:
+			// the values are encrypted as they were parsed (i.e. a number for an integer field)
+			// so they match the value that was stored
+			filter[path], err = self.encryptValue(v)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encrypt the values of an $in filter on an encrypted field
+func (self *FieldEncryption) encryptInFilter(path string, values interface{}) (interface{}, error) {
+	var plain []interface{}
+	switch v := values.(type) {
+	case []string:
+		for _, value := range v {
+			plain = append(plain, value)
+		}
+	case []interface{}:
+		plain = v
+	default:
+		return nil, encryptedFilterError(path)
+	}
+
+	var encrypted = make([]string, len(plain))
+	for i, value := range plain {
+		var err error
+		encrypted[i], err = self.encryptValue(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return map[string]interface{}{"$in": encrypted}, nil
+}
+
+// check if any of the clauses added to a filter with $and filter on a field
+func filtersField(filter map[string]interface{}, path string) bool {
+	var and, _ = filter["$and"].([]interface{})
+	for _, clause := range and {
+		if clauseFilter, ok := clause.(map[string]interface{}); ok {
+			if _, ok = clauseFilter[path]; ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// error returned when an encrypted field is filtered on with anything other than equality
+func encryptedFilterError(path string) error {
+	return mux.HttpError{
+		Code:        http.StatusBadRequest,
+		Description: fmt.Sprintf("The %s field is encrypted so it can only be filtered on for equality", path),
+	}
+}
+
+// decrypt the encrypted fields of query results if the caller is allowed to see them
+func decryptResults(request *http.Request, results []map[string]interface{}, config QueryConfig) error {
+	if config.Encryption == nil || !config.Encryption.canDecrypt(request) {
+		return nil
+	}
+
+	for _, result := range results {
+		var err = config.Encryption.decryptEvent(result)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// EventsGetHandler creates an http handler that retrieves a single event by its id
+// i.e. /events/5f1d7f3e8a9b1c2d3e4f5a6b returns the event object rather than an array
+// the id_format query param changes how the id is returned the same way GET /events does
+func EventsGetHandler(db *mongo.Collection, config QueryConfig) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var id, err = parseEventId(request.URL.Path)
+
+		var idFormat string
+		if err == nil {
+			idFormat, err = parseIdFormat(request.URL.Query(), config)
+		}
+
+		// create a timed context to use when making requests to the db
+		var timedContext, timedContextCancel = context.WithTimeout(request.Context(), config.queryTimeout())
+		defer timedContextCancel()
+
+		var event map[string]interface{}
+		if err == nil {
+			err = db.FindOne(timedContext, bson.M{"_id": id}).Decode(&event)
+			if err == mongo.ErrNoDocuments {
+				err = eventNotFoundError(id)
+			}
+		}
+
+		if err == nil {
+			err = decryptResults(request, []map[string]interface{}{event}, config)
+		}
+
+		if err == nil {
+			mux.WriteJsonResponse(writer, config.resultTransforms(IdFormatTransform(idFormat)).Transform(event))
+		} else {
+			mux.WriteJsonResponse(writer, dbUnavailableError(writer, err, config))
+		}
+	})
+}
+
+// EventsDeleteHandler creates an http handler that deletes a single event by its id
+// (i.e. to purge an erroneous event for compliance reasons)
+// the deletion is logged with the name of the token used so there is a record of it
+func EventsDeleteHandler(db *mongo.Collection, config QueryConfig) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var id, err = parseEventId(request.URL.Path)
+
+		var result *mongo.DeleteResult
+		if err == nil {
+			var timedContext, timedContextCancel = context.WithTimeout(request.Context(), config.queryTimeout())
+			defer timedContextCancel()
+
+			result, err = db.DeleteOne(timedContext, bson.M{"_id": id})
+		}
+
+		if err == nil && result.DeletedCount == 0 {
+			err = eventNotFoundError(id)
+		}
+
+		if err == nil {
+			if config.Logger != nil {
+				config.Logger.Printf("Event %s was deleted using the %q token\n", id.Hex(), mux.TokenName(request))
+			}
+
+			mux.WriteJsonResponse(writer, nil)
+		} else {
+			if _, isHttpError := err.(mux.HttpError); !isHttpError && config.Logger != nil {
+				config.Logger.Printf("An error occured while deleting an event: %s\n", err)
+			}
+
+			mux.WriteJsonResponse(writer, dbUnavailableError(writer, err, config))
+		}
+	})
+}
+
+// get the event id from a /events/<id> path
+// a malformed id results in a 400 error rather than a 404 so the user knows the id itself is wrong
+func parseEventId(path string) (primitive.ObjectID, error) {
+	var idHex = strings.TrimPrefix(path, "/events/")
+
+	var id, err = primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return id, mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: fmt.Sprintf("The event id %q is not a valid 24 character hex id", idHex),
+		}
+	}
+
+	return id, nil
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// number of events returned on each side of the pivot event when the user does not ask for a number
+const defaultContextEvents = 10
+
+// EventResourceHandler creates an http handler for the /events/<id>/... endpoints
+// /events/<id> retrieves (GET) or deletes (DELETE) an event
+// /events/<id>/context retrieves (GET) the events around an event
+func EventResourceHandler(db *mongo.Collection, config QueryConfig) http.Handler {
+	var eventRouter = mux.NewMethodRouter()
+	eventRouter.Handle(http.MethodGet, EventsGetHandler(db, config))
+	eventRouter.Handle(http.MethodDelete, EventsDeleteHandler(db, config))
+
+	var contextRouter = mux.NewMethodRouter()
+	contextRouter.Handle(http.MethodGet, eventContextHandler(db, config))
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// a path without a resource is the event itself
+		if !strings.Contains(strings.TrimPrefix(request.URL.Path, "/events/"), "/") {
+			eventRouter.ServeHTTP(writer, request)
+			return
+		}
+
+		var _, resource = parseEventPath(request.URL.Path)
+
+		switch resource {
+		case "context":
+			contextRouter.ServeHTTP(writer, request)
+		default:
+			mux.WriteJsonResponse(writer, mux.DefaultHttpError(http.StatusNotFound))
+		}
+	})
+}
+
+// split a /events/<id>/<resource> path into the event id and resource
+// the resource is empty if the path is /events/<id> or the id is not a valid event id
+func parseEventPath(path string) (primitive.ObjectID, string) {
+	var parts = strings.SplitN(strings.TrimPrefix(path, "/events/"), "/", 2)
+
+	var id, err = primitive.ObjectIDFromHex(parts[0])
+	if err != nil {
+		return primitive.NilObjectID, ""
+	}
+
+	if len(parts) == 1 {
+		return id, ""
+	}
+
+	return id, parts[1]
+}
+
+// the error sent when an event id does not match an event
+// it includes the id so the user can tell which event was missing
+// every handler for a single event should use it so the 404s are the same
+func eventNotFoundError(id primitive.ObjectID) error {
+	return mux.HttpError{
+		Code:        http.StatusNotFound,
+		Description: fmt.Sprintf("event %s not found", id.Hex()),
+	}
+}
+
+// create an http handler that retrieves the events around an event so it can be seen in context
+// the before and after query params set how many older and newer events are returned (10 by default)
+// the events are returned in the default order (newest first) with the event itself between
+// the newer and older events
+func eventContextHandler(db *mongo.Collection, config QueryConfig) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var id, _ = parseEventPath(request.URL.Path)
+		var queryParams = request.URL.Query()
+
+		var before, err = parseContextCount(queryParams, "before", config)
+
+		var after int64
+		if err == nil {
+			after, err = parseContextCount(queryParams, "after", config)
+		}
+
+		var idFormat string
+		if err == nil {
+			idFormat, err = parseIdFormat(queryParams, config)
+		}
+
+		// create a timed context to use when making requests to the db
+		var timedContext, timedContextCancel = context.WithTimeout(request.Context(), config.queryTimeout())
+		defer timedContextCancel()
+
+		var pivot map[string]interface{}
+		if err == nil {
+			err = db.FindOne(timedContext, bson.M{"_id": id}).Decode(&pivot)
+			if err == mongo.ErrNoDocuments {
+				err = eventNotFoundError(id)
+			}
+		}
+
+		// the events that come after the pivot in the default order are older
+		var keys []sortKey
+		if err == nil {
+			keys, err = parseSortKeys(url.Values{}, config)
+		}
+
+		var older []map[string]interface{}
+		if err == nil {
+			older, err = findNeighbours(timedContext, db, keys, pivot, before, config)
+		}
+
+		// reversing the order finds the newer events nearest first
+		var newer []map[string]interface{}
+		if err == nil {
+			var reversedKeys = make([]sortKey, len(keys))
+			for i, key := range keys {
+				reversedKeys[i] = sortKey{Field: key.Field, Descending: !key.Descending}
+			}
+
+			newer, err = findNeighbours(timedContext, db, reversedKeys, pivot, after, config)
+		}
+
+		if err != nil {
+			mux.WriteJsonResponse(writer, dbUnavailableError(writer, err, config))
+			return
+		}
+
+		var results = make([]map[string]interface{}, 0, len(newer)+1+len(older))
+		for i := len(newer) - 1; i >= 0; i-- {
+			results = append(results, newer[i])
+		}
+		results = append(results, pivot)
+		results = append(results, older...)
+
+		err = decryptResults(request, results, config)
+
+		var transforms = config.resultTransforms(IdFormatTransform(idFormat))
+		for i := 0; err == nil && i < len(results); i++ {
+			results[i] = transforms.Transform(results[i])
+		}
+
+		if err == nil {
+			mux.WriteJsonResponse(writer, results)
+		} else {
+			mux.WriteJsonResponse(writer, err)
+		}
+	})
+}
+
+// get how many events to return on one side of the pivot event
+// the max limit caps whatever the user asks for
+func parseContextCount(queryParams url.Values, name string, config QueryConfig) (int64, error) {
+	var count int64 = defaultContextEvents
+
+	if queryParams.Has(name) {
+		var err error
+		count, err = strconv.ParseInt(queryParams.Get(name), 10, 64)
+		if err != nil || count < 0 {
+			return 0, mux.HttpError{
+				Code:        http.StatusBadRequest,
+				Description: "The " + name + " query parameter must be a non negative integer",
+			}
+		}
+	}
+
+	if config.MaxLimit > 0 && count > config.MaxLimit {
+		count = config.MaxLimit
+	}
+
+	return count, nil
+}
+
+// find the events that come directly after the pivot event in the order of the sort keys
+// this uses the same clause as the after page token so it agrees with paging through the results
+func findNeighbours(ctx context.Context, db *mongo.Collection, keys []sortKey, pivot map[string]interface{},
+	count int64, config QueryConfig) ([]map[string]interface{}, error) {
+	if count == 0 {
+		return nil, nil
+	}
+
+	var token, err = encodeAfterToken(keys, pivot)
+
+	var filter = make(map[string]interface{})
+	if err == nil {
+		err = addAfterFilter(filter, keys, token)
+	}
+
+	var cursor *mongo.Cursor
+	if err == nil {
+		cursor, err = db.Find(ctx, filter, options.Find().SetSort(sortDocument(keys)).SetLimit(count))
+	}
+
+	var results []map[string]interface{}
+	if err == nil {
+		results, err = decodeCursor(ctx, cursor, config)
+	}
+
+	return results, err
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// create a stored event with a timestamp
+func contextEvent(timestamp int64) bson.D {
+	return bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "timestamp", Value: timestamp}}
+}
+
+// get the id of a stored event as a hex string
+func contextEventId(event bson.D) string {
+	return event[0].Value.(primitive.ObjectID).Hex()
+}
+
+func TestEventContextHandlerReturnsNeighboursInOrder(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("context", func(mt *mtest.T) {
+		var pivot = contextEvent(5)
+		// older events are returned newest first and newer events are returned oldest first
+		var older = []bson.D{contextEvent(4), contextEvent(3)}
+		var newer = []bson.D{contextEvent(6), contextEvent(7)}
+
+		mt.AddMockResponses(
+			mockCursorResponse(mt, pivot),
+			mockCursorResponse(mt, older...),
+			mockCursorResponse(mt, newer...),
+		)
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/events/"+contextEventId(pivot)+"/context?before=2&after=2", nil)
+		EventResourceHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(queryInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		var results []map[string]interface{}
+		json.Unmarshal(writer.Body.Bytes(), &results)
+
+		var expectedIds = []string{contextEventId(newer[1]), contextEventId(newer[0]), contextEventId(pivot), contextEventId(older[0]), contextEventId(older[1])}
+		if len(results) != len(expectedIds) {
+			t.Fatalf(queryInvalidResultCountError, len(expectedIds), len(results))
+		}
+		for i, id := range expectedIds {
+			if results[i]["_id"] != id {
+				t.Errorf("The events were not returned in order at position %d Expected: %s, Got: %v", i, id, results[i]["_id"])
+			}
+		}
+
+		// the older events are found using the default order starting after the pivot
+		var startedEvents = mt.GetAllStartedEvents()
+		var olderCommand = startedEvents[1].Command
+		if olderCommand.Lookup("limit").Int64() != 2 || !strings.Contains(olderCommand.Lookup("filter").String(), contextEventId(pivot)) {
+			t.Errorf("The older events were not found starting from the pivot Got: %s", olderCommand)
+		}
+		if olderCommand.Lookup("sort").String() != `{"timestamp": {"$numberInt":"-1"},"_id": {"$numberInt":"-1"}}` {
+			t.Errorf("The older events were not found using the default order Got: %s", olderCommand.Lookup("sort"))
+		}
+	})
+}
+
+func TestEventContextHandlerMissingPivot(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("missing pivot", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt))
+
+		var writer = httptest.NewRecorder()
+		var id = primitive.NewObjectID()
+		var request = httptest.NewRequest(http.MethodGet, "/events/"+id.Hex()+"/context", nil)
+		EventResourceHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusNotFound {
+			t.Errorf(queryInvalidStatusError, http.StatusNotFound, writer.Code)
+		}
+
+		var expectedBody = `{"description":"event ` + id.Hex() + ` not found"}`
+		if writer.Body.String() != expectedBody {
+			t.Errorf("Expected the response body %s but got %s", expectedBody, writer.Body.String())
+		}
+	})
+}
+
+func TestEventContextHandlerInvalidCount(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("invalid count", func(mt *mtest.T) {
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/events/"+primitive.NewObjectID().Hex()+"/context?before=-1", nil)
+		EventResourceHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusBadRequest {
+			t.Errorf(queryInvalidStatusError, http.StatusBadRequest, writer.Code)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestEventsGetHandler(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("found", func(mt *mtest.T) {
+		var event = contextEvent(5)
+		mt.AddMockResponses(mockCursorResponse(mt, event))
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/events/"+contextEventId(event), nil)
+		EventResourceHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(queryInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		// the event is returned as an object rather than an array
+		var result map[string]interface{}
+		var err = json.Unmarshal(writer.Body.Bytes(), &result)
+		if err != nil || result["_id"] != contextEventId(event) {
+			t.Errorf("The event was not returned Got: %s", writer.Body.String())
+		}
+
+		var filter, _ = mt.GetStartedEvent().Command.LookupErr("filter")
+		if filter.Document().Lookup("_id").ObjectID() != event[0].Value {
+			t.Errorf("The event was not found using its id Got: %s", filter)
+		}
+	})
+
+	mt.Run("not found", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt))
+
+		var id = primitive.NewObjectID()
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/events/"+id.Hex(), nil)
+		EventResourceHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusNotFound {
+			t.Errorf(queryInvalidStatusError, http.StatusNotFound, writer.Code)
+		}
+	})
+}
+
+func TestEventsGetHandlerMalformedId(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("malformed id", func(mt *mtest.T) {
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/events/not-an-id", nil)
+		EventResourceHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusBadRequest {
+			t.Errorf(queryInvalidStatusError, http.StatusBadRequest, writer.Code)
+		}
+
+		if len(mt.GetAllStartedEvents()) != 0 {
+			t.Error("The db was queried using a malformed id")
+		}
+	})
+}
+
+func TestEventsDeleteHandler(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	var id = primitive.NewObjectID()
+
+	var tests = []struct {
+		name     string
+		path     string
+		deleted  int32
+		expected int
+	}{
+		{"deleted", "/events/" + id.Hex(), 1, http.StatusNoContent},
+		{"not found", "/events/" + id.Hex(), 0, http.StatusNotFound},
+		{"malformed id", "/events/not-an-id", 0, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		mt.Run(test.name, func(mt *mtest.T) {
+			mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: test.deleted}))
+
+			var writer = httptest.NewRecorder()
+			var request = httptest.NewRequest(http.MethodDelete, test.path, nil)
+			EventResourceHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+			if writer.Code != test.expected {
+				t.Errorf(queryInvalidStatusError, test.expected, writer.Code)
+			}
+		})
+	}
+}
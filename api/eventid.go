@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// strategies used to generate the id of an added event
+const (
+	// events only have the ObjectID the database gives them as their _id
+	IdStrategyObjectId = "objectid"
+	// events are also given a ULID (i.e. 01G0EZ7ZKSD3K0MQ2VZ1B8QXJN) which sorts by the time it was generated as a string
+	IdStrategyUlid = "ulid"
+	// events are also given a version 7 UUID (i.e. 01800e7f-fe79-7a66-8a5b-97e2c8b5d3f1) which sorts by the time
+	// it was generated
+	IdStrategyUuidV7 = "uuidv7"
+)
+
+// the valid id strategies
+var IdStrategies = []string{IdStrategyObjectId, IdStrategyUlid, IdStrategyUuidV7}
+
+// field the generated id is stored in when no id field is configured
+const DefaultIdField = "event_id"
+
+// the alphabet ULIDs are encoded with (Crockford's base32)
+const ulidAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// create the 128 bits shared by ULIDs and version 7 UUIDs
+// the first 48 bits are the milliseconds since the Unix epoch and the rest are random
+func timeOrderedBits(now time.Time) ([16]byte, error) {
+	var bits [16]byte
+
+	var milliseconds [8]byte
+	binary.BigEndian.PutUint64(milliseconds[:], uint64(now.UnixNano()/int64(time.Millisecond)))
+	copy(bits[:6], milliseconds[2:])
+
+	var _, err = rand.Read(bits[6:])
+
+	return bits, err
+}
+
+// create a ULID encoded as 26 characters of Crockford's base32
+func newUlid(now time.Time) (string, error) {
+	var bits, err = timeOrderedBits(now)
+	if err != nil {
+		return "", err
+	}
+
+	// the 128 bits are encoded 5 at a time starting from the most significant bits
+	// 26 characters hold 130 bits so the first character only uses its lowest 3 bits
+	var encoded [26]byte
+	var high = binary.BigEndian.Uint64(bits[:8])
+	var low = binary.BigEndian.Uint64(bits[8:])
+	for i := len(encoded) - 1; i >= 0; i-- {
+		encoded[i] = ulidAlphabet[low&0x1f]
+		low = low>>5 | high<<59
+		high >>= 5
+	}
+
+	return string(encoded[:]), nil
+}
+
+// create a version 7 UUID in its canonical hyphenated form
+func newUuidV7(now time.Time) (string, error) {
+	var bits, err = timeOrderedBits(now)
+	if err != nil {
+		return "", err
+	}
+
+	// set the version (7) and the variant (10)
+	bits[6] = bits[6]&0x0f | 0x70
+	bits[8] = bits[8]&0x3f | 0x80
+
+	var encoded = hex.EncodeToString(bits[:])
+
+	return fmt.Sprintf("%s-%s-%s-%s-%s", encoded[:8], encoded[8:12], encoded[12:16], encoded[16:20], encoded[20:]), nil
+}
+
+// get the name of the field generated ids are stored in
+func (self InsertConfig) idField() string {
+	if len(self.IdField) == 0 {
+		return DefaultIdField
+	}
+
+	return self.IdField
+}
+
+// give an event an id using the configured id strategy
+// events that already have a value in the id field keep it
+func addEventId(event map[string]interface{}, now time.Time, config InsertConfig) error {
+	var generate func(time.Time) (string, error)
+	switch config.IdStrategy {
+	case IdStrategyUlid:
+		generate = newUlid
+	case IdStrategyUuidV7:
+		generate = newUuidV7
+	default:
+		return nil
+	}
+
+	if _, ok := event[config.idField()]; ok {
+		return nil
+	}
+
+	var id, err = generate(now)
+	if err == nil {
+		event[config.idField()] = id
+	}
+
+	return err
+}
+
+// create a unique index on the field generated ids are stored in
+// so events can be looked up by the id and an id can never be used twice
+// nothing is done if events only use their ObjectID
+func EnsureEventIdIndex(ctx context.Context, db *mongo.Collection, config InsertConfig) error {
+	if len(config.IdStrategy) == 0 || config.IdStrategy == IdStrategyObjectId {
+		return nil
+	}
+
+	// events added before the strategy was configured do not have the field
+	var index = mongo.IndexModel{
+		Keys:    bson.D{{Key: config.idField(), Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	}
+
+	var _, err = db.Indexes().CreateOne(ctx, index)
+
+	return err
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var ulidPattern = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+var uuidV7Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// generate ids for many events and make sure each is valid and unique
+func checkGeneratedIds(t *testing.T, strategy string, pattern *regexp.Regexp) {
+	var now = time.Now()
+	var seen = make(map[string]struct{})
+
+	for i := 0; i < 1000; i++ {
+		var event = map[string]interface{}{}
+		var err = addEventId(event, now, InsertConfig{IdStrategy: strategy})
+		if err != nil {
+			t.Fatalf("An error occured while generating a %s: %s", strategy, err)
+		}
+
+		var id, _ = event[DefaultIdField].(string)
+		if !pattern.MatchString(id) {
+			t.Fatalf("The generated %s %q is not valid", strategy, id)
+		}
+
+		if _, ok := seen[id]; ok {
+			t.Fatalf("The %s %q was generated twice", strategy, id)
+		}
+		seen[id] = struct{}{}
+	}
+}
+
+func TestAddEventIdUlid(t *testing.T) {
+	checkGeneratedIds(t, IdStrategyUlid, ulidPattern)
+
+	// the first 10 characters hold the time so ids generated later sort after earlier ones
+	var earlier, _ = newUlid(time.UnixMilli(1649445988000))
+	var later, _ = newUlid(time.UnixMilli(1649445988001))
+	if earlier[:10] != "01G05A8SN0" || strings.Compare(earlier, later) >= 0 {
+		t.Errorf("The ULIDs do not start with the time they were generated Got: %s and %s", earlier, later)
+	}
+}
+
+func TestAddEventIdUuidV7(t *testing.T) {
+	checkGeneratedIds(t, IdStrategyUuidV7, uuidV7Pattern)
+
+	var id, _ = newUuidV7(time.UnixMilli(1649445988000))
+	if !strings.HasPrefix(id, "01800aa4-66a0-") {
+		t.Errorf("The UUID does not start with the time it was generated Got: %s", id)
+	}
+}
+
+func TestAddEventIdObjectId(t *testing.T) {
+	var event = map[string]interface{}{}
+	addEventId(event, time.Now(), InsertConfig{})
+
+	if len(event) != 0 {
+		t.Errorf("An id was added to the event when events only use their ObjectID Got: %v", event)
+	}
+}
+
+func TestAddEventIdKeepsSuppliedId(t *testing.T) {
+	var event = map[string]interface{}{"id": "supplied"}
+	addEventId(event, time.Now(), InsertConfig{IdStrategy: IdStrategyUlid, IdField: "id"})
+
+	if event["id"] != "supplied" {
+		t.Errorf("The id supplied by the client was replaced Got: %v", event["id"])
+	}
+}
+
+func TestEnsureEventIdIndex(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("index", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		var err = EnsureEventIdIndex(context.Background(), mt.Coll, InsertConfig{IdStrategy: IdStrategyUuidV7})
+		if err != nil {
+			t.Fatalf("An error occured while creating the index: %s", err)
+		}
+
+		var index = mt.GetStartedEvent().Command.Lookup("indexes", "0")
+		if index.Document().Lookup("key", DefaultIdField).Int32() != 1 || !index.Document().Lookup("unique").Boolean() {
+			t.Errorf("A unique index was not created on the id field Got: %s", index)
+		}
+	})
+}
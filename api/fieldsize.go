@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/mitchellkelly/auditlog/mux"
+)
+
+// find the first field in an event that is larger than its limit
+// FieldMaxBytes limits the field at a path (i.e. attributes.message) which can also be a nested object
+// and DefaultFieldMaxBytes limits every other field that is not an object
+// strings are measured by their length in bytes and other values by the size of their json encoding
+// an empty path is returned if every field is within its limit
+func oversizedField(event map[string]interface{}, config InsertConfig) (string, int, int) {
+	return oversizedFieldWithPrefix(event, "", config)
+}
+
+func oversizedFieldWithPrefix(object map[string]interface{}, prefix string, config InsertConfig) (string, int, int) {
+	// the fields are checked in order so the same field is reported every time
+	var keys = make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var path = prefix + key
+		var value = object[key]
+
+		var nested, isObject = value.(map[string]interface{})
+
+		var limit, configured = config.FieldMaxBytes[path]
+		if !configured && !isObject {
+			limit = config.DefaultFieldMaxBytes
+		}
+
+		if limit > 0 {
+			var size = fieldSize(value)
+			if size > limit {
+				return path, size, limit
+			}
+		}
+
+		if isObject {
+			var field, size, limit = oversizedFieldWithPrefix(nested, path+".", config)
+			if len(field) > 0 {
+				return field, size, limit
+			}
+		}
+	}
+
+	return "", 0, 0
+}
+
+// get the size of a field value in bytes
+func fieldSize(value interface{}) int {
+	if s, ok := value.(string); ok {
+		return len(s)
+	}
+
+	var d, _ = json.Marshal(value)
+
+	return len(d)
+}
+
+// make sure every field in an event is within its size limit
+// a 400 naming the field is returned if one is not
+func checkFieldSizes(event map[string]interface{}, config InsertConfig) error {
+	var field, size, limit = oversizedField(event, config)
+	if len(field) == 0 {
+		return nil
+	}
+
+	return mux.HttpError{
+		Code:        http.StatusBadRequest,
+		Description: fmt.Sprintf("The %s field is %d bytes which is larger than the %d byte limit", field, size, limit),
+	}
+}
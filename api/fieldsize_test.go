@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// field limits that keep the summary short and every other field under 64 bytes
+var fieldSizeTestConfig = InsertConfig{
+	FieldMaxBytes:        map[string]int{"summary": 16},
+	DefaultFieldMaxBytes: 64,
+}
+
+func TestCheckFieldSizesUnderLimit(t *testing.T) {
+	var event = map[string]interface{}{
+		"summary":    "A user logged in",
+		"attributes": map[string]interface{}{"message": strings.Repeat("a", 64)},
+	}
+
+	var err = checkFieldSizes(event, fieldSizeTestConfig)
+	if err != nil {
+		t.Errorf("An event with every field within its limit was rejected: %s", err)
+	}
+}
+
+func TestCheckFieldSizesConfiguredFieldOverLimit(t *testing.T) {
+	var event = map[string]interface{}{
+		"summary": "A user logged in from a new device",
+	}
+
+	var err = checkFieldSizes(event, fieldSizeTestConfig)
+
+	var httpErr, ok = err.(mux.HttpError)
+	if !ok || httpErr.Code != http.StatusBadRequest {
+		t.Fatalf("An over limit field did not result in a 400 error: %v", err)
+	}
+
+	if !strings.Contains(httpErr.Description, "summary") {
+		t.Errorf("The error description did not name the field Got: %s", httpErr.Description)
+	}
+}
+
+func TestCheckFieldSizesNestedFieldOverDefaultLimit(t *testing.T) {
+	var event = map[string]interface{}{
+		"summary":    "A user logged in",
+		"attributes": map[string]interface{}{"message": strings.Repeat("a", 65)},
+	}
+
+	var field, size, limit = oversizedField(event, fieldSizeTestConfig)
+	if field != "attributes.message" || size != 65 || limit != 64 {
+		t.Errorf("The nested over limit field was not found Got: %s (%d of %d bytes)", field, size, limit)
+	}
+}
+
+func TestEventsAddHandlerFieldOverLimit(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("field over limit", func(mt *mtest.T) {
+		var body = `{"timestamp":1649445988,"summary":"A customer was added","source":{"service_name":"customer-management"},"attributes":{"message":"` +
+			strings.Repeat("a", 1024) + `"}}`
+
+		var config = InsertConfig{FieldMaxBytes: map[string]int{"attributes.message": 512}}
+
+		var writer = httptest.NewRecorder()
+		EventsAddHandler(mt.Coll, loadTestSchema(t), config).ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+
+		if writer.Code != http.StatusBadRequest {
+			t.Fatalf("An unexpected status code was returned when attempting to add an event Expected: %d, Got: %d", http.StatusBadRequest, writer.Code)
+		}
+
+		if !strings.Contains(writer.Body.String(), "attributes.message") {
+			t.Errorf("The response did not name the over limit field Got: %s", writer.Body.String())
+		}
+
+		if len(mt.GetAllStartedEvents()) != 0 {
+			t.Error("The event was inserted even though a field was over its limit")
+		}
+	})
+}
@@ -0,0 +1,442 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"github.com/qri-io/jsonschema"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CreateFilterFromQuery uses the url query params to create a filter that can be used to query the db
+// values are parsed into the type the schema declares for their field (i.e. integer or boolean)
+// so they match the stored values, and fields the schema does not describe are filtered as strings
+// a nil schema filters every field as a string
+// an error is returned if any of the filter values are invalid
+func CreateFilterFromQuery(queryParams url.Values, schema *jsonschema.Schema) (map[string]interface{}, error) {
+	// create a filter object
+	// we have to call make() because the collection.Find method assumes filter will be non nil
+	var filter = make(map[string]interface{})
+	// the comparisons for fields using the range operators (i.e. timestamp[gte]=1648857887)
+	// every operator on a field is combined into one sub document
+	var rangeFilters = make(map[string]map[string]interface{})
+
+	for k, _ := range queryParams {
+		// reserved params like limit control the query and are not event fields
+		if isReservedQueryParam(k) {
+			continue
+		}
+
+		var v interface{}
+
+		// queryParams is a url.Values type which is map[string][]string
+		// we want url.Values map key but we will call the url.Values.Get(k) method
+		// since it returns a string
+		var queryValueString = queryParams.Get(k)
+
+		// field[op]=value compares the field to the value (i.e. timestamp[gte]=1648857887)
+		if field, operator, ok := splitRangeOperator(k); ok {
+			var dbOperator, value, err = createRangeComparison(k, field, operator, queryValueString, schema)
+			if err != nil {
+				return nil, err
+			}
+
+			if rangeFilters[field] == nil {
+				rangeFilters[field] = make(map[string]interface{})
+			}
+			rangeFilters[field][dbOperator] = value
+			continue
+		}
+
+		// handle operators as a special case
+		// field__in=a,b,c matches events where field is any of the comma separated values
+		var separatorIndex = strings.LastIndex(k, operatorSeparator)
+		if separatorIndex != -1 {
+			var field = k[:separatorIndex]
+			var operator = k[separatorIndex+len(operatorSeparator):]
+
+			var createOperatorFilter, ok = filterOperators[operator]
+			if !ok {
+				return nil, mux.HttpError{
+					Code: http.StatusBadRequest,
+					Description: fmt.Sprintf("The filter operator %q in %s is not recognized. Valid operators are %s",
+						operator, k, strings.Join(filterOperatorNames(), ", ")),
+				}
+			}
+
+			var operatorFilter, err = createOperatorFilter(field, queryValueString, schema)
+			if err != nil {
+				return nil, err
+			}
+
+			filter[field] = operatorFilter
+			continue
+		}
+
+		// handle id values as a special case
+		// we want to query for a 24 character hex id
+		// but mongo assumes we are using the 12 byte format
+		if k == "_id" {
+			var objectId, _ = primitive.ObjectIDFromHex(queryValueString)
+			v = objectId
+		} else if queryValueString == NullFilterValue {
+			// the db matches a plain null filter against events that do not have the field as well
+			// so the bson type is used to only match fields that are present and null
+			// events without the field can be found using field__exists=false
+			v = map[string]interface{}{"$type": bsonNullType}
+		} else {
+			// a string filter value for a non string field would never match
+			// i.e. timestamp == "1648857887" does not match an event where timestamp == 1648857887
+			var err error
+			v, err = parseFilterValue(k, queryValueString, schema)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		filter[k] = v
+	}
+
+	for field, comparisons := range rangeFilters {
+		if _, ok := filter[field]; !ok {
+			filter[field] = comparisons
+			continue
+		}
+
+		// the comparisons are added with $and so they never clash with another filter on the field
+		var and, _ = filter["$and"].([]interface{})
+		filter["$and"] = append(and, map[string]interface{}{field: comparisons})
+	}
+
+	var err = addIdRangeFilter(filter, queryParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// check that the query params do not filter on more distinct fields than the configured maximum
+// reserved params are not counted and a field used with several operators (i.e. _id and _id__in) only counts once
+// queries that combine many fields can force the db into expensive index intersections or collection scans
+func checkFilterFieldCount(queryParams url.Values, config QueryConfig) error {
+	if config.MaxFilterFields <= 0 {
+		return nil
+	}
+
+	var fields = filterFields(queryParams)
+
+	if int64(len(fields)) > config.MaxFilterFields {
+		return mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: fmt.Sprintf("The query filters on %d fields which is more than the limit of %d", len(fields), config.MaxFilterFields),
+		}
+	}
+
+	return nil
+}
+
+// get the distinct fields the query params filter on in alphabetical order
+func filterFields(queryParams url.Values) []string {
+	var seen = make(map[string]struct{})
+	var fields []string
+	for k := range queryParams {
+		if isReservedQueryParam(k) {
+			continue
+		}
+
+		var separatorIndex = strings.LastIndex(k, operatorSeparator)
+		if separatorIndex != -1 {
+			k = k[:separatorIndex]
+		}
+
+		if field, _, ok := splitRangeOperator(k); ok {
+			k = field
+		}
+
+		if _, ok := seen[k]; !ok {
+			seen[k] = struct{}{}
+			fields = append(fields, k)
+		}
+	}
+
+	sort.Strings(fields)
+
+	return fields
+}
+
+// policies for queries that do not filter the events
+const (
+	// the query returns every event (up to the limit)
+	EmptyFilterAllow = "allow"
+	// the query is rejected
+	EmptyFilterRequireFilter = "require_filter"
+	// the query is rejected unless it includes all=true to show the whole collection is wanted
+	EmptyFilterRequireAll = "require_all"
+)
+
+// the valid empty filter policies
+var EmptyFilterPolicies = []string{EmptyFilterAllow, EmptyFilterRequireFilter, EmptyFilterRequireAll}
+
+// check a query that does not filter the events against the configured policy
+// so a missing filter does not accidentally scan the whole collection
+func checkEmptyFilter(filter map[string]interface{}, queryParams url.Values, config QueryConfig) error {
+	if len(filter) > 0 {
+		return nil
+	}
+
+	switch config.EmptyFilterPolicy {
+	case EmptyFilterRequireFilter:
+		return mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: "The query must filter the events using at least one field",
+		}
+	case EmptyFilterRequireAll:
+		if queryParams.Get("all") != "true" {
+			return mux.HttpError{
+				Code:        http.StatusBadRequest,
+				Description: "The query must filter the events using at least one field or include all=true to query every event",
+			}
+		}
+	}
+
+	return nil
+}
+
+// query param value that matches events where the field is present and null
+// rather than events where the field is the string "null"
+const NullFilterValue = "null"
+
+// the number the db uses for the bson null type in $type filters
+const bsonNullType = 10
+
+// separates a field from a filter operator in a query param (i.e. field__in)
+// any query param containing the separator must use one of the filterOperators
+const operatorSeparator = "__"
+
+// the filter operators that can be used in a query param mapped to the function that creates
+// the filter for the field from the query param value
+var filterOperators = map[string]func(field string, valueString string, schema *jsonschema.Schema) (interface{}, error){
+	"in":     createInFilter,
+	"exists": createExistsFilter,
+}
+
+// get the names of the filter operators in sorted order
+func filterOperatorNames() []string {
+	var names = make([]string, 0, len(filterOperators))
+	for name := range filterOperators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// the range operators that can be used in a query param (i.e. timestamp[gte]=1648857887)
+// mapped to the db operator they create
+var rangeOperators = map[string]string{
+	"gt":  "$gt",
+	"gte": "$gte",
+	"lt":  "$lt",
+	"lte": "$lte",
+	"ne":  "$ne",
+}
+
+// split a query param using the range operator syntax (i.e. timestamp[gte]) into its field and operator
+// ok is false if the query param does not use the syntax
+func splitRangeOperator(key string) (field string, operator string, ok bool) {
+	var openIndex = strings.LastIndex(key, "[")
+	if openIndex <= 0 || !strings.HasSuffix(key, "]") {
+		return key, "", false
+	}
+
+	return key[:openIndex], key[openIndex+1 : len(key)-1], true
+}
+
+// check if a field filter compares the field using any of the range operators
+func isComparison(fieldFilter map[string]interface{}) bool {
+	for _, dbOperator := range rangeOperators {
+		if _, ok := fieldFilter[dbOperator]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// create the comparison for a range operator
+// _id values are converted to object ids and other values are parsed into the type the schema
+// declares for the field so numbers are compared as numbers rather than strings
+func createRangeComparison(key string, field string, operator string, valueString string, schema *jsonschema.Schema) (string, interface{}, error) {
+	var dbOperator, ok = rangeOperators[operator]
+	if !ok {
+		var names = make([]string, 0, len(rangeOperators))
+		for name := range rangeOperators {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return "", nil, mux.HttpError{
+			Code: http.StatusBadRequest,
+			Description: fmt.Sprintf("The range operator %q in %s is not recognized. Valid range operators are %s",
+				operator, key, strings.Join(names, ", ")),
+		}
+	}
+
+	if field == "_id" {
+		var objectId, err = primitive.ObjectIDFromHex(valueString)
+		if err != nil {
+			return "", nil, mux.HttpError{
+				Code:        http.StatusBadRequest,
+				Description: fmt.Sprintf("The value %q of the %s filter is not a valid 24 character hex id", valueString, key),
+			}
+		}
+
+		return dbOperator, objectId, nil
+	}
+
+	var value, err = parseFilterValue(field, valueString, schema)
+
+	return dbOperator, value, err
+}
+
+// create an $in filter that matches any of the comma separated values
+// _id values are converted to object ids and any malformed ids result in a 400 error
+// other values are parsed into the type the schema declares for the field
+func createInFilter(field string, valueString string, schema *jsonschema.Schema) (interface{}, error) {
+	var values = strings.Split(valueString, ",")
+
+	if field != "_id" {
+		var fieldType = schemaFieldType(schema, field)
+		if len(fieldType) == 0 || fieldType == "string" {
+			return map[string]interface{}{"$in": values}, nil
+		}
+
+		var parsedValues = make([]interface{}, len(values))
+		for i, value := range values {
+			var parsed, err = parseFilterValue(field, value, schema)
+			if err != nil {
+				return nil, err
+			}
+
+			parsedValues[i] = parsed
+		}
+
+		return map[string]interface{}{"$in": parsedValues}, nil
+	}
+
+	var objectIds = make([]primitive.ObjectID, 0, len(values))
+	var malformedIds []string
+
+	for _, value := range values {
+		var objectId, err = primitive.ObjectIDFromHex(value)
+		if err == nil {
+			objectIds = append(objectIds, objectId)
+		} else {
+			malformedIds = append(malformedIds, value)
+		}
+	}
+
+	if len(malformedIds) > 0 {
+		return nil, mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: fmt.Sprintf("The following ids are not valid 24 character hex ids: %s", strings.Join(malformedIds, ", ")),
+		}
+	}
+
+	return map[string]interface{}{"$in": objectIds}, nil
+}
+
+// create an $exists filter that matches events that have (true) or do not have (false) the field
+// a field that is present and null exists
+func createExistsFilter(field string, valueString string, schema *jsonschema.Schema) (interface{}, error) {
+	var exists, err = strconv.ParseBool(valueString)
+	if err != nil {
+		return nil, mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: fmt.Sprintf("The value of %s__exists must be true or false", field),
+		}
+	}
+
+	return map[string]interface{}{"$exists": exists}, nil
+}
+
+// get the json schema type (i.e. integer) of the field at a dot separated path
+// an empty string is returned if the schema does not describe the field
+// or does not give it a single type other than null
+func schemaFieldType(schema *jsonschema.Schema, path string) string {
+	for _, name := range strings.Split(path, ".") {
+		if schema == nil {
+			return ""
+		}
+
+		var properties, ok = schema.JSONProp("properties").(*jsonschema.Properties)
+		if !ok {
+			return ""
+		}
+
+		schema = (*properties)[name]
+	}
+
+	if schema == nil {
+		return ""
+	}
+
+	var schemaType, ok = schema.JSONProp("type").(*jsonschema.Type)
+	if !ok {
+		return ""
+	}
+
+	// a field that can also be null (i.e. ["integer","null"]) is filtered as its other type
+	var fieldType string
+	for _, t := range strings.Split(schemaType.String(), ",") {
+		if t == "null" {
+			continue
+		}
+		if len(fieldType) != 0 {
+			return ""
+		}
+
+		fieldType = t
+	}
+
+	return fieldType
+}
+
+// parse a filter value into the type the schema declares for its field
+// the value is left as a string if the field is not a number, integer or boolean
+func parseFilterValue(field string, valueString string, schema *jsonschema.Schema) (interface{}, error) {
+	var fieldType = schemaFieldType(schema, field)
+
+	var value interface{}
+	var err error
+
+	switch fieldType {
+	case "integer":
+		value, err = strconv.ParseInt(valueString, 10, 64)
+	case "number":
+		// integers are kept exact since large ones (i.e. nanosecond timestamps) do not fit in a float
+		value, err = strconv.ParseInt(valueString, 10, 64)
+		if err != nil {
+			value, err = strconv.ParseFloat(valueString, 64)
+		}
+	case "boolean":
+		value, err = strconv.ParseBool(valueString)
+	default:
+		return valueString, nil
+	}
+
+	if err != nil {
+		return nil, mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: fmt.Sprintf("The value %q of the %s filter must be a %s", valueString, field, fieldType),
+		}
+	}
+
+	return value, nil
+}
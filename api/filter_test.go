@@ -0,0 +1,347 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"github.com/qri-io/jsonschema"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCreateFilterFromQueryIgnoresReservedParams(t *testing.T) {
+	var filter, _ = CreateFilterFromQuery(url.Values{"limit": {"10"}, "summary": {"one"}}, nil)
+
+	if _, ok := filter["limit"]; ok {
+		t.Error("The reserved limit query parameter was added to the filter")
+	}
+
+	if filter["summary"] != "one" {
+		t.Errorf("The summary filter was not created Expected: one, Got: %v", filter["summary"])
+	}
+}
+
+func TestCreateFilterFromQueryIdList(t *testing.T) {
+	var ids = []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+
+	var filter, err = CreateFilterFromQuery(url.Values{"_id__in": {ids[0].Hex() + "," + ids[1].Hex()}}, nil)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while creating a filter: %s", err)
+	}
+
+	var idFilter, ok = filter["_id"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("An _id filter was not created from the id list Got: %v", filter)
+	}
+
+	var filterIds, _ = idFilter["$in"].([]primitive.ObjectID)
+	if len(filterIds) != len(ids) || filterIds[0] != ids[0] || filterIds[1] != ids[1] {
+		t.Errorf("An unexpected id list was added to the filter Expected: %v, Got: %v", ids, idFilter["$in"])
+	}
+}
+
+func TestCreateFilterFromQueryIdListMalformedId(t *testing.T) {
+	var _, err = CreateFilterFromQuery(url.Values{"_id__in": {primitive.NewObjectID().Hex() + ",not-an-id"}}, nil)
+
+	var httpErr, ok = err.(mux.HttpError)
+	if !ok || httpErr.Code != http.StatusBadRequest {
+		t.Fatalf("A malformed id did not result in a 400 error: %v", err)
+	}
+
+	if !strings.Contains(httpErr.Description, "not-an-id") {
+		t.Errorf("The error description did not list the malformed id Got: %s", httpErr.Description)
+	}
+}
+
+func TestCreateFilterFromQueryRecognizedOperator(t *testing.T) {
+	var filter, err = CreateFilterFromQuery(url.Values{"source.service_name__in": {"billing,shipping"}}, nil)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while creating a filter: %s", err)
+	}
+
+	var serviceFilter, _ = filter["source.service_name"].(map[string]interface{})
+	var values, _ = serviceFilter["$in"].([]string)
+	if len(values) != 2 || values[0] != "billing" || values[1] != "shipping" {
+		t.Errorf("An unexpected filter was created for the in operator Got: %v", filter)
+	}
+}
+
+func TestCreateFilterFromQueryUnrecognizedOperator(t *testing.T) {
+	for _, key := range []string{"timestamp__between", "timestamp__in "} {
+		var _, err = CreateFilterFromQuery(url.Values{key: {"1"}}, nil)
+
+		var httpError, ok = err.(mux.HttpError)
+		if !ok || httpError.Code != http.StatusBadRequest {
+			t.Errorf("An unrecognized filter operator in %q did not result in a 400 Got: %v", key, err)
+			continue
+		}
+
+		if !strings.Contains(httpError.Description, key) || !strings.Contains(httpError.Description, "Valid operators are exists, in") {
+			t.Errorf("The error did not name the unknown operator and the valid ones Got: %s", httpError.Description)
+		}
+	}
+}
+
+func TestCheckFilterFieldCountAtLimit(t *testing.T) {
+	var queryParams = url.Values{
+		"summary":             {"one"},
+		"source.service_name": {"billing"},
+		"_id":                 {primitive.NewObjectID().Hex()},
+		"_id__in":             {primitive.NewObjectID().Hex()},
+		"limit":               {"10"},
+		"from":                {"2022-04-08T00:00:00Z"},
+	}
+
+	// _id and _id__in are one field and the reserved params are not counted
+	var err = checkFilterFieldCount(queryParams, QueryConfig{MaxFilterFields: 3})
+	if err != nil {
+		t.Errorf("A query at the filter field limit was rejected: %s", err)
+	}
+}
+
+func TestCheckFilterFieldCountAboveLimit(t *testing.T) {
+	var queryParams = url.Values{
+		"summary":             {"one"},
+		"source.service_name": {"billing"},
+		"attributes.user":     {"mitchell"},
+		"action__in":          {"create,delete"},
+	}
+
+	var err = checkFilterFieldCount(queryParams, QueryConfig{MaxFilterFields: 3})
+
+	var httpErr, ok = err.(mux.HttpError)
+	if !ok || httpErr.Code != http.StatusBadRequest {
+		t.Errorf("A query above the filter field limit did not result in a 400 error: %v", err)
+	}
+}
+
+func TestCheckFilterFieldCountNoLimit(t *testing.T) {
+	var err = checkFilterFieldCount(url.Values{"summary": {"one"}, "action": {"create"}}, QueryConfig{})
+	if err != nil {
+		t.Errorf("A query was rejected when there is no filter field limit: %s", err)
+	}
+}
+
+func TestCheckEmptyFilterRequireFilter(t *testing.T) {
+	var config = QueryConfig{EmptyFilterPolicy: EmptyFilterRequireFilter}
+
+	var err = checkEmptyFilter(map[string]interface{}{}, url.Values{"all": {"true"}}, config)
+
+	var httpErr, ok = err.(mux.HttpError)
+	if !ok || httpErr.Code != http.StatusBadRequest {
+		t.Errorf("A query without a filter did not result in a 400 error: %v", err)
+	}
+
+	err = checkEmptyFilter(map[string]interface{}{"summary": "one"}, url.Values{}, config)
+	if err != nil {
+		t.Errorf("A query with a filter was rejected: %s", err)
+	}
+}
+
+func TestCheckEmptyFilterRequireAll(t *testing.T) {
+	var config = QueryConfig{EmptyFilterPolicy: EmptyFilterRequireAll}
+
+	var err = checkEmptyFilter(map[string]interface{}{}, url.Values{}, config)
+
+	var httpErr, ok = err.(mux.HttpError)
+	if !ok || httpErr.Code != http.StatusBadRequest {
+		t.Errorf("A query without a filter or all=true did not result in a 400 error: %v", err)
+	}
+
+	err = checkEmptyFilter(map[string]interface{}{}, url.Values{"all": {"true"}}, config)
+	if err != nil {
+		t.Errorf("A query with all=true was rejected: %s", err)
+	}
+}
+
+func TestCheckEmptyFilterAllow(t *testing.T) {
+	var err = checkEmptyFilter(map[string]interface{}{}, url.Values{}, QueryConfig{})
+	if err != nil {
+		t.Errorf("A query without a filter was rejected by the default policy: %s", err)
+	}
+}
+
+func TestCreateFilterFromQueryNullValue(t *testing.T) {
+	var filter, err = CreateFilterFromQuery(url.Values{"attributes.reason": {NullFilterValue}}, nil)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while creating a filter: %s", err)
+	}
+
+	// a plain null would also match events without the field
+	var reasonFilter, _ = filter["attributes.reason"].(map[string]interface{})
+	if reasonFilter["$type"] != bsonNullType {
+		t.Errorf("The null filter does not only match null fields Got: %v", filter)
+	}
+}
+
+func TestCreateFilterFromQueryExists(t *testing.T) {
+	var filter, err = CreateFilterFromQuery(url.Values{"attributes.reason__exists": {"false"}}, nil)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while creating a filter: %s", err)
+	}
+
+	var reasonFilter, _ = filter["attributes.reason"].(map[string]interface{})
+	if reasonFilter["$exists"] != false {
+		t.Errorf("The missing field filter was not created Got: %v", filter)
+	}
+
+	_, err = CreateFilterFromQuery(url.Values{"attributes.reason__exists": {"maybe"}}, nil)
+	if httpError, ok := err.(mux.HttpError); !ok || httpError.Code != http.StatusBadRequest {
+		t.Errorf("An exists value that is not a boolean did not result in a 400 Got: %v", err)
+	}
+}
+
+func TestCreateFilterFromQuerySchemaTypes(t *testing.T) {
+	var schema = loadTestSchema(t)
+
+	var filter, err = CreateFilterFromQuery(url.Values{
+		"timestamp":           {"1649419200"},
+		"source.service_name": {"1234"},
+	}, schema)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while creating a filter: %s", err)
+	}
+
+	if filter["timestamp"] != int64(1649419200) {
+		t.Errorf("The number field was not filtered as a number Got: %#v", filter["timestamp"])
+	}
+
+	if filter["source.service_name"] != "1234" {
+		t.Errorf("The string field was not filtered as a string Got: %#v", filter["source.service_name"])
+	}
+
+	filter, err = CreateFilterFromQuery(url.Values{"timestamp__in": {"1.5,2"}}, schema)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while creating a filter: %s", err)
+	}
+
+	var timestampFilter, _ = filter["timestamp"].(map[string]interface{})
+	var values, _ = timestampFilter["$in"].([]interface{})
+	if len(values) != 2 || values[0] != 1.5 || values[1] != int64(2) {
+		t.Errorf("The in operator values were not parsed as numbers Got: %#v", filter["timestamp"])
+	}
+}
+
+func TestCreateFilterFromQuerySchemaTypeMismatch(t *testing.T) {
+	for _, key := range []string{"timestamp", "timestamp__in"} {
+		var _, err = CreateFilterFromQuery(url.Values{key: {"yesterday"}}, loadTestSchema(t))
+
+		var httpError, ok = err.(mux.HttpError)
+		if !ok || httpError.Code != http.StatusBadRequest {
+			t.Errorf("A value that is not a number for %s did not result in a 400 Got: %v", key, err)
+			continue
+		}
+
+		if !strings.Contains(httpError.Description, "yesterday") || !strings.Contains(httpError.Description, "number") {
+			t.Errorf("The error description did not name the value and type Got: %s", httpError.Description)
+		}
+	}
+}
+
+func TestParseFilterValueTypes(t *testing.T) {
+	var schema jsonschema.Schema
+	var err = json.Unmarshal([]byte(`{"type":"object","properties":{
+		"count":{"type":"integer"},
+		"active":{"type":"boolean"},
+		"score":{"type":["number","null"]}
+	}}`), &schema)
+	if err != nil {
+		t.Fatalf("An error occured while parsing the test schema: %s", err)
+	}
+
+	var tests = []struct {
+		field    string
+		value    string
+		expected interface{}
+	}{
+		{"count", "10", int64(10)},
+		{"active", "true", true},
+		{"score", "0.5", 0.5},
+		{"missing", "10", "10"},
+	}
+
+	for _, test := range tests {
+		var value, err = parseFilterValue(test.field, test.value, &schema)
+		if err != nil || value != test.expected {
+			t.Errorf("An unexpected value was parsed for %s Expected: %#v, Got: %#v, %v", test.field, test.expected, value, err)
+		}
+	}
+
+	var _, intErr = parseFilterValue("count", "1.5", &schema)
+	if intErr == nil {
+		t.Error("A decimal value for an integer field did not result in an error")
+	}
+}
+
+func TestCreateFilterFromQueryRangeOperators(t *testing.T) {
+	var filter, err = CreateFilterFromQuery(url.Values{
+		"timestamp[gte]":          {"1648857887"},
+		"timestamp[lt]":           {"1648944287.5"},
+		"source.service_name[ne]": {"billing-service"},
+	}, loadTestSchema(t))
+	if err != nil {
+		t.Fatalf("An unexpected error occured while creating a filter: %s", err)
+	}
+
+	var timestampFilter, _ = filter["timestamp"].(map[string]interface{})
+	if len(timestampFilter) != 2 || timestampFilter["$gte"] != int64(1648857887) || timestampFilter["$lt"] != 1648944287.5 {
+		t.Errorf("The range operators were not combined into one filter for the field Got: %#v", filter["timestamp"])
+	}
+
+	var serviceFilter, _ = filter["source.service_name"].(map[string]interface{})
+	if serviceFilter["$ne"] != "billing-service" {
+		t.Errorf("An unexpected filter was created for the ne operator Got: %#v", filter["source.service_name"])
+	}
+}
+
+func TestCreateFilterFromQueryRangeOperatorWithEquality(t *testing.T) {
+	var filter, err = CreateFilterFromQuery(url.Values{
+		"summary":     {"one"},
+		"summary[ne]": {"two"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while creating a filter: %s", err)
+	}
+
+	var and, _ = filter["$and"].([]interface{})
+	if filter["summary"] != "one" || len(and) != 1 {
+		t.Errorf("The range operator did not keep the equality filter on the same field Got: %#v", filter)
+	}
+}
+
+func TestCreateFilterFromQueryRangeOperatorId(t *testing.T) {
+	var id = primitive.NewObjectID()
+
+	var filter, err = CreateFilterFromQuery(url.Values{"_id[gt]": {id.Hex()}}, nil)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while creating a filter: %s", err)
+	}
+
+	var idFilter, _ = filter["_id"].(map[string]interface{})
+	if idFilter["$gt"] != id {
+		t.Errorf("The id was not converted to an object id Got: %#v", filter["_id"])
+	}
+
+	_, err = CreateFilterFromQuery(url.Values{"_id[gt]": {"not-an-id"}}, nil)
+	if httpError, ok := err.(mux.HttpError); !ok || httpError.Code != http.StatusBadRequest {
+		t.Errorf("A malformed id did not result in a 400 Got: %v", err)
+	}
+}
+
+func TestCreateFilterFromQueryInvalidRangeOperator(t *testing.T) {
+	var tests = map[string]string{
+		"timestamp[between]": "1",
+		"timestamp[gte]":     "yesterday",
+	}
+
+	for key, value := range tests {
+		var _, err = CreateFilterFromQuery(url.Values{key: {value}}, loadTestSchema(t))
+
+		if httpError, ok := err.(mux.HttpError); !ok || httpError.Code != http.StatusBadRequest {
+			t.Errorf("The filter %s=%s did not result in a 400 Got: %v", key, value, err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"github.com/qri-io/jsonschema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// HealthStatus is the response body sent by the health endpoints when everything is healthy
+type HealthStatus struct {
+	Status string `json:"status"`
+}
+
+// how long the health endpoints wait for the db so health checks fail fast
+// it is kept short and separate from the query timeout since monitoring tools expect a quick answer
+const healthCheckTimeout = 2 * time.Second
+
+// a health status that is sent with a 503
+type unavailableStatus struct {
+	HealthStatus
+}
+
+func (self unavailableStatus) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// HealthHandler creates an http handler that reports if the db can be reached
+// a 200 with {"status":"ok"} is returned when the db answers a ping and a 503 with {"status":"unavailable"} otherwise
+func HealthHandler(db *mongo.Collection) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var timedContext, timedContextCancel = context.WithTimeout(request.Context(), healthCheckTimeout)
+		defer timedContextCancel()
+
+		var err = db.Database().Client().Ping(timedContext, nil)
+		if err == nil {
+			mux.WriteJsonResponse(writer, HealthStatus{Status: "ok"})
+		} else {
+			mux.WriteJsonResponse(writer, unavailableStatus{HealthStatus{Status: "unavailable"}})
+		}
+	})
+}
+
+// ReadinessHandler creates an http handler that reports if the service is ready to accept events
+// by default the check only pings the db but a db that has lost its primary can still answer pings
+// so when checkWrites is true a probe document is also inserted and deleted to verify writes work
+// the endpoint does not require authentication so why the db failed is logged rather than sent
+func ReadinessHandler(db *mongo.Collection, checkWrites bool, logger *log.Logger) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// create a timed context so readiness checks fail fast
+		var timedContext, timedContextCancel = context.WithTimeout(request.Context(), healthCheckTimeout)
+		defer timedContextCancel()
+
+		var err = db.Database().Client().Ping(timedContext, nil)
+		if err == nil && checkWrites {
+			err = checkWriteCapability(timedContext, db)
+		}
+
+		if err == nil {
+			mux.WriteJsonResponse(writer, HealthStatus{Status: "ready"})
+			return
+		}
+
+		if logger != nil {
+			logger.Printf("The service is not ready: %s\n", err)
+		}
+
+		mux.WriteJsonResponse(writer, mux.HttpError{
+			Code:        http.StatusServiceUnavailable,
+			Description: "The service is not ready",
+		})
+	})
+}
+
+// insert and delete a probe document to make sure the db can accept writes
+// the probe uses its own collection so it never shows up in event queries
+func checkWriteCapability(ctx context.Context, db *mongo.Collection) error {
+	var probes = db.Database().Collection("readiness")
+
+	var result, err = probes.InsertOne(ctx, bson.M{"checked_at": time.Now().UTC()})
+	if err == nil {
+		_, err = probes.DeleteOne(ctx, bson.M{"_id": result.InsertedID})
+	}
+
+	return err
+}
+
+// HealthCheck is one dependency checked by DetailedHealthHandler
+type HealthCheck struct {
+	// name the dependency is reported under
+	Name string
+	// the service is only unhealthy if a critical dependency fails
+	// non critical failures are reported but do not change the status code
+	Critical bool
+	// returns an error if the dependency is not healthy
+	Check func(ctx context.Context) error
+}
+
+// DetailedHealthStatus is the response body sent by DetailedHealthHandler
+type DetailedHealthStatus struct {
+	// ok, degraded (a non critical dependency failed) or unavailable (a critical dependency failed)
+	Status string `json:"status"`
+	// map of dependency name to ok or error
+	Components map[string]string `json:"components"`
+}
+
+// an unavailable status is an error so it can be sent with a 503 using WriteJsonResponse
+type unavailableHealthStatus struct {
+	DetailedHealthStatus
+}
+
+func (self unavailableHealthStatus) Error() string {
+	return self.Status
+}
+
+func (self unavailableHealthStatus) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// DetailedHealthHandler creates an http handler that reports the status of each dependency
+// a 200 is returned as long as all of the critical dependencies are healthy and a 503 otherwise
+// the endpoint does not require authentication so the errors of failed dependencies are logged rather than sent
+func DetailedHealthHandler(checks []HealthCheck, logger *log.Logger) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// create a timed context so health checks fail fast
+		var timedContext, timedContextCancel = context.WithTimeout(request.Context(), healthCheckTimeout)
+		defer timedContextCancel()
+
+		var status = DetailedHealthStatus{
+			Status:     "ok",
+			Components: make(map[string]string, len(checks)),
+		}
+		var criticalFailure bool
+
+		for _, check := range checks {
+			var err = check.Check(timedContext)
+			if err == nil {
+				status.Components[check.Name] = "ok"
+				continue
+			}
+
+			if logger != nil {
+				logger.Printf("The %s health check failed: %s\n", check.Name, err)
+			}
+
+			status.Components[check.Name] = "error"
+			if check.Critical {
+				criticalFailure = true
+			} else {
+				status.Status = "degraded"
+			}
+		}
+
+		if criticalFailure {
+			status.Status = "unavailable"
+			mux.WriteJsonResponse(writer, unavailableHealthStatus{status})
+		} else {
+			mux.WriteJsonResponse(writer, status)
+		}
+	})
+}
+
+// DbHealthCheck creates a critical health check that pings the db
+func DbHealthCheck(db *mongo.Collection) HealthCheck {
+	return HealthCheck{
+		Name:     "mongo",
+		Critical: true,
+		Check: func(ctx context.Context) error {
+			return db.Database().Client().Ping(ctx, nil)
+		},
+	}
+}
+
+// SchemaHealthCheck creates a critical health check that makes sure the event json schema is loaded
+func SchemaHealthCheck(schema *jsonschema.Schema) HealthCheck {
+	return HealthCheck{
+		Name:     "schema",
+		Critical: true,
+		Check: func(ctx context.Context) error {
+			if schema == nil || schema.TopLevelType() == "unknown" {
+				return fmt.Errorf("The event json schema is not loaded")
+			}
+
+			return nil
+		},
+	}
+}
+
+// SinkHealthCheck creates a non critical health check for a secondary sink
+// sink failures are only logged when events are added so they never make the service unavailable
+// sinks that can not check themselves are always reported as ok
+func SinkHealthCheck(name string, sink EventSink) HealthCheck {
+	return HealthCheck{
+		Name:     name,
+		Critical: false,
+		Check: func(ctx context.Context) error {
+			var checker, ok = sink.(interface{ Check(context.Context) error })
+			if !ok {
+				return nil
+			}
+
+			return checker.Check(ctx)
+		},
+	}
+}
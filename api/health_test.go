@@ -0,0 +1,212 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var readinessInvalidStatusError = "An unexpected status code was returned when checking readiness " +
+	"Expected: %d, Got: %d"
+
+func TestHealthHandler(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	var tests = []struct {
+		name     string
+		response bson.D
+		code     int
+		status   string
+	}{
+		{"reachable", mtest.CreateSuccessResponse(), http.StatusOK, "ok"},
+		{"unreachable", mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 91, Name: "ShutdownInProgress", Message: "shutting down"}), http.StatusServiceUnavailable, "unavailable"},
+	}
+
+	for _, test := range tests {
+		mt.Run(test.name, func(mt *mtest.T) {
+			mt.AddMockResponses(test.response)
+
+			var writer = httptest.NewRecorder()
+			HealthHandler(mt.Coll).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+			var status HealthStatus
+			json.Unmarshal(writer.Body.Bytes(), &status)
+
+			if writer.Code != test.code || status.Status != test.status {
+				t.Errorf("An unexpected health status was returned Expected: %d %s, Got: %d %s", test.code, test.status, writer.Code, writer.Body.String())
+			}
+		})
+	}
+}
+
+func TestReadinessHandlerPingOnly(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("ping", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		var writer = httptest.NewRecorder()
+		ReadinessHandler(mt.Coll, false, nil).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		if writer.Code != http.StatusOK {
+			t.Errorf(readinessInvalidStatusError, http.StatusOK, writer.Code)
+		}
+	})
+}
+
+func TestReadinessHandlerPingsButCannotWrite(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("not writable", func(mt *mtest.T) {
+		// the ping succeeds but the probe insert fails because there is no primary
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{
+				Code:    10107,
+				Name:    "NotWritablePrimary",
+				Message: "not primary",
+			}),
+		)
+
+		var buf bytes.Buffer
+
+		var writer = httptest.NewRecorder()
+		ReadinessHandler(mt.Coll, true, log.New(&buf, "", 0)).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		if writer.Code != http.StatusServiceUnavailable {
+			t.Errorf(readinessInvalidStatusError, http.StatusServiceUnavailable, writer.Code)
+		}
+
+		// the endpoint is not authenticated so the db error is only logged
+		if strings.Contains(writer.Body.String(), "not primary") || !strings.Contains(buf.String(), "not primary") {
+			t.Errorf("The db error was sent instead of logged Got: %s", writer.Body.String())
+		}
+	})
+}
+
+func TestReadinessHandlerCanWrite(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("writable", func(mt *mtest.T) {
+		// ping, probe insert and probe delete all succeed
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+		var writer = httptest.NewRecorder()
+		ReadinessHandler(mt.Coll, true, nil).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		if writer.Code != http.StatusOK {
+			t.Errorf(readinessInvalidStatusError, http.StatusOK, writer.Code)
+		}
+	})
+}
+
+// create a health check that always returns the error provided
+func staticHealthCheck(name string, critical bool, err error) HealthCheck {
+	return HealthCheck{
+		Name:     name,
+		Critical: critical,
+		Check: func(ctx context.Context) error {
+			return err
+		},
+	}
+}
+
+func TestDetailedHealthHandlerCriticalFailure(t *testing.T) {
+	var checks = []HealthCheck{
+		staticHealthCheck("mongo", true, fmt.Errorf("connection refused")),
+		staticHealthCheck("schema", true, nil),
+	}
+
+	var buf bytes.Buffer
+
+	var writer = httptest.NewRecorder()
+	DetailedHealthHandler(checks, log.New(&buf, "", 0)).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/health/detailed", nil))
+
+	if writer.Code != http.StatusServiceUnavailable {
+		t.Errorf(readinessInvalidStatusError, http.StatusServiceUnavailable, writer.Code)
+	}
+
+	var status DetailedHealthStatus
+	json.Unmarshal(writer.Body.Bytes(), &status)
+
+	if status.Status != "unavailable" || status.Components["mongo"] != "error" || status.Components["schema"] != "ok" {
+		t.Errorf("The failed component was not flagged in the health status Got: %s", writer.Body.String())
+	}
+
+	// the endpoint is not authenticated so the error is only logged
+	if strings.Contains(writer.Body.String(), "connection refused") || !strings.Contains(buf.String(), "connection refused") {
+		t.Errorf("The dependency error was sent instead of logged Got: %s", writer.Body.String())
+	}
+}
+
+func TestDetailedHealthHandlerNonCriticalFailure(t *testing.T) {
+	var checks = []HealthCheck{
+		staticHealthCheck("mongo", true, nil),
+		staticHealthCheck("sink", false, fmt.Errorf("unreachable")),
+	}
+
+	var writer = httptest.NewRecorder()
+	DetailedHealthHandler(checks, nil).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/health/detailed", nil))
+
+	if writer.Code != http.StatusOK {
+		t.Errorf(readinessInvalidStatusError, http.StatusOK, writer.Code)
+	}
+
+	var status DetailedHealthStatus
+	json.Unmarshal(writer.Body.Bytes(), &status)
+
+	if status.Status != "degraded" || status.Components["sink"] != "error" {
+		t.Errorf("The failed non critical component was not reported in the health status Got: %s", writer.Body.String())
+	}
+}
+
+func TestSchemaHealthCheck(t *testing.T) {
+	if err := SchemaHealthCheck(loadTestSchema(t)).Check(context.Background()); err != nil {
+		t.Errorf("A loaded schema was reported as unhealthy: %s", err)
+	}
+
+	if err := SchemaHealthCheck(nil).Check(context.Background()); err == nil {
+		t.Error("A missing schema was reported as healthy")
+	}
+}
+
+func TestSinkHealthCheckFileReplaced(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "events.ndjson")
+
+	var sink, err = NewFileSink(path)
+	if err != nil {
+		t.Fatalf("An error occured while opening the sink file: %s", err)
+	}
+
+	var check = SinkHealthCheck("file_sink", sink)
+	if check.Critical {
+		t.Error("A sink health check should not be critical")
+	}
+
+	err = check.Check(context.Background())
+	if err != nil {
+		t.Errorf("The sink file was reported as unhealthy: %s", err)
+	}
+
+	os.Remove(path)
+
+	err = check.Check(context.Background())
+	if err == nil {
+		t.Error("A removed sink file was reported as healthy")
+	}
+}
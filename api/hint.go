@@ -0,0 +1,41 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// force the query to use an index when the query planner picks a bad one
+// the hint query param names the index and must be one of the allowed hints
+// without it the default hint for the fields the query filters on is used if one is configured
+func applyIndexHint(findOptions *options.FindOptions, queryParams url.Values, config QueryConfig) error {
+	if queryParams.Has("hint") {
+		var hint = queryParams.Get("hint")
+		if !containsField(config.IndexHints, hint) {
+			var description = "Index hints are not allowed"
+			if len(config.IndexHints) > 0 {
+				description = fmt.Sprintf("The hint query parameter must be one of %s", strings.Join(config.IndexHints, ", "))
+			}
+
+			return mux.HttpError{
+				Code:        http.StatusBadRequest,
+				Description: description,
+			}
+		}
+
+		findOptions.SetHint(hint)
+		return nil
+	}
+
+	var hint, ok = config.DefaultHints[strings.Join(filterFields(queryParams), ",")]
+	if ok {
+		findOptions.SetHint(hint)
+	}
+
+	return nil
+}
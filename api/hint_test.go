@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// run a query and get the hint that was sent to the db
+func queryHint(t *testing.T, config QueryConfig, target string) (int, string) {
+	var hint string
+	var code int
+
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("hint", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt))
+
+		var writer = httptest.NewRecorder()
+		EventsQueryHandler(mt.Coll, config).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, target, nil))
+		code = writer.Code
+
+		var started = mt.GetStartedEvent()
+		if started != nil {
+			var value, err = started.Command.LookupErr("hint")
+			if err == nil {
+				hint = value.StringValue()
+			}
+		}
+	})
+
+	return code, hint
+}
+
+func TestQueryHintApplied(t *testing.T) {
+	var code, hint = queryHint(t, QueryConfig{IndexHints: []string{"service_timestamp"}},
+		"/events?source.service_name=billing-service&hint=service_timestamp")
+
+	if code != http.StatusOK {
+		t.Fatalf(queryInvalidStatusError, http.StatusOK, code)
+	}
+	if hint != "service_timestamp" {
+		t.Errorf("Expected the query to be hinted to use service_timestamp but got %q", hint)
+	}
+}
+
+func TestQueryHintNotAllowed(t *testing.T) {
+	var code, _ = queryHint(t, QueryConfig{IndexHints: []string{"service_timestamp"}}, "/events?hint=summary_1")
+	if code != http.StatusBadRequest {
+		t.Errorf(queryInvalidStatusError, http.StatusBadRequest, code)
+	}
+
+	code, _ = queryHint(t, QueryConfig{}, "/events?hint=service_timestamp")
+	if code != http.StatusBadRequest {
+		t.Errorf(queryInvalidStatusError, http.StatusBadRequest, code)
+	}
+}
+
+func TestQueryDefaultHint(t *testing.T) {
+	var config = QueryConfig{
+		DefaultHints: map[string]string{"source.service_name,summary": "service_summary"},
+	}
+
+	var _, hint = queryHint(t, config, "/events?summary=login&source.service_name__in=billing-service&limit=5")
+	if hint != "service_summary" {
+		t.Errorf("Expected the default hint service_summary to be used but got %q", hint)
+	}
+
+	_, hint = queryHint(t, config, "/events?summary=login")
+	if len(hint) != 0 {
+		t.Errorf("Expected a query without a default hint for its fields to not be hinted but got %q", hint)
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// add bounds on the event id to the filter using the from and to query params
+// from and to are RFC 3339 times (i.e. 2022-04-08T00:00:00Z) and either can be left out
+// object ids start with the time they were created in seconds so the bounds match events
+// added within the range using the default _id index rather than needing an index on the timestamp field
+// the range is at second resolution so from and to include every event added in their second
+func addIdRangeFilter(filter map[string]interface{}, queryParams url.Values) error {
+	var bounds = make(map[string]interface{}, 2)
+
+	if queryParams.Has("from") {
+		var from, err = parseRangeTime(queryParams, "from")
+		if err != nil {
+			return err
+		}
+
+		// the other bytes of the id are zero so this is the smallest id created in that second
+		bounds["$gte"] = primitive.NewObjectIDFromTimestamp(from)
+	}
+
+	if queryParams.Has("to") {
+		var to, err = parseRangeTime(queryParams, "to")
+		if err != nil {
+			return err
+		}
+
+		// the smallest id created in the next second is the first id after the range
+		bounds["$lt"] = primitive.NewObjectIDFromTimestamp(to.Truncate(time.Second).Add(time.Second))
+	}
+
+	if len(bounds) == 0 {
+		return nil
+	}
+
+	// the bounds are added with $and so they never clash with an _id filter
+	var and, _ = filter["$and"].([]interface{})
+	filter["$and"] = append(and, map[string]interface{}{"_id": bounds})
+
+	return nil
+}
+
+// parse the time in a from or to query param
+func parseRangeTime(queryParams url.Values, name string) (time.Time, error) {
+	var t, err = time.Parse(time.RFC3339, queryParams.Get(name))
+	if err != nil {
+		return t, mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: fmt.Sprintf("The %s query parameter must be an RFC 3339 time (i.e. 2022-04-08T00:00:00Z)", name),
+		}
+	}
+
+	return t, nil
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// get the _id bounds added to a filter by the from and to query params
+func idRangeBounds(t *testing.T, queryParams url.Values) map[string]interface{} {
+	var filter, err = CreateFilterFromQuery(queryParams, nil)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while creating a filter: %s", err)
+	}
+
+	var and, _ = filter["$and"].([]interface{})
+	if len(and) != 1 {
+		t.Fatalf("The _id range was not added to the filter Got: %v", filter)
+	}
+
+	var clause, _ = and[0].(map[string]interface{})
+	var bounds, ok = clause["_id"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("The _id range was not added to the filter Got: %v", filter)
+	}
+
+	return bounds
+}
+
+// check if an id is within the bounds of an _id range filter
+func idInBounds(id primitive.ObjectID, bounds map[string]interface{}) bool {
+	if from, ok := bounds["$gte"].(primitive.ObjectID); ok && bytes.Compare(id[:], from[:]) < 0 {
+		return false
+	}
+	if to, ok := bounds["$lt"].(primitive.ObjectID); ok && bytes.Compare(id[:], to[:]) >= 0 {
+		return false
+	}
+
+	return true
+}
+
+// create an id the same way the driver does for an event added at a time
+func idCreatedAt(created time.Time) primitive.ObjectID {
+	var id = primitive.NewObjectID()
+	var timestampId = primitive.NewObjectIDFromTimestamp(created)
+	copy(id[:4], timestampId[:4])
+
+	return id
+}
+
+func TestIdRangeBracketsEventsInWindow(t *testing.T) {
+	var from = time.Date(2022, 4, 8, 12, 0, 0, 0, time.UTC)
+	var to = time.Date(2022, 4, 8, 13, 0, 0, 0, time.UTC)
+
+	var bounds = idRangeBounds(t, url.Values{
+		"from": {from.Format(time.RFC3339)},
+		"to":   {to.Format(time.RFC3339)},
+	})
+
+	var tests = []struct {
+		created time.Time
+		inRange bool
+	}{
+		{from.Add(-time.Second), false},
+		{from, true},
+		{from.Add(30 * time.Minute), true},
+		{to, true},
+		{to.Add(999 * time.Millisecond), true},
+		{to.Add(time.Second), false},
+	}
+
+	for _, test := range tests {
+		if idInBounds(idCreatedAt(test.created), bounds) != test.inRange {
+			t.Errorf("An event created at %s was not bracketed correctly Expected in range: %t", test.created, test.inRange)
+		}
+	}
+}
+
+func TestIdRangeOpenEnded(t *testing.T) {
+	var from = time.Date(2022, 4, 8, 12, 0, 0, 0, time.UTC)
+
+	var bounds = idRangeBounds(t, url.Values{"from": {from.Format(time.RFC3339)}})
+
+	if _, ok := bounds["$lt"]; ok {
+		t.Errorf("An upper bound was added without a to query parameter Got: %v", bounds)
+	}
+
+	if !idInBounds(idCreatedAt(from.AddDate(1, 0, 0)), bounds) {
+		t.Error("An event created after from was not in the range")
+	}
+}
+
+func TestIdRangeDoesNotClashWithIdFilter(t *testing.T) {
+	var id = primitive.NewObjectID()
+
+	var filter, err = CreateFilterFromQuery(url.Values{"_id": {id.Hex()}, "from": {"2022-04-08T12:00:00Z"}}, nil)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while creating a filter: %s", err)
+	}
+
+	if filter["_id"] != id {
+		t.Errorf("The _id filter was replaced by the range Got: %v", filter["_id"])
+	}
+}
+
+func TestIdRangeInvalidTime(t *testing.T) {
+	var _, err = CreateFilterFromQuery(url.Values{"to": {"yesterday"}}, nil)
+
+	var httpErr, ok = err.(mux.HttpError)
+	if !ok || httpErr.Code != http.StatusBadRequest {
+		t.Errorf("An invalid to time did not result in a 400 error: %v", err)
+	}
+}
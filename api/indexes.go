@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// an index as it is listed by the database
+type listedIndex struct {
+	Name string `bson:"name"`
+	Key  bson.D `bson:"key"`
+}
+
+// make sure there is an index on each of the fields so queries that filter or sort on them do not scan the collection
+// fields that already have a single field index are left alone so restarting never fails
+// because an index was created with a different name or direction
+// the indexes that were created and the ones that were already present are logged
+func EnsureQueryIndexes(ctx context.Context, db *mongo.Collection, fields []string, logger *log.Logger) error {
+	var cursor, err = db.Indexes().List(ctx)
+
+	var existing []listedIndex
+	if err == nil {
+		err = cursor.All(ctx, &existing)
+	}
+	if err != nil {
+		return err
+	}
+
+	var models []mongo.IndexModel
+	var missing []string
+	var checked []string
+	for _, field := range fields {
+		// a field can be listed more than once (i.e. if it is also the timestamp field)
+		if containsField(checked, field) {
+			continue
+		}
+		checked = append(checked, field)
+
+		var name, present = singleFieldIndex(existing, field)
+		if present {
+			if logger != nil {
+				logger.Printf("The %s index on %s is already present\n", name, field)
+			}
+			continue
+		}
+
+		missing = append(missing, field)
+		models = append(models, mongo.IndexModel{Keys: bson.D{{Key: field, Value: 1}}})
+	}
+
+	if len(models) == 0 {
+		return nil
+	}
+
+	var names []string
+	names, err = db.Indexes().CreateMany(ctx, models)
+	if err == nil && logger != nil {
+		for i, name := range names {
+			logger.Printf("Created the %s index on %s\n", name, missing[i])
+		}
+	}
+
+	return err
+}
+
+// find an index whose only key is the field
+// a single field index can be read in either direction so the direction does not matter
+func singleFieldIndex(indexes []listedIndex, field string) (string, bool) {
+	for _, index := range indexes {
+		if len(index.Key) == 1 && index.Key[0].Key == field {
+			return index.Name, true
+		}
+	}
+
+	return "", false
+}
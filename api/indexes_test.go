@@ -0,0 +1,67 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestEnsureQueryIndexes(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("indexes", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mockCursorResponse(mt,
+				bson.D{{Key: "name", Value: "_id_"}, {Key: "key", Value: bson.D{{Key: "_id", Value: 1}}}},
+				bson.D{{Key: "name", Value: "timestamp_-1"}, {Key: "key", Value: bson.D{{Key: "timestamp", Value: -1}}}},
+			),
+			mtest.CreateSuccessResponse(),
+		)
+
+		var buf bytes.Buffer
+		var err = EnsureQueryIndexes(context.Background(), mt.Coll,
+			[]string{"timestamp", "source.service_name", "timestamp"}, log.New(&buf, "", 0))
+		if err != nil {
+			t.Fatalf("An error occured while creating the indexes: %s", err)
+		}
+
+		mt.GetStartedEvent()
+		var indexes = mt.GetStartedEvent().Command.Lookup("indexes").Array()
+		var values, _ = indexes.Values()
+		if len(values) != 1 || values[0].Document().Lookup("key", "source.service_name").Int32() != 1 {
+			t.Errorf("Only the missing index should have been created Got: %s", indexes)
+		}
+
+		if !strings.Contains(buf.String(), "timestamp_-1 index on timestamp is already present") ||
+			!strings.Contains(buf.String(), "Created the source.service_name_1 index on source.service_name") {
+			t.Errorf("The indexes were not logged Got: %s", buf.String())
+		}
+	})
+}
+
+func TestEnsureQueryIndexesAllPresent(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("all present", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt,
+			bson.D{{Key: "name", Value: "timestamp_1"}, {Key: "key", Value: bson.D{{Key: "timestamp", Value: 1}}}},
+		))
+
+		var err = EnsureQueryIndexes(context.Background(), mt.Coll, []string{"timestamp"}, nil)
+		if err != nil {
+			t.Fatalf("An error occured while checking the indexes: %s", err)
+		}
+
+		mt.GetStartedEvent()
+		if mt.GetStartedEvent() != nil {
+			t.Error("An index was created when every index was already present")
+		}
+	})
+}
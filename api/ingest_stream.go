@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"github.com/qri-io/jsonschema"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// LineAck reports the result of adding the event on one line of a newline delimited json stream
+type LineAck struct {
+	// line number of the event in the request body starting at 1
+	Line int  `json:"line"`
+	Ok   bool `json:"ok"`
+	// id of the added event
+	Id interface{} `json:"id,omitempty"`
+	// the database was unavailable so the event is in the write ahead log and will be inserted later
+	Queued bool `json:"queued,omitempty"`
+	// why the event was not added
+	Error string `json:"error,omitempty"`
+}
+
+// EventsStreamAddHandler creates an http handler that adds the events in a newline delimited json body
+// one event per line, and acknowledges each line as soon as the event on it has been added
+// the response is newline delimited json with a LineAck for every event
+// (i.e. {"line":1,"ok":true,"id":"<id>"} or {"line":2,"ok":false,"error":"<reason>"})
+// so a producer sending a large file gets feedback while it is still sending and can stop early
+// an event that is rejected does not stop the rest of the stream from being added
+// blank lines are skipped without an acknowledgement
+// if the body can not be read (i.e. a line is larger than the max event size) the last acknowledgement
+// holds the error and the rest of the body is not read
+func EventsStreamAddHandler(db *mongo.Collection, schema *jsonschema.Schema, config InsertConfig) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		setValidationHeader(writer, config)
+
+		// the acknowledgements can only be sent while the body is still being read if the connection is full duplex
+		// otherwise they are held until the whole body has been read
+		var flushAcks = request.ProtoMajor >= 2 || mux.EnableFullDuplex(writer) == nil
+
+		var maxLineBytes = config.MaxEventBytes
+		if maxLineBytes == 0 {
+			maxLineBytes = DefaultMaxEventBytes
+		}
+
+		var scanner = bufio.NewScanner(request.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+
+		var stream = mux.NewNdjsonStream(writer)
+		var heldAcks []LineAck
+
+		var sendAck = func(ack LineAck) {
+			if !flushAcks {
+				heldAcks = append(heldAcks, ack)
+				return
+			}
+
+			stream.Write(ack)
+			stream.Flush()
+		}
+
+		var line int
+		for scanner.Scan() {
+			line++
+
+			var d = bytes.TrimSpace(scanner.Bytes())
+			if len(d) == 0 {
+				continue
+			}
+
+			// each line is received separately so it gets its own metadata
+			var id, queued, err = addEvent(request.Context(), db, schema, d, newRequestMetadata(request, time.Now()), config)
+			if err != nil {
+				sendAck(LineAck{Line: line, Error: lineAckError(line, err, config)})
+				continue
+			}
+
+			sendAck(LineAck{Line: line, Ok: true, Id: id, Queued: queued})
+		}
+
+		var err = scanner.Err()
+		if err == bufio.ErrTooLong {
+			sendAck(LineAck{
+				Line:  line + 1,
+				Error: fmt.Sprintf("The line is larger than the %d byte limit", maxLineBytes),
+			})
+		} else if err != nil {
+			sendAck(LineAck{Line: line + 1, Error: "The request body could not be read"})
+		}
+
+		for _, ack := range heldAcks {
+			stream.Write(ack)
+		}
+
+		stream.Close()
+	})
+}
+
+// get the error sent back to the user for a line that could not be added
+// only client errors are described since the acknowledgements are sent in a 200 response
+// which would otherwise pass internal details (i.e. database error messages) through the 5xx error scrubbing
+func lineAckError(line int, err error, config InsertConfig) string {
+	var httpError, ok = err.(mux.HttpError)
+	if ok && httpError.Code < http.StatusInternalServerError {
+		return httpError.Description
+	}
+
+	if config.Logger != nil {
+		config.Logger.Printf("An error occured while adding the event on line %d of a stream: %s\n", line, err)
+	}
+
+	return "An internal error occured while adding the event"
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// read the acknowledgements from a newline delimited json response
+func readLineAcks(t *testing.T, body io.Reader) []LineAck {
+	var acks []LineAck
+
+	var decoder = json.NewDecoder(body)
+	for decoder.More() {
+		var ack LineAck
+		var err = decoder.Decode(&ack)
+		if err != nil {
+			t.Fatalf("An error occured while decoding an acknowledgement: %s", err)
+		}
+		acks = append(acks, ack)
+	}
+
+	return acks
+}
+
+func TestEventsStreamAddHandlerInterleavedResults(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("interleaved", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+		var body = strings.Join([]string{
+			validEventJson,
+			`{"summary":`,
+			validEventJson,
+			"",
+			`{"summary":"","source":{},"attributes":{}}`,
+			validEventJson,
+		}, "\n")
+
+		var writer = httptest.NewRecorder()
+		var handler = EventsStreamAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{})
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events/stream", strings.NewReader(body)))
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(queryInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		var acks = readLineAcks(t, writer.Body)
+
+		var expected = []struct {
+			line int
+			ok   bool
+		}{{1, true}, {2, false}, {3, true}, {5, false}, {6, true}}
+
+		if len(acks) != len(expected) {
+			t.Fatalf("Expected %d acknowledgements but got %d: %v", len(expected), len(acks), acks)
+		}
+
+		for i, ack := range acks {
+			if ack.Line != expected[i].line || ack.Ok != expected[i].ok {
+				t.Errorf("Expected line %d to have ok=%t but got line %d with ok=%t", expected[i].line, expected[i].ok, ack.Line, ack.Ok)
+			}
+			if ack.Ok && ack.Id == nil {
+				t.Errorf("The acknowledgement for line %d does not have the event id", ack.Line)
+			}
+			if !ack.Ok && len(ack.Error) == 0 {
+				t.Errorf("The acknowledgement for line %d does not have an error", ack.Line)
+			}
+		}
+
+		var inserts = 0
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "insert" {
+				inserts++
+			}
+		}
+		if inserts != 3 {
+			t.Errorf("Expected 3 events to be inserted but %d were", inserts)
+		}
+	})
+}
+
+func TestEventsStreamAddHandlerLineTooLong(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("line too long", func(mt *mtest.T) {
+		var writer = httptest.NewRecorder()
+		var handler = EventsStreamAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{MaxEventBytes: 16})
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events/stream", strings.NewReader(validEventJson)))
+
+		var acks = readLineAcks(t, writer.Body)
+		if len(acks) != 1 || acks[0].Ok || acks[0].Line != 1 {
+			t.Errorf("Expected a single failed acknowledgement for line 1 but got %v", acks)
+		}
+	})
+}
+
+// the acknowledgement for a line should be received while the rest of the body is still being sent
+func TestEventsStreamAddHandlerAcknowledgesBeforeBodyEnds(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("full duplex", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+		var server = httptest.NewServer(EventsStreamAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{}))
+		defer server.Close()
+
+		var bodyReader, bodyWriter = io.Pipe()
+		defer bodyWriter.Close()
+
+		var responses = make(chan *http.Response, 1)
+		var errs = make(chan error, 1)
+		go func() {
+			var response, err = http.Post(server.URL, "application/x-ndjson", bodyReader)
+			if err != nil {
+				errs <- err
+				return
+			}
+			responses <- response
+		}()
+
+		io.WriteString(bodyWriter, validEventJson+"\n")
+
+		var response *http.Response
+		select {
+		case response = <-responses:
+		case err := <-errs:
+			t.Fatalf("An error occured while sending the stream: %s", err)
+		}
+		defer response.Body.Close()
+
+		var reader = bufio.NewReader(response.Body)
+
+		var readAck = func() LineAck {
+			var line, err = reader.ReadBytes('\n')
+			if err != nil {
+				t.Fatalf("An error occured while reading an acknowledgement: %s", err)
+			}
+
+			var ack LineAck
+			json.Unmarshal(line, &ack)
+			return ack
+		}
+
+		// the body is still open so the first acknowledgement is sent before the body ends
+		var ack = readAck()
+		if ack.Line != 1 || !ack.Ok {
+			t.Errorf("Expected line 1 to be added but got %v", ack)
+		}
+
+		io.WriteString(bodyWriter, validEventJson+"\n")
+		ack = readAck()
+		if ack.Line != 2 || !ack.Ok {
+			t.Errorf("Expected line 2 to be added but got %v", ack)
+		}
+
+		bodyWriter.Close()
+	})
+}
+
+func TestEventsStreamAddHandlerInternalErrorHidden(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("internal error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "secret database detail"}))
+
+		var buf bytes.Buffer
+		var config = InsertConfig{Logger: log.New(&buf, "", 0)}
+
+		var writer = httptest.NewRecorder()
+		var handler = EventsStreamAddHandler(mt.Coll, loadTestSchema(t), config)
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events/stream", strings.NewReader(validEventJson+"\n{\"summary\":\n")))
+
+		var acks = readLineAcks(t, writer.Body)
+		if len(acks) != 2 || acks[0].Ok || acks[1].Ok {
+			t.Fatalf("Expected 2 failed acknowledgements but got %v", acks)
+		}
+
+		// the database error is logged rather than sent to the user
+		if strings.Contains(acks[0].Error, "secret") || !strings.Contains(buf.String(), "secret database detail") {
+			t.Errorf("The database error was sent to the user instead of being logged Got: %q, Logged: %s", acks[0].Error, buf.String())
+		}
+
+		// invalid json is the user's error so it is still described
+		if acks[1].Error != "The request body must be valid json" {
+			t.Errorf("The client error was not described Got: %q", acks[1].Error)
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mitchellkelly/auditlog/mux"
+)
+
+// RequestMetadata records who submitted an event and when the service received it
+// it is added to events by the server so auditors do not have to trust the event body
+type RequestMetadata struct {
+	// name of the token the request was authenticated with
+	Token string `json:"token,omitempty" bson:"token,omitempty"`
+	// address of the client that sent the request
+	ClientIp  string    `json:"client_ip" bson:"client_ip"`
+	UserAgent string    `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	Received  time.Time `json:"received" bson:"received"`
+}
+
+// create the metadata for a request
+func newRequestMetadata(request *http.Request, received time.Time) RequestMetadata {
+	// RemoteAddr is host:port but tests and some servers only set the host
+	var clientIp, _, err = net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		clientIp = request.RemoteAddr
+	}
+
+	return RequestMetadata{
+		Token:     mux.TokenName(request),
+		ClientIp:  clientIp,
+		UserAgent: request.UserAgent(),
+		Received:  received.UTC(),
+	}
+}
+
+// add the request metadata to an event under the configured field
+// this is done after validation so the schema only has to permit the field rather than describe it
+func addRequestMetadata(event map[string]interface{}, metadata RequestMetadata, config InsertConfig) {
+	if len(config.MetadataField) != 0 {
+		event[config.MetadataField] = metadata
+	}
+}
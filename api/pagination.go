@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// response header containing the token used to request the next page of results
+const nextPageHeader = "X-Next-After"
+
+// one field used to order query results
+type sortKey struct {
+	Field      string
+	Descending bool
+}
+
+// by default results are returned newest first
+// _id is always the last sort key so events that share a timestamp still have a total order
+// which keeps keyset pagination from skipping or repeating events
+var defaultSortKeys = []sortKey{
+	{Field: "timestamp", Descending: true},
+	{Field: "_id", Descending: true},
+}
+
+// get the keys used to order the query results
+// the sort query param is a comma separated list of fields with a leading - for descending fields (i.e. -timestamp,summary)
+// and the order query param (asc or desc) sets the direction of every default key
+// _id is added as the last key if it is not already sorted on so the order is always total
+// unless the tiebreaker has been disabled
+func parseSortKeys(queryParams url.Values, config QueryConfig) ([]sortKey, error) {
+	if queryParams.Has("sort") && queryParams.Has("order") {
+		return nil, mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: "The sort and order query parameters can not be used together",
+		}
+	}
+
+	var keys []sortKey
+
+	var sortIncludesId bool
+	for _, field := range strings.Split(queryParams.Get("sort"), ",") {
+		field = strings.TrimSpace(field)
+
+		var descending = strings.HasPrefix(field, "-")
+		field = strings.TrimPrefix(field, "-")
+
+		// empty fields (i.e. a trailing comma) are ignored
+		if len(field) == 0 {
+			continue
+		}
+
+		if config.SortableFields != nil && !containsField(config.SortableFields, field) {
+			return nil, mux.HttpError{
+				Code: http.StatusBadRequest,
+				Description: fmt.Sprintf("Results can not be sorted by %s. Sorting is limited to indexed fields to avoid expensive sorts. The sortable fields are %s",
+					field, strings.Join(config.SortableFields, ", ")),
+			}
+		}
+
+		if field == "_id" {
+			sortIncludesId = true
+		}
+
+		keys = append(keys, sortKey{Field: field, Descending: descending})
+	}
+
+	if len(keys) > 0 {
+		if !sortIncludesId && !config.DisableSortTiebreaker {
+			keys = append(keys, sortKey{Field: "_id", Descending: keys[0].Descending})
+		}
+
+		return keys, nil
+	}
+
+	keys = make([]sortKey, len(defaultSortKeys))
+	copy(keys, defaultSortKeys)
+	if config.DisableSortTiebreaker {
+		keys = keys[:len(keys)-1]
+	}
+
+	switch queryParams.Get("order") {
+	case "", "desc":
+	case "asc":
+		for i := range keys {
+			keys[i].Descending = false
+		}
+	default:
+		return nil, mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: "The order query parameter must be either asc or desc",
+		}
+	}
+
+	return keys, nil
+}
+
+// check if a list of fields contains a field
+func containsField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// create the sort document used in the find options
+func sortDocument(keys []sortKey) bson.D {
+	var sort = make(bson.D, 0, len(keys))
+	for _, key := range keys {
+		var direction = 1
+		if key.Descending {
+			direction = -1
+		}
+
+		sort = append(sort, bson.E{Key: key.Field, Value: direction})
+	}
+
+	return sort
+}
+
+// create the token a user can send in the after query param to get the events that come after this one
+// the token holds the event's value for each of the sort keys
+func encodeAfterToken(keys []sortKey, event map[string]interface{}) (string, error) {
+	var values = make([]interface{}, len(keys))
+	for i, key := range keys {
+		values[i] = lookupField(event, key.Field)
+	}
+
+	var d, err = json.Marshal(values)
+
+	return base64.RawURLEncoding.EncodeToString(d), err
+}
+
+// add a clause to the filter that only matches events that come after the token in the sort order
+// for keys k1, k2 the clause is (k1 after v1) or (k1 == v1 and k2 after v2)
+// where after means $lt for descending keys and $gt for ascending keys
+func addAfterFilter(filter map[string]interface{}, keys []sortKey, token string) error {
+	var invalidTokenError = mux.HttpError{
+		Code:        http.StatusBadRequest,
+		Description: "The after query parameter is not a valid page token",
+	}
+
+	var values []interface{}
+
+	var d, err = base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(d, &values)
+	}
+	if err != nil || len(values) != len(keys) {
+		return invalidTokenError
+	}
+
+	// ids are stored in the token as hex strings
+	for i, key := range keys {
+		if key.Field == "_id" {
+			var hexId, _ = values[i].(string)
+			var objectId, err = primitive.ObjectIDFromHex(hexId)
+			if err != nil {
+				return invalidTokenError
+			}
+
+			values[i] = objectId
+		}
+	}
+
+	var clauses = make([]interface{}, 0, len(keys))
+	for i, key := range keys {
+		var clause = make(map[string]interface{}, i+1)
+		for j := 0; j < i; j++ {
+			clause[keys[j].Field] = values[j]
+		}
+
+		var operator = "$gt"
+		if key.Descending {
+			operator = "$lt"
+		}
+		clause[key.Field] = map[string]interface{}{operator: values[i]}
+
+		clauses = append(clauses, clause)
+	}
+
+	// the clause is added with $and so it never clashes with the rest of the filter
+	var and, _ = filter["$and"].([]interface{})
+	filter["$and"] = append(and, map[string]interface{}{"$or": clauses})
+
+	return nil
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// compare two values of the same type returning -1, 0 or 1
+func compareValues(a, b interface{}) int {
+	switch av := a.(type) {
+	case float64:
+		var bv = b.(float64)
+		if av < bv {
+			return -1
+		} else if av > bv {
+			return 1
+		}
+		return 0
+	case primitive.ObjectID:
+		var bv = b.(primitive.ObjectID)
+		return bytes.Compare(av[:], bv[:])
+	}
+
+	return 0
+}
+
+// evaluate the subset of the mongo filter language used by addAfterFilter against an event
+func matchesFilter(event map[string]interface{}, filter map[string]interface{}) bool {
+	for field, condition := range filter {
+		switch field {
+		case "$and":
+			for _, clause := range condition.([]interface{}) {
+				if !matchesFilter(event, clause.(map[string]interface{})) {
+					return false
+				}
+			}
+		case "$or":
+			var matched bool
+			for _, clause := range condition.([]interface{}) {
+				matched = matched || matchesFilter(event, clause.(map[string]interface{}))
+			}
+			if !matched {
+				return false
+			}
+		default:
+			var operators, isOperator = condition.(map[string]interface{})
+			if !isOperator {
+				if compareValues(event[field], condition) != 0 {
+					return false
+				}
+				continue
+			}
+
+			for operator, value := range operators {
+				var comparison = compareValues(event[field], value)
+				if (operator == "$lt" && comparison >= 0) || (operator == "$gt" && comparison <= 0) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// run a query against the seeded events the same way mongo would
+func runSeededQuery(events []map[string]interface{}, keys []sortKey, filter map[string]interface{}, limit int) []map[string]interface{} {
+	var results []map[string]interface{}
+	for _, event := range events {
+		if matchesFilter(event, filter) {
+			results = append(results, event)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		for _, key := range keys {
+			var comparison = compareValues(results[i][key.Field], results[j][key.Field])
+			if comparison != 0 {
+				return (comparison < 0) != key.Descending
+			}
+		}
+		return false
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
+
+func TestKeysetPaginationDescendingWithDuplicateTimestamps(t *testing.T) {
+	// seed events where most of the timestamps are shared by several events
+	var events []map[string]interface{}
+	for _, timestamp := range []float64{3, 1, 3, 2, 2, 3, 1, 2, 3} {
+		events = append(events, map[string]interface{}{
+			"_id":       primitive.NewObjectID(),
+			"timestamp": timestamp,
+		})
+	}
+
+	var keys, _ = parseSortKeys(url.Values{}, QueryConfig{})
+	var expected = runSeededQuery(events, keys, map[string]interface{}{}, len(events))
+
+	// page through the events two at a time using the after token
+	var paged []map[string]interface{}
+	var token string
+	for page := 0; page < len(events); page++ {
+		var filter = map[string]interface{}{}
+		if len(token) != 0 {
+			if err := addAfterFilter(filter, keys, token); err != nil {
+				t.Fatalf("An unexpected error occured while applying a page token: %s", err)
+			}
+		}
+
+		var results = runSeededQuery(events, keys, filter, 2)
+		if len(results) == 0 {
+			break
+		}
+		paged = append(paged, results...)
+
+		token, _ = encodeAfterToken(keys, results[len(results)-1])
+	}
+
+	if len(paged) != len(expected) {
+		t.Fatalf("Paging did not return every event exactly once Expected: %d, Got: %d", len(expected), len(paged))
+	}
+
+	for i := range expected {
+		if paged[i]["_id"] != expected[i]["_id"] {
+			t.Errorf("Paging returned events out of order at position %d Expected: %v, Got: %v", i, expected[i], paged[i])
+		}
+	}
+
+	// the first event should be the newest
+	if paged[0]["timestamp"] != float64(3) {
+		t.Errorf("Events were not returned newest first Got: %v", paged[0])
+	}
+}
+
+func TestKeysetPaginationUserSortWithSharedValues(t *testing.T) {
+	// every event shares the same timestamp so only the tiebreaker orders them
+	var events []map[string]interface{}
+	for i := 0; i < 25; i++ {
+		events = append(events, map[string]interface{}{
+			"_id":       primitive.NewObjectID(),
+			"timestamp": float64(1),
+		})
+	}
+
+	var keys, _ = parseSortKeys(url.Values{"sort": {"timestamp"}}, QueryConfig{})
+
+	// the events are seeded in a different order each time but are always returned in the same order
+	var first []map[string]interface{}
+	for attempt := 0; attempt < 3; attempt++ {
+		var shuffled = make([]map[string]interface{}, len(events))
+		copy(shuffled, events)
+		for i := range shuffled {
+			var j = (i*7 + attempt*3) % len(shuffled)
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		}
+
+		var results = runSeededQuery(shuffled, keys, map[string]interface{}{}, len(events))
+		if first == nil {
+			first = results
+			continue
+		}
+
+		for i := range results {
+			if results[i]["_id"] != first[i]["_id"] {
+				t.Fatalf("Events that share a sort value were not returned in a deterministic order at position %d", i)
+			}
+		}
+	}
+}
+
+func TestParseSortKeysTiebreakerDisabled(t *testing.T) {
+	var config = QueryConfig{DisableSortTiebreaker: true}
+
+	var keys, _ = parseSortKeys(url.Values{"sort": {"-timestamp"}}, config)
+	var expectedKeys = []sortKey{{Field: "timestamp", Descending: true}}
+	if !reflect.DeepEqual(keys, expectedKeys) {
+		t.Errorf("Unexpected sort keys were returned Expected: %v, Got: %v", expectedKeys, keys)
+	}
+
+	keys, _ = parseSortKeys(url.Values{}, config)
+	if !reflect.DeepEqual(keys, expectedKeys) {
+		t.Errorf("Unexpected default sort keys were returned Expected: %v, Got: %v", expectedKeys, keys)
+	}
+}
+
+func TestAddAfterFilterInvalidToken(t *testing.T) {
+	var keys, _ = parseSortKeys(url.Values{}, QueryConfig{})
+
+	for _, token := range []string{"not a token", "WzFd"} {
+		if err := addAfterFilter(map[string]interface{}{}, keys, token); err == nil {
+			t.Errorf("An invalid page token %q did not result in an error", token)
+		}
+	}
+}
+
+func TestParseSortKeysAllowedField(t *testing.T) {
+	var config = QueryConfig{SortableFields: []string{"timestamp", "_id"}}
+
+	var keys, err = parseSortKeys(url.Values{"sort": {"timestamp"}}, config)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while sorting by an allowed field: %s", err)
+	}
+
+	// _id is added as a tiebreaker in the same direction
+	var expectedKeys = []sortKey{{Field: "timestamp"}, {Field: "_id"}}
+	if !reflect.DeepEqual(keys, expectedKeys) {
+		t.Errorf("Unexpected sort keys were returned Expected: %v, Got: %v", expectedKeys, keys)
+	}
+
+	keys, _ = parseSortKeys(url.Values{"sort": {"-_id"}}, config)
+	expectedKeys = []sortKey{{Field: "_id", Descending: true}}
+	if !reflect.DeepEqual(keys, expectedKeys) {
+		t.Errorf("Unexpected sort keys were returned Expected: %v, Got: %v", expectedKeys, keys)
+	}
+}
+
+func TestParseSortKeysDisallowedField(t *testing.T) {
+	var config = QueryConfig{SortableFields: []string{"timestamp", "_id"}}
+
+	var _, err = parseSortKeys(url.Values{"sort": {"-timestamp,summary"}}, config)
+
+	var httpError, ok = err.(mux.HttpError)
+	if !ok || httpError.Code != http.StatusBadRequest {
+		t.Fatalf("Sorting by a field that is not allowed did not result in a 400 Got: %v", err)
+	}
+
+	if !strings.Contains(httpError.Description, "summary") || !strings.Contains(httpError.Description, "timestamp, _id") {
+		t.Errorf("The error did not explain the sort restriction Got: %s", httpError.Description)
+	}
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/qri-io/jsonschema"
+)
+
+// header set on the responses of the handlers that add events when validation is disabled
+// so clients know their events were not checked against the json schema
+const ValidationHeader = "X-Validation"
+
+// validate an event body using the json schema unless validation is disabled
+// disabling validation takes precedence over the schema but the body still has to be json
+func validateEvent(ctx context.Context, schema *jsonschema.Schema, d []byte, config InsertConfig) (ValidationError, error) {
+	if config.DisableValidation && json.Valid(d) {
+		return nil, nil
+	}
+
+	return validateEventBody(ctx, schema, d)
+}
+
+// tell the client that the events in the request are not validated
+func setValidationHeader(writer http.ResponseWriter, config InsertConfig) {
+	if config.DisableValidation {
+		writer.Header().Set(ValidationHeader, "disabled")
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestEventsAddHandlerValidationDisabled(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("validation disabled", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		// the event does not match the schema but is added anyway
+		var writer = httptest.NewRecorder()
+		var handler = EventsAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{DisableValidation: true})
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(`{"summary":1}`)))
+
+		if writer.Code != http.StatusNoContent {
+			t.Fatalf("An event that was not validated was not added Expected: %d, Got: %d", http.StatusNoContent, writer.Code)
+		}
+
+		if writer.Header().Get(ValidationHeader) != "disabled" {
+			t.Errorf("Expected the %s header to be disabled but got %q", ValidationHeader, writer.Header().Get(ValidationHeader))
+		}
+
+		// the body still has to be json
+		writer = httptest.NewRecorder()
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(`{"summary":`)))
+
+		if writer.Code != http.StatusBadRequest {
+			t.Errorf("A body that is not json was not rejected Expected: %d, Got: %d", http.StatusBadRequest, writer.Code)
+		}
+	})
+}
+
+func TestEventsAddHandlerValidationEnabledNoHeader(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("validation enabled", func(mt *mtest.T) {
+		var writer = httptest.NewRecorder()
+		var handler = EventsAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{})
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(`{"summary":1}`)))
+
+		if writer.Code != http.StatusBadRequest {
+			t.Fatalf("An invalid event was not rejected Expected: %d, Got: %d", http.StatusBadRequest, writer.Code)
+		}
+
+		if _, ok := writer.Header()[ValidationHeader]; ok {
+			t.Errorf("The %s header was set while validation was enabled", ValidationHeader)
+		}
+	})
+}
+
+func TestEventsBulkAddHandlerValidationDisabled(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("validation disabled", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		var writer = httptest.NewRecorder()
+		var handler = EventsBulkAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{DisableValidation: true})
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events/batch", strings.NewReader(`[{"summary":1},{"summary":2}]`)))
+
+		if writer.Code != http.StatusNoContent {
+			t.Fatalf("A batch that was not validated was not added Expected: %d, Got: %d", http.StatusNoContent, writer.Code)
+		}
+
+		if writer.Header().Get(ValidationHeader) != "disabled" {
+			t.Errorf("Expected the %s header to be disabled but got %q", ValidationHeader, writer.Header().Get(ValidationHeader))
+		}
+	})
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Transformer changes a query result before it is sent to the user (i.e. to hide or rename fields)
+// the document can be changed in place or a new one can be returned
+type Transformer interface {
+	Transform(doc map[string]interface{}) map[string]interface{}
+}
+
+// TransformFunc lets a plain function be used as a Transformer
+type TransformFunc func(doc map[string]interface{}) map[string]interface{}
+
+func (self TransformFunc) Transform(doc map[string]interface{}) map[string]interface{} {
+	return self(doc)
+}
+
+// TransformPipeline applies its transforms to a document in order
+type TransformPipeline []Transformer
+
+func (self TransformPipeline) Transform(doc map[string]interface{}) map[string]interface{} {
+	for _, transform := range self {
+		doc = transform.Transform(doc)
+	}
+
+	return doc
+}
+
+// add transforms to the end of a copy of the pipeline
+// the pipeline itself is shared by every request so it is never appended to directly
+func (self TransformPipeline) with(transforms ...Transformer) TransformPipeline {
+	var pipeline = make(TransformPipeline, 0, len(self)+len(transforms))
+	pipeline = append(pipeline, self...)
+
+	return append(pipeline, transforms...)
+}
+
+// get the pipeline applied to the results of a query
+// the transforms of the query are added to the configured transforms
+// and the bson types are flattened last (if configured) so every other transform sees the stored values
+func (self QueryConfig) resultTransforms(transforms ...Transformer) TransformPipeline {
+	if self.FlattenBsonTypes {
+		transforms = append(transforms, FlattenTransform())
+	}
+
+	return self.Transforms.with(transforms...)
+}
+
+// RedactTransform removes the fields at the dot separated paths from documents
+func RedactTransform(fields []string) Transformer {
+	return TransformFunc(func(doc map[string]interface{}) map[string]interface{} {
+		for _, path := range fields {
+			var parent, name = fieldParent(doc, path)
+			if parent != nil {
+				delete(parent, name)
+			}
+		}
+
+		return doc
+	})
+}
+
+// number of characters at the end of a masked string that are left visible
+const maskVisibleChars = 4
+
+// MaskTransform hides the values of the fields at the dot separated paths
+// strings keep their last 4 characters (i.e. ************1234) so values can still be told apart
+// and any other value is replaced with a fixed mask
+func MaskTransform(fields []string) Transformer {
+	return TransformFunc(func(doc map[string]interface{}) map[string]interface{} {
+		for _, path := range fields {
+			var parent, name = fieldParent(doc, path)
+			if parent == nil {
+				continue
+			}
+
+			var value, ok = parent[name]
+			if !ok || value == nil {
+				continue
+			}
+
+			var masked = strings.Repeat("*", maskVisibleChars)
+			if s, isString := value.(string); isString {
+				var runes = []rune(s)
+				if len(runes) > maskVisibleChars*2 {
+					masked = strings.Repeat("*", len(runes)-maskVisibleChars) + string(runes[len(runes)-maskVisibleChars:])
+				} else {
+					masked = strings.Repeat("*", len(runes))
+				}
+			}
+
+			parent[name] = masked
+		}
+
+		return doc
+	})
+}
+
+// AliasTransform renames the top level fields of documents using the aliases
+func AliasTransform(aliases map[string]string) Transformer {
+	return TransformFunc(func(doc map[string]interface{}) map[string]interface{} {
+		return aliasFields(doc, aliases)
+	})
+}
+
+// IdFormatTransform changes how the _id of documents is returned (see IdFormats)
+func IdFormatTransform(format string) Transformer {
+	return TransformFunc(func(doc map[string]interface{}) map[string]interface{} {
+		return formatId(doc, format)
+	})
+}
+
+// FlattenTransform replaces bson values that do not have a plain json form with one that does
+// dates become RFC 3339 strings in UTC, object ids become hex strings
+// and decimals become json numbers (or strings for NaN and Infinity which json numbers can not hold)
+// values are replaced at any depth including inside arrays
+func FlattenTransform() Transformer {
+	return TransformFunc(func(doc map[string]interface{}) map[string]interface{} {
+		for name, value := range doc {
+			doc[name] = flattenValue(value)
+		}
+
+		return doc
+	})
+}
+
+// get the plain json form of a bson value
+func flattenValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case primitive.DateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case primitive.ObjectID:
+		return v.Hex()
+	case primitive.Decimal128:
+		if _, _, err := v.BigInt(); err != nil {
+			return v.String()
+		}
+		return json.Number(v.String())
+	case map[string]interface{}:
+		for name, item := range v {
+			v[name] = flattenValue(item)
+		}
+	case primitive.M:
+		for name, item := range v {
+			v[name] = flattenValue(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = flattenValue(item)
+		}
+	case primitive.A:
+		for i, item := range v {
+			v[i] = flattenValue(item)
+		}
+	}
+
+	return value
+}
+
+// creates a transform from the comma separated arguments it was configured with
+type TransformFactory func(args []string) (Transformer, error)
+
+// the transforms that can be used in a transform pipeline spec by name
+var transformFactories = map[string]TransformFactory{
+	"redact": func(args []string) (Transformer, error) {
+		return RedactTransform(args), nil
+	},
+	"mask": func(args []string) (Transformer, error) {
+		return MaskTransform(args), nil
+	},
+	"alias": func(args []string) (Transformer, error) {
+		var aliases, err = ParseFieldAliases(strings.Join(args, ","))
+		return AliasTransform(aliases), err
+	},
+	"id_format": func(args []string) (Transformer, error) {
+		if len(args) != 1 || !containsField(IdFormats, args[0]) {
+			return nil, fmt.Errorf("The id_format transform must have one of %s", strings.Join(IdFormats, ", "))
+		}
+		return IdFormatTransform(args[0]), nil
+	},
+}
+
+// RegisterTransform adds a transform that can be used by name in a transform pipeline spec
+// it must be called before the spec is parsed
+func RegisterTransform(name string, factory TransformFactory) {
+	transformFactories[name] = factory
+}
+
+// get the names of the transforms in sorted order
+func transformNames() []string {
+	var names = make([]string, 0, len(transformFactories))
+	for name := range transformFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ParseTransformPipeline creates a pipeline from a spec listing the transforms in the order they are applied
+// transforms are separated by semicolons and each one is a name and its comma separated arguments
+// i.e. redact=attributes.ssn,attributes.dob;mask=attributes.email;alias=actor:user
+func ParseTransformPipeline(spec string) (TransformPipeline, error) {
+	var pipeline TransformPipeline
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		var parts = strings.SplitN(entry, "=", 2)
+
+		var factory, ok = transformFactories[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("The transform %q is not recognized. Valid transforms are %s",
+				parts[0], strings.Join(transformNames(), ", "))
+		}
+
+		var args []string
+		if len(parts) == 2 {
+			for _, arg := range strings.Split(parts[1], ",") {
+				if arg = strings.TrimSpace(arg); len(arg) != 0 {
+					args = append(args, arg)
+				}
+			}
+		}
+
+		var transform, err = factory(args)
+		if err != nil {
+			return nil, err
+		}
+
+		pipeline = append(pipeline, transform)
+	}
+
+	return pipeline, nil
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestTransformPipelineOrder(t *testing.T) {
+	var newDoc = func() map[string]interface{} {
+		return map[string]interface{}{
+			"actor":      "mitchell",
+			"attributes": map[string]interface{}{"email": "mitchell@example.com"},
+		}
+	}
+
+	// renaming actor first means the redact transform no longer finds it
+	var aliasFirst = TransformPipeline{AliasTransform(map[string]string{"actor": "user"}), RedactTransform([]string{"actor"})}
+	var doc = aliasFirst.Transform(newDoc())
+	if doc["user"] != "mitchell" {
+		t.Errorf("The aliased field was redacted even though the alias was applied first Got: %v", doc)
+	}
+
+	var redactFirst = TransformPipeline{RedactTransform([]string{"actor"}), AliasTransform(map[string]string{"actor": "user"})}
+	doc = redactFirst.Transform(newDoc())
+	if _, ok := doc["user"]; ok {
+		t.Errorf("The field was not redacted before it was aliased Got: %v", doc)
+	}
+	if _, ok := doc["actor"]; ok {
+		t.Errorf("The redacted field is still in the document Got: %v", doc)
+	}
+}
+
+func TestParseTransformPipeline(t *testing.T) {
+	var pipeline, err = ParseTransformPipeline("mask=attributes.email;alias=attributes:details")
+	if err != nil {
+		t.Fatalf("The transform pipeline could not be parsed: %s", err)
+	}
+
+	var doc = pipeline.Transform(map[string]interface{}{
+		"attributes": map[string]interface{}{"email": "mitchell@example.com", "plan": "pro"},
+	})
+
+	var expected = map[string]interface{}{
+		"details": map[string]interface{}{"email": "****************.com", "plan": "pro"},
+	}
+	if !reflect.DeepEqual(doc, expected) {
+		t.Errorf("Unexpected transformed document Expected: %v, Got: %v", expected, doc)
+	}
+
+	for _, spec := range []string{"encrypt=attributes.email", "id_format=base64", "alias=actor"} {
+		if _, err = ParseTransformPipeline(spec); err == nil {
+			t.Errorf("The invalid transform pipeline %q was parsed", spec)
+		}
+	}
+}
+
+func TestRegisterTransform(t *testing.T) {
+	RegisterTransform("uppercase_summary", func(args []string) (Transformer, error) {
+		return TransformFunc(func(doc map[string]interface{}) map[string]interface{} {
+			if summary, ok := doc["summary"].(string); ok {
+				doc["summary"] = strings.ToUpper(summary)
+			}
+			return doc
+		}), nil
+	})
+	defer delete(transformFactories, "uppercase_summary")
+
+	var pipeline, err = ParseTransformPipeline("uppercase_summary")
+	if err != nil {
+		t.Fatalf("A registered transform could not be used: %s", err)
+	}
+
+	if doc := pipeline.Transform(map[string]interface{}{"summary": "paid"}); doc["summary"] != "PAID" {
+		t.Errorf("The registered transform was not applied Got: %v", doc)
+	}
+}
+
+func TestEventsQueryHandlerTransforms(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("transforms", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt, bson.D{
+			{Key: "actor", Value: "mitchell"},
+			{Key: "attributes", Value: bson.D{{Key: "ssn", Value: "123-45-6789"}}},
+		}))
+
+		var config = QueryConfig{Transforms: TransformPipeline{RedactTransform([]string{"attributes.ssn"})}}
+
+		// the alias of the query is applied after the configured transforms
+		var writer = httptest.NewRecorder()
+		EventsQueryHandler(mt.Coll, config).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/events?alias=actor:user", nil))
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(queryInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		var body = writer.Body.String()
+		if strings.Contains(body, "123-45-6789") || !strings.Contains(body, `"user":"mitchell"`) {
+			t.Errorf("The transforms were not applied to the results Got: %s", body)
+		}
+	})
+}
+
+func TestFlattenTransform(t *testing.T) {
+	var id, _ = primitive.ObjectIDFromHex("62508ea4c4f0f7e1b5a3e6d1")
+	var price, _ = primitive.ParseDecimal128("12.50")
+	var date = primitive.NewDateTimeFromTime(time.Date(2022, 4, 8, 19, 26, 28, 123000000, time.UTC))
+
+	var doc = FlattenTransform().Transform(map[string]interface{}{
+		"created":    date,
+		"order_id":   id,
+		"price":      price,
+		"attributes": map[string]interface{}{"refunded": primitive.A{date}},
+	})
+
+	if doc["created"] != "2022-04-08T19:26:28.123Z" {
+		t.Errorf("The date was not flattened to an RFC 3339 string Got: %#v", doc["created"])
+	}
+	if doc["order_id"] != "62508ea4c4f0f7e1b5a3e6d1" {
+		t.Errorf("The object id was not flattened to a hex string Got: %#v", doc["order_id"])
+	}
+
+	var d, _ = json.Marshal(doc)
+	if !strings.Contains(string(d), `"price":12.50`) {
+		t.Errorf("The decimal was not flattened to a json number Got: %s", d)
+	}
+	if !strings.Contains(string(d), `"refunded":["2022-04-08T19:26:28.123Z"]`) {
+		t.Errorf("The nested date was not flattened Got: %s", d)
+	}
+
+	// json numbers can not hold NaN so it is kept as a string
+	var notANumber, _ = primitive.ParseDecimal128("NaN")
+	doc = FlattenTransform().Transform(map[string]interface{}{"price": notANumber})
+	if doc["price"] != "NaN" {
+		t.Errorf("A decimal that is not a number was not flattened to a string Got: %#v", doc["price"])
+	}
+}
+
+func TestEventsQueryHandlerFlattenBsonTypes(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("flatten", func(mt *mtest.T) {
+		var price, _ = primitive.ParseDecimal128("12.50")
+		mt.AddMockResponses(mockCursorResponse(mt, bson.D{
+			{Key: "price", Value: price},
+			{Key: "created", Value: primitive.NewDateTimeFromTime(time.Date(2022, 4, 8, 19, 26, 28, 0, time.UTC))},
+		}))
+
+		var writer = httptest.NewRecorder()
+		EventsQueryHandler(mt.Coll, QueryConfig{FlattenBsonTypes: true}).ServeHTTP(writer,
+			httptest.NewRequest(http.MethodGet, "/events", nil))
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(queryInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		var body = writer.Body.String()
+		if !strings.Contains(body, `"price":12.50`) || !strings.Contains(body, `"created":"2022-04-08T19:26:28Z"`) {
+			t.Errorf("The bson types in the results were not flattened Got: %s", body)
+		}
+	})
+}
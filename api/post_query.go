@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"github.com/qri-io/jsonschema"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// EventsPostQueryHandler creates an http handler that queries events using a json body
+// instead of url query params so complex filters are not limited by url length
+// the body is an object with a filter object holding the same key value pairs as the GET query params
+// and any of the reserved query params (limit, order, after etc.) as top level keys
+// i.e. {"filter":{"_id__in":["<id>","<id>"]},"limit":10}
+func EventsPostQueryHandler(db *mongo.Collection, config QueryConfig) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// read the data from the request body
+		var d, err = ioutil.ReadAll(request.Body)
+		if err != nil {
+			err = mux.DefaultHttpError(http.StatusBadRequest)
+		}
+
+		var queryParams url.Values
+		if err == nil {
+			queryParams, err = queryParamsFromJson(d)
+		}
+
+		if err == nil {
+			queryEvents(writer, request, db, config, queryParams)
+		} else {
+			mux.WriteJsonResponse(writer, err)
+		}
+	})
+}
+
+// translate a json query body into the equivalent url query params
+// so the query is built exactly the same way the GET handler builds it
+func queryParamsFromJson(d []byte) (url.Values, error) {
+	var body map[string]interface{}
+
+	var err = json.Unmarshal(d, &body)
+	if err != nil {
+		return nil, queryBodyError("The request body must be a json object")
+	}
+
+	var queryParams = make(url.Values)
+
+	for key, value := range body {
+		if key == "filter" {
+			var filter, ok = value.(map[string]interface{})
+			if !ok {
+				return nil, queryBodyError("The filter must be a json object")
+			}
+
+			for field, fieldValue := range filter {
+				if isReservedQueryParam(field) {
+					return nil, queryBodyError(fmt.Sprintf("The filter field %s is reserved and must be a top level key", field))
+				}
+
+				var valueString, err = queryParamString(fieldValue)
+				if err != nil {
+					return nil, queryBodyError(fmt.Sprintf("The filter field %s %s", field, err))
+				}
+
+				queryParams.Set(field, valueString)
+			}
+
+			continue
+		}
+
+		if !isReservedQueryParam(key) {
+			return nil, queryBodyError(fmt.Sprintf("The query option %s is not recognized", key))
+		}
+
+		var valueString, err = queryParamString(value)
+		if err != nil {
+			return nil, queryBodyError(fmt.Sprintf("The query option %s %s", key, err))
+		}
+
+		queryParams.Set(key, valueString)
+	}
+
+	return queryParams, nil
+}
+
+// format a json value the same way it would be written in a url query param
+// lists are written as comma separated values
+func queryParamString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []interface{}:
+		var values = make([]string, len(v))
+		for i, item := range v {
+			var itemString, err = queryParamString(item)
+			if err != nil {
+				return "", err
+			}
+
+			values[i] = itemString
+		}
+
+		return strings.Join(values, ","), nil
+	}
+
+	return "", fmt.Errorf("must be a string, number, boolean or a list of them")
+}
+
+// create a filter from the json filter object that POST /events/query accepts
+// the filter is built exactly the same way the query endpoints build it
+// an empty filter is returned if there is no filter object
+func filterFromJson(rawFilter json.RawMessage, schema *jsonschema.Schema) (map[string]interface{}, error) {
+	if len(rawFilter) == 0 {
+		return make(map[string]interface{}), nil
+	}
+
+	var queryBody, _ = json.Marshal(map[string]json.RawMessage{"filter": rawFilter})
+
+	var queryParams, err = queryParamsFromJson(queryBody)
+	if err != nil {
+		return nil, err
+	}
+
+	return CreateFilterFromQuery(queryParams, schema)
+}
+
+// create a 400 error describing why a json query body is invalid
+func queryBodyError(description string) mux.HttpError {
+	return mux.HttpError{
+		Code:        http.StatusBadRequest,
+		Description: description,
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// run a request against a query handler and return the response and the find command sent to the db
+func runMockQuery(mt *mtest.T, handler http.Handler, request *http.Request) (*httptest.ResponseRecorder, bson.Raw) {
+	mt.AddMockResponses(mockCursorResponse(mt, bson.D{{Key: "summary", Value: "one"}}, bson.D{{Key: "summary", Value: "two"}}))
+	mt.ClearEvents()
+
+	var writer = httptest.NewRecorder()
+	handler.ServeHTTP(writer, request)
+
+	var started = mt.GetStartedEvent()
+	if started == nil {
+		mt.Fatalf("No command was sent to the db Response: %s", writer.Body.String())
+	}
+
+	return writer, started.Command
+}
+
+func TestEventsPostQueryHandlerMatchesGet(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("post", func(mt *mtest.T) {
+		var ids = []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+		var config = QueryConfig{DefaultLimit: 100}
+
+		var getRequest = httptest.NewRequest(http.MethodGet,
+			"/events?_id__in="+ids[0].Hex()+","+ids[1].Hex()+"&source.service_name=billing&limit=10&order=asc", nil)
+		var getWriter, getCommand = runMockQuery(mt, EventsQueryHandler(mt.Coll, config), getRequest)
+
+		var body = `{"filter":{"_id__in":["` + ids[0].Hex() + `","` + ids[1].Hex() + `"],"source.service_name":"billing"},"limit":10,"order":"asc"}`
+		var postRequest = httptest.NewRequest(http.MethodPost, "/events/query", strings.NewReader(body))
+		var postWriter, postCommand = runMockQuery(mt, EventsPostQueryHandler(mt.Coll, config), postRequest)
+
+		// the filters are compared as maps since their key order is not deterministic
+		var getFilter, postFilter bson.M
+		getCommand.Lookup("filter").Unmarshal(&getFilter)
+		postCommand.Lookup("filter").Unmarshal(&postFilter)
+		if !reflect.DeepEqual(getFilter, postFilter) {
+			t.Errorf("The POST query filter did not match the GET query Expected: %v, Got: %v", getFilter, postFilter)
+		}
+
+		for _, key := range []string{"sort", "limit"} {
+			if !getCommand.Lookup(key).Equal(postCommand.Lookup(key)) {
+				t.Errorf("The POST query %s did not match the GET query Expected: %s, Got: %s", key, getCommand.Lookup(key), postCommand.Lookup(key))
+			}
+		}
+
+		if getWriter.Code != http.StatusOK || postWriter.Body.String() != getWriter.Body.String() {
+			t.Errorf("The POST query results did not match the GET query results Expected: %s, Got: %s", getWriter.Body.String(), postWriter.Body.String())
+		}
+	})
+}
+
+func TestEventsPostQueryHandlerInvalidBody(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("invalid", func(mt *mtest.T) {
+		for _, body := range []string{`[]`, `{"filter":"summary"}`, `{"filter":{"limit":10}}`, `{"unknown":1}`, `{"filter":{"source":{"service_name":"billing"}}}`} {
+			var writer = httptest.NewRecorder()
+			var request = httptest.NewRequest(http.MethodPost, "/events/query", strings.NewReader(body))
+			EventsPostQueryHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+			if writer.Code != http.StatusBadRequest {
+				t.Errorf("An invalid query body %s did not result in a 400 Got: %d", body, writer.Code)
+			}
+		}
+	})
+}
+
+func TestEventsPostQueryHandlerFields(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("fields", func(mt *mtest.T) {
+		var request = httptest.NewRequest(http.MethodPost, "/events/query", strings.NewReader(`{"fields":["summary","source.service_name"]}`))
+		var writer, command = runMockQuery(mt, EventsPostQueryHandler(mt.Coll, QueryConfig{DefaultLimit: 100}), request)
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf("A query with fields did not result in a 200 Got: %d Response: %s", writer.Code, writer.Body.String())
+		}
+
+		// the sort fields are always returned so the next page token can be created
+		var expected = bson.D{{Key: "summary", Value: int32(1)}, {Key: "source.service_name", Value: int32(1)},
+			{Key: "timestamp", Value: int32(1)}, {Key: "_id", Value: int32(1)}}
+		var projection bson.D
+		command.Lookup("projection").Unmarshal(&projection)
+		if !reflect.DeepEqual(projection, expected) {
+			t.Errorf("The query projection was not the requested fields Expected: %v, Got: %v", expected, projection)
+		}
+	})
+
+	mt.Run("invalid", func(mt *mtest.T) {
+		for _, body := range []string{`{"fields":[""]}`, `{"fields":["$$ROOT"]}`, `{"fields":["source","source.service_name"]}`, `{"fields":["summary","summary"]}`} {
+			var writer = httptest.NewRecorder()
+			var request = httptest.NewRequest(http.MethodPost, "/events/query", strings.NewReader(body))
+			EventsPostQueryHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+			if writer.Code != http.StatusBadRequest {
+				t.Errorf("An invalid fields list %s did not result in a 400 Got: %d", body, writer.Code)
+			}
+		}
+	})
+}
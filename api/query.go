@@ -0,0 +1,171 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// query parameters that control how a query is run rather than which events are matched
+// these are never added to the filter created by CreateFilterFromQuery
+var reservedQueryParams = map[string]struct{}{
+	"limit":       {},
+	"skip":        {},
+	"alias":       {},
+	"order":       {},
+	"after":       {},
+	"sort":        {},
+	"from":        {},
+	"to":          {},
+	"id_format":   {},
+	"snapshot":    {},
+	"all":         {},
+	"hint":        {},
+	"consistency": {},
+	"last":        {},
+	"fields":      {},
+	// accepted so clients that ask for sorted keys are not filtering on a sortKeys field
+	// the keys of the results are always sorted so it has no effect
+	"sortKeys": {},
+}
+
+// check if a query parameter is used to control the query rather than to filter events
+func isReservedQueryParam(name string) bool {
+	var _, reserved = reservedQueryParams[name]
+
+	return reserved
+}
+
+// CreateFindOptionsFromQuery uses the reserved url query params to create the options
+// used when running a find command against the db
+func CreateFindOptionsFromQuery(queryParams url.Values, config QueryConfig) (*options.FindOptions, error) {
+	var findOptions = options.Find()
+
+	var limit, err = parseLimit(queryParams, config)
+	if err == nil && limit > 0 {
+		findOptions.SetLimit(limit)
+	}
+
+	var skip int64
+	if err == nil {
+		skip, err = parseSkip(queryParams)
+	}
+	if err == nil && skip > 0 {
+		findOptions.SetSkip(skip)
+	}
+
+	var keys []sortKey
+	if err == nil {
+		keys, err = parseSortKeys(queryParams, config)
+	}
+	if err == nil {
+		findOptions.SetSort(sortDocument(keys))
+	}
+
+	if err == nil {
+		err = applyProjection(findOptions, queryParams, keys)
+	}
+
+	return findOptions, err
+}
+
+// only return the fields listed in the fields query param (i.e. fields=timestamp,summary)
+// _id and the sort fields are always returned so the next page token can be created from the last result
+func applyProjection(findOptions *options.FindOptions, queryParams url.Values, keys []sortKey) error {
+	if !queryParams.Has("fields") {
+		return nil
+	}
+
+	var projection bson.D
+	for _, field := range strings.Split(queryParams.Get("fields"), ",") {
+		// an overlapping field (i.e. source and source.service_name) is rejected by the db
+		if !isFieldPath(field) || projectionIncludes(projection, field) {
+			return mux.HttpError{
+				Code:        http.StatusBadRequest,
+				Description: "The fields query parameter must be a comma separated list of distinct field names",
+			}
+		}
+
+		projection = append(projection, bson.E{Key: field, Value: 1})
+	}
+
+	for _, key := range keys {
+		if !projectionIncludes(projection, key.Field) {
+			projection = append(projection, bson.E{Key: key.Field, Value: 1})
+		}
+	}
+
+	findOptions.SetProjection(projection)
+
+	return nil
+}
+
+// check if a field is a plain dot separated field path rather than an expression (i.e. $$ROOT)
+func isFieldPath(field string) bool {
+	for _, part := range strings.Split(field, ".") {
+		if len(part) == 0 || strings.Contains(part, "$") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// check if a field or one of its parents or children is already in a projection
+func projectionIncludes(projection bson.D, field string) bool {
+	for _, element := range projection {
+		if element.Key == field || strings.HasPrefix(field, element.Key+".") || strings.HasPrefix(element.Key, field+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// get the number of events the user wants returned
+// the default limit is used when the user does not provide one
+// and the max limit caps whatever the user asks for
+// a limit of 0 means no limit
+func parseLimit(queryParams url.Values, config QueryConfig) (int64, error) {
+	var limit = config.DefaultLimit
+
+	if queryParams.Has("limit") {
+		var err error
+		limit, err = strconv.ParseInt(queryParams.Get("limit"), 10, 64)
+		if err != nil || limit < 0 {
+			return 0, mux.HttpError{
+				Code:        http.StatusBadRequest,
+				Description: "The limit query parameter must be a non negative integer",
+			}
+		}
+	}
+
+	if config.MaxLimit > 0 && (limit == 0 || limit > config.MaxLimit) {
+		limit = config.MaxLimit
+	}
+
+	return limit, nil
+}
+
+// get the number of matching events the user wants skipped before the results start
+// the db still has to walk every skipped event so the after query param is the better way to page deep into the results
+func parseSkip(queryParams url.Values) (int64, error) {
+	if !queryParams.Has("skip") {
+		return 0, nil
+	}
+
+	var skip, err = strconv.ParseInt(queryParams.Get("skip"), 10, 64)
+	if err != nil || skip < 0 {
+		return 0, mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: "The skip query parameter must be a non negative integer",
+		}
+	}
+
+	return skip, nil
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var findOptionsInvalidLimitError = "An unexpected limit was set when creating find options from a query " +
+	"Expected: %d, Got: %d"
+
+// query config with the same default and max limits used by the server
+var limitTestConfig = QueryConfig{
+	DefaultLimit: 100,
+	MaxLimit:     10000,
+}
+
+// get the limit set on the find options or 0 if no limit was set
+func findOptionsLimit(findOptions *options.FindOptions) int64 {
+	if findOptions.Limit == nil {
+		return 0
+	}
+
+	return *findOptions.Limit
+}
+
+func TestCreateFindOptionsFromQueryNoLimitUsesDefault(t *testing.T) {
+	var findOptions, err = CreateFindOptionsFromQuery(url.Values{}, limitTestConfig)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while creating find options: %s", err)
+	}
+
+	if findOptionsLimit(findOptions) != 100 {
+		t.Errorf(findOptionsInvalidLimitError, 100, findOptionsLimit(findOptions))
+	}
+}
+
+func TestCreateFindOptionsFromQueryInRangeLimit(t *testing.T) {
+	var findOptions, err = CreateFindOptionsFromQuery(url.Values{"limit": {"500"}}, limitTestConfig)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while creating find options: %s", err)
+	}
+
+	if findOptionsLimit(findOptions) != 500 {
+		t.Errorf(findOptionsInvalidLimitError, 500, findOptionsLimit(findOptions))
+	}
+}
+
+func TestCreateFindOptionsFromQueryOverMaxLimitIsCapped(t *testing.T) {
+	var findOptions, err = CreateFindOptionsFromQuery(url.Values{"limit": {"50000"}}, limitTestConfig)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while creating find options: %s", err)
+	}
+
+	if findOptionsLimit(findOptions) != 10000 {
+		t.Errorf(findOptionsInvalidLimitError, 10000, findOptionsLimit(findOptions))
+	}
+}
+
+func TestCreateFindOptionsFromQueryInvalidLimit(t *testing.T) {
+	for _, limit := range []string{"ten", "-1"} {
+		var _, err = CreateFindOptionsFromQuery(url.Values{"limit": {limit}}, limitTestConfig)
+
+		var httpErr, ok = err.(mux.HttpError)
+		if !ok || httpErr.Code != http.StatusBadRequest {
+			t.Errorf("An invalid limit %q did not result in a 400 error: %v", limit, err)
+		}
+	}
+}
+
+func TestEventsQueryHandlerEmptyFilterRejected(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("empty filter", func(mt *mtest.T) {
+		var writer = httptest.NewRecorder()
+		var handler = EventsQueryHandler(mt.Coll, QueryConfig{EmptyFilterPolicy: EmptyFilterRequireFilter})
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/events?limit=10", nil))
+
+		if writer.Code != http.StatusBadRequest {
+			t.Errorf(queryInvalidStatusError, http.StatusBadRequest, writer.Code)
+		}
+
+		if len(mt.GetAllStartedEvents()) != 0 {
+			t.Error("The database was queried without a filter")
+		}
+	})
+}
+
+func TestEventsQueryHandlerSortParam(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("sort", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt))
+
+		var writer = httptest.NewRecorder()
+		EventsQueryHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/events?sort=-timestamp,summary,&summary=one", nil))
+		if writer.Code != http.StatusOK {
+			t.Fatalf(queryInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		var command = mt.GetStartedEvent().Command
+
+		// the fields are sorted on in order with _id added last so the order is stable
+		var sort, err = command.LookupErr("sort")
+		var elements, _ = sort.Document().Elements()
+		if err != nil || len(elements) != 3 || elements[0].Key() != "timestamp" || elements[1].Key() != "summary" || elements[2].Key() != "_id" {
+			t.Fatalf("An unexpected sort was used Got: %s", sort)
+		}
+		if elements[0].Value().AsInt64() != -1 || elements[1].Value().AsInt64() != 1 {
+			t.Errorf("The sort directions were not applied Got: %s", sort)
+		}
+
+		if _, err = command.LookupErr("filter", "sort"); err == nil {
+			t.Errorf("The sort query parameter was added to the filter Got: %s", command.Lookup("filter"))
+		}
+	})
+}
+
+func TestCreateFindOptionsFromQuerySkip(t *testing.T) {
+	var findOptions, err = CreateFindOptionsFromQuery(url.Values{"limit": {"50"}, "skip": {"100"}}, limitTestConfig)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while creating find options: %s", err)
+	}
+
+	if findOptions.Skip == nil || *findOptions.Skip != 100 {
+		t.Errorf("An unexpected skip was set when creating find options from a query Expected: 100, Got: %v", findOptions.Skip)
+	}
+
+	if findOptionsLimit(findOptions) != 50 {
+		t.Errorf(findOptionsInvalidLimitError, 50, findOptionsLimit(findOptions))
+	}
+}
+
+func TestCreateFindOptionsFromQueryInvalidSkip(t *testing.T) {
+	for _, skip := range []string{"ten", "-1"} {
+		var _, err = CreateFindOptionsFromQuery(url.Values{"skip": {skip}}, limitTestConfig)
+
+		var httpErr, ok = err.(mux.HttpError)
+		if !ok || httpErr.Code != http.StatusBadRequest {
+			t.Errorf("An invalid skip %q did not result in a 400 error: %v", skip, err)
+		}
+	}
+}
+
+func TestCreateFilterFromQueryIgnoresPaginationParams(t *testing.T) {
+	var filter, _ = CreateFilterFromQuery(url.Values{"limit": {"50"}, "skip": {"100"}, "summary": {"one"}}, nil)
+
+	if len(filter) != 1 || filter["summary"] != "one" {
+		t.Errorf("The pagination query parameters were added to the filter Got: %v", filter)
+	}
+}
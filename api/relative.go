@@ -0,0 +1,108 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellkelly/auditlog/mux"
+)
+
+// field events are filtered on by the last query param when no timestamp field is configured
+const DefaultTimestampField = "timestamp"
+
+// get the field that holds the time an event happened
+func (self QueryConfig) timestampField() string {
+	if len(self.TimestampField) == 0 {
+		return DefaultTimestampField
+	}
+
+	return self.TimestampField
+}
+
+// parse a relative duration using the go duration syntax (i.e. 90m or 1h30m)
+// a number of days (i.e. 7d) is also accepted since go durations stop at hours
+func parseRelativeDuration(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		var days, err = strconv.ParseFloat(strings.TrimSuffix(value, "d"), 64)
+		if err != nil {
+			return 0, err
+		}
+
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// add a lower bound on the timestamp field to the filter using the last query param
+// i.e. last=24h matches events whose timestamp is within the 24 hours before now
+// timestamps are nanoseconds since the Unix epoch as described by the event schema
+func addRelativeTimeFilter(filter map[string]interface{}, queryParams url.Values, config QueryConfig, now time.Time) error {
+	if !queryParams.Has("last") {
+		return nil
+	}
+
+	var duration, err = parseRelativeDuration(queryParams.Get("last"))
+	if err != nil || duration <= 0 {
+		return mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: "The last query parameter must be a positive duration (i.e. 30m, 24h or 7d)",
+		}
+	}
+
+	var bound = map[string]interface{}{"$gte": now.Add(-duration).UnixNano()}
+
+	// the bound is added with $and so it never clashes with a filter on the timestamp field
+	var and, _ = filter["$and"].([]interface{})
+	filter["$and"] = append(and, map[string]interface{}{config.timestampField(): bound})
+
+	return nil
+}
+
+// check that the time window of a query is not longer than the configured maximum
+// the window starts at from (or the start of the last window) and ends at to (or now)
+// a query with to but without a start has no lower bound so it is always over the maximum
+// queries that are not bounded by time are not checked
+func checkTimeRange(queryParams url.Values, config QueryConfig, now time.Time) error {
+	if config.MaxTimeRange <= 0 {
+		return nil
+	}
+
+	// a window with no start is longer than any maximum
+	var window = time.Duration(math.MaxInt64)
+	if queryParams.Has("last") {
+		// the last param has already been checked when its filter was added
+		window, _ = parseRelativeDuration(queryParams.Get("last"))
+	} else if queryParams.Has("from") {
+		var from, err = parseRangeTime(queryParams, "from")
+		if err != nil {
+			return err
+		}
+
+		var to = now
+		if queryParams.Has("to") {
+			to, err = parseRangeTime(queryParams, "to")
+			if err != nil {
+				return err
+			}
+		}
+
+		window = to.Sub(from)
+	} else if !queryParams.Has("to") {
+		return nil
+	}
+
+	if window > config.MaxTimeRange {
+		return mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: fmt.Sprintf("The time range of the query can be at most %s", config.MaxTimeRange),
+		}
+	}
+
+	return nil
+}
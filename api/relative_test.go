@@ -0,0 +1,148 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// get the lower bound added to a filter by the last query param
+func relativeTimeBound(t *testing.T, last string, config QueryConfig, now time.Time) interface{} {
+	var filter = make(map[string]interface{})
+	var err = addRelativeTimeFilter(filter, url.Values{"last": {last}}, config, now)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while adding the relative time filter: %s", err)
+	}
+
+	var and, _ = filter["$and"].([]interface{})
+	if len(and) != 1 {
+		t.Fatalf("The relative time bound was not added to the filter Got: %v", filter)
+	}
+
+	var clause, _ = and[0].(map[string]interface{})
+	var bound, ok = clause[config.timestampField()].(map[string]interface{})
+	if !ok {
+		t.Fatalf("The relative time bound was not added on the %s field Got: %v", config.timestampField(), filter)
+	}
+
+	return bound["$gte"]
+}
+
+func TestRelativeTimeFilterHours(t *testing.T) {
+	var now = time.Date(2022, 4, 8, 19, 26, 28, 0, time.UTC)
+
+	var bound = relativeTimeBound(t, "1h", QueryConfig{}, now)
+	if bound != now.Add(-time.Hour).UnixNano() {
+		t.Errorf("Expected a bound of %d but got %v", now.Add(-time.Hour).UnixNano(), bound)
+	}
+}
+
+func TestRelativeTimeFilterDays(t *testing.T) {
+	var now = time.Date(2022, 4, 8, 19, 26, 28, 0, time.UTC)
+
+	var bound = relativeTimeBound(t, "7d", QueryConfig{TimestampField: "occurred_at"}, now)
+	if bound != now.Add(-7*24*time.Hour).UnixNano() {
+		t.Errorf("Expected a bound of %d but got %v", now.Add(-7*24*time.Hour).UnixNano(), bound)
+	}
+}
+
+func TestRelativeTimeFilterInvalidDuration(t *testing.T) {
+	for _, last := range []string{"yesterday", "-1h", "0s", "d"} {
+		var err = addRelativeTimeFilter(make(map[string]interface{}), url.Values{"last": {last}}, QueryConfig{}, time.Now())
+
+		var httpError, ok = err.(mux.HttpError)
+		if !ok || httpError.Code != http.StatusBadRequest {
+			t.Errorf("The invalid duration %q did not result in a 400 Got: %v", last, err)
+		}
+	}
+}
+
+func TestEventsQueryHandlerLast(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("last", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt))
+
+		var before = time.Now().Add(-24 * time.Hour).UnixNano()
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/events?last=24h&summary=one", nil)
+		EventsQueryHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(queryInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		var bound = mt.GetStartedEvent().Command.Lookup("filter", "$and", "0", "timestamp", "$gte").Int64()
+		if bound < before || bound > time.Now().Add(-24*time.Hour).UnixNano() {
+			t.Errorf("The timestamp bound %d is not 24 hours before the query", bound)
+		}
+	})
+}
+
+func TestCheckTimeRangeWithinMaximum(t *testing.T) {
+	var now = time.Date(2022, 4, 8, 19, 26, 28, 0, time.UTC)
+	var config = QueryConfig{MaxTimeRange: 7 * 24 * time.Hour}
+
+	for _, queryParams := range []url.Values{
+		{"from": {"2022-04-01T00:00:00Z"}, "to": {"2022-04-07T23:59:59Z"}},
+		{"from": {"2022-04-02T00:00:00Z"}},
+		{"last": {"7d"}},
+		// queries that are not bounded by time are not checked
+		{"summary": {"one"}},
+	} {
+		var err = checkTimeRange(queryParams, config, now)
+		if err != nil {
+			t.Errorf("A query within the maximum time range was rejected %v: %s", queryParams, err)
+		}
+	}
+}
+
+func TestCheckTimeRangeOverMaximum(t *testing.T) {
+	var now = time.Date(2022, 4, 8, 19, 26, 28, 0, time.UTC)
+	var config = QueryConfig{MaxTimeRange: 7 * 24 * time.Hour}
+
+	for _, queryParams := range []url.Values{
+		{"from": {"2021-04-01T00:00:00Z"}, "to": {"2022-04-01T00:00:00Z"}},
+		{"from": {"2022-03-01T00:00:00Z"}},
+		{"last": {"30d"}},
+		// a window without a start has no bound
+		{"to": {"2022-04-01T00:00:00Z"}},
+	} {
+		var err = checkTimeRange(queryParams, config, now)
+
+		var httpError, ok = err.(mux.HttpError)
+		if !ok || httpError.Code != http.StatusBadRequest {
+			t.Errorf("A query over the maximum time range did not result in a 400 %v: %v", queryParams, err)
+			continue
+		}
+
+		if httpError.Description != "The time range of the query can be at most 168h0m0s" {
+			t.Errorf("The error did not state the maximum time range Got: %s", httpError.Description)
+		}
+	}
+}
+
+func TestEventsQueryHandlerTimeRangeOverMaximum(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("over maximum", func(mt *mtest.T) {
+		var writer = httptest.NewRecorder()
+		EventsQueryHandler(mt.Coll, QueryConfig{MaxTimeRange: 24 * time.Hour}).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/events?last=2d", nil))
+
+		if writer.Code != http.StatusBadRequest {
+			t.Errorf(queryInvalidStatusError, http.StatusBadRequest, writer.Code)
+		}
+
+		if len(mt.GetAllStartedEvents()) != 0 {
+			t.Error("The database was queried with a time range over the maximum")
+		}
+	})
+}
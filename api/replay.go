@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"github.com/qri-io/jsonschema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// number of replayed events between progress reports
+const replayProgressInterval = 100
+
+// ReplayProgress reports how far a replay has got
+// LastId is the id of the last event written to the destination so an interrupted replay
+// can be resumed by sending it as the after value of a new replay
+// it is left out until an event with an object id has been written
+type ReplayProgress struct {
+	Replayed int                 `json:"replayed"`
+	LastId   *primitive.ObjectID `json:"last_id,omitempty"`
+	Done     bool                `json:"done"`
+	Error    string              `json:"error,omitempty"`
+}
+
+// the json body of a replay request
+type replayRequest struct {
+	// name of the sink the events are written to
+	Destination string `json:"destination"`
+	// the same filter object that POST /events/query accepts
+	Filter json.RawMessage `json:"filter"`
+	// id of the last event that was already replayed
+	After string `json:"after"`
+}
+
+// EventsReplayHandler creates an http handler that writes stored events to a sink
+// so downstream systems can be rebuilt from the audit log
+// the body is a json object naming one of the destinations with an optional filter and after id
+// i.e. {"destination":"file","filter":{"source.service_name":"billing-service"},"after":"<id>"}
+// events are written one at a time in the order they were added so a slow sink slows the replay
+// rather than events piling up in memory
+// progress is streamed back as newline delimited json and the last line has done set to true
+// if the replay fails the last line holds the error and the id to resume from
+func EventsReplayHandler(db *mongo.Collection, destinations map[string]EventSink, config QueryConfig) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var sink, filter, err = parseReplayRequest(request, destinations, config.Schema)
+		if err != nil {
+			mux.WriteJsonResponse(writer, err)
+			return
+		}
+
+		// a replay can take much longer than a query so it only stops if the client goes away
+		var ctx = request.Context()
+
+		// event ids increase as events are added so sorting by id replays them in order
+		// and the batch size bounds how many events are held while waiting on the sink
+		var findOptions = options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetBatchSize(replayProgressInterval)
+
+		var cursor *mongo.Cursor
+		cursor, err = db.Find(ctx, filter, findOptions)
+		if err != nil {
+			mux.WriteJsonResponse(writer, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var stream = mux.NewNdjsonStream(writer)
+		var progress ReplayProgress
+
+		for err == nil && cursor.Next(ctx) {
+			var event map[string]interface{}
+			err = cursor.Decode(&event)
+			if err == nil {
+				err = sink.Write(ctx, event)
+			}
+			if err != nil {
+				break
+			}
+
+			progress.Replayed++
+			// only object ids can be sent as the after value of a replay
+			var lastId, ok = event["_id"].(primitive.ObjectID)
+			if ok {
+				progress.LastId = &lastId
+			}
+
+			if progress.Replayed%replayProgressInterval == 0 {
+				stream.Write(progress)
+				stream.Flush()
+			}
+		}
+
+		if err == nil {
+			err = cursor.Err()
+		}
+
+		if err == nil {
+			progress.Done = true
+		} else {
+			if config.Logger != nil {
+				config.Logger.Printf("An error occured while replaying events: %s\n", err)
+			}
+
+			progress.Error = err.Error()
+		}
+
+		stream.Write(progress)
+		stream.Close()
+	})
+}
+
+// read the replay request body and get the sink and filter it refers to
+func parseReplayRequest(request *http.Request, destinations map[string]EventSink, schema *jsonschema.Schema) (EventSink, map[string]interface{}, error) {
+	var d, err = ioutil.ReadAll(request.Body)
+	if err != nil {
+		return nil, nil, mux.DefaultHttpError(http.StatusBadRequest)
+	}
+
+	var body replayRequest
+	err = json.Unmarshal(d, &body)
+	if err != nil {
+		return nil, nil, queryBodyError("The request body must be a json object")
+	}
+
+	var sink, ok = destinations[body.Destination]
+	if !ok {
+		var names = make([]string, 0, len(destinations))
+		for name := range destinations {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return nil, nil, queryBodyError(fmt.Sprintf("The destination %q is not configured. The configured destinations are %s",
+			body.Destination, strings.Join(names, ", ")))
+	}
+
+	var filter map[string]interface{}
+	filter, err = filterFromJson(body.Filter, schema)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(body.After) > 0 {
+		var after, err = primitive.ObjectIDFromHex(body.After)
+		if err != nil {
+			return nil, nil, queryBodyError("The after value must be the id of an event")
+		}
+
+		var and, _ = filter["$and"].([]interface{})
+		filter["$and"] = append(and, map[string]interface{}{"_id": map[string]interface{}{"$gt": after}})
+	}
+
+	return sink, filter, nil
+}
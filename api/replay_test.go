@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// seed the mocked db with events that have increasing ids
+func seedReplayEvents(mt *mtest.T, count int) []primitive.ObjectID {
+	var ids = make([]primitive.ObjectID, count)
+	var documents = make([]bson.D, count)
+	for i := range ids {
+		ids[i] = primitive.NewObjectID()
+		documents[i] = bson.D{{Key: "_id", Value: ids[i]}, {Key: "summary", Value: "replayed"}}
+	}
+
+	mt.AddMockResponses(mockCursorResponse(mt, documents...))
+
+	return ids
+}
+
+// get the last progress report in a replay response
+func lastReplayProgress(t *testing.T, body string) ReplayProgress {
+	var lines = strings.Split(strings.TrimSpace(body), "\n")
+
+	var progress ReplayProgress
+	var err = json.Unmarshal([]byte(lines[len(lines)-1]), &progress)
+	if err != nil {
+		t.Fatalf("The replay response did not end with a progress report Got: %s", body)
+	}
+
+	return progress
+}
+
+func TestEventsReplayHandlerReplaysRangeInOrder(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("replay", func(mt *mtest.T) {
+		var ids = seedReplayEvents(mt, 3)
+		var sink = newFakeSink(nil)
+
+		var after = primitive.NewObjectID()
+		var body = `{"destination":"fake","filter":{"source.service_name":"billing"},"after":"` + after.Hex() + `"}`
+
+		var writer = httptest.NewRecorder()
+		var handler = EventsReplayHandler(mt.Coll, map[string]EventSink{"fake": sink}, QueryConfig{})
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/admin/replay", strings.NewReader(body)))
+
+		var progress = lastReplayProgress(t, writer.Body.String())
+		if !progress.Done || progress.Replayed != 3 || progress.LastId == nil || *progress.LastId != ids[2] {
+			t.Errorf("An unexpected final progress report was returned Got: %+v", progress)
+		}
+
+		for _, id := range ids {
+			if event := receiveEvent(t, sink); event["_id"] != id {
+				t.Errorf("The events were not replayed in order Expected: %s, Got: %v", id.Hex(), event["_id"])
+			}
+		}
+
+		// the replay resumes after the id provided and is ordered by id
+		var command = mt.GetStartedEvent().Command
+		if !strings.Contains(command.Lookup("filter").String(), after.Hex()) {
+			t.Errorf("The filter did not start after the id provided Got: %s", command.Lookup("filter"))
+		}
+		if command.Lookup("sort").String() != `{"_id": {"$numberInt":"1"}}` {
+			t.Errorf("The events were not sorted by id Got: %s", command.Lookup("sort"))
+		}
+	})
+}
+
+func TestEventsReplayHandlerSinkFailureReportsResumePoint(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("sink failure", func(mt *mtest.T) {
+		seedReplayEvents(mt, 2)
+		var sink = newFakeSink(errors.New("broker unavailable"))
+
+		var writer = httptest.NewRecorder()
+		var handler = EventsReplayHandler(mt.Coll, map[string]EventSink{"fake": sink}, QueryConfig{})
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/admin/replay", strings.NewReader(`{"destination":"fake"}`)))
+
+		var progress = lastReplayProgress(t, writer.Body.String())
+		if progress.Done || progress.Replayed != 0 || !strings.Contains(progress.Error, "broker unavailable") {
+			t.Errorf("The sink failure was not reported Got: %+v", progress)
+		}
+	})
+}
+
+func TestEventsReplayHandlerUnknownDestination(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("unknown destination", func(mt *mtest.T) {
+		var writer = httptest.NewRecorder()
+		var handler = EventsReplayHandler(mt.Coll, map[string]EventSink{"fake": newFakeSink(nil)}, QueryConfig{})
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/admin/replay", strings.NewReader(`{"destination":"webhook"}`)))
+
+		if writer.Code != http.StatusBadRequest {
+			t.Errorf("An unexpected status code was returned for an unknown destination Expected: %d, Got: %d", http.StatusBadRequest, writer.Code)
+		}
+
+		if len(mt.GetAllStartedEvents()) != 0 {
+			t.Error("The database was queried for an unknown destination")
+		}
+	})
+}
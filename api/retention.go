@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// field the time of an event is stored in as a bson date so a TTL index can expire it
+// the timestamp field can not be used since it is a number and TTL indexes only expire dates
+const DefaultRetentionField = "expires_from"
+
+// name of the TTL index that expires events
+const retentionIndexName = "retention"
+
+// error code the database returns when an index already exists with different options
+const indexOptionsConflictCode = 85
+
+// get the field that holds the time an event happened
+func (self InsertConfig) timestampField() string {
+	if len(self.TimestampField) == 0 {
+		return DefaultTimestampField
+	}
+
+	return self.TimestampField
+}
+
+// add the time an event happened to it as a bson date so the TTL index can expire it
+// timestamps are nanoseconds since the Unix epoch as described by the event schema
+// the time the event was received is used if it does not have a timestamp the date can be taken from
+// nothing is added if no retention field is configured
+func addRetentionDate(event map[string]interface{}, received time.Time, config InsertConfig) {
+	if len(config.RetentionField) == 0 {
+		return
+	}
+
+	var date, ok = timestampDate(lookupField(event, config.timestampField()))
+	if !ok {
+		date = received
+	}
+
+	event[config.RetentionField] = date.UTC()
+}
+
+// convert a timestamp in nanoseconds since the Unix epoch to a time
+// ok is false if the value is not a timestamp
+func timestampDate(value interface{}) (date time.Time, ok bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case int64:
+		return time.Unix(0, v), true
+	case int32:
+		return time.Unix(0, int64(v)), true
+	case float64:
+		return time.Unix(0, int64(v)), true
+	case json.Number:
+		var nanoseconds, err = v.Int64()
+		if err != nil {
+			var f float64
+			f, err = v.Float64()
+			nanoseconds = int64(f)
+		}
+		return time.Unix(0, nanoseconds), err == nil
+	}
+
+	return time.Time{}, false
+}
+
+// create a TTL index on the retention field so the database deletes events once they are older than retention
+// if the index already exists with a different retention it is changed to the new retention
+// events added before the retention field was configured do not have it so they never expire
+func EnsureRetentionIndex(ctx context.Context, db *mongo.Collection, field string, retention time.Duration) error {
+	var keys = bson.D{{Key: field, Value: 1}}
+	var expireAfterSeconds = int32(retention / time.Second)
+
+	var index = mongo.IndexModel{
+		Keys:    keys,
+		Options: options.Index().SetName(retentionIndexName).SetExpireAfterSeconds(expireAfterSeconds),
+	}
+
+	var _, err = db.Indexes().CreateOne(ctx, index)
+
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) && commandErr.Code == indexOptionsConflictCode {
+		err = db.Database().RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: db.Name()},
+			{Key: "index", Value: bson.D{
+				{Key: "keyPattern", Value: keys},
+				{Key: "expireAfterSeconds", Value: expireAfterSeconds},
+			}},
+		}).Err()
+	}
+
+	return err
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestAddRetentionDate(t *testing.T) {
+	var received = time.Date(2022, 4, 8, 19, 26, 28, 0, time.UTC)
+	var config = InsertConfig{RetentionField: DefaultRetentionField}
+
+	var event = map[string]interface{}{"timestamp": float64(1649445988000000000)}
+	addRetentionDate(event, received, config)
+	if event[DefaultRetentionField] != time.Unix(0, 1649445988000000000).UTC() {
+		t.Errorf("The timestamp of the event was not added as a date Got: %v", event[DefaultRetentionField])
+	}
+
+	// events without a timestamp still expire
+	event = map[string]interface{}{"summary": "one"}
+	addRetentionDate(event, received, config)
+	if event[DefaultRetentionField] != received {
+		t.Errorf("The time the event was received was not added as a date Got: %v", event[DefaultRetentionField])
+	}
+
+	event = map[string]interface{}{"timestamp": float64(1649445988000000000)}
+	addRetentionDate(event, received, InsertConfig{})
+	if _, ok := event[DefaultRetentionField]; ok {
+		t.Error("A retention date was added without a retention field")
+	}
+}
+
+func TestEventsBulkAddHandlerAddsRetentionDate(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("batch", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		var writer = httptest.NewRecorder()
+		var handler = EventsBulkAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{RetentionField: DefaultRetentionField})
+		var body = "[" + validEventJson + "," + validEventJson + "]"
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events/batch", strings.NewReader(body)))
+
+		if writer.Code != http.StatusNoContent {
+			t.Fatalf("An unexpected status code was returned when adding a batch Expected: %d, Got: %d", http.StatusNoContent, writer.Code)
+		}
+
+		var command = mt.GetStartedEvent().Command
+		for _, index := range []string{"0", "1"} {
+			var date, err = command.LookupErr("documents", index, DefaultRetentionField)
+			if err != nil || date.Type != bsontype.DateTime {
+				t.Errorf("A retention date was not added to the event at index %s of the batch Got: %s", index, command)
+			}
+		}
+	})
+}
+
+func TestEnsureRetentionIndex(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("index", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		var err = EnsureRetentionIndex(context.Background(), mt.Coll, DefaultRetentionField, 30*24*time.Hour)
+		if err != nil {
+			t.Fatalf("An error occured while creating the index: %s", err)
+		}
+
+		var index = mt.GetStartedEvent().Command.Lookup("indexes", "0").Document()
+		if index.Lookup("key", DefaultRetentionField).Int32() != 1 || index.Lookup("expireAfterSeconds").Int32() != 30*24*60*60 {
+			t.Errorf("A TTL index was not created on the retention field Got: %s", index)
+		}
+	})
+}
+
+func TestEnsureRetentionIndexChangesRetention(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("change retention", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: indexOptionsConflictCode, Name: "IndexOptionsConflict"}),
+			mtest.CreateSuccessResponse(),
+		)
+
+		var err = EnsureRetentionIndex(context.Background(), mt.Coll, DefaultRetentionField, time.Hour)
+		if err != nil {
+			t.Fatalf("An error occured while changing the index: %s", err)
+		}
+
+		mt.GetStartedEvent()
+		var command = mt.GetStartedEvent().Command
+		if command.Lookup("collMod").StringValue() != mt.Coll.Name() || command.Lookup("index", "expireAfterSeconds").Int32() != 3600 {
+			t.Errorf("The retention of the existing index was not changed Got: %s", command)
+		}
+	})
+}
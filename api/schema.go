@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/qri-io/jsonschema"
+)
+
+// the parts of a json schema that the api uses to describe events
+// the jsonschema package does not expose a schema's keywords
+// so the schema is marshaled back to json and read into this type
+type schemaDescription struct {
+	// uri of the json schema draft the schema is written for
+	Draft string `json:"$schema"`
+	// either a single type name or a list of type names
+	Type       interface{}                  `json:"type"`
+	Properties map[string]schemaDescription `json:"properties"`
+}
+
+// read the keywords the api uses from a json schema
+func describeSchema(schema *jsonschema.Schema) (schemaDescription, error) {
+	var description schemaDescription
+
+	var d, err = json.Marshal(schema)
+	if err == nil {
+		err = json.Unmarshal(d, &description)
+	}
+
+	return description, err
+}
+
+// get the names of the declared properties in sorted order
+func (self schemaDescription) propertyNames() []string {
+	var names = make([]string, 0, len(self.Properties))
+	for name := range self.Properties {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// the json schema drafts the jsonschema package can validate events with
+// 2019-09 is the draft it implements and draft-07 schemas use the same keywords
+var SchemaDrafts = []string{"draft-07", "2019-09"}
+
+// get the name of the draft (i.e. draft-07 or 2019-09) from a $schema uri
+// (i.e. http://json-schema.org/draft-07/schema# or https://json-schema.org/draft/2019-09/schema)
+// the uri is returned unchanged if it is not a json-schema.org draft uri
+func schemaDraftName(uri string) string {
+	var parts = strings.Split(strings.TrimSuffix(uri, "#"), "/")
+
+	for i, part := range parts {
+		if strings.HasPrefix(part, "draft-") {
+			return part
+		}
+		if part == "draft" && i+1 < len(parts) {
+			return parts[i+1]
+		}
+	}
+
+	return uri
+}
+
+// CheckSchemaDraft makes sure a json schema is written for the draft the service expects
+// so a schema written for another draft is not silently validated using the wrong keywords
+// a schema that does not declare a draft is assumed to be written for the expected draft
+func CheckSchemaDraft(schema *jsonschema.Schema, draft string) error {
+	var description, err = describeSchema(schema)
+	if err != nil {
+		return err
+	}
+
+	if len(description.Draft) == 0 {
+		return nil
+	}
+
+	var schemaDraft = schemaDraftName(description.Draft)
+	if schemaDraft != draft {
+		return fmt.Errorf("The event schema is written for json schema %s ($schema is %s) but %s is expected",
+			schemaDraft, description.Draft, draft)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/qri-io/jsonschema"
+)
+
+// create a json schema that declares the draft uri provided
+func schemaWithDraft(t *testing.T, uri string) *jsonschema.Schema {
+	var schema jsonschema.Schema
+
+	var d = `{"type":"object"}`
+	if len(uri) != 0 {
+		d = `{"$schema":"` + uri + `","type":"object"}`
+	}
+
+	var err = json.Unmarshal([]byte(d), &schema)
+	if err != nil {
+		t.Fatalf("An error occured while creating a test schema: %s", err)
+	}
+
+	return &schema
+}
+
+func TestCheckSchemaDraftMatches(t *testing.T) {
+	var tests = []struct {
+		uri   string
+		draft string
+	}{
+		{"http://json-schema.org/draft-07/schema#", "draft-07"},
+		{"https://json-schema.org/draft-07/schema", "draft-07"},
+		{"https://json-schema.org/draft/2019-09/schema", "2019-09"},
+		// a schema that does not declare a draft uses the expected one
+		{"", "2019-09"},
+	}
+
+	for _, test := range tests {
+		var err = CheckSchemaDraft(schemaWithDraft(t, test.uri), test.draft)
+		if err != nil {
+			t.Errorf("A schema for %q was rejected when expecting %s: %s", test.uri, test.draft, err)
+		}
+	}
+}
+
+func TestCheckSchemaDraftMismatch(t *testing.T) {
+	var err = CheckSchemaDraft(schemaWithDraft(t, "http://json-schema.org/draft-04/schema#"), "draft-07")
+	if err == nil {
+		t.Fatal("A schema for another draft was not rejected")
+	}
+
+	if !strings.Contains(err.Error(), "draft-04") || !strings.Contains(err.Error(), "draft-07") {
+		t.Errorf("The error did not name both drafts Got: %s", err)
+	}
+}
+
+func TestCheckSchemaDraftEventSchema(t *testing.T) {
+	var err = CheckSchemaDraft(loadTestSchema(t), "draft-07")
+	if err != nil {
+		t.Errorf("The event schema was rejected: %s", err)
+	}
+}
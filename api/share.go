@@ -0,0 +1,136 @@
+package api
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// longest query token that is accepted when a shared query is run
+const MaxQueryTokenLength = 2048
+
+// largest size in bytes a query token can decompress to
+// so a small token can not expand into a huge query
+const maxSharedQueryBytes = 8 * 1024
+
+// path prefix shared queries are run from
+const sharedQueryPath = "/events/shared/"
+
+// SharedQuery is the response body sent by EventsShareHandler
+type SharedQuery struct {
+	// url safe token holding the query
+	Token string `json:"token"`
+	// path the query can be run from
+	Path string `json:"path"`
+}
+
+// EventsShareHandler creates an http handler that encodes the query params of a query into a token
+// so the query can be shared as a link without being saved
+// i.e. GET /events/share?source.service_name=billing-service&last=24h
+// the query is checked the same way a query is before the token is created so a broken query is not shared
+func EventsShareHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var queryParams = request.URL.Query()
+
+		// the types of the filter values are checked when the shared query is run
+		var _, err = CreateFilterFromQuery(queryParams, nil)
+
+		var token string
+		if err == nil {
+			token, err = encodeQueryToken(queryParams)
+		}
+
+		if err == nil && len(token) > MaxQueryTokenLength {
+			err = mux.HttpError{
+				Code:        http.StatusRequestURITooLong,
+				Description: "The query is too large to be shared",
+			}
+		}
+
+		if err == nil {
+			mux.WriteJsonResponse(writer, SharedQuery{
+				Token: token,
+				Path:  sharedQueryPath + token,
+			})
+		} else {
+			mux.WriteJsonResponse(writer, err)
+		}
+	})
+}
+
+// EventsSharedQueryHandler creates an http handler that runs the query held in the token at the end of the path
+// (i.e. /events/shared/<token>) the same way EventsQueryHandler runs query params
+// query params in the url are added to the shared query so its results can be paged with after
+func EventsSharedQueryHandler(db *mongo.Collection, config QueryConfig) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var queryParams, err = decodeQueryToken(strings.TrimPrefix(request.URL.Path, sharedQueryPath))
+		if err != nil {
+			mux.WriteJsonResponse(writer, err)
+			return
+		}
+
+		for key, values := range request.URL.Query() {
+			queryParams[key] = values
+		}
+
+		queryEvents(writer, request, db, config, queryParams)
+	})
+}
+
+// encode query params into a compressed url safe token
+// the params are encoded in key order so the same query always gives the same token
+func encodeQueryToken(queryParams url.Values) (string, error) {
+	var compressed bytes.Buffer
+
+	var compressor, err = flate.NewWriter(&compressed, flate.BestCompression)
+	if err == nil {
+		_, err = compressor.Write([]byte(queryParams.Encode()))
+	}
+	if err == nil {
+		err = compressor.Close()
+	}
+
+	return base64.RawURLEncoding.EncodeToString(compressed.Bytes()), err
+}
+
+// decode a token created by encodeQueryToken into query params
+// tokens that are too long, are not valid or decompress to too much data are rejected with a 400
+func decodeQueryToken(token string) (url.Values, error) {
+	var tokenError = mux.HttpError{
+		Code:        http.StatusBadRequest,
+		Description: "The query token is not valid",
+	}
+
+	if len(token) == 0 || len(token) > MaxQueryTokenLength {
+		return nil, tokenError
+	}
+
+	var compressed, err = base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, tokenError
+	}
+
+	// one byte more than the limit is read so a query that is too large can be told apart from one at the limit
+	var decompressor = flate.NewReader(bytes.NewReader(compressed))
+	var d []byte
+	d, err = ioutil.ReadAll(io.LimitReader(decompressor, maxSharedQueryBytes+1))
+	if err != nil || len(d) > maxSharedQueryBytes {
+		return nil, tokenError
+	}
+
+	var queryParams url.Values
+	queryParams, err = url.ParseQuery(string(d))
+	if err != nil {
+		return nil, tokenError
+	}
+
+	return queryParams, nil
+}
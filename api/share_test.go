@@ -0,0 +1,122 @@
+package api
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// a query using operators, nested fields and reserved params
+var complexSharedQuery = url.Values{
+	"source.service_name__in": {"billing-service,shipping-service"},
+	"summary":                 {"A customer was billed & refunded"},
+	"last":                    {"7d"},
+	"sort":                    {"-timestamp,summary"},
+	"limit":                   {"25"},
+}
+
+func TestQueryTokenRoundTrip(t *testing.T) {
+	var token, err = encodeQueryToken(complexSharedQuery)
+	if err != nil {
+		t.Fatalf("The query could not be encoded: %s", err)
+	}
+
+	if url.PathEscape(token) != token {
+		t.Errorf("The query token is not url safe Got: %s", token)
+	}
+
+	var queryParams url.Values
+	queryParams, err = decodeQueryToken(token)
+	if err != nil {
+		t.Fatalf("The query token could not be decoded: %s", err)
+	}
+
+	if !reflect.DeepEqual(queryParams, complexSharedQuery) {
+		t.Errorf("The decoded query does not match the encoded query Expected: %v, Got: %v", complexSharedQuery, queryParams)
+	}
+}
+
+func TestDecodeQueryTokenInvalid(t *testing.T) {
+	// a small token that decompresses to far more than a query can be
+	var compressed bytes.Buffer
+	var compressor, _ = flate.NewWriter(&compressed, flate.BestCompression)
+	compressor.Write([]byte("summary=" + strings.Repeat("a", 1024*1024)))
+	compressor.Close()
+
+	var tokens = []string{
+		"",
+		"not a token!",
+		base64.RawURLEncoding.EncodeToString([]byte("not compressed")),
+		strings.Repeat("a", MaxQueryTokenLength+1),
+		base64.RawURLEncoding.EncodeToString(compressed.Bytes()),
+	}
+	for _, token := range tokens {
+		var _, err = decodeQueryToken(token)
+
+		var httpError, ok = err.(mux.HttpError)
+		if !ok || httpError.Code != http.StatusBadRequest {
+			t.Errorf("The invalid token %.20q did not result in a 400 Got: %v", token, err)
+		}
+	}
+}
+
+func TestEventsSharedQueryHandler(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("shared query", func(mt *mtest.T) {
+		// create the token the same way a client would
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodGet, "/events/share?"+complexSharedQuery.Encode(), nil)
+		EventsShareHandler().ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf("An unexpected status code was returned when sharing a query Expected: %d, Got: %d", http.StatusOK, writer.Code)
+		}
+
+		var shared SharedQuery
+		var err = json.Unmarshal(writer.Body.Bytes(), &shared)
+		if err != nil || !strings.HasPrefix(shared.Path, "/events/shared/") {
+			t.Fatalf("An unexpected body was returned when sharing a query Got: %s", writer.Body)
+		}
+
+		// run the query from the token
+		mt.AddMockResponses(mockCursorResponse(mt, bson.D{{Key: "summary", Value: "A customer was billed & refunded"}}))
+
+		writer = httptest.NewRecorder()
+		EventsSharedQueryHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, shared.Path, nil))
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(queryInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		var command = mt.GetStartedEvent().Command
+		if command.Lookup("filter", "summary").StringValue() != "A customer was billed & refunded" {
+			t.Errorf("The shared filter was not used Got: %s", command.Lookup("filter"))
+		}
+
+		if command.Lookup("limit").AsInt64() != 25 {
+			t.Errorf("The shared limit was not used Got: %s", command.Lookup("limit"))
+		}
+	})
+}
+
+func TestEventsShareHandlerInvalidQuery(t *testing.T) {
+	var writer = httptest.NewRecorder()
+	EventsShareHandler().ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/events/share?summary__between=a,b", nil))
+
+	if writer.Code != http.StatusBadRequest {
+		t.Errorf("An invalid query was shared Expected: %d, Got: %d", http.StatusBadRequest, writer.Code)
+	}
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// EventSink is a secondary destination that events are written to after they are added to the database
+// (i.e. another collection during a migration or a message queue for fan out)
+// the event includes its database _id and must not be modified by the sink
+type EventSink interface {
+	Write(ctx context.Context, event map[string]interface{}) error
+}
+
+// CollectionSink writes events to another mongo collection
+type CollectionSink struct {
+	Collection *mongo.Collection
+}
+
+func (self CollectionSink) Write(ctx context.Context, event map[string]interface{}) error {
+	var _, err = self.Collection.InsertOne(ctx, event)
+
+	return err
+}
+
+// Check makes sure the database the collection is in can be reached
+func (self CollectionSink) Check(ctx context.Context) error {
+	return self.Collection.Database().RunCommand(ctx, bson.D{{Key: "ping", Value: 1}}).Err()
+}
+
+// FileSink appends events to a file as newline delimited json
+type FileSink struct {
+	// events are written one at a time so lines from concurrent writes are not interleaved
+	lock sync.Mutex
+	file *os.File
+	path string
+}
+
+// NewFileSink opens (or creates) the file at path for appending events
+func NewFileSink(path string) (*FileSink, error) {
+	var file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{file: file, path: path}, nil
+}
+
+// Check makes sure the file is still open and has not been removed (i.e. by log rotation)
+// since events written after that would be lost
+func (self *FileSink) Check(ctx context.Context) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var opened, err = self.file.Stat()
+
+	var current os.FileInfo
+	if err == nil {
+		current, err = os.Stat(self.path)
+	}
+
+	if err == nil && !os.SameFile(opened, current) {
+		err = fmt.Errorf("The file %s has been replaced since it was opened", self.path)
+	}
+
+	return err
+}
+
+func (self *FileSink) Write(ctx context.Context, event map[string]interface{}) error {
+	var d, err = json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	_, err = self.file.Write(append(d, '\n'))
+
+	return err
+}
+
+// number of workers that write events to the secondary sinks when none is configured
+const DefaultSinkWorkers = 4
+
+// number of events that can wait to be written to the secondary sinks when no queue size is configured
+const DefaultSinkQueueSize = 1000
+
+// how long writing one event to a secondary sink can take when no timeout is configured
+const DefaultSinkTimeout = 10 * time.Second
+
+// an event waiting to be written to one of the secondary sinks
+type sinkWrite struct {
+	sink  EventSink
+	event map[string]interface{}
+}
+
+// SinkWriter writes added events to the secondary sinks in the background
+// a fixed number of workers write the events from a bounded queue so a slow sink
+// can not start an unbounded number of writes
+// the events are already in the database so a write that fails or does not fit in the queue is only logged and counted
+type SinkWriter struct {
+	sinks   []EventSink
+	logger  *log.Logger
+	timeout time.Duration
+	queue   chan sinkWrite
+	workers sync.WaitGroup
+
+	// held while adding to the queue so it is not closed at the same time
+	lock   sync.RWMutex
+	closed bool
+
+	written int64
+	failed  int64
+	dropped int64
+}
+
+// create a sink writer and start its workers
+// workers, queueSize and timeout less than 1 are replaced with the defaults
+// failures are not logged if logger is nil
+func NewSinkWriter(sinks []EventSink, logger *log.Logger, workers int, queueSize int, timeout time.Duration) *SinkWriter {
+	if workers < 1 {
+		workers = DefaultSinkWorkers
+	}
+	if queueSize < 1 {
+		queueSize = DefaultSinkQueueSize
+	}
+	if timeout <= 0 {
+		timeout = DefaultSinkTimeout
+	}
+
+	var writer = &SinkWriter{
+		sinks:   sinks,
+		logger:  logger,
+		timeout: timeout,
+		queue:   make(chan sinkWrite, queueSize),
+	}
+
+	writer.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer writer.workers.Done()
+
+			for write := range writer.queue {
+				writer.writeEvent(write)
+			}
+		}()
+	}
+
+	return writer
+}
+
+// queue added events to be written to every sink
+// events that do not fit in the queue are dropped rather than holding up the request
+func (self *SinkWriter) write(events []map[string]interface{}) {
+	if self == nil {
+		return
+	}
+
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	for _, event := range events {
+		for _, sink := range self.sinks {
+			var queued = false
+			if !self.closed {
+				select {
+				case self.queue <- sinkWrite{sink: sink, event: event}:
+					queued = true
+				default:
+				}
+			}
+
+			if !queued {
+				atomic.AddInt64(&self.dropped, 1)
+				self.logf("An event with id %s was not written to a secondary sink since the queue is full\n", csvCell(event["_id"]))
+			}
+		}
+	}
+}
+
+// write an event to a sink with a timeout
+// the request context is not used since it is cancelled as soon as the response is sent
+func (self *SinkWriter) writeEvent(write sinkWrite) {
+	var timedContext, timedContextCancel = context.WithTimeout(context.Background(), self.timeout)
+	defer timedContextCancel()
+
+	var err = write.sink.Write(timedContext, write.event)
+	if err != nil {
+		atomic.AddInt64(&self.failed, 1)
+		self.logf("An error occured while writing an event with id %s to a secondary sink: %s\n", csvCell(write.event["_id"]), err)
+		return
+	}
+
+	atomic.AddInt64(&self.written, 1)
+}
+
+func (self *SinkWriter) logf(format string, v ...interface{}) {
+	if self.logger != nil {
+		self.logger.Printf(format, v...)
+	}
+}
+
+// Close stops the writer from taking more events and waits for the queued events to be written
+// the context error is returned if it is done first
+func (self *SinkWriter) Close(ctx context.Context) error {
+	self.lock.Lock()
+	if !self.closed {
+		self.closed = true
+		close(self.queue)
+	}
+	self.lock.Unlock()
+
+	var done = make(chan struct{})
+	go func() {
+		self.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// the number of events written to the sinks, the writes that failed and the events that were dropped
+// as a json object so the writer can be published with expvar
+func (self *SinkWriter) String() string {
+	return fmt.Sprintf(`{"written":%d,"failed":%d,"dropped":%d,"queued":%d}`,
+		atomic.LoadInt64(&self.written), atomic.LoadInt64(&self.failed), atomic.LoadInt64(&self.dropped), len(self.queue))
+}
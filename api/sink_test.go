@@ -0,0 +1,221 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// sink that sends every event it receives to a channel
+type fakeSink struct {
+	events chan map[string]interface{}
+	err    error
+}
+
+func newFakeSink(err error) fakeSink {
+	return fakeSink{
+		events: make(chan map[string]interface{}, 10),
+		err:    err,
+	}
+}
+
+func (self fakeSink) Write(ctx context.Context, event map[string]interface{}) error {
+	self.events <- event
+
+	return self.err
+}
+
+// wait for a sink to receive an event
+func receiveEvent(t *testing.T, sink fakeSink) map[string]interface{} {
+	select {
+	case event := <-sink.events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("The sink did not receive the inserted event")
+	}
+
+	return nil
+}
+
+// buffer that can be written to by the sink routines while a test reads it
+type lockedBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (self *lockedBuffer) Write(p []byte) (int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.buf.Write(p)
+}
+
+func (self *lockedBuffer) String() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.buf.String()
+}
+
+func TestEventsAddHandlerWritesToSinks(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("sinks", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		var sink = newFakeSink(nil)
+		// a failing sink should not affect the request or the other sinks
+		var failingSink = newFakeSink(fmt.Errorf("broker unavailable"))
+		var logs lockedBuffer
+
+		var handler = EventsAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{
+			SinkWriter: NewSinkWriter([]EventSink{failingSink, sink}, log.New(&logs, "", 0), 0, 0, 0),
+		})
+
+		var writer = httptest.NewRecorder()
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(validEventJson)))
+
+		if writer.Code != http.StatusNoContent {
+			t.Fatalf("An unexpected status code was returned when attempting to add an event Expected: %d, Got: %d", http.StatusNoContent, writer.Code)
+		}
+
+		var event = receiveEvent(t, sink)
+		if event["summary"] != "A customer was added" || event["_id"] == nil {
+			t.Errorf("The sink did not receive the inserted event Got: %v", event)
+		}
+
+		receiveEvent(t, failingSink)
+
+		// the failure is logged after the write returns
+		var deadline = time.Now().Add(time.Second)
+		for !strings.Contains(logs.String(), "broker unavailable") && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if !strings.Contains(logs.String(), "broker unavailable") {
+			t.Errorf("The sink failure was not logged Got: %s", logs.String())
+		}
+	})
+}
+
+func TestEventsBulkAddHandlerWritesToSinks(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("sinks", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		var sink = newFakeSink(nil)
+
+		var body = "[" + validEventJson + "," + validEventJson + "]"
+
+		var writer = httptest.NewRecorder()
+		var request = httptest.NewRequest(http.MethodPost, "/events/batch", strings.NewReader(body))
+		EventsBulkAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{SinkWriter: NewSinkWriter([]EventSink{sink}, nil, 0, 0, 0)}).ServeHTTP(writer, request)
+
+		if writer.Code != http.StatusNoContent {
+			t.Fatalf(batchInvalidStatusError, http.StatusNoContent, writer.Code)
+		}
+
+		var first = receiveEvent(t, sink)
+		var second = receiveEvent(t, sink)
+		if first["_id"] == nil || first["_id"] == second["_id"] {
+			t.Errorf("The sink did not receive each inserted event with its id Got: %v and %v", first, second)
+		}
+	})
+}
+
+func TestFileSinkAppendsEvents(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "events.ndjson")
+
+	var sink, err = NewFileSink(path)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while opening the file sink: %s", err)
+	}
+
+	sink.Write(context.Background(), map[string]interface{}{"summary": "one"})
+	sink.Write(context.Background(), map[string]interface{}{"summary": "two"})
+
+	var d, _ = ioutil.ReadFile(path)
+
+	var expected = "{\"summary\":\"one\"}\n{\"summary\":\"two\"}\n"
+	if string(d) != expected {
+		t.Errorf("The file sink did not append the events as newline delimited json Expected: %q, Got: %q", expected, string(d))
+	}
+}
+
+// sink that blocks every write until it is released or the write times out
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (self blockingSink) Write(ctx context.Context, event map[string]interface{}) error {
+	select {
+	case <-self.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestSinkWriterBoundedQueue(t *testing.T) {
+	var sink = blockingSink{release: make(chan struct{})}
+	var logs lockedBuffer
+
+	// one event is being written and one is waiting so the third is dropped
+	var writer = NewSinkWriter([]EventSink{sink}, log.New(&logs, "", 0), 1, 1, time.Minute)
+	writer.write([]map[string]interface{}{{"_id": "one"}})
+
+	// wait for the worker to take the first event so the queue is empty again
+	var deadline = time.Now().Add(time.Second)
+	for len(writer.queue) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	writer.write([]map[string]interface{}{{"_id": "two"}, {"_id": "three"}})
+
+	close(sink.release)
+	var err = writer.Close(context.Background())
+	if err != nil {
+		t.Fatalf("An unexpected error occured while closing the sink writer: %s", err)
+	}
+
+	var expected = `{"written":2,"failed":0,"dropped":1,"queued":0}`
+	if writer.String() != expected {
+		t.Errorf("The sink writes were not counted Expected: %s, Got: %s", expected, writer.String())
+	}
+
+	if !strings.Contains(logs.String(), "three") {
+		t.Errorf("The dropped event was not logged Got: %s", logs.String())
+	}
+}
+
+func TestSinkWriterTimeout(t *testing.T) {
+	var writer = NewSinkWriter([]EventSink{blockingSink{release: make(chan struct{})}}, nil, 1, 1, 10*time.Millisecond)
+	writer.write([]map[string]interface{}{{"_id": "one"}})
+
+	// a sink that never responds does not hold up the writer
+	var closeContext, closeContextCancel = context.WithTimeout(context.Background(), time.Second)
+	defer closeContextCancel()
+
+	var err = writer.Close(closeContext)
+	if err != nil {
+		t.Fatalf("The write to a stalled sink was not timed out: %s", err)
+	}
+
+	var expected = `{"written":0,"failed":1,"dropped":0,"queued":0}`
+	if writer.String() != expected {
+		t.Errorf("The timed out write was not counted as failed Expected: %s, Got: %s", expected, writer.String())
+	}
+}
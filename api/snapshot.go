@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// response header containing the snapshot id that later pages of the same export should use
+const snapshotHeader = "X-Snapshot"
+
+// add an upper bound on the event id to the filter when the snapshot query param is used
+// so an export only includes the events that were added before it began
+// snapshot=true starts a new snapshot and snapshot=<snapshot id> continues one so every page of an export
+// sees the same events
+// the snapshot id is an id created when the snapshot started and ids increase as events are added
+// so any event added by this service after the snapshot started has a larger id
+// the snapshot id is returned (or NilObjectID if no snapshot was requested)
+func addSnapshotFilter(filter map[string]interface{}, queryParams url.Values) (primitive.ObjectID, error) {
+	if !queryParams.Has("snapshot") {
+		return primitive.NilObjectID, nil
+	}
+
+	var snapshot = queryParams.Get("snapshot")
+
+	var snapshotId primitive.ObjectID
+	if start, err := strconv.ParseBool(snapshot); err == nil {
+		if !start {
+			return primitive.NilObjectID, nil
+		}
+
+		snapshotId = primitive.NewObjectID()
+	} else {
+		snapshotId, err = primitive.ObjectIDFromHex(snapshot)
+		if err != nil {
+			return primitive.NilObjectID, mux.HttpError{
+				Code:        http.StatusBadRequest,
+				Description: "The snapshot query parameter must be true or the snapshot id returned in the " + snapshotHeader + " header",
+			}
+		}
+	}
+
+	// the bound is added with $and so it never clashes with an _id filter
+	var and, _ = filter["$and"].([]interface{})
+	filter["$and"] = append(and, map[string]interface{}{"_id": map[string]interface{}{"$lt": snapshotId}})
+
+	return snapshotId, nil
+}
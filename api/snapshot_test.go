@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestSnapshotExcludesEventsAddedDuringExport(t *testing.T) {
+	// events that were added before the export started
+	var existing []map[string]interface{}
+	for i := 0; i < 5; i++ {
+		existing = append(existing, map[string]interface{}{"_id": primitive.NewObjectID()})
+	}
+
+	var filter = map[string]interface{}{}
+	var snapshotId, err = addSnapshotFilter(filter, url.Values{"snapshot": {"true"}})
+	if err != nil {
+		t.Fatalf("An unexpected error occured while starting a snapshot: %s", err)
+	}
+
+	// events that were added while the export was running
+	var added []map[string]interface{}
+	for i := 0; i < 5; i++ {
+		added = append(added, map[string]interface{}{"_id": primitive.NewObjectID()})
+	}
+
+	for _, event := range existing {
+		if !matchesFilter(event, filter) {
+			t.Errorf("An event added before the snapshot was excluded: %v", event)
+		}
+	}
+	for _, event := range added {
+		if matchesFilter(event, filter) {
+			t.Errorf("An event added during the export was included: %v", event)
+		}
+	}
+
+	// later pages continue the same snapshot
+	var nextPageFilter = map[string]interface{}{}
+	addSnapshotFilter(nextPageFilter, url.Values{"snapshot": {snapshotId.Hex()}})
+	for _, event := range added {
+		if matchesFilter(event, nextPageFilter) {
+			t.Errorf("An event added during the export was included in a later page: %v", event)
+		}
+	}
+}
+
+func TestSnapshotInvalidId(t *testing.T) {
+	var _, err = addSnapshotFilter(map[string]interface{}{}, url.Values{"snapshot": {"yesterday"}})
+
+	var httpErr, ok = err.(mux.HttpError)
+	if !ok || httpErr.Code != http.StatusBadRequest {
+		t.Errorf("An invalid snapshot id did not result in a 400 error: %v", err)
+	}
+}
+
+func TestEventsQueryHandlerSnapshotHeader(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("snapshot", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt))
+
+		var writer = httptest.NewRecorder()
+		EventsQueryHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/events?snapshot=true", nil))
+
+		var snapshotId = writer.Header().Get(snapshotHeader)
+		if len(snapshotId) == 0 {
+			t.Fatal("The snapshot id was not returned")
+		}
+
+		var filter = mt.GetStartedEvent().Command.Lookup("filter").String()
+		if !strings.Contains(filter, snapshotId) {
+			t.Errorf("The query was not bounded by the snapshot Got: %s", filter)
+		}
+	})
+}
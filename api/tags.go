@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"github.com/qri-io/jsonschema"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// field of an event holding the tags it was given after it was added
+// events can be queried by tag using the field (i.e. tags=incident-123)
+const TagsField = "tags"
+
+// tag collection document holding the tags of one event
+// its _id is the _id of the event
+type eventTags struct {
+	Id interface{} `bson:"_id"`
+}
+
+// TagResult reports how many events a tag was applied to
+// Matched events that already had the tag are not counted as Tagged
+type TagResult struct {
+	Tag     string `json:"tag"`
+	Matched int64  `json:"matched"`
+	Tagged  int64  `json:"tagged"`
+}
+
+// the json body of a tag request
+type tagRequest struct {
+	// the same filter object that POST /events/query accepts
+	Filter json.RawMessage `json:"filter"`
+	Tag    string          `json:"tag"`
+}
+
+// EventsTagHandler creates an http handler that adds a tag to every event matching a filter
+// (i.e. to mark the events involved in an incident so they can be found again later)
+// the body is a json object with the filter and the tag
+// i.e. {"filter":{"source.service_name":"billing-service"},"tag":"incident-123"}
+// the tag is added to the tags array of the events so the rest of the event is left as it was added
+// and applying the same tag again does not add it twice
+// if QueryConfig.TagCollection is set the events are never changed and the tags are kept in that collection instead
+func EventsTagHandler(db *mongo.Collection, config QueryConfig) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var filter, tag, err = parseTagRequest(request, config.Schema)
+
+		var result TagResult
+		if err == nil {
+			var timedContext, timedContextCancel = context.WithTimeout(request.Context(), config.queryTimeout())
+			defer timedContextCancel()
+
+			if config.TagCollection != nil {
+				result, err = tagEventsInCollection(timedContext, db, config.TagCollection, filter, tag)
+			} else {
+				result, err = tagEvents(timedContext, db, filter, tag)
+			}
+		}
+
+		if err == nil {
+			mux.WriteJsonResponse(writer, result)
+		} else {
+			if _, isHttpError := err.(mux.HttpError); !isHttpError && config.Logger != nil {
+				config.Logger.Printf("An error occured while tagging events: %s\n", err)
+			}
+
+			mux.WriteJsonResponse(writer, dbUnavailableError(writer, err, config))
+		}
+	})
+}
+
+// add a tag to the tags array of every event matching a filter
+func tagEvents(ctx context.Context, db *mongo.Collection, filter map[string]interface{}, tag string) (TagResult, error) {
+	var update = map[string]interface{}{
+		"$addToSet": map[string]interface{}{TagsField: tag},
+	}
+
+	var result, err = db.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return TagResult{}, err
+	}
+
+	return TagResult{
+		Tag:     tag,
+		Matched: result.MatchedCount,
+		Tagged:  result.ModifiedCount,
+	}, nil
+}
+
+// add a tag to the tag collection document of every event matching a filter
+// the documents are created for events that have not been tagged before
+func tagEventsInCollection(ctx context.Context, db *mongo.Collection, tags *mongo.Collection,
+	filter map[string]interface{}, tag string) (TagResult, error) {
+	var result = TagResult{Tag: tag}
+
+	var cursor, err = db.Find(ctx, filter, options.Find().SetProjection(map[string]interface{}{"_id": 1}))
+	if err != nil {
+		return result, err
+	}
+
+	var events []eventTags
+	err = cursor.All(ctx, &events)
+	if err != nil || len(events) == 0 {
+		return result, err
+	}
+
+	var models = make([]mongo.WriteModel, 0, len(events))
+	for _, event := range events {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(map[string]interface{}{"_id": event.Id}).
+			SetUpdate(map[string]interface{}{"$addToSet": map[string]interface{}{TagsField: tag}}).
+			SetUpsert(true))
+	}
+
+	var writeResult *mongo.BulkWriteResult
+	writeResult, err = tags.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return result, err
+	}
+
+	result.Matched = int64(len(events))
+	result.Tagged = writeResult.ModifiedCount + writeResult.UpsertedCount
+
+	return result, nil
+}
+
+// replace a filter on the tags field with a filter on the ids of the events with the tags
+// when the tags are kept in the tag collection rather than on the events
+func applyTagFilter(ctx context.Context, filter map[string]interface{}, config QueryConfig) error {
+	var value, ok = filter[TagsField]
+	if !ok || config.TagCollection == nil {
+		return nil
+	}
+
+	var timedContext, timedContextCancel = context.WithTimeout(ctx, config.queryTimeout())
+	defer timedContextCancel()
+
+	var cursor, err = config.TagCollection.Find(timedContext, map[string]interface{}{TagsField: value},
+		options.Find().SetProjection(map[string]interface{}{"_id": 1}))
+	if err != nil {
+		return err
+	}
+
+	var tagged []eventTags
+	err = cursor.All(timedContext, &tagged)
+	if err != nil {
+		return err
+	}
+
+	var ids = make([]interface{}, 0, len(tagged))
+	for _, event := range tagged {
+		ids = append(ids, event.Id)
+	}
+
+	// the ids are added with $and so they never clash with an _id filter
+	delete(filter, TagsField)
+	var and, _ = filter["$and"].([]interface{})
+	filter["$and"] = append(and, map[string]interface{}{"_id": map[string]interface{}{"$in": ids}})
+
+	return nil
+}
+
+// read the tag request body and get the filter and tag
+// the filter has to match on at least one field so a missing filter can not tag every event
+func parseTagRequest(request *http.Request, schema *jsonschema.Schema) (map[string]interface{}, string, error) {
+	var d, err = ioutil.ReadAll(request.Body)
+	if err != nil {
+		return nil, "", mux.DefaultHttpError(http.StatusBadRequest)
+	}
+
+	var body tagRequest
+	err = json.Unmarshal(d, &body)
+	if err != nil {
+		return nil, "", queryBodyError("The request body must be a json object")
+	}
+
+	if len(body.Tag) == 0 {
+		return nil, "", queryBodyError("The tag must be a non empty string")
+	}
+
+	var filter map[string]interface{}
+	filter, err = filterFromJson(body.Filter, schema)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(filter) == 0 {
+		return nil, "", queryBodyError("The filter must match on at least one field")
+	}
+
+	return filter, body.Tag, nil
+}
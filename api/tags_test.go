@@ -0,0 +1,176 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestEventsTagHandlerTagsFilteredEvents(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("tag", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 3}, bson.E{Key: "nModified", Value: 2}))
+
+		var body = `{"filter":{"source.service_name":"billing"},"tag":"incident-123"}`
+
+		var writer = httptest.NewRecorder()
+		EventsTagHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events/tags", strings.NewReader(body)))
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf("The events were not tagged Expected: %d, Got: %d", http.StatusOK, writer.Code)
+		}
+
+		if writer.Body.String() != `{"tag":"incident-123","matched":3,"tagged":2}` {
+			t.Errorf("An unexpected tag result was returned Got: %s", writer.Body.String())
+		}
+
+		var update = mt.GetStartedEvent().Command.Lookup("updates", "0")
+		if service := update.Document().Lookup("q", "source.service_name").StringValue(); service != "billing" {
+			t.Errorf("The tag was not applied to the filtered events Got: %s", update)
+		}
+		if tag := update.Document().Lookup("u", "$addToSet", TagsField).StringValue(); tag != "incident-123" {
+			t.Errorf("The tag was not added to the tags of the events Got: %s", update)
+		}
+		if !update.Document().Lookup("multi").Boolean() {
+			t.Errorf("The tag was not applied to every matching event Got: %s", update)
+		}
+	})
+}
+
+func TestEventsTagHandlerRequiresFilterAndTag(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("invalid", func(mt *mtest.T) {
+		for _, body := range []string{
+			`{"tag":"incident-123"}`,
+			`{"filter":{"source.service_name":"billing"}}`,
+			`{"filter":{"source.service_name":"billing"},"tag":""}`,
+		} {
+			var writer = httptest.NewRecorder()
+			EventsTagHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events/tags", strings.NewReader(body)))
+
+			if writer.Code != http.StatusBadRequest {
+				t.Errorf("An invalid tag request was not rejected %s Expected: %d, Got: %d", body, http.StatusBadRequest, writer.Code)
+			}
+		}
+
+		if len(mt.GetAllStartedEvents()) != 0 {
+			t.Error("Events were tagged by an invalid tag request")
+		}
+	})
+}
+
+func TestEventsQueryHandlerByTag(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("query by tag", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt, bson.D{
+			{Key: "summary", Value: "A refund was issued"},
+			{Key: TagsField, Value: bson.A{"incident-123"}},
+		}))
+
+		var writer = httptest.NewRecorder()
+		EventsQueryHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/events?tags=incident-123", nil))
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(queryInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		// an equality filter on an array matches events whose array contains the value
+		if tag := mt.GetStartedEvent().Command.Lookup("filter", TagsField).StringValue(); tag != "incident-123" {
+			t.Errorf("The query did not filter on the tag Got: %s", mt.GetStartedEvent().Command)
+		}
+
+		if !strings.Contains(writer.Body.String(), `"tags":["incident-123"]`) {
+			t.Errorf("The tagged event was not returned Got: %s", writer.Body.String())
+		}
+	})
+}
+
+func TestEventsTagHandlerTagCollection(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("tag collection", func(mt *mtest.T) {
+		var tagged, untagged = primitive.NewObjectID(), primitive.NewObjectID()
+		mt.AddMockResponses(
+			mockCursorResponse(mt, bson.D{{Key: "_id", Value: tagged}}, bson.D{{Key: "_id", Value: untagged}}),
+			// the first event already had a tag document and the second one was created
+			mtest.CreateSuccessResponse(
+				bson.E{Key: "n", Value: 2},
+				bson.E{Key: "nModified", Value: 1},
+				bson.E{Key: "upserted", Value: bson.A{bson.D{{Key: "index", Value: 1}, {Key: "_id", Value: untagged}}}},
+			),
+		)
+
+		var body = `{"filter":{"source.service_name":"billing"},"tag":"incident-123"}`
+		var config = QueryConfig{TagCollection: mt.DB.Collection("tag")}
+
+		var writer = httptest.NewRecorder()
+		EventsTagHandler(mt.Coll, config).ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events/tags", strings.NewReader(body)))
+
+		if writer.Body.String() != `{"tag":"incident-123","matched":2,"tagged":2}` {
+			t.Errorf("An unexpected tag result was returned Got: %s", writer.Body.String())
+		}
+
+		var find = mt.GetStartedEvent()
+		if find.CommandName != "find" || find.Command.Lookup("find").StringValue() != mt.Coll.Name() {
+			t.Errorf("The events to tag were not found in the events collection Got: %s", find.Command)
+		}
+
+		var update = mt.GetStartedEvent()
+		if update.CommandName != "update" || update.Command.Lookup("update").StringValue() != "tag" {
+			t.Fatalf("The tags were not added to the tag collection Got: %s", update.Command)
+		}
+		var first = update.Command.Lookup("updates", "0").Document()
+		if first.Lookup("q", "_id").ObjectID() != tagged || !first.Lookup("upsert").Boolean() {
+			t.Errorf("The tag was not added to the tag document of the event Got: %s", first)
+		}
+	})
+}
+
+func TestEventsQueryHandlerByTagInTagCollection(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("query by tag", func(mt *mtest.T) {
+		var tagged = primitive.NewObjectID()
+		mt.AddMockResponses(
+			mockCursorResponse(mt, bson.D{{Key: "_id", Value: tagged}}),
+			mockCursorResponse(mt, bson.D{{Key: "_id", Value: tagged}, {Key: "summary", Value: "A refund was issued"}}),
+		)
+
+		var config = QueryConfig{TagCollection: mt.DB.Collection("tag")}
+
+		var writer = httptest.NewRecorder()
+		EventsQueryHandler(mt.Coll, config).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/events?tags=incident-123", nil))
+
+		if writer.Code != http.StatusOK {
+			t.Fatalf(queryInvalidStatusError, http.StatusOK, writer.Code)
+		}
+
+		var tagFind = mt.GetStartedEvent()
+		if tag := tagFind.Command.Lookup("filter", TagsField).StringValue(); tag != "incident-123" {
+			t.Errorf("The tag collection was not searched for the tag Got: %s", tagFind.Command)
+		}
+
+		// the events are matched by the ids of the tagged events rather than a tags field
+		var eventFind = mt.GetStartedEvent()
+		if _, err := eventFind.Command.LookupErr("filter", TagsField); err == nil {
+			t.Errorf("The events were filtered on a tags field Got: %s", eventFind.Command)
+		}
+		var id, err = eventFind.Command.LookupErr("filter", "$and", "0", "_id", "$in", "0")
+		if err != nil || id.ObjectID() != tagged {
+			t.Errorf("The events were not filtered on the ids of the tagged events Got: %s", eventFind.Command)
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ParseFieldAliases parses a comma separated list of stored:alias pairs (i.e. actor:user,action:verb)
+// into a map of stored field name to the name it should have in query results
+func ParseFieldAliases(spec string) (map[string]string, error) {
+	var aliases = make(map[string]string)
+
+	if len(spec) == 0 {
+		return aliases, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		var names = strings.Split(pair, ":")
+		if len(names) != 2 || len(names[0]) == 0 || len(names[1]) == 0 {
+			return nil, mux.HttpError{
+				Code:        http.StatusBadRequest,
+				Description: fmt.Sprintf("The field alias %q must be in the format field:alias", pair),
+			}
+		}
+
+		aliases[names[0]] = names[1]
+	}
+
+	return aliases, nil
+}
+
+// get the aliases used for a query
+// aliases provided in the alias query param are added to (and override) the configured aliases
+func queryFieldAliases(queryParams url.Values, config QueryConfig) (map[string]string, error) {
+	var requestAliases, err = ParseFieldAliases(queryParams.Get("alias"))
+	if err != nil {
+		return nil, err
+	}
+
+	var aliases = make(map[string]string, len(config.FieldAliases)+len(requestAliases))
+	for field, alias := range config.FieldAliases {
+		aliases[field] = alias
+	}
+	for field, alias := range requestAliases {
+		aliases[field] = alias
+	}
+
+	return aliases, nil
+}
+
+// rename the top level fields of an event using the aliases
+// fields without an alias are left unchanged
+func aliasFields(event map[string]interface{}, aliases map[string]string) map[string]interface{} {
+	if len(aliases) == 0 {
+		return event
+	}
+
+	var aliased = make(map[string]interface{}, len(event))
+	for field, value := range event {
+		if alias, ok := aliases[field]; ok {
+			field = alias
+		}
+
+		aliased[field] = value
+	}
+
+	return aliased
+}
+
+// rename the first part of dot separated column names using the aliases
+// so csv exports line up with the aliased events
+func aliasColumns(columns []string, aliases map[string]string) []string {
+	if len(aliases) == 0 {
+		return columns
+	}
+
+	var aliased = make([]string, len(columns))
+	for i, column := range columns {
+		var parts = strings.SplitN(column, ".", 2)
+		if alias, ok := aliases[parts[0]]; ok {
+			parts[0] = alias
+		}
+
+		aliased[i] = strings.Join(parts, ".")
+	}
+
+	return aliased
+}
+
+// ways the _id of an event can be returned in query results
+const (
+	// the id as a 24 character hex string (i.e. "62508ea4c4f0f7e1b5a3e6d1")
+	IdFormatHex = "hex"
+	// the id as an extended json object (i.e. {"$oid":"62508ea4c4f0f7e1b5a3e6d1"})
+	IdFormatObject = "object"
+	// the id is left out of the results
+	IdFormatExclude = "exclude"
+)
+
+// the valid _id formats
+var IdFormats = []string{IdFormatHex, IdFormatObject, IdFormatExclude}
+
+// get the format used for the _id of the query results
+// the id_format query param overrides the configured format which defaults to hex
+func parseIdFormat(queryParams url.Values, config QueryConfig) (string, error) {
+	var format = config.IdFormat
+	if queryParams.Has("id_format") {
+		format = queryParams.Get("id_format")
+	}
+
+	switch format {
+	case "":
+		return IdFormatHex, nil
+	case IdFormatHex, IdFormatObject, IdFormatExclude:
+		return format, nil
+	default:
+		return "", mux.HttpError{
+			Code:        http.StatusBadRequest,
+			Description: fmt.Sprintf("The id_format query parameter must be one of %s", strings.Join(IdFormats, ", ")),
+		}
+	}
+}
+
+// change how the _id of an event is returned
+// ids that are not object ids are left unchanged unless they are excluded
+func formatId(event map[string]interface{}, format string) map[string]interface{} {
+	var id, ok = event["_id"]
+	if !ok {
+		return event
+	}
+
+	switch format {
+	case IdFormatExclude:
+		delete(event, "_id")
+	case IdFormatHex:
+		if objectId, ok := id.(primitive.ObjectID); ok {
+			event["_id"] = objectId.Hex()
+		}
+	case IdFormatObject:
+		if objectId, ok := id.(primitive.ObjectID); ok {
+			event["_id"] = map[string]string{"$oid": objectId.Hex()}
+		}
+	}
+
+	return event
+}
+
+// remove the _id column from csv exports when ids are excluded
+func idFormatColumns(columns []string, format string) []string {
+	if format != IdFormatExclude {
+		return columns
+	}
+
+	var formatted = make([]string, 0, len(columns))
+	for _, column := range columns {
+		if column != "_id" {
+			formatted = append(formatted, column)
+		}
+	}
+
+	return formatted
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestEventsQueryHandlerFieldAliases(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("aliases", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt, bson.D{
+			{Key: "actor", Value: "mitchell"},
+			{Key: "action", Value: "login"},
+			{Key: "summary", Value: "one"},
+		}))
+
+		// actor is aliased by the config and action is aliased by the request
+		var handler = EventsQueryHandler(mt.Coll, QueryConfig{
+			FieldAliases: map[string]string{"actor": "user"},
+		})
+
+		var writer = httptest.NewRecorder()
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/events?alias=action:verb", nil))
+
+		var results []map[string]interface{}
+		json.Unmarshal(writer.Body.Bytes(), &results)
+		if len(results) != 1 {
+			t.Fatalf(queryInvalidResultCountError, 1, len(results))
+		}
+
+		var expectedEvent = map[string]interface{}{"user": "mitchell", "verb": "login", "summary": "one"}
+		for field, value := range expectedEvent {
+			if results[0][field] != value {
+				t.Errorf("An unexpected value was returned for the %s field Expected: %v, Got: %v", field, value, results[0][field])
+			}
+		}
+
+		if _, ok := results[0]["actor"]; ok {
+			t.Error("The aliased actor field was returned under its stored name")
+		}
+	})
+}
+
+func TestParseFieldAliasesMalformed(t *testing.T) {
+	for _, spec := range []string{"actor", "actor:", ":user", "actor:user:extra"} {
+		var _, err = ParseFieldAliases(spec)
+		if err == nil {
+			t.Errorf("A malformed alias %q did not result in an error", spec)
+		}
+	}
+}
+
+// query the mocked db for one event and get its json with the id format provided
+func queryIdFormat(mt *mtest.T, id primitive.ObjectID, target string, config QueryConfig) string {
+	mt.AddMockResponses(mockCursorResponse(mt, bson.D{{Key: "_id", Value: id}, {Key: "summary", Value: "one"}}))
+
+	var writer = httptest.NewRecorder()
+	EventsQueryHandler(mt.Coll, config).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, target, nil))
+
+	return writer.Body.String()
+}
+
+func TestEventsQueryHandlerIdFormatHexByDefault(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("hex", func(mt *mtest.T) {
+		var id = primitive.NewObjectID()
+
+		var body = queryIdFormat(mt, id, "/events", QueryConfig{})
+		if body != `[{"_id":"`+id.Hex()+`","summary":"one"}]` {
+			t.Errorf("The id was not returned as a hex string Got: %s", body)
+		}
+	})
+}
+
+func TestEventsQueryHandlerIdFormatObject(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("object", func(mt *mtest.T) {
+		var id = primitive.NewObjectID()
+
+		var body = queryIdFormat(mt, id, "/events?id_format=object", QueryConfig{})
+		if body != `[{"_id":{"$oid":"`+id.Hex()+`"},"summary":"one"}]` {
+			t.Errorf("The id was not returned as an extended json object Got: %s", body)
+		}
+	})
+}
+
+func TestEventsQueryHandlerIdFormatExclude(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("exclude", func(mt *mtest.T) {
+		// the configured format is used when the query does not override it
+		var body = queryIdFormat(mt, primitive.NewObjectID(), "/events", QueryConfig{IdFormat: IdFormatExclude})
+		if body != `[{"summary":"one"}]` {
+			t.Errorf("The id was not excluded Got: %s", body)
+		}
+	})
+}
+
+func TestEventsQueryHandlerIdFormatInvalid(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("invalid", func(mt *mtest.T) {
+		var writer = httptest.NewRecorder()
+		EventsQueryHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/events?id_format=base64", nil))
+
+		if writer.Code != http.StatusBadRequest {
+			t.Errorf(queryInvalidStatusError, http.StatusBadRequest, writer.Code)
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// decode the json of an event so it can be added to the database
+// when a typed event is configured the json is decoded into it first so its fields keep their exact types
+// (i.e. an int64 is stored as a 64 bit integer rather than as a float that loses precision)
+// fields that are not in the typed event are dropped
+// otherwise the json is decoded into a map and every number is stored as a float
+func decodeEvent(d []byte, config InsertConfig) (map[string]interface{}, error) {
+	var event map[string]interface{}
+
+	if config.NewEvent == nil {
+		var err = decodeJson(d, &event)
+		return event, err
+	}
+
+	var typedEvent = config.NewEvent()
+	var err = decodeJson(d, typedEvent)
+
+	// the json can match the schema and still not fit the typed event (i.e. a field that does not fit its type)
+	// which is the fault of the event rather than the service
+	if err != nil {
+		err = typedEventError(err)
+	}
+
+	// the rest of the insert works with a map so the typed event is converted using its bson encoding
+	// which keeps the types of its fields
+	var encoded []byte
+	if err == nil {
+		encoded, err = bson.Marshal(typedEvent)
+	}
+
+	if err == nil {
+		err = bson.Unmarshal(encoded, &event)
+	}
+
+	return event, err
+}
+
+// get the 400 error for json that could not be decoded into a typed event
+func typedEventError(err error) error {
+	var httpError mux.HttpError
+	if errors.As(err, &httpError) {
+		return err
+	}
+
+	// errors from the fields of the typed event (i.e. a custom UnmarshalJSON) describe what was wrong with the value
+	var description = fmt.Sprintf("The event does not match the fields of the typed event: %s", err)
+
+	var typeError *json.UnmarshalTypeError
+	var syntaxError *json.SyntaxError
+	if errors.As(err, &typeError) && len(typeError.Field) != 0 {
+		description = fmt.Sprintf("The %s field can not be stored as a %s", typeError.Field, typeError.Type)
+	} else if errors.As(err, &syntaxError) {
+		description = "The request body must be valid json"
+	}
+
+	return mux.HttpError{
+		Code:        http.StatusBadRequest,
+		Description: description,
+	}
+}
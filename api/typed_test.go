@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// an event with a fixed shape whose numbers are too large to be stored exactly as floats
+type numericTestEvent struct {
+	Timestamp  int64                  `json:"timestamp" bson:"timestamp"`
+	Summary    string                 `json:"summary" bson:"summary"`
+	Source     map[string]interface{} `json:"source" bson:"source"`
+	Attributes map[string]interface{} `json:"attributes" bson:"attributes"`
+	Bytes      int64                  `json:"bytes" bson:"bytes"`
+	Retries    int32                  `json:"retries" bson:"retries"`
+}
+
+var numericEventJson = `{"timestamp":1649445988123456789,"summary":"A file was uploaded","source":{},"attributes":{},` +
+	`"bytes":9007199254740993,"retries":3}`
+
+func TestDecodeEventTypedKeepsNumericTypes(t *testing.T) {
+	var config = InsertConfig{NewEvent: func() interface{} { return &numericTestEvent{} }}
+
+	var event, err = decodeEvent([]byte(numericEventJson), config)
+	if err != nil {
+		t.Fatalf("An error occured while decoding the event: %s", err)
+	}
+
+	if event["timestamp"] != int64(1649445988123456789) {
+		t.Errorf("Expected the timestamp to be the int64 1649445988123456789 but got %#v", event["timestamp"])
+	}
+	if event["bytes"] != int64(9007199254740993) {
+		t.Errorf("Expected bytes to be the int64 9007199254740993 but got %#v", event["bytes"])
+	}
+	if event["retries"] != int32(3) {
+		t.Errorf("Expected retries to be the int32 3 but got %#v", event["retries"])
+	}
+}
+
+func TestDecodeEventMapLosesPrecision(t *testing.T) {
+	var event, err = decodeEvent([]byte(numericEventJson), InsertConfig{})
+	if err != nil {
+		t.Fatalf("An error occured while decoding the event: %s", err)
+	}
+
+	// without a typed event every number is a float so large integers are rounded
+	var bytes, ok = event["bytes"].(float64)
+	if !ok || int64(bytes) == 9007199254740993 {
+		t.Errorf("Expected bytes to be a rounded float64 but got %#v", event["bytes"])
+	}
+}
+
+func TestEventsAddHandlerTypedEvent(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("typed", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		var config = InsertConfig{NewEvent: func() interface{} { return &numericTestEvent{} }}
+
+		var writer = httptest.NewRecorder()
+		EventsAddHandler(mt.Coll, loadTestSchema(t), config).ServeHTTP(writer,
+			httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(numericEventJson)))
+
+		if writer.Code != http.StatusNoContent {
+			t.Fatalf("Expected the event to be added with a %d but got %d", http.StatusNoContent, writer.Code)
+		}
+
+		var timestamp = mt.GetStartedEvent().Command.Lookup("documents", "0", "timestamp")
+		if timestamp.Type != bsontype.Int64 || timestamp.Int64() != 1649445988123456789 {
+			t.Errorf("Expected the timestamp to be inserted as the int64 1649445988123456789 but got %s", timestamp)
+		}
+	})
+}
+
+func TestEventsAddHandlerTypedEventMismatch(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("mismatch", func(mt *mtest.T) {
+		var config = InsertConfig{NewEvent: func() interface{} { return &numericTestEvent{} }}
+
+		// the event matches the schema but 1.5 can not be stored in the int64 timestamp
+		var body = `{"timestamp":1.5,"summary":"A file was uploaded","source":{},"attributes":{}}`
+
+		var writer = httptest.NewRecorder()
+		EventsAddHandler(mt.Coll, loadTestSchema(t), config).ServeHTTP(writer,
+			httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+
+		if writer.Code != http.StatusBadRequest {
+			t.Fatalf("Expected an event that does not fit the typed event to get a %d but got %d", http.StatusBadRequest, writer.Code)
+		}
+
+		if !strings.Contains(writer.Body.String(), "timestamp") {
+			t.Errorf("The error did not name the field that did not fit Got: %s", writer.Body.String())
+		}
+	})
+}
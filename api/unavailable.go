@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// how long clients are told to wait before retrying when the database is unavailable
+// and no retry after is configured
+const DefaultRetryAfter = 5 * time.Second
+
+// check if an error was caused by the database being unreachable or not responding in time
+// rather than by a problem with the request or the service
+func isDbUnavailable(err error) bool {
+	var selectionError topology.ServerSelectionError
+
+	return errors.As(err, &selectionError) || mongo.IsNetworkError(err) || mongo.IsTimeout(err)
+}
+
+// error code mongo uses when a document does not match the validator of the collection
+const documentValidationFailureCode = 121
+
+// check if an error means an insert can never succeed no matter how many times it is retried
+// i.e. the event is a duplicate or it does not match the validator of the collection
+func isPermanentInsertError(err error) bool {
+	if mongo.IsDuplicateKeyError(err) {
+		return true
+	}
+
+	var serverError mongo.ServerError
+
+	return errors.As(err, &serverError) && serverError.HasErrorCode(documentValidationFailureCode)
+}
+
+// convert an error returned while reading events to the error sent to the user
+// errors caused by the database being unavailable or by every cursor slot being in use are sent as a 503
+// with a Retry-After header so clients can tell them apart from internal errors and know when to try again
+// any other error is returned unchanged
+func dbUnavailableError(writer http.ResponseWriter, err error, config QueryConfig) error {
+	if err == nil || (err != errCursorLimit && !isDbUnavailable(err)) {
+		return err
+	}
+
+	var retryAfter = config.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = DefaultRetryAfter
+	}
+
+	// Retry-After is a whole number of seconds so round up to make sure clients wait long enough
+	var seconds = int64((retryAfter + time.Second - 1) / time.Second)
+	writer.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+
+	var description = "The database is unavailable"
+	if err == errCursorLimit {
+		description = "Too many queries are running, try again later"
+	}
+
+	return mux.HttpError{
+		Code:        http.StatusServiceUnavailable,
+		Description: description,
+	}
+}
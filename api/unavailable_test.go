@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+func TestIsDbUnavailable(t *testing.T) {
+	var unavailable = []error{
+		topology.ServerSelectionError{Wrapped: fmt.Errorf("no reachable servers")},
+		fmt.Errorf("wrapped: %w", context.DeadlineExceeded),
+	}
+	for _, err := range unavailable {
+		if !isDbUnavailable(err) {
+			t.Errorf("The error %q was not treated as the database being unavailable", err)
+		}
+	}
+
+	if isDbUnavailable(fmt.Errorf("error decoding key timestamp")) {
+		t.Error("A decoding error was treated as the database being unavailable")
+	}
+}
+
+func TestEventsQueryHandlerConnectionErrorUnavailable(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("connection error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    6,
+			Message: "connection reset by peer",
+			Labels:  []string{"NetworkError"},
+		}))
+
+		var writer = httptest.NewRecorder()
+		EventsQueryHandler(mt.Coll, QueryConfig{}).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/events", nil))
+
+		if writer.Code != http.StatusServiceUnavailable {
+			t.Errorf(queryInvalidStatusError, http.StatusServiceUnavailable, writer.Code)
+		}
+
+		if writer.Header().Get("Retry-After") != "5" {
+			t.Errorf("Expected a Retry-After of 5 but got %q", writer.Header().Get("Retry-After"))
+		}
+	})
+}
+
+func TestEventsQueryHandlerDecodeErrorInternal(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("decode error", func(mt *mtest.T) {
+		mt.AddMockResponses(mockCursorResponse(mt, bson.D{{Key: "summary", Value: "one"}}, malformedDocument))
+
+		var writer = httptest.NewRecorder()
+		EventsQueryHandler(mt.Coll, QueryConfig{StrictDecoding: true}).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/events", nil))
+
+		if writer.Code != http.StatusInternalServerError {
+			t.Errorf(queryInvalidStatusError, http.StatusInternalServerError, writer.Code)
+		}
+
+		if len(writer.Header().Get("Retry-After")) != 0 {
+			t.Error("A Retry-After header was sent for an internal error")
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	"github.com/mitchellkelly/auditlog/mux"
+	"github.com/qri-io/jsonschema"
+)
+
+// ValidationResult is the response body sent by EventsValidateHandler
+type ValidationResult struct {
+	Valid       bool                    `json:"valid"`
+	Description string                  `json:"description,omitempty"`
+	Details     []ValidationErrorDetail `json:"details,omitempty"`
+}
+
+// an invalid validation result is an error so it can be sent with a 400 (or 422) using WriteJsonResponse
+type invalidValidationResult struct {
+	ValidationResult
+	statusCode int
+}
+
+func (self invalidValidationResult) Error() string {
+	return self.Description
+}
+
+// invalid results are always caused by the user
+func (self invalidValidationResult) StatusCode() int {
+	return self.statusCode
+}
+
+// EventsValidateHandler creates an http handler that checks if an event would be accepted
+// by EventsAddHandler without adding it to the database
+// the config is the one used by EventsAddHandler so events are reported the same way
+func EventsValidateHandler(schema *jsonschema.Schema, config InsertConfig) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// read the data from the request body
+		var d, err = ioutil.ReadAll(request.Body)
+		if err != nil {
+			err = mux.DefaultHttpError(http.StatusBadRequest)
+		}
+
+		var validationError ValidationError
+		if err == nil {
+			// validate the request data using the same json schema used when adding events
+			validationError, err = validateEventBody(request.Context(), schema, d)
+			if err != nil {
+				err = validationFailure(err, config.Logger)
+			}
+		}
+
+		if err == nil && len(validationError) > 0 {
+			err = invalidValidationResult{
+				ValidationResult: ValidationResult{
+					Valid:       false,
+					Description: validationError.Error(),
+					Details:     validationError.Details(),
+				},
+				statusCode: config.invalidEventStatus(),
+			}
+		}
+
+		if err == nil {
+			mux.WriteJsonResponse(writer, ValidationResult{Valid: true})
+		} else {
+			mux.WriteJsonResponse(writer, err)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/qri-io/jsonschema"
+)
+
+var validateInvalidStatusError = "An unexpected status code was returned when attempting to validate an event " +
+	"Expected: %d, Got: %d"
+
+func TestEventsValidateHandlerValidEvent(t *testing.T) {
+	var writer = httptest.NewRecorder()
+	var request = httptest.NewRequest(http.MethodPost, "/events/validate", strings.NewReader(validEventJson))
+	EventsValidateHandler(loadTestSchema(t), InsertConfig{}).ServeHTTP(writer, request)
+
+	if writer.Code != http.StatusOK {
+		t.Errorf(validateInvalidStatusError, http.StatusOK, writer.Code)
+	}
+
+	var expectedResponseText = `{"valid":true}`
+	if writer.Body.String() != expectedResponseText {
+		t.Errorf("An unexpected validation result was returned Expected: %s, Got: %s", expectedResponseText, writer.Body.String())
+	}
+}
+
+func TestEventsValidateHandlerInvalidEvent(t *testing.T) {
+	var writer = httptest.NewRecorder()
+	var request = httptest.NewRequest(http.MethodPost, "/events/validate", strings.NewReader(`{"summary":"","source":{},"attributes":{}}`))
+	EventsValidateHandler(loadTestSchema(t), InsertConfig{}).ServeHTTP(writer, request)
+
+	if writer.Code != http.StatusBadRequest {
+		t.Errorf(validateInvalidStatusError, http.StatusBadRequest, writer.Code)
+	}
+
+	var result ValidationResult
+	json.Unmarshal(writer.Body.Bytes(), &result)
+
+	// the timestamp is missing and the summary is too short
+	if result.Valid || len(result.Details) != 2 {
+		t.Errorf("The validation result did not describe both failures Got: %s", writer.Body.String())
+	}
+}
+
+// a json schema that refers to a definition that does not exist
+var brokenSchemaJson = `{"type":"object","properties":{"summary":{"$ref":"#/definitions/missing"}}}`
+
+func TestEventsValidateHandlerBrokenSchema(t *testing.T) {
+	var schema jsonschema.Schema
+	var err = json.Unmarshal([]byte(brokenSchemaJson), &schema)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while parsing the broken schema: %s", err)
+	}
+
+	var buf bytes.Buffer
+
+	var writer = httptest.NewRecorder()
+	var request = httptest.NewRequest(http.MethodPost, "/events/validate", strings.NewReader(validEventJson))
+	EventsValidateHandler(&schema, InsertConfig{Logger: log.New(&buf, "", 0)}).ServeHTTP(writer, request)
+
+	// the event is fine so the user should not be told it is invalid
+	if writer.Code != http.StatusInternalServerError {
+		t.Errorf(validateInvalidStatusError, http.StatusInternalServerError, writer.Code)
+	}
+
+	if buf.Len() == 0 {
+		t.Error("The schema error was not logged")
+	}
+}
+
+func TestEventsValidateHandlerNotJson(t *testing.T) {
+	var writer = httptest.NewRecorder()
+	var request = httptest.NewRequest(http.MethodPost, "/events/validate", strings.NewReader(`{"summary":`))
+	EventsValidateHandler(loadTestSchema(t), InsertConfig{}).ServeHTTP(writer, request)
+
+	if writer.Code != http.StatusBadRequest {
+		t.Errorf(validateInvalidStatusError, http.StatusBadRequest, writer.Code)
+	}
+}
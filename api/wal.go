@@ -0,0 +1,318 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// how often the write ahead log is drained into the database when no interval is configured
+const DefaultWalDrainInterval = 5 * time.Second
+
+// WriteAheadLog is a local file that added events are written to before they are inserted into the database
+// so an event that has been accepted is not lost if the database is unavailable or the service stops
+// events that could not be inserted are drained into the database once it is reachable again
+// each line of the file is an event encoded as canonical extended json so its types are kept
+type WriteAheadLog struct {
+	lock sync.Mutex
+	path string
+	file *os.File
+	// ids of the events in the file that have been inserted and can be removed from it
+	persisted map[string]struct{}
+	// ids of the events that are still being inserted by the request that added them
+	// so they are not inserted by Drain at the same time
+	inflight map[string]struct{}
+}
+
+// open the write ahead log at a path creating the file if it does not exist
+// events already in the file (i.e. from before a restart) are inserted by the next Drain
+func OpenWriteAheadLog(path string) (*WriteAheadLog, error) {
+	var file, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	// end a line that was only partly written when the service stopped
+	// so the next event is not appended to it
+	var d []byte
+	d, err = ioutil.ReadFile(path)
+	if err == nil && len(d) > 0 && d[len(d)-1] != '\n' {
+		_, err = file.Write([]byte{'\n'})
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &WriteAheadLog{
+		path:      path,
+		file:      file,
+		persisted: make(map[string]struct{}),
+		inflight:  make(map[string]struct{}),
+	}, nil
+}
+
+// the key used to keep track of an event in the log
+func walKey(event map[string]interface{}) string {
+	return csvCell(event["_id"])
+}
+
+// write an event to the log and wait until it is on disk
+// the event is given an _id first if it does not have one so inserting it again after a failure
+// can be recognized as a duplicate instead of adding the event twice
+// the caller must then insert the event and call Ack or Release
+func (self *WriteAheadLog) Append(event map[string]interface{}) error {
+	if _, ok := event["_id"]; !ok {
+		event["_id"] = primitive.NewObjectID()
+	}
+
+	var d, err = bson.MarshalExtJSON(event, true, false)
+	if err != nil {
+		return err
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	_, err = self.file.Write(append(d, '\n'))
+	if err == nil {
+		err = self.file.Sync()
+	}
+
+	if err == nil {
+		self.inflight[walKey(event)] = struct{}{}
+	}
+
+	return err
+}
+
+// mark an event as no longer needing to be inserted so it is removed from the log
+// this is used once the event has been inserted or if it can never be inserted
+func (self *WriteAheadLog) Ack(event map[string]interface{}) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	delete(self.inflight, walKey(event))
+	self.persisted[walKey(event)] = struct{}{}
+}
+
+// leave an event that could not be inserted in the log so it is inserted by Drain
+func (self *WriteAheadLog) Release(event map[string]interface{}) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	delete(self.inflight, walKey(event))
+}
+
+// read the events in the log that have not been inserted
+func (self *WriteAheadLog) pending() ([]map[string]interface{}, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var events []map[string]interface{}
+	var err = self.readLines(func(line []byte, event map[string]interface{}) {
+		var key = walKey(event)
+		if _, ok := self.persisted[key]; ok {
+			return
+		}
+		if _, ok := self.inflight[key]; ok {
+			return
+		}
+
+		events = append(events, event)
+	})
+
+	return events, err
+}
+
+// call a function with every event in the log file
+// a line that can not be decoded (i.e. one that was only partly written when the service stopped) is skipped
+// the lock must be held while this is called
+func (self *WriteAheadLog) readLines(f func(line []byte, event map[string]interface{})) error {
+	var file, err = os.Open(self.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader = bufio.NewReader(file)
+	for {
+		var line []byte
+		line, err = reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var event map[string]interface{}
+			if bson.UnmarshalExtJSON(line, true, &event) == nil {
+				f(line, event)
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// remove the events that have been inserted from the log file
+// the remaining events are written to a new file which replaces the log so the log is never left half written
+func (self *WriteAheadLog) compact() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if len(self.persisted) == 0 {
+		return nil
+	}
+
+	var remaining bytes.Buffer
+	var err = self.readLines(func(line []byte, event map[string]interface{}) {
+		if _, ok := self.persisted[walKey(event)]; !ok {
+			remaining.Write(bytes.TrimRight(line, "\n"))
+			remaining.WriteByte('\n')
+		}
+	})
+
+	var tempPath = self.path + ".tmp"
+	if err == nil {
+		err = writeFileSync(tempPath, remaining.Bytes())
+	}
+
+	if err == nil {
+		self.file.Close()
+		err = os.Rename(tempPath, self.path)
+	}
+
+	if err == nil {
+		self.file, err = os.OpenFile(self.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	}
+
+	if err == nil {
+		self.persisted = make(map[string]struct{})
+	}
+
+	return err
+}
+
+// write a file and wait until it is on disk
+func writeFileSync(path string, d []byte) error {
+	var file, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(d)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	var closeErr = file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// insert the events in the log that have not been inserted into the database and remove them from the log
+// events that are already in the database (i.e. they were inserted right before the service stopped)
+// are treated as inserted and events that can never be inserted are logged and removed
+// the number of events inserted is returned and draining stops at the first event that fails for any other reason
+func (self *WriteAheadLog) Drain(ctx context.Context, db *mongo.Collection, config InsertConfig) (int, error) {
+	var events, err = self.pending()
+
+	var inserted []map[string]interface{}
+	for i := 0; err == nil && i < len(events); i++ {
+		var timedContext, timedContextCancel = context.WithTimeout(ctx, 10*time.Second)
+		_, err = db.InsertOne(timedContext, events[i])
+		timedContextCancel()
+
+		// an event that can never be inserted would stop the rest of the log from being drained
+		// so it is removed from the log
+		// a duplicate is usually the same event inserted right before the service stopped
+		// but it can also be a different event whose client supplied _id is taken so it is logged as well
+		if isPermanentInsertError(err) {
+			if config.Logger != nil && mongo.IsDuplicateKeyError(err) {
+				config.Logger.Printf("Removed event %s from the write ahead log since an event with the same id is already in the database\n",
+					csvCell(events[i]["_id"]))
+			} else if config.Logger != nil {
+				config.Logger.Printf("Removed event %s from the write ahead log since it can not be inserted: %s\n",
+					csvCell(events[i]["_id"]), err)
+			}
+
+			err = nil
+			self.Ack(events[i])
+			continue
+		}
+
+		if err == nil {
+			self.Ack(events[i])
+			logEventAdded(config, events[i]["_id"], events[i])
+			inserted = append(inserted, events[i])
+		}
+	}
+
+	if len(inserted) > 0 {
+		config.SinkWriter.write(inserted)
+	}
+
+	var compactErr = self.compact()
+	if err == nil {
+		err = compactErr
+	}
+
+	return len(inserted), err
+}
+
+// drain the log into the database every interval until the context is done
+// a drain that is running when the context is done stops at the next insert and Run returns once it has
+// 0 means DefaultWalDrainInterval
+func (self *WriteAheadLog) Run(ctx context.Context, db *mongo.Collection, config InsertConfig, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultWalDrainInterval
+	}
+
+	var ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var inserted, err = self.Drain(ctx, db, config)
+			if config.Logger != nil {
+				if err != nil {
+					config.Logger.Printf("An error occured while draining the write ahead log: %s\n", err)
+				} else if inserted > 0 {
+					config.Logger.Printf("Inserted %d events from the write ahead log\n", inserted)
+				}
+			}
+		}
+	}
+}
+
+// remove the events that have been inserted from the log and close the log file
+// the events that are left are inserted by the first Drain after the log is opened again
+func (self *WriteAheadLog) Close() error {
+	var err = self.compact()
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var closeErr = self.file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	return err
+}
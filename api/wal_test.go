@@ -0,0 +1,397 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// the events in the write ahead log file that have not been removed
+func walLines(t *testing.T, path string) []string {
+	var d, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("The write ahead log could not be read: %s", err)
+	}
+
+	var lines = strings.Split(strings.TrimSpace(string(d)), "\n")
+	if len(lines) == 1 && len(lines[0]) == 0 {
+		return nil
+	}
+
+	return lines
+}
+
+func TestWriteAheadLogSurvivesOutageAndRestart(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("outage and restart", func(mt *mtest.T) {
+		var path = filepath.Join(t.TempDir(), "events.wal")
+		var wal, err = OpenWriteAheadLog(path)
+		if err != nil {
+			t.Fatalf("The write ahead log could not be opened: %s", err)
+		}
+
+		// the database goes away while the event is being inserted
+		var networkError = mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    6,
+			Message: "connection reset by peer",
+			Labels:  []string{"NetworkError"},
+		})
+		mt.AddMockResponses(networkError, networkError)
+
+		var writer = httptest.NewRecorder()
+		var handler = EventsAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{WriteAheadLog: wal})
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(validEventJson)))
+
+		if writer.Code != http.StatusAccepted {
+			t.Fatalf("The event was not accepted while the database was unavailable Expected: %d, Got: %d", http.StatusAccepted, writer.Code)
+		}
+
+		if len(walLines(t, path)) != 1 {
+			t.Fatalf("The event was not kept in the write ahead log Got: %q", walLines(t, path))
+		}
+
+		// the service restarts before the database comes back
+		wal.Close()
+		wal, err = OpenWriteAheadLog(path)
+		if err != nil {
+			t.Fatalf("The write ahead log could not be reopened: %s", err)
+		}
+		defer wal.Close()
+
+		mt.ClearMockResponses()
+		mt.ClearEvents()
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		var inserted int
+		inserted, err = wal.Drain(context.Background(), mt.Coll, InsertConfig{})
+		if err != nil {
+			t.Fatalf("The write ahead log could not be drained: %s", err)
+		}
+
+		if inserted != 1 {
+			t.Errorf("Expected 1 event to be inserted from the write ahead log but got %d", inserted)
+		}
+
+		var summary = mt.GetStartedEvent().Command.Lookup("documents", "0", "summary").StringValue()
+		if summary != "A customer was added" {
+			t.Errorf("An unexpected event was inserted from the write ahead log Got: %s", summary)
+		}
+
+		if len(walLines(t, path)) != 0 {
+			t.Errorf("The inserted event was not removed from the write ahead log Got: %q", walLines(t, path))
+		}
+	})
+}
+
+func TestWriteAheadLogRemovesInsertedEvents(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("inserted", func(mt *mtest.T) {
+		var path = filepath.Join(t.TempDir(), "events.wal")
+		var wal, err = OpenWriteAheadLog(path)
+		if err != nil {
+			t.Fatalf("The write ahead log could not be opened: %s", err)
+		}
+		defer wal.Close()
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		var writer = httptest.NewRecorder()
+		var handler = EventsAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{WriteAheadLog: wal})
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(validEventJson)))
+
+		if writer.Code != http.StatusNoContent {
+			t.Fatalf("The event was not added Expected: %d, Got: %d", http.StatusNoContent, writer.Code)
+		}
+
+		// draining without pending events does not touch the database
+		var inserted int
+		inserted, err = wal.Drain(context.Background(), mt.Coll, InsertConfig{})
+		if err != nil || inserted != 0 {
+			t.Errorf("Expected nothing to be drained but got %d events and the error %v", inserted, err)
+		}
+
+		if len(walLines(t, path)) != 0 {
+			t.Errorf("The inserted event was not removed from the write ahead log Got: %q", walLines(t, path))
+		}
+	})
+}
+
+func TestWriteAheadLogKeepsEventAfterOtherErrors(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("not primary", func(mt *mtest.T) {
+		var path = filepath.Join(t.TempDir(), "events.wal")
+		var wal, err = OpenWriteAheadLog(path)
+		if err != nil {
+			t.Fatalf("The write ahead log could not be opened: %s", err)
+		}
+		defer wal.Close()
+
+		// the database is reachable but the insert fails for a reason that does not last
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    10107,
+			Message: "not primary",
+		}))
+
+		var writer = httptest.NewRecorder()
+		var handler = EventsAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{WriteAheadLog: wal})
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(validEventJson)))
+
+		if writer.Code != http.StatusInternalServerError {
+			t.Errorf("An unexpected status code was returned when attempting to add an event Expected: %d, Got: %d", http.StatusInternalServerError, writer.Code)
+		}
+
+		mt.ClearMockResponses()
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		var inserted int
+		inserted, err = wal.Drain(context.Background(), mt.Coll, InsertConfig{})
+		if err != nil || inserted != 1 {
+			t.Errorf("Expected the event to be kept in the write ahead log and drained but got %d events and the error %v", inserted, err)
+		}
+	})
+}
+
+func TestWriteAheadLogDrainRemovesInvalidEvents(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("validation", func(mt *mtest.T) {
+		var path = filepath.Join(t.TempDir(), "events.wal")
+		var wal, err = OpenWriteAheadLog(path)
+		if err != nil {
+			t.Fatalf("The write ahead log could not be opened: %s", err)
+		}
+		defer wal.Close()
+
+		var invalidEvent = map[string]interface{}{"summary": "An order was placed"}
+		var validEvent = map[string]interface{}{"summary": "An order was shipped"}
+		for _, event := range []map[string]interface{}{invalidEvent, validEvent} {
+			err = wal.Append(event)
+			if err != nil {
+				t.Fatalf("The event could not be written to the write ahead log: %s", err)
+			}
+			wal.Release(event)
+		}
+
+		// the first event does not match the validator of the collection so it can never be inserted
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   0,
+			Code:    121,
+			Message: "Document failed validation",
+		}), mtest.CreateSuccessResponse())
+
+		var inserted int
+		inserted, err = wal.Drain(context.Background(), mt.Coll, InsertConfig{})
+		if err != nil || inserted != 1 {
+			t.Errorf("Expected the invalid event to be skipped but got %d events and the error %v", inserted, err)
+		}
+
+		if len(walLines(t, path)) != 0 {
+			t.Errorf("The invalid event was not removed from the write ahead log Got: %q", walLines(t, path))
+		}
+	})
+}
+
+func TestWriteAheadLogBatchOutage(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("batch outage", func(mt *mtest.T) {
+		var path = filepath.Join(t.TempDir(), "events.wal")
+		var wal, err = OpenWriteAheadLog(path)
+		if err != nil {
+			t.Fatalf("The write ahead log could not be opened: %s", err)
+		}
+		defer wal.Close()
+
+		var networkError = mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    6,
+			Message: "connection reset by peer",
+			Labels:  []string{"NetworkError"},
+		})
+		mt.AddMockResponses(networkError, networkError)
+
+		var writer = httptest.NewRecorder()
+		var handler = EventsBulkAddHandler(mt.Coll, loadTestSchema(t), InsertConfig{WriteAheadLog: wal})
+		var body = "[" + validEventJson + "," + validEventJson + "]"
+		handler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, "/events/batch", strings.NewReader(body)))
+
+		if writer.Code != http.StatusAccepted {
+			t.Fatalf("The batch was not accepted while the database was unavailable Expected: %d, Got: %d", http.StatusAccepted, writer.Code)
+		}
+
+		if len(walLines(t, path)) != 2 {
+			t.Fatalf("The events were not kept in the write ahead log Got: %q", walLines(t, path))
+		}
+
+		mt.ClearMockResponses()
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+		var inserted int
+		inserted, err = wal.Drain(context.Background(), mt.Coll, InsertConfig{})
+		if err != nil || inserted != 2 {
+			t.Errorf("Expected the batch to be drained but got %d events and the error %v", inserted, err)
+		}
+	})
+}
+
+func TestWriteAheadLogDrainDuplicateIsPersisted(t *testing.T) {
+	var mt = newMockDb(t)
+	defer mt.Close()
+
+	mt.Run("duplicate", func(mt *mtest.T) {
+		var path = filepath.Join(t.TempDir(), "events.wal")
+		var wal, err = OpenWriteAheadLog(path)
+		if err != nil {
+			t.Fatalf("The write ahead log could not be opened: %s", err)
+		}
+		defer wal.Close()
+
+		// the event was inserted right before the service stopped so it never got removed from the log
+		var event = map[string]interface{}{"summary": "An order was placed"}
+		err = wal.Append(event)
+		if err != nil {
+			t.Fatalf("The event could not be written to the write ahead log: %s", err)
+		}
+		wal.Release(event)
+
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   0,
+			Code:    11000,
+			Message: "E11000 duplicate key error collection: auditlog.event index: _id_ dup key",
+		}))
+
+		var logs bytes.Buffer
+		var inserted int
+		inserted, err = wal.Drain(context.Background(), mt.Coll, InsertConfig{Logger: log.New(&logs, "", 0)})
+		if err != nil {
+			t.Fatalf("A duplicate event failed the drain: %s", err)
+		}
+
+		// the id could also belong to a different event so the drop is logged
+		if !strings.Contains(logs.String(), csvCell(event["_id"])) {
+			t.Errorf("The duplicate event was removed without being logged Got: %s", logs.String())
+		}
+
+		if inserted != 0 {
+			t.Errorf("Expected the duplicate event to not be counted as inserted but got %d", inserted)
+		}
+
+		if len(walLines(t, path)) != 0 {
+			t.Errorf("The duplicate event was not removed from the write ahead log Got: %q", walLines(t, path))
+		}
+	})
+}
+
+func TestWriteAheadLogSkipsPartialLines(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "events.wal")
+	var d, _ = bson.MarshalExtJSON(map[string]interface{}{"_id": "order-1234"}, true, false)
+	// the last line was only partly written when the service stopped
+	var err = ioutil.WriteFile(path, append(append(d, '\n'), `{"_id":"ord`...), 0600)
+	if err != nil {
+		t.Fatalf("The write ahead log could not be written: %s", err)
+	}
+
+	var wal *WriteAheadLog
+	wal, err = OpenWriteAheadLog(path)
+	if err != nil {
+		t.Fatalf("The write ahead log could not be opened: %s", err)
+	}
+	defer wal.Close()
+
+	var events []map[string]interface{}
+	events, err = wal.pending()
+	if err != nil {
+		t.Fatalf("The pending events could not be read: %s", err)
+	}
+
+	if len(events) != 1 || events[0]["_id"] != "order-1234" {
+		t.Errorf("Expected only the complete event to be pending but got %v", events)
+	}
+
+	// an event added after the partial line is still read
+	err = wal.Append(map[string]interface{}{"_id": "order-5678"})
+	if err == nil {
+		events, err = wal.pending()
+	}
+	if err != nil {
+		t.Fatalf("The event could not be written to the write ahead log: %s", err)
+	}
+
+	if len(events) != 1 {
+		t.Errorf("Expected the added event to be inflight rather than pending but got %v", events)
+	}
+
+	if len(walLines(t, path)) != 3 {
+		t.Errorf("The added event was not written on its own line Got: %q", walLines(t, path))
+	}
+}
+
+func TestWriteAheadLogCloseCompacts(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "events.wal")
+	var wal, err = OpenWriteAheadLog(path)
+	if err != nil {
+		t.Fatalf("The write ahead log could not be opened: %s", err)
+	}
+
+	var inserted = map[string]interface{}{"summary": "An order was placed"}
+	var pending = map[string]interface{}{"summary": "An order was shipped"}
+	for _, event := range []map[string]interface{}{inserted, pending} {
+		err = wal.Append(event)
+		if err != nil {
+			t.Fatalf("The event could not be written to the write ahead log: %s", err)
+		}
+	}
+	wal.Ack(inserted)
+	wal.Release(pending)
+
+	err = wal.Close()
+	if err != nil {
+		t.Fatalf("An unexpected error occured while closing the write ahead log: %s", err)
+	}
+
+	// only the event that was not inserted is left for the next start
+	var lines = walLines(t, path)
+	if len(lines) != 1 || !strings.Contains(lines[0], "shipped") {
+		t.Errorf("The inserted event was not removed when the write ahead log was closed Got: %q", lines)
+	}
+}
+
+func TestWriteAheadLogRunStopsWhenCancelled(t *testing.T) {
+	var wal, err = OpenWriteAheadLog(filepath.Join(t.TempDir(), "events.wal"))
+	if err != nil {
+		t.Fatalf("The write ahead log could not be opened: %s", err)
+	}
+	defer wal.Close()
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	var done = make(chan struct{})
+	go func() {
+		wal.Run(ctx, nil, InsertConfig{}, time.Hour)
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return once its context was cancelled")
+	}
+}
@@ -0,0 +1,917 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellkelly/auditlog/api"
+	"github.com/mitchellkelly/auditlog/mux"
+)
+
+// value shown in place of secrets when the configuration is displayed
+const redactedValue = "REDACTED"
+
+// Duration is a time.Duration that is written as a readable string (i.e. 10s) in json
+type Duration time.Duration
+
+func (self Duration) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(time.Duration(self).String())), nil
+}
+
+// Config holds the settings the service is running with
+// the values come from command line flags and environment variables
+type Config struct {
+	ServerPort   string `json:"server_port"`
+	ServeTls     bool   `json:"serve_tls"`
+	TlsCert      string `json:"tls_cert"`
+	TlsKey       string `json:"tls_key"`
+	AdminAddress string `json:"admin_address"`
+
+	ApiToken  string   `json:"api_token"`
+	ApiTokens []string `json:"api_tokens"`
+	// tokens mapped to the scope (read or write) that limits the requests they can make
+	TokenScopes map[string]string `json:"token_scopes"`
+	IngestToken string            `json:"ingest_token"`
+	QueryToken  string            `json:"query_token"`
+	AdminToken  string            `json:"admin_token"`
+	// request header holding the identity of the caller set by an external auth gateway
+	IdentityHeader string `json:"identity_header"`
+
+	SchemaFilePath    string `json:"schema_file_path"`
+	SchemaDraft       string `json:"schema_draft"`
+	DisableValidation bool   `json:"disable_validation"`
+	AppendOnly        bool   `json:"append_only"`
+	AckMode           string `json:"ack_mode"`
+	AsyncWorkers      int64  `json:"async_workers"`
+	AsyncQueueSize    int64  `json:"async_queue_size"`
+
+	DbHost                   string   `json:"db_host"`
+	DbPort                   string   `json:"db_port"`
+	DbUsername               string   `json:"db_username"`
+	DbPassword               string   `json:"db_password"`
+	DbServerSelectionTimeout Duration `json:"db_server_selection_timeout"`
+	DbSocketTimeout          Duration `json:"db_socket_timeout"`
+	DbPoolMonitor            bool     `json:"db_pool_monitor"`
+	DbMaxOpenCursors         int64    `json:"db_max_open_cursors"`
+
+	StrictDecoding    bool              `json:"strict_decoding"`
+	DefaultQueryLimit int64             `json:"default_query_limit"`
+	MaxQueryLimit     int64             `json:"max_query_limit"`
+	FieldAliases      map[string]string `json:"field_aliases"`
+	ResultTransforms  string            `json:"result_transforms"`
+	FlattenBsonTypes  bool              `json:"flatten_bson_types"`
+	SortableFields    []string          `json:"sortable_fields"`
+	SortTiebreaker    bool              `json:"sort_tiebreaker"`
+	QueryTimeout      Duration          `json:"query_timeout"`
+	PartialResults    bool              `json:"partial_results"`
+	MaxFilterFields   int64             `json:"max_filter_fields"`
+	DeleteBatchSize   int64             `json:"delete_batch_size"`
+	AggregateFields   []string          `json:"aggregate_fields"`
+	AggregateDiskUse  bool              `json:"aggregate_allow_disk_use"`
+	AggregateMaxTime  Duration          `json:"aggregate_max_time"`
+	IdFormat          string            `json:"id_format"`
+	EmptyFilterPolicy string            `json:"empty_filter_policy"`
+	IndexHints        []string          `json:"index_hints"`
+	IndexFields       []string          `json:"index_fields"`
+	DefaultHints      map[string]string `json:"default_hints"`
+	RetryAfter        Duration          `json:"retry_after"`
+	ReadConsistency   string            `json:"read_consistency"`
+	TimestampField    string            `json:"timestamp_field"`
+	MaxTimeRange      Duration          `json:"max_time_range"`
+
+	MaxEventBytes        int64          `json:"max_event_bytes"`
+	MaxBodyBytes         int64          `json:"max_body_bytes"`
+	CorrelationField     string         `json:"correlation_field"`
+	BodyReadTimeout      Duration       `json:"body_read_timeout"`
+	InvalidEventStatus   int64          `json:"invalid_event_status"`
+	PartialBatches       bool           `json:"partial_batches"`
+	MetadataField        string         `json:"metadata_field"`
+	TypedEvents          bool           `json:"typed_events"`
+	IdStrategy           string         `json:"id_strategy"`
+	IdField              string         `json:"id_field"`
+	RetentionSeconds     int64          `json:"retention_seconds"`
+	FieldMaxBytes        map[string]int `json:"field_max_bytes"`
+	DefaultFieldMaxBytes int64          `json:"default_field_max_bytes"`
+	SinkCollection       string         `json:"sink_collection"`
+	SinkFile             string         `json:"sink_file"`
+	SinkWorkers          int64          `json:"sink_workers"`
+	SinkQueueSize        int64          `json:"sink_queue_size"`
+	SinkTimeout          Duration       `json:"sink_timeout"`
+	EncryptedFields      []string       `json:"encrypted_fields"`
+	EncryptionKey        string         `json:"encryption_key"`
+	DeterministicEncrypt bool           `json:"deterministic_encryption"`
+	DecryptTokens        []string       `json:"decrypt_tokens"`
+	WalPath              string         `json:"wal_path"`
+	WalDrainInterval     Duration       `json:"wal_drain_interval"`
+
+	MaxHeaderBytes      int64 `json:"max_header_bytes"`
+	MaxHeaders          int64 `json:"max_headers"`
+	MaxHeaderValueBytes int64 `json:"max_header_value_bytes"`
+
+	GzipLevel int64 `json:"gzip_level"`
+
+	ReadinessCheck string `json:"readiness_check"`
+
+	DisableRootEndpoint bool `json:"disable_root_endpoint"`
+
+	RateLimit      int64 `json:"rate_limit"`
+	RateLimitBurst int64 `json:"rate_limit_burst"`
+
+	CorsOrigins []string `json:"cors_origins"`
+	CorsMethods []string `json:"cors_methods"`
+	CorsHeaders []string `json:"cors_headers"`
+
+	StartupAttempts   int64    `json:"startup_attempts"`
+	StartupRetryDelay Duration `json:"startup_retry_delay"`
+
+	LogFields            []string `json:"log_fields"`
+	LogHeaders           []string `json:"log_headers"`
+	SlowRequestThreshold Duration `json:"slow_request_threshold"`
+	LogSampleRate        float64  `json:"log_sample_rate"`
+	LogSampleHeader      string   `json:"log_sample_header"`
+	LogFormat            string   `json:"log_format"`
+
+	ResponseHeaders         map[string]string `json:"response_headers"`
+	OverrideResponseHeaders bool              `json:"override_response_headers"`
+}
+
+// LoadConfig reads the service configuration from environment variables
+// an error is returned if a required value is missing or a value is invalid
+func LoadConfig(serverPort string, serveTls bool) (Config, error) {
+	var config = Config{
+		ServerPort: serverPort,
+		ServeTls:   serveTls,
+	}
+
+	// if a port was not set we will use a default port
+	if len(config.ServerPort) == 0 {
+		// use 443 when using tls or 80 otherwise
+		if config.ServeTls {
+			config.ServerPort = "443"
+		} else {
+			config.ServerPort = "80"
+		}
+	}
+
+	// get the cert and key values from env variables
+	if config.ServeTls {
+		config.TlsCert = os.Getenv("AUDIT_LOG_TLS_CERT")
+		config.TlsKey = os.Getenv("AUDIT_LOG_TLS_KEY")
+	}
+
+	// the admin and health endpoints are served on a separate listener if an admin address is provided
+	// (i.e. 127.0.0.1:9090 to only allow internal requests)
+	config.AdminAddress = os.Getenv("AUDIT_LOG_ADMIN_ADDRESS")
+
+	// TODO using a single api token is not a very secure authentication method
+	// ideally the service would use a more dynamic authentication method like JWTs
+	config.ApiToken = os.Getenv("AUDIT_LOG_API_TOKEN")
+	// more tokens with the same access as the api token (i.e. one for each producer or while rotating tokens)
+	// requests are not authenticated if neither is provided (i.e. behind a gateway that authenticates them)
+	config.ApiTokens = GetEnvList("AUDIT_LOG_API_TOKENS")
+
+	// optional tokens for producers that should only add events and for consumers that should only query them
+	config.IngestToken = os.Getenv("AUDIT_LOG_INGEST_TOKEN")
+	config.QueryToken = os.Getenv("AUDIT_LOG_QUERY_TOKEN")
+
+	// the admin endpoints are only available if an admin token is provided
+	config.AdminToken = os.Getenv("AUDIT_LOG_ADMIN_TOKEN")
+
+	// the identity a gateway in front of the service adds to requests (i.e. X-Forwarded-User)
+	// it is only read when requests are not authenticated by the service
+	config.IdentityHeader = os.Getenv("AUDIT_LOG_IDENTITY_HEADER")
+
+	config.SchemaFilePath = os.Getenv("AUDIT_LOG_EVENT_SCHEMA_FILE")
+	if len(config.SchemaFilePath) == 0 {
+		return config, fmt.Errorf("A path to a json schema file for audit log events was not provided. Please provide on using the AUDIT_LOG_EVENT_SCHEMA_FILE environment variable")
+	}
+
+	// get the json schema draft the event schema must be written for
+	config.SchemaDraft = os.Getenv("AUDIT_LOG_SCHEMA_DRAFT")
+	if len(config.SchemaDraft) == 0 {
+		config.SchemaDraft = "draft-07"
+	}
+	if !containsString(api.SchemaDrafts, config.SchemaDraft) {
+		return config, fmt.Errorf("The AUDIT_LOG_SCHEMA_DRAFT environment variable must be one of %s", strings.Join(api.SchemaDrafts, ", "))
+	}
+
+	// get the db username and password from env variable
+	config.DbUsername = os.Getenv("AUDIT_LOG_DB_USERNAME")
+	config.DbPassword = os.Getenv("AUDIT_LOG_DB_PASSWORD")
+
+	// get the db host from env variable
+	// setting it to localhost if it is not provided
+	config.DbHost = os.Getenv("AUDIT_LOG_DB_HOST")
+	if len(config.DbHost) == 0 {
+		config.DbHost = "localhost"
+	}
+	// get the db port from env variable
+	// setting it to the mongo default if it is not provided
+	config.DbPort = os.Getenv("AUDIT_LOG_DB_PORT")
+	if len(config.DbPort) == 0 {
+		config.DbPort = "27017"
+	}
+
+	// get the driver timeouts from env variables
+	var dbServerSelectionTimeout, err = GetEnvDuration("AUDIT_LOG_DB_SERVER_SELECTION_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return config, err
+	}
+	config.DbServerSelectionTimeout = Duration(dbServerSelectionTimeout)
+
+	var dbSocketTimeout time.Duration
+	dbSocketTimeout, err = GetEnvDuration("AUDIT_LOG_DB_SOCKET_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return config, err
+	}
+	config.DbSocketTimeout = Duration(dbSocketTimeout)
+
+	// get whether the db connection pool is monitored
+	config.DbPoolMonitor, err = GetEnvBool("AUDIT_LOG_DB_POOL_MONITOR", false)
+	if err != nil {
+		return config, err
+	}
+
+	// get how many query cursors can be open at once
+	// 0 means there is no limit
+	config.DbMaxOpenCursors, err = GetEnvInt("AUDIT_LOG_DB_MAX_OPEN_CURSORS", 0)
+	if err != nil {
+		return config, err
+	}
+
+	// get the decoding mode used when reading events from the db
+	// by default events that cannot be decoded are skipped rather than failing the whole query
+	config.StrictDecoding, err = GetEnvBool("AUDIT_LOG_STRICT_DECODING", false)
+	if err != nil {
+		return config, err
+	}
+
+	// get the number of events returned by a query when the user does not provide a limit
+	// and the largest limit a user is allowed to request
+	config.DefaultQueryLimit, err = GetEnvInt("AUDIT_LOG_DEFAULT_QUERY_LIMIT", 100)
+	if err != nil {
+		return config, err
+	}
+	config.MaxQueryLimit, err = GetEnvInt("AUDIT_LOG_MAX_QUERY_LIMIT", 10000)
+	if err != nil {
+		return config, err
+	}
+
+	// get the field aliases applied to query results
+	config.FieldAliases, err = api.ParseFieldAliases(os.Getenv("AUDIT_LOG_FIELD_ALIASES"))
+	if err != nil {
+		return config, fmt.Errorf("The AUDIT_LOG_FIELD_ALIASES environment variable is invalid: %s", err)
+	}
+
+	// get the transforms applied to every query result
+	// the spec is parsed here so a mistake stops the service from starting
+	config.ResultTransforms = os.Getenv("AUDIT_LOG_RESULT_TRANSFORMS")
+	_, err = api.ParseTransformPipeline(config.ResultTransforms)
+	if err != nil {
+		return config, fmt.Errorf("The AUDIT_LOG_RESULT_TRANSFORMS environment variable is invalid: %s", err)
+	}
+
+	// get whether dates, object ids and decimals in query results are returned as plain json
+	// they are returned the way they always have been by default so existing clients are not broken
+	config.FlattenBsonTypes, err = GetEnvBool("AUDIT_LOG_FLATTEN_BSON_TYPES", false)
+	if err != nil {
+		return config, err
+	}
+
+	// get the fields query results can be sorted by
+	// by default only the indexed fields used by the default sort are allowed
+	config.SortableFields = GetEnvList("AUDIT_LOG_SORTABLE_FIELDS")
+	if len(config.SortableFields) == 0 {
+		config.SortableFields = []string{"timestamp", "_id"}
+	}
+
+	// get whether _id is added as the last sort key so the order of query results is always total
+	config.SortTiebreaker, err = GetEnvBool("AUDIT_LOG_SORT_TIEBREAKER", true)
+	if err != nil {
+		return config, err
+	}
+
+	// get how long a query can run and whether a query that runs out of time returns the events read so far
+	var queryTimeout time.Duration
+	queryTimeout, err = GetEnvDuration("AUDIT_LOG_QUERY_TIMEOUT", api.DefaultQueryTimeout)
+	if err != nil {
+		return config, err
+	}
+	config.QueryTimeout = Duration(queryTimeout)
+	config.PartialResults, err = GetEnvBool("AUDIT_LOG_PARTIAL_RESULTS", false)
+	if err != nil {
+		return config, err
+	}
+
+	// get how event ids are returned in query results
+	config.IdFormat = os.Getenv("AUDIT_LOG_ID_FORMAT")
+	if len(config.IdFormat) == 0 {
+		config.IdFormat = api.IdFormatHex
+	}
+	if !containsString(api.IdFormats, config.IdFormat) {
+		return config, fmt.Errorf("The AUDIT_LOG_ID_FORMAT environment variable must be one of %s", strings.Join(api.IdFormats, ", "))
+	}
+
+	// get the indexes queries can be forced to use and the index used for each combination of filter fields
+	config.IndexHints = GetEnvList("AUDIT_LOG_INDEX_HINTS")
+
+	// get the fields that are indexed at startup along with the timestamp field
+	config.IndexFields = GetEnvList("AUDIT_LOG_INDEX_FIELDS")
+	var defaultHints = os.Getenv("AUDIT_LOG_DEFAULT_HINTS")
+	if len(defaultHints) > 0 {
+		err = json.Unmarshal([]byte(defaultHints), &config.DefaultHints)
+		if err != nil {
+			return config, fmt.Errorf("The AUDIT_LOG_DEFAULT_HINTS environment variable must be a json object of filter fields to index names")
+		}
+	}
+
+	// get how long clients are told to wait when the database is unavailable
+	var retryAfter time.Duration
+	retryAfter, err = GetEnvDuration("AUDIT_LOG_RETRY_AFTER", api.DefaultRetryAfter)
+	if err != nil {
+		return config, err
+	}
+	config.RetryAfter = Duration(retryAfter)
+
+	// get the read consistency used by queries that do not ask for one
+	config.ReadConsistency = os.Getenv("AUDIT_LOG_READ_CONSISTENCY")
+	if len(config.ReadConsistency) != 0 && !containsString(api.Consistencies, config.ReadConsistency) {
+		return config, fmt.Errorf("The AUDIT_LOG_READ_CONSISTENCY environment variable must be one of %s",
+			strings.Join(api.Consistencies, ", "))
+	}
+
+	// get the field relative time windows (i.e. last=24h) are applied to
+	config.TimestampField = os.Getenv("AUDIT_LOG_TIMESTAMP_FIELD")
+	if len(config.TimestampField) == 0 {
+		config.TimestampField = api.DefaultTimestampField
+	}
+
+	// get the longest time window a query can cover
+	// queries can cover any time window by default
+	var maxTimeRange time.Duration
+	maxTimeRange, err = GetEnvDuration("AUDIT_LOG_MAX_TIME_RANGE", 0)
+	if err != nil {
+		return config, err
+	}
+	config.MaxTimeRange = Duration(maxTimeRange)
+
+	// get what happens to a query that does not filter the events
+	config.EmptyFilterPolicy = os.Getenv("AUDIT_LOG_EMPTY_FILTER_POLICY")
+	if len(config.EmptyFilterPolicy) == 0 {
+		config.EmptyFilterPolicy = api.EmptyFilterAllow
+	}
+	if !containsString(api.EmptyFilterPolicies, config.EmptyFilterPolicy) {
+		return config, fmt.Errorf("The AUDIT_LOG_EMPTY_FILTER_POLICY environment variable must be one of %s",
+			strings.Join(api.EmptyFilterPolicies, ", "))
+	}
+
+	// get the largest number of distinct fields a query can filter on
+	config.MaxFilterFields, err = GetEnvInt("AUDIT_LOG_MAX_FILTER_FIELDS", 20)
+	if err != nil {
+		return config, err
+	}
+
+	// get the limits that keep aggregations from overloading the db
+	config.AggregateFields = GetEnvList("AUDIT_LOG_AGGREGATE_FIELDS")
+	config.AggregateDiskUse, err = GetEnvBool("AUDIT_LOG_AGGREGATE_ALLOW_DISK_USE", true)
+	if err != nil {
+		return config, err
+	}
+	var aggregateMaxTime time.Duration
+	aggregateMaxTime, err = GetEnvDuration("AUDIT_LOG_AGGREGATE_MAX_TIME", 0)
+	if err != nil {
+		return config, err
+	}
+	config.AggregateMaxTime = Duration(aggregateMaxTime)
+
+	// get the number of events a bulk delete removes at a time
+	config.DeleteBatchSize, err = GetEnvInt("AUDIT_LOG_DELETE_BATCH_SIZE", api.DefaultDeleteBatchSize)
+	if err != nil {
+		return config, err
+	}
+
+	// get the largest size an event can be once it is encoded for the db
+	config.MaxEventBytes, err = GetEnvInt("AUDIT_LOG_MAX_EVENT_BYTES", api.DefaultMaxEventBytes)
+	if err != nil {
+		return config, err
+	}
+
+	// get the largest request body that is read when adding events
+	config.MaxBodyBytes, err = GetEnvInt("AUDIT_LOG_MAX_BODY_BYTES", api.DefaultMaxBodyBytes)
+	if err != nil {
+		return config, err
+	}
+
+	// get the event field whose value is included when logging that an event was added
+	config.CorrelationField = os.Getenv("AUDIT_LOG_CORRELATION_FIELD")
+
+	// get how long clients have to send the body of a request that adds events
+	var bodyReadTimeout time.Duration
+	bodyReadTimeout, err = GetEnvDuration("AUDIT_LOG_BODY_READ_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return config, err
+	}
+	config.BodyReadTimeout = Duration(bodyReadTimeout)
+
+	// get the status code sent when a well formed event does not match the schema
+	config.InvalidEventStatus, err = GetEnvInt("AUDIT_LOG_INVALID_EVENT_STATUS", http.StatusBadRequest)
+	if err != nil || (config.InvalidEventStatus != http.StatusBadRequest && config.InvalidEventStatus != http.StatusUnprocessableEntity) {
+		return config, fmt.Errorf("The AUDIT_LOG_INVALID_EVENT_STATUS environment variable must be either 400 or 422")
+	}
+
+	// get whether the valid events in a batch are added when some of the others are invalid
+	config.PartialBatches, err = GetEnvBool("AUDIT_LOG_PARTIAL_BATCHES", false)
+	if err != nil {
+		return config, err
+	}
+
+	// get the field request metadata (who sent an event and when) is added to
+	config.MetadataField = os.Getenv("AUDIT_LOG_METADATA_FIELD")
+
+	// get how added events are acknowledged when the request does not ask for a mode
+	config.AckMode = os.Getenv("AUDIT_LOG_ACK_MODE")
+	if len(config.AckMode) != 0 && !containsString(api.AckModes, config.AckMode) {
+		return config, fmt.Errorf("The AUDIT_LOG_ACK_MODE environment variable must be one of %s", strings.Join(api.AckModes, ", "))
+	}
+
+	// get how many events added in async mode are inserted at once and how many can wait to be inserted
+	config.AsyncWorkers, err = GetEnvInt("AUDIT_LOG_ASYNC_WORKERS", api.DefaultAsyncWorkers)
+	if err == nil && config.AsyncWorkers < 1 {
+		err = fmt.Errorf("The AUDIT_LOG_ASYNC_WORKERS environment variable must be at least 1")
+	}
+	if err != nil {
+		return config, err
+	}
+
+	config.AsyncQueueSize, err = GetEnvInt("AUDIT_LOG_ASYNC_QUEUE_SIZE", api.DefaultAsyncQueueSize)
+	if err == nil && config.AsyncQueueSize < 1 {
+		err = fmt.Errorf("The AUDIT_LOG_ASYNC_QUEUE_SIZE environment variable must be at least 1")
+	}
+	if err != nil {
+		return config, err
+	}
+
+	// get whether events are added without being validated against the schema
+	config.DisableValidation, err = GetEnvBool("AUDIT_LOG_DISABLE_VALIDATION", false)
+	if err != nil {
+		return config, err
+	}
+
+	// get whether events can be changed or deleted once they are added
+	config.AppendOnly, err = GetEnvBool("AUDIT_LOG_APPEND_ONLY", true)
+	if err != nil {
+		return config, err
+	}
+
+	// get whether events are decoded into the Event struct before they are added
+	config.TypedEvents, err = GetEnvBool("AUDIT_LOG_TYPED_EVENTS", false)
+	if err != nil {
+		return config, err
+	}
+
+	// get how added events are given an id and the field it is stored in
+	config.IdStrategy = os.Getenv("AUDIT_LOG_ID_STRATEGY")
+	if len(config.IdStrategy) == 0 {
+		config.IdStrategy = api.IdStrategyObjectId
+	}
+	if !containsString(api.IdStrategies, config.IdStrategy) {
+		return config, fmt.Errorf("The AUDIT_LOG_ID_STRATEGY environment variable must be one of %s", strings.Join(api.IdStrategies, ", "))
+	}
+	config.IdField = os.Getenv("AUDIT_LOG_ID_FIELD")
+	if len(config.IdField) == 0 {
+		config.IdField = api.DefaultIdField
+	}
+
+	// get how long events are kept before the database deletes them
+	// events are kept forever if it is not set
+	config.RetentionSeconds, err = GetEnvInt("AUDIT_LOG_RETENTION_SECONDS", 0)
+	if err != nil {
+		return config, err
+	}
+	if config.RetentionSeconds > math.MaxInt32 {
+		return config, fmt.Errorf("The AUDIT_LOG_RETENTION_SECONDS environment variable must be at most %d", math.MaxInt32)
+	}
+
+	// get the write ahead log file and how often it is drained into the db
+	config.WalPath = os.Getenv("AUDIT_LOG_WAL_PATH")
+	var walDrainInterval time.Duration
+	walDrainInterval, err = GetEnvDuration("AUDIT_LOG_WAL_DRAIN_INTERVAL", api.DefaultWalDrainInterval)
+	if err != nil {
+		return config, err
+	}
+	config.WalDrainInterval = Duration(walDrainInterval)
+
+	// get the size limits of individual event fields
+	config.FieldMaxBytes, err = parseFieldLimits(os.Getenv("AUDIT_LOG_FIELD_MAX_BYTES"))
+	if err != nil {
+		return config, err
+	}
+	config.DefaultFieldMaxBytes, err = GetEnvInt("AUDIT_LOG_DEFAULT_FIELD_MAX_BYTES", 0)
+	if err != nil {
+		return config, err
+	}
+
+	// get the secondary destinations added events are also written to
+	// the collection is in the same db as the events collection
+	config.SinkCollection = os.Getenv("AUDIT_LOG_SINK_COLLECTION")
+	config.SinkFile = os.Getenv("AUDIT_LOG_SINK_FILE")
+
+	// get how many events are written to the sinks at once, how many can wait and how long each write can take
+	config.SinkWorkers, err = GetEnvInt("AUDIT_LOG_SINK_WORKERS", api.DefaultSinkWorkers)
+	if err == nil && config.SinkWorkers < 1 {
+		err = fmt.Errorf("The AUDIT_LOG_SINK_WORKERS environment variable must be at least 1")
+	}
+	if err != nil {
+		return config, err
+	}
+
+	config.SinkQueueSize, err = GetEnvInt("AUDIT_LOG_SINK_QUEUE_SIZE", api.DefaultSinkQueueSize)
+	if err == nil && config.SinkQueueSize < 1 {
+		err = fmt.Errorf("The AUDIT_LOG_SINK_QUEUE_SIZE environment variable must be at least 1")
+	}
+	if err != nil {
+		return config, err
+	}
+
+	var sinkTimeout time.Duration
+	sinkTimeout, err = GetEnvDuration("AUDIT_LOG_SINK_TIMEOUT", api.DefaultSinkTimeout)
+	if err == nil && sinkTimeout <= 0 {
+		err = fmt.Errorf("The AUDIT_LOG_SINK_TIMEOUT environment variable must be a positive duration")
+	}
+	if err != nil {
+		return config, err
+	}
+	config.SinkTimeout = Duration(sinkTimeout)
+
+	// get the event fields that are encrypted before they are stored and the base64 encoded AES key used
+	config.EncryptedFields = GetEnvList("AUDIT_LOG_ENCRYPTED_FIELDS")
+	config.EncryptionKey = os.Getenv("AUDIT_LOG_ENCRYPTION_KEY")
+	// a key that is set is always checked so a malformed key is found at startup rather than when it is first used
+	if len(config.EncryptedFields) != 0 || len(config.EncryptionKey) != 0 {
+		var key, keyErr = base64.StdEncoding.DecodeString(config.EncryptionKey)
+		if keyErr != nil || (len(key) != 16 && len(key) != 24 && len(key) != 32) {
+			return config, fmt.Errorf("The AUDIT_LOG_ENCRYPTION_KEY environment variable must be a base64 encoded 16, 24 or 32 byte key")
+		}
+	}
+	config.DeterministicEncrypt, err = GetEnvBool("AUDIT_LOG_DETERMINISTIC_ENCRYPTION", false)
+	if err != nil {
+		return config, err
+	}
+	// the names of the tokens (api, ingest or query) whose queries see decrypted values
+	config.DecryptTokens = GetEnvList("AUDIT_LOG_DECRYPT_TOKENS")
+
+	// get the limits applied to request headers
+	// max header bytes limits the total size of the headers and is enforced by the http server
+	// the header count and value size limits are enforced by a middleware
+	config.MaxHeaderBytes, err = GetEnvInt("AUDIT_LOG_MAX_HEADER_BYTES", http.DefaultMaxHeaderBytes)
+	if err != nil {
+		return config, err
+	}
+	config.MaxHeaders, err = GetEnvInt("AUDIT_LOG_MAX_HEADERS", 100)
+	if err != nil {
+		return config, err
+	}
+	config.MaxHeaderValueBytes, err = GetEnvInt("AUDIT_LOG_MAX_HEADER_VALUE_BYTES", 8192)
+	if err != nil {
+		return config, err
+	}
+
+	// get the level used to compress responses
+	// 0 turns compression off and 1 (fastest) to 9 (best compression) are the gzip levels
+	// responses are not compressed by default
+	config.GzipLevel, err = GetEnvInt("AUDIT_LOG_GZIP_LEVEL", gzip.NoCompression)
+	if err != nil || config.GzipLevel > gzip.BestCompression {
+		return config, fmt.Errorf("The AUDIT_LOG_GZIP_LEVEL environment variable must be between %d and %d", gzip.NoCompression, gzip.BestCompression)
+	}
+
+	// get how deep the readiness check should go
+	// ping only checks the db is reachable and write also verifies the db accepts writes
+	config.ReadinessCheck = os.Getenv("AUDIT_LOG_READINESS_CHECK")
+	if len(config.ReadinessCheck) == 0 {
+		config.ReadinessCheck = "ping"
+	}
+	if config.ReadinessCheck != "ping" && config.ReadinessCheck != "write" {
+		return config, fmt.Errorf("The AUDIT_LOG_READINESS_CHECK environment variable must be either ping or write")
+	}
+
+	// get if requests for the root path get the service info
+	config.DisableRootEndpoint, err = GetEnvBool("AUDIT_LOG_DISABLE_ROOT_ENDPOINT", false)
+	if err != nil {
+		return config, err
+	}
+
+	// get how many times the startup sequence is attempted before giving up
+	// and how long to wait between attempts
+	config.StartupAttempts, err = GetEnvInt("AUDIT_LOG_STARTUP_ATTEMPTS", 1)
+	if err != nil {
+		return config, err
+	}
+	var startupRetryDelay time.Duration
+	startupRetryDelay, err = GetEnvDuration("AUDIT_LOG_STARTUP_RETRY_DELAY", 5*time.Second)
+	if err != nil {
+		return config, err
+	}
+	config.StartupRetryDelay = Duration(startupRetryDelay)
+
+	// get the request attributes and headers included in request logs
+	// by default only the method and path are logged since the query may contain sensitive values
+	config.LogFields = GetEnvList("AUDIT_LOG_LOG_FIELDS")
+	if len(config.LogFields) == 0 {
+		config.LogFields = mux.DefaultLogFields
+	}
+	for _, field := range config.LogFields {
+		if !containsString(mux.LogFields, field) {
+			return config, fmt.Errorf("The AUDIT_LOG_LOG_FIELDS environment variable contains an unknown field %s. Valid fields are %s", field, strings.Join(mux.LogFields, ", "))
+		}
+	}
+	config.LogHeaders = GetEnvList("AUDIT_LOG_LOG_HEADERS")
+
+	// get how long a request can take before it is logged as slow
+	// slow requests are not logged by default
+	var slowRequestThreshold time.Duration
+	slowRequestThreshold, err = GetEnvDuration("AUDIT_LOG_SLOW_REQUEST_THRESHOLD", 0)
+	if err != nil {
+		return config, err
+	}
+	config.SlowRequestThreshold = Duration(slowRequestThreshold)
+
+	// get the fraction of successful requests that are logged
+	// failed and slow requests are always logged and every request is logged by default
+	config.LogSampleRate, err = GetEnvFraction("AUDIT_LOG_LOG_SAMPLE_RATE", 0)
+	if err != nil {
+		return config, err
+	}
+	config.LogSampleHeader = os.Getenv("AUDIT_LOG_LOG_SAMPLE_HEADER")
+
+	// get how each request is logged
+	config.LogFormat = os.Getenv("AUDIT_LOG_LOG_FORMAT")
+	if len(config.LogFormat) == 0 {
+		config.LogFormat = mux.LogFormatText
+	}
+	if !containsString(mux.LogFormats, config.LogFormat) {
+		return config, fmt.Errorf("The AUDIT_LOG_LOG_FORMAT environment variable must be one of %s", strings.Join(mux.LogFormats, ", "))
+	}
+
+	// get the headers added to every response
+	// the value is a json object since header values can contain commas (i.e. {"Cache-Control":"no-store, private"})
+	config.ResponseHeaders = make(map[string]string)
+	var responseHeaders = os.Getenv("AUDIT_LOG_RESPONSE_HEADERS")
+	if len(responseHeaders) > 0 {
+		err = json.Unmarshal([]byte(responseHeaders), &config.ResponseHeaders)
+		if err != nil {
+			return config, fmt.Errorf("The AUDIT_LOG_RESPONSE_HEADERS environment variable must be a json object of header names to values")
+		}
+	}
+	config.OverrideResponseHeaders, err = GetEnvBool("AUDIT_LOG_RESPONSE_HEADERS_OVERRIDE", false)
+	if err != nil {
+		return config, err
+	}
+
+	// get how many requests per second each client can make
+	// clients are not limited if it is 0
+	config.RateLimit, err = GetEnvInt("AUDIT_LOG_RATE_LIMIT", 0)
+	if err != nil {
+		return config, err
+	}
+	// the burst defaults to one second of requests
+	config.RateLimitBurst, err = GetEnvInt("AUDIT_LOG_RATE_LIMIT_BURST", config.RateLimit)
+	if err != nil {
+		return config, err
+	}
+
+	// get the origins browsers can call the service from and what their requests can use
+	// cross origin requests are not allowed if there are no origins
+	config.CorsOrigins = GetEnvList("AUDIT_LOG_CORS_ORIGINS")
+	config.CorsMethods = GetEnvList("AUDIT_LOG_CORS_METHODS")
+	if len(config.CorsMethods) == 0 {
+		config.CorsMethods = []string{http.MethodGet, http.MethodPost, http.MethodDelete}
+	}
+	config.CorsHeaders = GetEnvList("AUDIT_LOG_CORS_HEADERS")
+	if len(config.CorsHeaders) == 0 {
+		config.CorsHeaders = []string{"Authorization", "Content-Type"}
+	}
+
+	// get the tokens that are limited to reading or writing
+	// the value is a json object of tokens to scopes (i.e. {"<token>":"read","<other token>":"write"})
+	var tokenScopes = os.Getenv("AUDIT_LOG_TOKEN_SCOPES")
+	if len(tokenScopes) > 0 {
+		err = json.Unmarshal([]byte(tokenScopes), &config.TokenScopes)
+		if err != nil {
+			return config, fmt.Errorf("The AUDIT_LOG_TOKEN_SCOPES environment variable must be a json object of tokens to scopes")
+		}
+	}
+	for token, scope := range config.TokenScopes {
+		if len(token) == 0 {
+			return config, fmt.Errorf("The AUDIT_LOG_TOKEN_SCOPES environment variable contains an empty token")
+		}
+
+		_, err = mux.ScopedToken(token, scope)
+		if err != nil {
+			return config, fmt.Errorf("The AUDIT_LOG_TOKEN_SCOPES environment variable is not valid: %s", err)
+		}
+	}
+
+	return config, nil
+}
+
+// parse a comma separated list of field:bytes pairs (i.e. attributes.message:65536,summary:1024)
+// into a map of field path to the largest size the field can be
+func parseFieldLimits(spec string) (map[string]int, error) {
+	var limits = make(map[string]int)
+
+	for _, pair := range strings.Split(spec, ",") {
+		if len(pair) == 0 {
+			continue
+		}
+
+		// the bytes come after the last colon
+		var separatorIndex = strings.LastIndex(pair, ":")
+		var limit, err = strconv.Atoi(pair[separatorIndex+1:])
+		if separatorIndex <= 0 || err != nil || limit <= 0 {
+			return nil, fmt.Errorf("The AUDIT_LOG_FIELD_MAX_BYTES environment variable entry %q must be in the format field:bytes", pair)
+		}
+
+		limits[pair[:separatorIndex]] = limit
+	}
+
+	return limits, nil
+}
+
+// check if a list of strings contains a value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// create a copy of the configuration with all of the secrets replaced
+// so it is safe to show to users
+func (self Config) Redacted() Config {
+	var redact = func(value string) string {
+		if len(value) == 0 {
+			return value
+		}
+
+		return redactedValue
+	}
+
+	self.ApiToken = redact(self.ApiToken)
+	// the list is copied so the tokens in the original config are left as they are
+	var apiTokens []string
+	for _, token := range self.ApiTokens {
+		apiTokens = append(apiTokens, redact(token))
+	}
+	self.ApiTokens = apiTokens
+	// the tokens are the keys so each one is replaced with a numbered placeholder to keep the scopes visible
+	if self.TokenScopes != nil {
+		var tokens = make([]string, 0, len(self.TokenScopes))
+		for token := range self.TokenScopes {
+			tokens = append(tokens, token)
+		}
+		sort.Strings(tokens)
+
+		var tokenScopes = make(map[string]string, len(tokens))
+		for i, token := range tokens {
+			tokenScopes[fmt.Sprintf("%s_%d", redactedValue, i+1)] = self.TokenScopes[token]
+		}
+		self.TokenScopes = tokenScopes
+	}
+	self.IngestToken = redact(self.IngestToken)
+	self.QueryToken = redact(self.QueryToken)
+	self.AdminToken = redact(self.AdminToken)
+	self.DbPassword = redact(self.DbPassword)
+	self.TlsKey = redact(self.TlsKey)
+	self.EncryptionKey = redact(self.EncryptionKey)
+
+	return self
+}
+
+// get warnings about settings that are valid but probably not what the operator intended
+func (self Config) Warnings() []string {
+	var warnings []string
+
+	// the schema is still read (i.e. for the csv columns) so it is easy to miss that it is not used for validation
+	if self.DisableValidation && len(self.SchemaFilePath) != 0 {
+		warnings = append(warnings, fmt.Sprintf("Validation is disabled so events will be added without being checked against the schema in %s", self.SchemaFilePath))
+	}
+
+	if !self.authenticated() {
+		warnings = append(warnings, "No token was provided so requests are not authenticated")
+	} else if len(self.IdentityHeader) != 0 {
+		// a caller with a token could otherwise claim to be someone else
+		warnings = append(warnings, fmt.Sprintf("The %s identity header is ignored since requests are authenticated with a token", self.IdentityHeader))
+	}
+
+	// encrypted values are only decrypted for the tokens that are listed
+	if len(self.EncryptedFields) != 0 && len(self.DecryptTokens) == 0 {
+		warnings = append(warnings, "No decrypt tokens were provided so encrypted fields are not decrypted for any caller")
+	}
+
+	return warnings
+}
+
+// check if requests are authenticated because at least one token was provided
+func (self Config) authenticated() bool {
+	return len(self.ApiToken) != 0 || len(self.ApiTokens) != 0 || len(self.IngestToken) != 0 ||
+		len(self.QueryToken) != 0 || len(self.TokenScopes) != 0
+}
+
+// ConfigHandler creates an http handler that shows the configuration the service is running with
+// secrets are redacted
+func ConfigHandler(config Config) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		mux.WriteJsonResponse(writer, config.Redacted())
+	})
+}
+
+// get a boolean value from an environment variable
+// the default value is used if the variable is not set
+func GetEnvBool(name string, defaultValue bool) (bool, error) {
+	var valueString = os.Getenv(name)
+	if len(valueString) == 0 {
+		return defaultValue, nil
+	}
+
+	var value, err = strconv.ParseBool(valueString)
+	if err != nil {
+		return defaultValue, fmt.Errorf("The %s environment variable must be a boolean value: %s", name, err)
+	}
+
+	return value, err
+}
+
+// get a comma separated list of values from an environment variable
+// whitespace around the values is removed and empty values are ignored
+func GetEnvList(name string) []string {
+	var values []string
+
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		value = strings.TrimSpace(value)
+		if len(value) != 0 {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// get a non negative integer value from an environment variable
+// the default value is used if the variable is not set
+func GetEnvInt(name string, defaultValue int64) (int64, error) {
+	var valueString = os.Getenv(name)
+	if len(valueString) == 0 {
+		return defaultValue, nil
+	}
+
+	var value, err = strconv.ParseInt(valueString, 10, 64)
+	if err != nil || value < 0 {
+		return defaultValue, fmt.Errorf("The %s environment variable must be a non negative integer value", name)
+	}
+
+	return value, nil
+}
+
+// get a value between 0 and 1 (i.e. 0.25) from an environment variable
+// the default value is used if the variable is not set
+func GetEnvFraction(name string, defaultValue float64) (float64, error) {
+	var valueString = os.Getenv(name)
+	if len(valueString) == 0 {
+		return defaultValue, nil
+	}
+
+	var value, err = strconv.ParseFloat(valueString, 64)
+	if err != nil || value < 0 || value > 1 {
+		return defaultValue, fmt.Errorf("The %s environment variable must be a number between 0 and 1", name)
+	}
+
+	return value, nil
+}
+
+// get a positive duration value (i.e. 10s) from an environment variable
+// the default value is used if the variable is not set
+func GetEnvDuration(name string, defaultValue time.Duration) (time.Duration, error) {
+	var valueString = os.Getenv(name)
+	if len(valueString) == 0 {
+		return defaultValue, nil
+	}
+
+	var value, err = time.ParseDuration(valueString)
+	if err != nil || value <= 0 {
+		return defaultValue, fmt.Errorf("The %s environment variable must be a positive duration (i.e. 10s)", name)
+	}
+
+	return value, nil
+}
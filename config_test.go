@@ -0,0 +1,246 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellkelly/auditlog/api"
+)
+
+func TestGetEnvDurationInvalidValues(t *testing.T) {
+	for _, value := range []string{"ten", "-1s", "0s"} {
+		t.Setenv("AUDIT_LOG_TEST_DURATION", value)
+
+		var _, err = GetEnvDuration("AUDIT_LOG_TEST_DURATION", time.Second)
+		if err == nil {
+			t.Errorf("An invalid duration %q did not result in an error", value)
+		}
+	}
+}
+
+func TestGetEnvFractionInvalidValues(t *testing.T) {
+	for _, value := range []string{"half", "-0.1", "1.5"} {
+		t.Setenv("AUDIT_LOG_TEST_FRACTION", value)
+
+		var _, err = GetEnvFraction("AUDIT_LOG_TEST_FRACTION", 0)
+		if err == nil {
+			t.Errorf("An invalid fraction %q did not result in an error", value)
+		}
+	}
+}
+
+func TestConfigHandlerRedactsSecrets(t *testing.T) {
+	var config = Config{
+		ApiToken:    "bhakrswqtqnspfqbclzn",
+		ApiTokens:   []string{"zlxkcjvhbgnfmdpwoqie"},
+		TokenScopes: map[string]string{"pqowieurytalskdjfhgz": "read"},
+		AdminToken:  "qwmzkhdlqpwoeirutyal",
+		DbUsername:  "auditlog",
+		DbPassword:  "hunter2",
+		TlsKey:      "/etc/auditlog/tls.key",
+		DbHost:      "mongo-db",
+	}
+
+	var writer = httptest.NewRecorder()
+	ConfigHandler(config).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+
+	if writer.Code != http.StatusOK {
+		t.Fatalf("An unexpected status code was returned when requesting the config Expected: %d, Got: %d", http.StatusOK, writer.Code)
+	}
+
+	var body = writer.Body.String()
+	for _, secret := range []string{config.ApiToken, config.ApiTokens[0], "pqowieurytalskdjfhgz", config.AdminToken, config.DbPassword, config.TlsKey} {
+		if strings.Contains(body, secret) {
+			t.Errorf("A secret was not redacted from the config response: %s", body)
+		}
+	}
+
+	// values that are not secrets should still be shown
+	for _, value := range []string{config.DbUsername, config.DbHost} {
+		if !strings.Contains(body, value) {
+			t.Errorf("The config response is missing the value %s: %s", value, body)
+		}
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	t.Setenv("AUDIT_LOG_API_TOKEN", "bhakrswqtqnspfqbclzn")
+	t.Setenv("AUDIT_LOG_EVENT_SCHEMA_FILE", "resources/events_schema.json")
+
+	var config, err = LoadConfig("", false)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while loading the config: %s", err)
+	}
+
+	if config.ServerPort != "80" || config.DbHost != "localhost" || config.DbPort != "27017" {
+		t.Errorf("The default config values were not applied Got: %+v", config)
+	}
+
+	if time.Duration(config.DbServerSelectionTimeout) != 30*time.Second {
+		t.Errorf("The default server selection timeout was not applied Got: %s", time.Duration(config.DbServerSelectionTimeout))
+	}
+
+	if !config.AppendOnly {
+		t.Error("The audit log is not append only by default")
+	}
+
+	if config.GzipLevel != 0 {
+		t.Errorf("Responses are compressed by default Got: %d", config.GzipLevel)
+	}
+
+	if config.AsyncWorkers != api.DefaultAsyncWorkers || config.AsyncQueueSize != api.DefaultAsyncQueueSize {
+		t.Errorf("The default async insert limits were not applied Got: %d workers and a queue of %d", config.AsyncWorkers, config.AsyncQueueSize)
+	}
+
+	if config.SinkWorkers != api.DefaultSinkWorkers || config.SinkQueueSize != api.DefaultSinkQueueSize || time.Duration(config.SinkTimeout) != api.DefaultSinkTimeout {
+		t.Errorf("The default sink limits were not applied Got: %d workers, a queue of %d and a timeout of %s",
+			config.SinkWorkers, config.SinkQueueSize, time.Duration(config.SinkTimeout))
+	}
+}
+
+func TestLoadConfigMissingApiToken(t *testing.T) {
+	t.Setenv("AUDIT_LOG_API_TOKEN", "")
+	t.Setenv("AUDIT_LOG_API_TOKENS", "")
+	t.Setenv("AUDIT_LOG_EVENT_SCHEMA_FILE", "resources/events_schema.json")
+
+	// requests are not authenticated without a token
+	var config, err = LoadConfig("", false)
+	if err != nil {
+		t.Fatalf("Loading the config without an api token resulted in an error: %s", err)
+	}
+
+	var warnings = config.Warnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "not authenticated") {
+		t.Errorf("Expected a warning that requests are not authenticated but got %q", warnings)
+	}
+}
+
+func TestLoadConfigOnlyIngestToken(t *testing.T) {
+	t.Setenv("AUDIT_LOG_API_TOKEN", "")
+	t.Setenv("AUDIT_LOG_API_TOKENS", "")
+	t.Setenv("AUDIT_LOG_INGEST_TOKEN", "ingestwqtqnspfqbclzn")
+	t.Setenv("AUDIT_LOG_EVENT_SCHEMA_FILE", "resources/events_schema.json")
+
+	var config, err = LoadConfig("", false)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while loading the config: %s", err)
+	}
+
+	// the ingest token turns authentication on even without an api token
+	for _, warning := range config.Warnings() {
+		if strings.Contains(warning, "not authenticated") {
+			t.Errorf("An ingest token was provided but requests were reported as not authenticated")
+		}
+	}
+}
+
+func TestLoadConfigEncryptionKey(t *testing.T) {
+	t.Setenv("AUDIT_LOG_EVENT_SCHEMA_FILE", "resources/events_schema.json")
+
+	// a malformed key fails at startup even before any field is encrypted with it
+	for _, fields := range []string{"attributes.customer_name", ""} {
+		t.Setenv("AUDIT_LOG_ENCRYPTED_FIELDS", fields)
+		t.Setenv("AUDIT_LOG_ENCRYPTION_KEY", "not base64!")
+
+		var _, err = LoadConfig("", false)
+		if err == nil {
+			t.Errorf("A malformed encryption key did not result in an error with encrypted fields %q", fields)
+		}
+	}
+
+	t.Setenv("AUDIT_LOG_ENCRYPTED_FIELDS", "attributes.customer_name")
+	t.Setenv("AUDIT_LOG_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+
+	var config, err = LoadConfig("", false)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while loading the config: %s", err)
+	}
+
+	var warned = false
+	for _, warning := range config.Warnings() {
+		warned = warned || strings.Contains(warning, "not decrypted")
+	}
+	if !warned {
+		t.Errorf("Expected a warning that encrypted fields are not decrypted without decrypt tokens but got %q", config.Warnings())
+	}
+}
+
+func TestLoadConfigIdentityHeader(t *testing.T) {
+	t.Setenv("AUDIT_LOG_API_TOKEN", "")
+	t.Setenv("AUDIT_LOG_EVENT_SCHEMA_FILE", "resources/events_schema.json")
+	t.Setenv("AUDIT_LOG_IDENTITY_HEADER", "X-Forwarded-User")
+
+	var config, err = LoadConfig("", false)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while loading the config: %s", err)
+	}
+
+	if config.IdentityHeader != "X-Forwarded-User" {
+		t.Errorf("The identity header was not loaded Got: %s", config.IdentityHeader)
+	}
+
+	// the header is ignored when requests are authenticated with a token
+	t.Setenv("AUDIT_LOG_API_TOKEN", "bhakrswqtqnspfqbclzn")
+	config, err = LoadConfig("", false)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while loading the config: %s", err)
+	}
+
+	var warnings = config.Warnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "X-Forwarded-User") {
+		t.Errorf("Expected a warning that the identity header is ignored but got %q", warnings)
+	}
+}
+
+func TestLoadConfigInvalidTokenScopes(t *testing.T) {
+	t.Setenv("AUDIT_LOG_API_TOKEN", "bhakrswqtqnspfqbclzn")
+	t.Setenv("AUDIT_LOG_EVENT_SCHEMA_FILE", "resources/events_schema.json")
+
+	for _, tokenScopes := range []string{`{"pqowieurytalskdjfhgz":"admin"}`, `{"":"read"}`, `["read"]`} {
+		t.Setenv("AUDIT_LOG_TOKEN_SCOPES", tokenScopes)
+
+		var _, err = LoadConfig("", false)
+		if err == nil {
+			t.Errorf("Loading the config with the token scopes %s did not result in an error", tokenScopes)
+		}
+	}
+}
+
+func TestLoadConfigUnknownLogField(t *testing.T) {
+	t.Setenv("AUDIT_LOG_API_TOKEN", "bhakrswqtqnspfqbclzn")
+	t.Setenv("AUDIT_LOG_EVENT_SCHEMA_FILE", "resources/events_schema.json")
+	t.Setenv("AUDIT_LOG_LOG_FIELDS", "method, url")
+
+	var _, err = LoadConfig("", false)
+	if err == nil {
+		t.Error("Loading the config with an unknown log field did not result in an error")
+	}
+}
+
+func TestConfigWarningsValidationDisabledWithSchema(t *testing.T) {
+	t.Setenv("AUDIT_LOG_API_TOKEN", "bhakrswqtqnspfqbclzn")
+	t.Setenv("AUDIT_LOG_EVENT_SCHEMA_FILE", "resources/events_schema.json")
+
+	var config, err = LoadConfig("", false)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while loading the config: %s", err)
+	}
+
+	if len(config.Warnings()) != 0 {
+		t.Errorf("Expected no warnings with validation enabled but got %q", config.Warnings())
+	}
+
+	t.Setenv("AUDIT_LOG_DISABLE_VALIDATION", "true")
+	config, err = LoadConfig("", false)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while loading the config: %s", err)
+	}
+
+	var warnings = config.Warnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "resources/events_schema.json") {
+		t.Errorf("Expected a warning naming the unused schema but got %q", warnings)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Event has the fields of the events described by the default event schema (resources/events_schema.json)
+// events are decoded into it before they are added when typed events are enabled so the timestamp
+// is stored as a 64 bit integer instead of a float that can not hold every nanosecond
+// deployments that change the schema need to change this struct to match
+type Event struct {
+	Timestamp  EventTimestamp         `json:"timestamp" bson:"timestamp"`
+	Summary    string                 `json:"summary" bson:"summary"`
+	Source     map[string]interface{} `json:"source" bson:"source"`
+	Attributes map[string]interface{} `json:"attributes" bson:"attributes"`
+}
+
+// EventTimestamp is the nanoseconds since the Unix epoch of an event
+// it is stored as a 64 bit integer and accepts every number the schema allows for the timestamp
+// including ones written with an exponent (i.e. 1.649445988e18)
+// a fraction of a nanosecond is rounded to the nearest nanosecond
+// the schema maximum is the largest timestamp that fits so larger values are only seen without validation
+type EventTimestamp int64
+
+func (self *EventTimestamp) UnmarshalJSON(d []byte) error {
+	var value, err = strconv.ParseInt(string(d), 10, 64)
+	if err == nil {
+		*self = EventTimestamp(value)
+		return nil
+	}
+
+	// the exact value is used rather than a float so large timestamps are not rounded to the nearest float
+	var number, _, parseErr = big.ParseFloat(string(d), 10, 256, big.ToNearestEven)
+	if parseErr != nil {
+		return fmt.Errorf("The timestamp must be a number")
+	}
+
+	// Int truncates so half a nanosecond is added first to round to the nearest nanosecond
+	var half = big.NewFloat(0.5)
+	if number.Sign() < 0 {
+		half.Neg(half)
+	}
+
+	var integer, _ = number.Add(number, half).Int(nil)
+	if !integer.IsInt64() {
+		return fmt.Errorf("The timestamp must fit in a 64 bit integer")
+	}
+
+	*self = EventTimestamp(integer.Int64())
+
+	return nil
+}
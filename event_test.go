@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+func TestEventTimestampUnmarshal(t *testing.T) {
+	var tests = []struct {
+		json     string
+		expected EventTimestamp
+	}{
+		{"1649445988123456789", 1649445988123456789},
+		// numbers the schema allows that are not written as plain integers
+		{"1e18", 1000000000000000000},
+		{"1.649445988123456789e18", 1649445988123456789},
+		{"1.5", 2},
+		{"1649445988123456789.0", 1649445988123456789},
+	}
+
+	for _, test := range tests {
+		var timestamp EventTimestamp
+		var err = json.Unmarshal([]byte(test.json), &timestamp)
+		if err != nil || timestamp != test.expected {
+			t.Errorf("An unexpected timestamp was decoded from %s Expected: %d, Got: %d, %v", test.json, test.expected, timestamp, err)
+		}
+	}
+
+	for _, invalid := range []string{`"yesterday"`, "1e19"} {
+		var timestamp EventTimestamp
+		var err = json.Unmarshal([]byte(invalid), &timestamp)
+		if err == nil {
+			t.Errorf("The invalid timestamp %s was decoded as %d", invalid, timestamp)
+		}
+	}
+}
+
+func TestEventTimestampStoredAsInt64(t *testing.T) {
+	var d, err = bson.Marshal(Event{Timestamp: 1649445988123456789})
+	if err != nil {
+		t.Fatalf("An unexpected error occured while encoding the event: %s", err)
+	}
+
+	var timestamp = bson.Raw(d).Lookup("timestamp")
+	if timestamp.Type != bsontype.Int64 || timestamp.Int64() != 1649445988123456789 {
+		t.Errorf("Expected the timestamp to be stored as the int64 1649445988123456789 but got %s", timestamp)
+	}
+}
@@ -5,11 +5,13 @@ go 1.18
 require (
 	github.com/qri-io/jsonschema v0.2.1
 	go.mongodb.org/mongo-driver v1.9.0
+	golang.org/x/time v0.5.0
 )
 
 require (
 	github.com/go-stack/stack v1.8.0 // indirect
 	github.com/golang/snappy v0.0.1 // indirect
+	github.com/google/go-cmp v0.5.2 // indirect
 	github.com/klauspost/compress v1.13.6 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/qri-io/jsonpointer v0.1.1 // indirect
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mitchellkelly/auditlog/mux"
+)
+
+// Listener is an http server along with the function used to start it
+type Listener struct {
+	Server *http.Server
+	// starts the server (i.e. Server.ListenAndServe)
+	Serve func() error
+}
+
+// RunListeners starts every listener and waits until one of them stops or a signal is received
+// the rest of the listeners are then shut down gracefully so they always stop together
+// the error from the listener that stopped first is returned
+// http.ErrServerClosed is returned if the listeners were stopped by a signal
+func RunListeners(listeners []Listener, signals <-chan os.Signal, shutdownTimeout time.Duration) error {
+	// buffered so the listener routines can always exit
+	var serveErrors = make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener Listener) {
+			serveErrors <- listener.Serve()
+		}(listener)
+	}
+
+	var err error
+	select {
+	case err = <-serveErrors:
+	case <-signals:
+		err = http.ErrServerClosed
+	}
+
+	// give in flight requests a chance to finish before the listeners are closed
+	var timedContext, timedContextCancel = context.WithTimeout(context.Background(), shutdownTimeout)
+	defer timedContextCancel()
+
+	for _, listener := range listeners {
+		listener.Server.Shutdown(timedContext)
+	}
+
+	return err
+}
+
+// NewListenerHandlers splits the service routes between the public and admin listeners
+// if an admin address is not provided every route is served by the public listener and the admin handler is nil
+// otherwise the internal routes (health, admin, etc.) are only served by the admin listener
+// and the public listener only serves the data api
+func NewListenerHandlers(adminAddress string, apiHandler http.Handler, internalRoutes map[string]http.Handler) (http.Handler, http.Handler) {
+	// create a multiplexer for the internal endpoints
+	// they do not use the api token
+	var internalMultiplexer = http.NewServeMux()
+	for pattern, handler := range internalRoutes {
+		internalMultiplexer.Handle(pattern, handler)
+	}
+
+	if len(adminAddress) == 0 {
+		// every other request is passed on to the data api
+		internalMultiplexer.Handle("/", apiHandler)
+
+		return internalMultiplexer, nil
+	}
+
+	return apiHandler, internalMultiplexer
+}
+
+// AddPrivateRoutes adds the internal routes that expose details about the service (i.e. metrics)
+// to the internal routes
+// they are served without authentication on the admin listener since it is kept off the public network
+// without an admin listener they would be served by the public listener so they require the admin token
+// and they are not served at all if there is no admin token either
+func AddPrivateRoutes(internalRoutes map[string]http.Handler, privateRoutes map[string]http.Handler, adminAddress string, adminToken string) {
+	for pattern, handler := range privateRoutes {
+		if len(adminAddress) != 0 {
+			internalRoutes[pattern] = handler
+		} else if len(adminToken) != 0 {
+			internalRoutes[pattern] = mux.AuthenticationMiddleware{
+				Token:   adminToken,
+				Handler: handler,
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// handler that responds with a fixed status code so tests can tell which handler served a request
+func statusHandler(statusCode int) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(statusCode)
+	})
+}
+
+// the status code returned by the data api in the listener tests
+var testApiStatusCode = http.StatusUnauthorized
+
+var testInternalRoutes = map[string]http.Handler{
+	"/admin/": statusHandler(http.StatusOK),
+}
+
+func getStatusCode(t *testing.T, url string) int {
+	var response, err = http.Get(url)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while making a request to %s: %s", url, err)
+	}
+	response.Body.Close()
+
+	return response.StatusCode
+}
+
+func TestNewListenerHandlersAdminListener(t *testing.T) {
+	var publicHandler, adminHandler = NewListenerHandlers("127.0.0.1:9090", statusHandler(testApiStatusCode), testInternalRoutes)
+	if adminHandler == nil {
+		t.Fatal("An admin handler was not created even though an admin address was provided")
+	}
+
+	var publicServer = httptest.NewServer(publicHandler)
+	defer publicServer.Close()
+	var adminServer = httptest.NewServer(adminHandler)
+	defer adminServer.Close()
+
+	var statusCode = getStatusCode(t, adminServer.URL+"/admin/config")
+	if statusCode != http.StatusOK {
+		t.Errorf("The admin route was not reachable on the admin listener Expected: %d, Got: %d", http.StatusOK, statusCode)
+	}
+
+	// the public listener passes the request on to the data api
+	statusCode = getStatusCode(t, publicServer.URL+"/admin/config")
+	if statusCode != testApiStatusCode {
+		t.Errorf("The admin route was reachable on the public listener Expected: %d, Got: %d", testApiStatusCode, statusCode)
+	}
+}
+
+func TestNewListenerHandlersSingleListener(t *testing.T) {
+	var publicHandler, adminHandler = NewListenerHandlers("", statusHandler(testApiStatusCode), testInternalRoutes)
+	if adminHandler != nil {
+		t.Fatal("An admin handler was created even though an admin address was not provided")
+	}
+
+	var publicServer = httptest.NewServer(publicHandler)
+	defer publicServer.Close()
+
+	var statusCode = getStatusCode(t, publicServer.URL+"/admin/config")
+	if statusCode != http.StatusOK {
+		t.Errorf("The admin route was not reachable on the public listener Expected: %d, Got: %d", http.StatusOK, statusCode)
+	}
+
+	statusCode = getStatusCode(t, publicServer.URL+"/events")
+	if statusCode != testApiStatusCode {
+		t.Errorf("The data api was not reachable on the public listener Expected: %d, Got: %d", testApiStatusCode, statusCode)
+	}
+}
+
+func TestRunListenersStopTogether(t *testing.T) {
+	var publicServer = &http.Server{Addr: "127.0.0.1:0"}
+	var adminServer = &http.Server{Addr: "127.0.0.1:0"}
+
+	var adminStopped = make(chan error, 1)
+	var listeners = []Listener{
+		{
+			Server: publicServer,
+			// the public listener fails right away
+			Serve: func() error {
+				return fmt.Errorf("address already in use")
+			},
+		},
+		{
+			Server: adminServer,
+			Serve: func() error {
+				var err = adminServer.ListenAndServe()
+				adminStopped <- err
+				return err
+			},
+		},
+	}
+
+	var err = RunListeners(listeners, make(chan os.Signal), time.Second)
+	if err == nil || err.Error() != "address already in use" {
+		t.Errorf("The error from the listener that stopped first was not returned Got: %v", err)
+	}
+
+	select {
+	case err = <-adminStopped:
+		if err != http.ErrServerClosed {
+			t.Errorf("The admin listener was not shut down gracefully Got: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("The admin listener was not stopped when the public listener stopped")
+	}
+}
+
+func TestRunListenersSignal(t *testing.T) {
+	var server = &http.Server{Addr: "127.0.0.1:0"}
+
+	var signals = make(chan os.Signal, 1)
+	signals <- os.Interrupt
+
+	var err = RunListeners([]Listener{{Server: server, Serve: server.ListenAndServe}}, signals, time.Second)
+	if err != http.ErrServerClosed {
+		t.Errorf("Receiving a signal did not shut down the listeners gracefully Got: %v", err)
+	}
+}
+
+func TestAddPrivateRoutes(t *testing.T) {
+	var privateRoutes = map[string]http.Handler{
+		"/metrics": statusHandler(http.StatusOK),
+	}
+
+	var tests = []struct {
+		adminAddress string
+		adminToken   string
+		token        string
+		expected     int
+	}{
+		// the admin listener is kept off the public network so no token is needed
+		{"127.0.0.1:9090", "", "", http.StatusOK},
+		// the public listener requires the admin token
+		{"", "adminwqtqnspfqbclzn", "", http.StatusUnauthorized},
+		{"", "adminwqtqnspfqbclzn", "adminwqtqnspfqbclzn", http.StatusOK},
+		// without either the route is passed on to the data api
+		{"", "", "", testApiStatusCode},
+	}
+
+	for _, test := range tests {
+		var internalRoutes = map[string]http.Handler{}
+		AddPrivateRoutes(internalRoutes, privateRoutes, test.adminAddress, test.adminToken)
+
+		var publicHandler, adminHandler = NewListenerHandlers(test.adminAddress, statusHandler(testApiStatusCode), internalRoutes)
+		var handler = publicHandler
+		if adminHandler != nil {
+			handler = adminHandler
+		}
+
+		var request = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if len(test.token) != 0 {
+			request.Header.Set("Authorization", "Bearer "+test.token)
+		}
+
+		var writer = httptest.NewRecorder()
+		handler.ServeHTTP(writer, request)
+
+		if writer.Code != test.expected {
+			t.Errorf("An unexpected status was returned for /metrics with admin address %q and admin token %q Expected: %d, Got: %d",
+				test.adminAddress, test.adminToken, test.expected, writer.Code)
+		}
+	}
+}
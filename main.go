@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"expvar"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/mitchellkelly/auditlog/api"
@@ -21,6 +25,54 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// name of the token that can only be used to add events
+const ingestTokenName = "ingest"
+
+// events must be sent as json so a body of the wrong kind (i.e. form data) is rejected before it is read
+var jsonMediaTypes = []string{"application/json"}
+
+// a stream of events is sent as newline delimited json
+var ndjsonMediaTypes = []string{"application/x-ndjson"}
+
+// the http methods that change or delete events
+var mutationMethods = []string{http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// error sent for requests that would change or delete events in append only mode
+// the 403 tells clients the request is turned off by the server rather than not supported (a 405)
+var appendOnlyError = mux.HttpError{
+	Code:        http.StatusForbidden,
+	Description: "The audit log is append only so events can not be changed or deleted",
+}
+
+// wrap an events handler so requests that would change or delete events get a 403 in append only mode
+// the handler is returned as is if append only mode is off
+func appendOnly(handler http.Handler, enabled bool) http.Handler {
+	if !enabled {
+		return handler
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if containsString(mutationMethods, request.Method) {
+			mux.WriteJsonResponse(writer, appendOnlyError)
+			return
+		}
+
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+// create a router for an endpoint that only accepts POST requests
+// the body has to have one of the media types or the request gets a 415
+func newPostRouter(mediaTypes []string, handler http.Handler) mux.MethodRouter {
+	var methodRouter = mux.NewMethodRouter()
+	methodRouter.Handle(http.MethodPost, mux.ContentTypeMiddleware{
+		MediaTypes: mediaTypes,
+		Handler:    handler,
+	})
+
+	return methodRouter
+}
+
 // read the json schema file and create a json schema object that can be used
 // to validate json data
 func ReadJsonSchema(schemaFilePath string) (jsonschema.Schema, error) {
@@ -42,35 +94,52 @@ func ReadJsonSchema(schemaFilePath string) (jsonschema.Schema, error) {
 	return eventJsonSchema, err
 }
 
-// use the database connection details to get the auditlog event collection
-func GetDbCollection(dbHost, dbPort, dbUsername, dbPassword string) (*mongo.Collection, error) {
-	var dbCredString string
-	// if either vaule is empty then we will leave the credential string empty
+// create the options used to connect to the db
+// the server selection and socket timeouts let the driver fail fast when the cluster is unhealthy
+// instead of stalling until the operation context times out
+func NewDbClientOptions(dbHost, dbPort, dbUsername, dbPassword string, serverSelectionTimeout, socketTimeout time.Duration) *options.ClientOptions {
+	// create an options object to use to supply options when creating the db
+	var dbConnectionString = fmt.Sprintf("mongodb://%s:%s", dbHost, dbPort)
+
+	var dbClientOptions = options.Client().
+		ApplyURI(dbConnectionString).
+		SetServerSelectionTimeout(serverSelectionTimeout).
+		SetSocketTimeout(socketTimeout)
+
+	// the credentials are set on their own rather than in the connection string
+	// so characters like @, : and / in them do not have to be escaped
+	// if either vaule is empty then we will not use credentials
 	if len(dbUsername) != 0 && len(dbPassword) != 0 {
-		dbCredString = fmt.Sprintf("%s@%s", dbUsername, dbPassword)
+		dbClientOptions.SetAuth(options.Credential{
+			Username: dbUsername,
+			Password: dbPassword,
+		})
 	}
 
-	// create an options object to use to supply options when creating the db
-	var dbConnectionString = fmt.Sprintf("mongodb://%s%s:%s", dbCredString, dbHost, dbPort)
-
-	var dbClientOptions = options.Client().ApplyURI(dbConnectionString)
+	return dbClientOptions
+}
 
+// use the database client options to get the auditlog event collection
+func GetDbCollection(dbClientOptions *options.ClientOptions) (*mongo.Collection, error) {
 	// create a timed context to use when making requests to the db
 	var timedContext, timedContextCancel = context.WithTimeout(context.Background(), 10*time.Second)
 
 	// connect to db
 	var dbClient, err = mongo.Connect(timedContext, dbClientOptions)
+	// cancel the timed context to release any resources associated with it
+	timedContextCancel()
 	if err != nil {
 		return nil, fmt.Errorf("An error occured while connecting to the database: %s", err)
 	}
-	// cancel the timed context to release any resources associated with it
-	timedContextCancel()
 
 	// create a new timed context to use to test the db connection
 	timedContext, timedContextCancel = context.WithTimeout(context.Background(), 10*time.Second)
 	// test the db connection
 	err = dbClient.Ping(timedContext, nil)
+	timedContextCancel()
 	if err != nil {
+		// release the client so a retried startup does not leave connections behind
+		dbClient.Disconnect(context.Background())
 		return nil, fmt.Errorf("An error occured while verifying the connection to the database: %s", err)
 	}
 
@@ -96,114 +165,622 @@ func main() {
 	// parse the command line args for flag values
 	flag.Parse()
 
-	var tlsCert string
-	var tlsKey string
-
-	// if a port was not set we will use a default port
-	if len(serverPort) == 0 {
-		// use 443 when using tls or 80 otherwise
-		if shouldServeTls {
-			serverPort = "443"
-		} else {
-			serverPort = "80"
-		}
+	// read the rest of the configuration from environment variables
+	var config, startupError = LoadConfig(serverPort, shouldServeTls)
+	if startupError != nil {
+		log.Fatal(startupError)
 	}
 
-	// TODO using a single api token is not a very secure authentication method
-	// ideally the service would use a more dynamic authentication method like JWTs
-	var apiToken = os.Getenv("AUDIT_LOG_API_TOKEN")
-	if len(apiToken) == 0 {
-		log.Fatalf("A token that can be used to authenticate requests was not provided. Please provide on using the AUDIT_LOG_API_TOKEN environment variable")
+	for _, warning := range config.Warnings() {
+		log.Printf("Warning: %s\n", warning)
 	}
 
-	var schemaFilePath = os.Getenv("AUDIT_LOG_EVENT_SCHEMA_FILE")
-	if len(schemaFilePath) == 0 {
-		log.Fatalf("A path to a json schema file for audit log events was not provided. Please provide on using the AUDIT_LOG_EVENT_SCHEMA_FILE environment variable")
-	}
+	var eventJsonSchema jsonschema.Schema
+	var csvColumns []string
+	var dbCollection *mongo.Collection
 
-	// get the db username and password from env variable
-	var dbUsername = os.Getenv("AUDIT_LOG_DB_USERNAME")
-	var dbPassword = os.Getenv("AUDIT_LOG_DB_PASSWORD")
+	log.Printf("Using a db server selection timeout of %s and a socket timeout of %s\n",
+		time.Duration(config.DbServerSelectionTimeout), time.Duration(config.DbSocketTimeout))
 
-	// get the db host from env variable
-	// setting it to localhost if it is not provided
-	var dbHost = os.Getenv("AUDIT_LOG_DB_HOST")
-	if len(dbHost) == 0 {
-		dbHost = "localhost"
+	var dbClientOptions = NewDbClientOptions(config.DbHost, config.DbPort, config.DbUsername, config.DbPassword,
+		time.Duration(config.DbServerSelectionTimeout), time.Duration(config.DbSocketTimeout))
+
+	// log connection churn and publish the state of the connection pool in the metrics
+	if config.DbPoolMonitor {
+		var poolMetrics = NewPoolMetrics(log.Default())
+		dbClientOptions.SetPoolMonitor(poolMetrics.Monitor())
+		expvar.Publish("db_pool", poolMetrics)
 	}
-	// get the db port from env variable
-	// setting it to the mongo default if it is not provided
-	var dbPort = os.Getenv("AUDIT_LOG_DB_PORT")
-	if dbPort == "" {
-		dbPort = "27017"
+
+	// the steps that need to succeed before the server can start
+	// they are retried as a unit so a failure in any of them does not stop the service for good
+	var startupSteps = []StartupStep{
+		{
+			// use the schema file to get a json schema that can be used to validate event json
+			Name: "read event schema",
+			Run: func() (err error) {
+				eventJsonSchema, err = ReadJsonSchema(config.SchemaFilePath)
+				if err == nil {
+					err = api.CheckSchemaDraft(&eventJsonSchema, config.SchemaDraft)
+				}
+				return err
+			},
+		},
+		{
+			// get the columns used when exporting events as csv from the json schema
+			Name: "read event schema properties",
+			Run: func() (err error) {
+				csvColumns, err = api.CsvColumnsFromSchema(&eventJsonSchema)
+				if err != nil {
+					err = fmt.Errorf("An error occured while reading the properties of the audit log event json schema: %s", err)
+				}
+				return err
+			},
+		},
+		{
+			// get the audit log event collection using the db connection details
+			Name: "connect to db",
+			Run: func() (err error) {
+				dbCollection, err = GetDbCollection(dbClientOptions)
+				return err
+			},
+		},
+		{
+			// make sure generated event ids are indexed and unique
+			Name: "create event id index",
+			Run: func() error {
+				var timedContext, timedContextCancel = context.WithTimeout(context.Background(), 10*time.Second)
+				defer timedContextCancel()
+
+				return api.EnsureEventIdIndex(timedContext, dbCollection, api.InsertConfig{
+					IdStrategy: config.IdStrategy,
+					IdField:    config.IdField,
+				})
+			},
+		},
+		{
+			// make sure queries on the timestamp and the configured fields do not scan the collection
+			Name: "create query indexes",
+			Run: func() error {
+				var timedContext, timedContextCancel = context.WithTimeout(context.Background(), 10*time.Second)
+				defer timedContextCancel()
+
+				return api.EnsureQueryIndexes(timedContext, dbCollection,
+					append([]string{config.TimestampField}, config.IndexFields...), log.Default())
+			},
+		},
+		{
+			// let the database delete events once they are older than the retention period
+			Name: "create retention index",
+			Run: func() error {
+				if config.RetentionSeconds == 0 {
+					return nil
+				}
+
+				var timedContext, timedContextCancel = context.WithTimeout(context.Background(), 10*time.Second)
+				defer timedContextCancel()
+
+				return api.EnsureRetentionIndex(timedContext, dbCollection, api.DefaultRetentionField,
+					time.Duration(config.RetentionSeconds)*time.Second)
+			},
+		},
 	}
 
-	// use the schema file to get a json schema that can be used to validate event json
-	var eventJsonSchema, startupError = ReadJsonSchema(schemaFilePath)
+	startupError = RunStartup(log.Default(), startupSteps, int(config.StartupAttempts), time.Duration(config.StartupRetryDelay))
 	if startupError != nil {
 		log.Fatal(startupError)
 	}
 
-	var dbCollection *mongo.Collection
-	// get the audit log event schema using the db connection details
-	dbCollection, startupError = GetDbCollection(dbHost, dbPort, dbUsername, dbPassword)
-	if startupError != nil {
-		log.Fatal(startupError)
+	// limit the query cursors that can be open at once and publish how many are open in the metrics
+	var cursorLimiter *api.CursorLimiter
+	if config.DbMaxOpenCursors > 0 {
+		cursorLimiter = api.NewCursorLimiter(int(config.DbMaxOpenCursors))
+		expvar.Publish("open_cursors", cursorLimiter)
 	}
 
+	// encrypt the configured event fields before they are stored
+	var fieldEncryption *api.FieldEncryption
+	if len(config.EncryptedFields) != 0 {
+		var key, err = base64.StdEncoding.DecodeString(config.EncryptionKey)
+		if err == nil {
+			fieldEncryption, err = api.NewFieldEncryption(key, config.EncryptedFields, config.DeterministicEncrypt, config.DecryptTokens)
+		}
+		if err != nil {
+			log.Fatalf("An error occured while setting up field encryption: %s", err)
+		}
+	}
+
+	// the transforms applied to every query result
+	// the spec was already checked when the config was loaded
+	var resultTransforms, _ = api.ParseTransformPipeline(config.ResultTransforms)
+
+	// the settings used by the handlers that query events
+	var queryConfig = api.QueryConfig{
+		Schema:                   &eventJsonSchema,
+		StrictDecoding:           config.StrictDecoding,
+		Logger:                   log.Default(),
+		DefaultLimit:             config.DefaultQueryLimit,
+		MaxLimit:                 config.MaxQueryLimit,
+		CsvColumns:               csvColumns,
+		FieldAliases:             config.FieldAliases,
+		SortableFields:           config.SortableFields,
+		QueryTimeout:             time.Duration(config.QueryTimeout),
+		AllowPartialResults:      config.PartialResults,
+		MaxFilterFields:          config.MaxFilterFields,
+		IdFormat:                 config.IdFormat,
+		DisableSortTiebreaker:    !config.SortTiebreaker,
+		EmptyFilterPolicy:        config.EmptyFilterPolicy,
+		IndexHints:               config.IndexHints,
+		DefaultHints:             config.DefaultHints,
+		RetryAfter:               time.Duration(config.RetryAfter),
+		DefaultConsistency:       config.ReadConsistency,
+		TimestampField:           config.TimestampField,
+		MaxTimeRange:             time.Duration(config.MaxTimeRange),
+		CursorLimiter:            cursorLimiter,
+		Encryption:               fieldEncryption,
+		Transforms:               resultTransforms,
+		FlattenBsonTypes:         config.FlattenBsonTypes,
+		AggregateFields:          config.AggregateFields,
+		DisallowAggregateDiskUse: !config.AggregateDiskUse,
+		AggregateMaxTime:         time.Duration(config.AggregateMaxTime),
+	}
+
+	// events can not be changed in append only mode so their tags are kept in their own collection
+	if config.AppendOnly {
+		queryConfig.TagCollection = dbCollection.Database().Collection("tag")
+	}
+
+	// the secondary destinations every added event is also written to
+	// they are also named so stored events can be replayed to one of them
+	var sinks []api.EventSink
+	var sinkDestinations = make(map[string]api.EventSink)
+	if len(config.SinkCollection) != 0 {
+		var collectionSink = api.CollectionSink{
+			Collection: dbCollection.Database().Collection(config.SinkCollection),
+		}
+		sinks = append(sinks, collectionSink)
+		sinkDestinations["collection"] = collectionSink
+	}
+	if len(config.SinkFile) != 0 {
+		var fileSink, err = api.NewFileSink(config.SinkFile)
+		if err != nil {
+			log.Fatalf("An error occured while opening the event sink file: %s", err)
+		}
+		sinks = append(sinks, fileSink)
+		sinkDestinations["file"] = fileSink
+	}
+
+	// the sinks are written to by a fixed number of workers from a bounded queue
+	// the number of events written, failed and dropped is published as sinks in the metrics
+	var sinkWriter *api.SinkWriter
+	if len(sinks) != 0 {
+		sinkWriter = api.NewSinkWriter(sinks, log.Default(), int(config.SinkWorkers), int(config.SinkQueueSize), time.Duration(config.SinkTimeout))
+		expvar.Publish("sinks", sinkWriter)
+	}
+
+	// the settings used by the handlers that add events
+	var insertConfig = api.InsertConfig{
+		MaxEventBytes:        int(config.MaxEventBytes),
+		MaxBodyBytes:         config.MaxBodyBytes,
+		Logger:               log.Default(),
+		CorrelationField:     config.CorrelationField,
+		BodyReadTimeout:      time.Duration(config.BodyReadTimeout),
+		SinkWriter:           sinkWriter,
+		InvalidEventStatus:   int(config.InvalidEventStatus),
+		PartialBatches:       config.PartialBatches,
+		MetadataField:        config.MetadataField,
+		FieldMaxBytes:        config.FieldMaxBytes,
+		DefaultFieldMaxBytes: int(config.DefaultFieldMaxBytes),
+		IdStrategy:           config.IdStrategy,
+		IdField:              config.IdField,
+		TimestampField:       config.TimestampField,
+		DisableValidation:    config.DisableValidation,
+		DefaultAckMode:       config.AckMode,
+		Encryption:           fieldEncryption,
+	}
+	if config.RetentionSeconds > 0 {
+		insertConfig.RetentionField = api.DefaultRetentionField
+	}
+	if config.TypedEvents {
+		insertConfig.NewEvent = func() interface{} { return &Event{} }
+	}
+
+	// keep added events in a local file until they are in the db so they survive a db outage
+	// the background drain is stopped and the log is closed when the service stops
+	var wal *api.WriteAheadLog
+	var walContext, walContextCancel = context.WithCancel(context.Background())
+	defer walContextCancel()
+	var walDone = make(chan struct{})
+	if len(config.WalPath) != 0 {
+		var err error
+		wal, err = api.OpenWriteAheadLog(config.WalPath)
+		if err != nil {
+			log.Fatalf("An error occured while opening the write ahead log: %s", err)
+		}
+		insertConfig.WriteAheadLog = wal
+
+		// insert the events that were not drained before the service last stopped
+		// if the db is not reachable yet they are left for the background drain
+		var inserted int
+		inserted, err = wal.Drain(context.Background(), dbCollection, insertConfig)
+		if err != nil {
+			log.Printf("An error occured while draining the write ahead log: %s\n", err)
+		} else if inserted > 0 {
+			log.Printf("Inserted %d events from the write ahead log\n", inserted)
+		}
+
+		go func() {
+			defer close(walDone)
+			wal.Run(walContext, dbCollection, insertConfig, time.Duration(config.WalDrainInterval))
+		}()
+	}
+
+	// events added in async mode are inserted by a fixed number of workers from a bounded queue
+	// it is created after the write ahead log so the workers use it as well
+	var asyncInserter = api.NewAsyncInserter(dbCollection, insertConfig, int(config.AsyncWorkers), int(config.AsyncQueueSize))
+	insertConfig.AsyncInserter = asyncInserter
+
 	// create a new http multiplexer for handling http requests
-	var muliplexer = http.NewServeMux()
+	// it knows the template of each route so requests can be grouped by route in metrics and logs
+	var muliplexer = mux.NewRouteRegistry()
 
 	// create a new method router so we can group similar operations for events to one endpoint path
 	var eventsRouter = mux.NewMethodRouter()
 	// add the ability to ADD events to the event router
-	eventsRouter.Handle(http.MethodPost, api.EventsAddHandler(dbCollection, &eventJsonSchema))
+	eventsRouter.Handle(http.MethodPost, mux.ContentTypeMiddleware{
+		MediaTypes: jsonMediaTypes,
+		Handler:    api.EventsAddHandler(dbCollection, &eventJsonSchema, insertConfig),
+	})
 	// add the ability to QUERY events to the event router
-	eventsRouter.Handle(http.MethodGet, api.EventsQueryHandler(dbCollection))
+	eventsRouter.Handle(http.MethodGet, api.EventsQueryHandler(dbCollection, queryConfig))
 
 	// add the audit log events router to the multiplexer
-	muliplexer.Handle("/events", eventsRouter)
+	muliplexer.Handle("/events", appendOnly(eventsRouter, config.AppendOnly))
+
+	// create a router for adding many events in one request
+	var eventsBatchRouter = newPostRouter(jsonMediaTypes, api.EventsBulkAddHandler(dbCollection, &eventJsonSchema, insertConfig))
+
+	// add the audit log events batch router to the multiplexer
+	muliplexer.Handle("/events/batch", eventsBatchRouter)
+
+	// create a router for adding a stream of newline delimited events
+	var eventsStreamRouter = newPostRouter(ndjsonMediaTypes, api.EventsStreamAddHandler(dbCollection, &eventJsonSchema, insertConfig))
+
+	// add the audit log events stream router to the multiplexer
+	muliplexer.Handle("/events/stream", eventsStreamRouter)
+
+	// create a router for querying events using a json body
+	var eventsQueryRouter = mux.NewMethodRouter()
+	eventsQueryRouter.Handle(http.MethodPost, api.EventsPostQueryHandler(dbCollection, queryConfig))
+
+	// add the audit log events query router to the multiplexer
+	// queries in a request body use POST so the ingest token has to be turned away explicitly
+	muliplexer.Handle("/events/query", http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if mux.TokenName(request) == ingestTokenName {
+			mux.WriteJsonResponse(writer, mux.DefaultHttpError(http.StatusForbidden))
+			return
+		}
+
+		eventsQueryRouter.ServeHTTP(writer, request)
+	}))
+
+	// create a router for tagging the events matching a filter
+	var eventsTagRouter = mux.NewMethodRouter()
+	eventsTagRouter.Handle(http.MethodPost, api.EventsTagHandler(dbCollection, queryConfig))
+
+	// add the audit log events tag router to the multiplexer
+	// the ingest token can only add events so it is turned away the same way as for queries
+	muliplexer.Handle("/events/tags", http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if mux.TokenName(request) == ingestTokenName {
+			mux.WriteJsonResponse(writer, mux.DefaultHttpError(http.StatusForbidden))
+			return
+		}
+
+		eventsTagRouter.ServeHTTP(writer, request)
+	}))
+
+	// create a router for checking if an event is valid without adding it
+	var eventsValidateRouter = newPostRouter(jsonMediaTypes, api.EventsValidateHandler(&eventJsonSchema, insertConfig))
+
+	// add the audit log events validate router to the multiplexer
+	muliplexer.Handle("/events/validate", eventsValidateRouter)
+
+	// create a router for counting events grouped by a field
+	var eventsAggregateRouter = mux.NewMethodRouter()
+	eventsAggregateRouter.Handle(http.MethodGet, api.EventsAggregateHandler(dbCollection, queryConfig))
+
+	// add the audit log events aggregate router to the multiplexer
+	muliplexer.Handle("/events/aggregate", eventsAggregateRouter)
 
-	// TODO probably need GET PUT DELETE /events/<event>
-	// TODO probably need GET /health
+	// create a router for counting the events that match a filter
+	var eventsCountRouter = mux.NewMethodRouter()
+	eventsCountRouter.Handle(http.MethodGet, api.EventsCountHandler(dbCollection, queryConfig))
+
+	// add the audit log events count router to the multiplexer
+	muliplexer.Handle("/events/count", eventsCountRouter)
+
+	// create a router for encoding a query into a token that can be shared
+	var eventsShareRouter = mux.NewMethodRouter()
+	eventsShareRouter.Handle(http.MethodGet, api.EventsShareHandler())
+
+	// add the audit log events share router to the multiplexer
+	muliplexer.Handle("/events/share", eventsShareRouter)
+
+	// create a router for running a shared query from its token
+	var eventsSharedRouter = mux.NewMethodRouter()
+	eventsSharedRouter.Handle(http.MethodGet, api.EventsSharedQueryHandler(dbCollection, queryConfig))
+
+	// add the audit log shared query router to the multiplexer
+	muliplexer.Handle("/events/shared/", eventsSharedRouter, "/events/shared/{token}")
+
+	// add the consumer watermark endpoints to the multiplexer
+	// watermarks are kept in their own collection next to the events
+	var consumerCollection = dbCollection.Database().Collection("consumer")
+	muliplexer.Handle("/consumers/", api.ConsumersHandler(dbCollection, consumerCollection, queryConfig),
+		"/consumers/{consumer}/watermark", "/consumers/{consumer}/events")
+
+	// add the endpoints for a single event to the multiplexer
+	muliplexer.Handle("/events/", appendOnly(api.EventResourceHandler(dbCollection, queryConfig), config.AppendOnly),
+		"/events/{id}", "/events/{id}/context")
+
+	// TODO probably need PUT /events/<event>
 
 	// the http handler that will be used to serve http requests
 	var serveHandler http.Handler = muliplexer
 
-	// wrap the multiplexer in a middleware handler that logs when reqests are made
-	serveHandler = mux.LoggingMiddleware{
+	// replace the descriptions of 500 level errors (i.e. database errors) with default errors
+	// so no sensitive info gets sent to the user and log the descriptive error instead
+	serveHandler = mux.ErrorScrubMiddleware{
 		Logger:  log.Default(),
 		Handler: serveHandler,
 	}
 
-	// wrap the multiplexer in a middleware handler that authenticates requests
-	serveHandler = mux.AuthenticationMiddleware{
-		Token:   apiToken,
+	// record the sizes of the request and response bodies of each route
+	// the metrics are published using expvar and served on /metrics
+	var requestBytes = mux.NewHistogramVec(mux.ByteSizeBuckets)
+	var responseBytes = mux.NewHistogramVec(mux.ByteSizeBuckets)
+	expvar.Publish("request_bytes", requestBytes)
+	expvar.Publish("response_bytes", responseBytes)
+
+	// requests are labeled with the template of their route (i.e. /events/{id}/context)
+	serveHandler = mux.MetricsMiddleware{
+		RequestBytes:  requestBytes,
+		ResponseBytes: responseBytes,
+		Handler:       serveHandler,
+	}
+
+	// let clients pick the response format with a path extension (i.e. /events.csv)
+	// this is outside the metrics middleware so requests are labeled with the route they were served by
+	serveHandler = mux.FormatExtensionMiddleware{
+		Formats: map[string]string{
+			"json":   "application/json",
+			"csv":    "text/csv",
+			"ndjson": mux.NdjsonMediaType,
+		},
 		Handler: serveHandler,
 	}
 
+	// wrap the multiplexer in a middleware handler that logs when reqests are made
+	// json access logs are written without the date prefix so each line can be parsed on its own
+	var accessLogger = log.Default()
+	if config.LogFormat == mux.LogFormatJson {
+		accessLogger = log.New(log.Writer(), "", 0)
+	}
+
+	serveHandler = mux.LoggingMiddleware{
+		Logger:               accessLogger,
+		Fields:               config.LogFields,
+		Headers:              config.LogHeaders,
+		SlowRequestThreshold: time.Duration(config.SlowRequestThreshold),
+		SampleRate:           config.LogSampleRate,
+		SampleHeader:         config.LogSampleHeader,
+		Format:               config.LogFormat,
+		Handler:              serveHandler,
+	}
+
+	// the api token is kept in a store so it can be rotated using the admin endpoint
+	var apiTokenStore = mux.NewTokenStore(config.ApiToken)
+
+	// the ingest token can only add events and the query token can only read them
+	var restrictedTokens = []mux.RestrictedToken{
+		{Token: config.IngestToken, Name: ingestTokenName, Methods: []string{http.MethodPost}},
+		{Token: config.QueryToken, Name: "query", Methods: []string{http.MethodGet}},
+	}
+	// the scoped tokens are limited to the methods of their scope
+	// the scopes were checked when the config was loaded
+	for token, scope := range config.TokenScopes {
+		var scopedToken, _ = mux.ScopedToken(token, scope)
+		restrictedTokens = append(restrictedTokens, scopedToken)
+	}
+
+	// wrap the multiplexer in a middleware handler that authenticates requests
+	var authentication = mux.AuthenticationMiddleware{
+		TokenStore:       apiTokenStore,
+		Tokens:           config.ApiTokens,
+		Name:             "api",
+		RestrictedTokens: restrictedTokens,
+		IdentityHeader:   config.IdentityHeader,
+		Handler:          serveHandler,
+	}
+	serveHandler = authentication
+
+	// limit how many requests each client can make so one client can not flood the service
+	// the limit is checked before authentication so requests with an invalid token are limited as well
+	// by their ip address
+	if config.RateLimit > 0 {
+		var rateLimiter = mux.NewRateLimitMiddleware(float64(config.RateLimit), int(config.RateLimitBurst), serveHandler)
+		rateLimiter.ValidToken = authentication.ValidToken
+		serveHandler = rateLimiter
+	}
+
+	// let browsers on the allowed origins call the service
+	// preflight requests are answered before authentication since browsers do not send the token with them
+	if len(config.CorsOrigins) > 0 {
+		serveHandler = mux.CorsMiddleware{
+			AllowedOrigins: config.CorsOrigins,
+			AllowedMethods: config.CorsMethods,
+			AllowedHeaders: config.CorsHeaders,
+			Handler:        serveHandler,
+		}
+	}
+
+	// identify the service to requests for the root path
+	// the root path is checked before authentication so it can be requested without a token
+	if !config.DisableRootEndpoint {
+		serveHandler = RootMiddleware{
+			Info: ServiceInfo{
+				Name:      serviceName,
+				Version:   Version,
+				Endpoints: muliplexer.Templates(),
+			},
+			Handler: serveHandler,
+		}
+	}
+
+	// the dependencies reported by the detailed health endpoint
+	var healthChecks = []api.HealthCheck{
+		api.DbHealthCheck(dbCollection),
+		api.SchemaHealthCheck(&eventJsonSchema),
+	}
+	for name, sink := range sinkDestinations {
+		healthChecks = append(healthChecks, api.SinkHealthCheck(name+"_sink", sink))
+	}
+
+	// the operational endpoints that do not use the api token
+	var internalRoutes = map[string]http.Handler{
+		"/health": api.HealthHandler(dbCollection),
+		"/readyz": api.ReadinessHandler(dbCollection, config.ReadinessCheck == "write", log.Default()),
+	}
+
+	// the metrics and dependency statuses are only public on the admin listener
+	AddPrivateRoutes(internalRoutes, map[string]http.Handler{
+		"/metrics":         mux.MetricsHandler(),
+		"/health/detailed": api.DetailedHealthHandler(healthChecks, log.Default()),
+	}, config.AdminAddress, config.AdminToken)
+
+	// the admin endpoints are authenticated using the admin token
+	// they are not added at all if no admin token was provided
+	if len(config.AdminToken) != 0 {
+		var adminMultiplexer = http.NewServeMux()
+
+		var configRouter = mux.NewMethodRouter()
+		configRouter.Handle(http.MethodGet, ConfigHandler(config))
+		adminMultiplexer.Handle("/admin/config", configRouter)
+
+		var replayRouter = mux.NewMethodRouter()
+		replayRouter.Handle(http.MethodPost, api.EventsReplayHandler(dbCollection, sinkDestinations, queryConfig))
+		adminMultiplexer.Handle("/admin/replay", replayRouter)
+
+		// deleting events is turned off in append only mode
+		var deleteHandler = api.EventsBulkDeleteHandler(dbCollection, config.DeleteBatchSize, queryConfig)
+		if config.AppendOnly {
+			deleteHandler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				mux.WriteJsonResponse(writer, appendOnlyError)
+			})
+		}
+
+		var deleteRouter = mux.NewMethodRouter()
+		deleteRouter.Handle(http.MethodPost, deleteHandler)
+		adminMultiplexer.Handle("/admin/delete", deleteRouter)
+
+		var rotateTokenRouter = mux.NewMethodRouter()
+		rotateTokenRouter.Handle(http.MethodPost, mux.TokenRotateHandler(apiTokenStore))
+		adminMultiplexer.Handle("/admin/rotate-token", rotateTokenRouter)
+
+		internalRoutes["/admin/"] = mux.AuthenticationMiddleware{
+			Token:   config.AdminToken,
+			Handler: adminMultiplexer,
+		}
+	}
+
+	// the internal routes are moved to their own listener if an admin address was provided
+	var publicHandler, adminHandler = NewListenerHandlers(config.AdminAddress, serveHandler, internalRoutes)
+
+	// wrap the public handler in a middleware handler that compresses responses
+	if config.GzipLevel != 0 {
+		publicHandler, startupError = mux.NewGzipMiddleware(int(config.GzipLevel), publicHandler)
+		if startupError != nil {
+			log.Fatal(startupError)
+		}
+	}
+
+	// wrap the public handler in a middleware handler that adds the configured headers to every response
+	if len(config.ResponseHeaders) > 0 {
+		publicHandler = mux.HeaderMiddleware{
+			Headers:  config.ResponseHeaders,
+			Override: config.OverrideResponseHeaders,
+			Handler:  publicHandler,
+		}
+	}
+
+	// wrap the handlers in a middleware handler that rejects requests with unreasonable headers
+	publicHandler = mux.HeaderLimitMiddleware{
+		MaxHeaders:          int(config.MaxHeaders),
+		MaxHeaderValueBytes: int(config.MaxHeaderValueBytes),
+		Handler:             publicHandler,
+	}
+
+	// wrap the handlers in a middleware handler that sends a 500 if any of them panic
+	// it is the outermost handler so a panic in any of the other middleware is caught as well
+	publicHandler = mux.RecoveryMiddleware{
+		Logger:  log.Default(),
+		Handler: publicHandler,
+	}
+
 	// create an http server for serving requests using the wrapped multiplexer we created
 	var server = http.Server{
-		Addr:    fmt.Sprintf(":%s", serverPort),
-		Handler: serveHandler,
+		Addr:           fmt.Sprintf(":%s", config.ServerPort),
+		Handler:        publicHandler,
+		MaxHeaderBytes: int(config.MaxHeaderBytes),
 	}
 
-	// TODO run a routine watching for sigint so we can gracefully close the server
+	var listeners = []Listener{
+		{
+			Server: &server,
+			Serve: func() error {
+				if config.ServeTls {
+					return server.ListenAndServeTLS(config.TlsCert, config.TlsKey)
+				}
+				return server.ListenAndServe()
+			},
+		},
+	}
 
-	log.Println("Server started successfully")
+	if adminHandler != nil {
+		adminHandler = mux.HeaderLimitMiddleware{
+			MaxHeaders:          int(config.MaxHeaders),
+			MaxHeaderValueBytes: int(config.MaxHeaderValueBytes),
+			Handler:             adminHandler,
+		}
+		adminHandler = mux.RecoveryMiddleware{
+			Logger:  log.Default(),
+			Handler: adminHandler,
+		}
 
-	// start the server
-	var serverError error
-	if shouldServeTls {
-		// get the cert and key values from env variables
-		tlsCert = os.Getenv("AUDIT_LOG_TLS_CERT")
-		tlsKey = os.Getenv("AUDIT_LOG_TLS_KEY")
+		// the admin server is expected to be bound to an internal only address so it does not use tls
+		var adminServer = http.Server{
+			Addr:           config.AdminAddress,
+			Handler:        adminHandler,
+			MaxHeaderBytes: int(config.MaxHeaderBytes),
+		}
 
-		serverError = server.ListenAndServeTLS(tlsCert, tlsKey)
-	} else {
-		serverError = server.ListenAndServe()
+		listeners = append(listeners, Listener{
+			Server: &adminServer,
+			Serve:  adminServer.ListenAndServe,
+		})
+
+		log.Printf("Serving the admin and health endpoints on %s\n", config.AdminAddress)
 	}
 
+	// watch for sigint and sigterm so the servers can be closed gracefully
+	var signals = make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	log.Println("Server started successfully")
+
+	// start the servers
+	// they all stop when any of them stops
+	var serverError = RunListeners(listeners, signals, 10*time.Second)
 	// serverError will always be a non nil value
 	// check the reason that the server stopped
 	// gracefully shutting down a server will return a http.ErrServerClosed error
@@ -214,4 +791,33 @@ func main() {
 	} else {
 		log.Printf("Server shutdown because an error occured: %s\n", serverError)
 	}
+
+	// finish inserting the events that were added in async mode before the service stops
+	var closeContext, closeContextCancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer closeContextCancel()
+
+	var closeErr = asyncInserter.Close(closeContext)
+	if closeErr != nil {
+		log.Printf("Not every event added in the background was inserted before the service stopped: %s\n", closeErr)
+	}
+
+	// the async inserter uses the write ahead log so it is stopped after the inserter has finished
+	// a drain that is running is stopped at its next insert and the events it did not insert stay in the log
+	if wal != nil {
+		walContextCancel()
+		<-walDone
+
+		closeErr = wal.Close()
+		if closeErr != nil {
+			log.Printf("An error occured while closing the write ahead log: %s\n", closeErr)
+		}
+	}
+
+	// the inserted events are written to the sinks so they are closed after the async inserter and the write ahead log
+	if sinkWriter != nil {
+		closeErr = sinkWriter.Close(closeContext)
+		if closeErr != nil {
+			log.Printf("Not every event was written to the secondary sinks before the service stopped: %s\n", closeErr)
+		}
+	}
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellkelly/auditlog/mux"
+)
+
+func TestNewDbClientOptionsAppliesTimeouts(t *testing.T) {
+	var dbClientOptions = NewDbClientOptions("localhost", "27017", "", "", 5*time.Second, 2*time.Second)
+
+	if dbClientOptions.ServerSelectionTimeout == nil || *dbClientOptions.ServerSelectionTimeout != 5*time.Second {
+		t.Errorf("The server selection timeout was not applied to the db client options Expected: %s, Got: %v", 5*time.Second, dbClientOptions.ServerSelectionTimeout)
+	}
+
+	if dbClientOptions.SocketTimeout == nil || *dbClientOptions.SocketTimeout != 2*time.Second {
+		t.Errorf("The socket timeout was not applied to the db client options Expected: %s, Got: %v", 2*time.Second, dbClientOptions.SocketTimeout)
+	}
+}
+
+func TestNewDbClientOptionsCredentials(t *testing.T) {
+	var dbClientOptions = NewDbClientOptions("localhost", "27017", "user", "pass", time.Second, time.Second)
+
+	if dbClientOptions.Auth == nil || dbClientOptions.Auth.Username != "user" || dbClientOptions.Auth.Password != "pass" {
+		t.Errorf("The db credentials were not applied to the db client options Got: %+v", dbClientOptions.Auth)
+	}
+}
+
+func TestNewDbClientOptionsSpecialCharacterCredentials(t *testing.T) {
+	var password = "p@ss:w/rd%2F"
+	var dbClientOptions = NewDbClientOptions("localhost", "27017", "user@example.com", password, time.Second, time.Second)
+
+	if dbClientOptions.Auth == nil || dbClientOptions.Auth.Username != "user@example.com" || dbClientOptions.Auth.Password != password {
+		t.Errorf("The db credentials were changed by the connection string Got: %+v", dbClientOptions.Auth)
+	}
+
+	if len(dbClientOptions.Hosts) != 1 || dbClientOptions.Hosts[0] != "localhost:27017" {
+		t.Errorf("The db host was changed by the credentials Got: %v", dbClientOptions.Hosts)
+	}
+
+	var err = dbClientOptions.Validate()
+	if err != nil {
+		t.Errorf("The db client options are not valid: %s", err)
+	}
+}
+
+func TestAppendOnly(t *testing.T) {
+	var methodRouter = mux.NewMethodRouter()
+	methodRouter.Handle(http.MethodGet, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {}))
+	methodRouter.Handle(http.MethodDelete, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {}))
+
+	var tests = []struct {
+		method   string
+		enabled  bool
+		expected int
+	}{
+		{http.MethodGet, true, http.StatusOK},
+		{http.MethodDelete, true, http.StatusForbidden},
+		{http.MethodPatch, true, http.StatusForbidden},
+		{http.MethodDelete, false, http.StatusOK},
+		// methods the route never supports are not allowed rather than forbidden
+		{http.MethodPatch, false, http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		var writer = httptest.NewRecorder()
+		appendOnly(methodRouter, test.enabled).ServeHTTP(writer, httptest.NewRequest(test.method, "/events/62508ea4c4f0f7e1b5a3e6d1", nil))
+
+		if writer.Code != test.expected {
+			t.Errorf("An unexpected status was sent for %s with append only %t Expected: %d, Got: %d", test.method, test.enabled, test.expected, writer.Code)
+		}
+	}
+}
+
+func TestNewPostRouterContentType(t *testing.T) {
+	var handler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNoContent)
+	})
+
+	var tests = []struct {
+		path        string
+		mediaTypes  []string
+		contentType string
+		expected    int
+	}{
+		{"/events/stream", ndjsonMediaTypes, "application/x-ndjson", http.StatusNoContent},
+		{"/events/stream", ndjsonMediaTypes, "application/x-ndjson; charset=utf-8", http.StatusNoContent},
+		{"/events/stream", ndjsonMediaTypes, "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"/events/stream", ndjsonMediaTypes, "", http.StatusUnsupportedMediaType},
+		{"/events/validate", jsonMediaTypes, "application/json", http.StatusNoContent},
+		{"/events/validate", jsonMediaTypes, "text/plain", http.StatusUnsupportedMediaType},
+		{"/events/validate", jsonMediaTypes, "", http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		var request = httptest.NewRequest(http.MethodPost, test.path, strings.NewReader("{}"))
+		if len(test.contentType) != 0 {
+			request.Header.Set("Content-Type", test.contentType)
+		}
+
+		var writer = httptest.NewRecorder()
+		newPostRouter(test.mediaTypes, handler).ServeHTTP(writer, request)
+
+		if writer.Code != test.expected {
+			t.Errorf("An unexpected status was sent for %s with a Content-Type of %q Expected: %d, Got: %d", test.path, test.contentType, test.expected, writer.Code)
+		}
+	}
+
+	// methods other than POST are not allowed
+	var writer = httptest.NewRecorder()
+	newPostRouter(jsonMediaTypes, handler).ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/events/validate", nil))
+
+	if writer.Code != http.StatusMethodNotAllowed {
+		t.Errorf("An unexpected status was sent for a GET request Expected: %d, Got: %d", http.StatusMethodNotAllowed, writer.Code)
+	}
+}
@@ -0,0 +1,77 @@
+package mux
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// formats the LoggingMiddleware can log requests in
+const (
+	// a "New Request" line with the request attributes as key=value pairs
+	LogFormatText = "text"
+	// a json object per request once it has finished so log pipelines can parse it
+	LogFormatJson = "json"
+)
+
+// the valid log formats
+var LogFormats = []string{LogFormatText, LogFormatJson}
+
+// request header whose value is included in json access logs so a request can be traced across services
+const RequestIdHeader = "X-Request-Id"
+
+// call the next http handler then log the request as a single json object
+// the line always has the method, path, status, response size, duration, remote address and request id
+// along with the configured fields and headers
+// json can only be parsed if the line is not prefixed so the logger should not have a prefix or flags
+func (self LoggingMiddleware) serveJson(writer http.ResponseWriter, request *http.Request) {
+	var result = self.serveTimed(writer, request)
+	request = result.request
+
+	// every request is logged when the sample rate is 0 or 1
+	var sampling = self.SampleRate > 0 && self.SampleRate < 1
+	if sampling && !self.logged(result) {
+		return
+	}
+
+	var entry = map[string]interface{}{
+		"time":        time.Now().UTC().Format(time.RFC3339Nano),
+		"level":       "info",
+		"route":       RouteTemplate(request),
+		"status":      result.statusCode,
+		"bytes":       result.bytesWritten,
+		"duration_ms": durationMilliseconds(result.phases.Duration),
+		"request_id":  request.Header.Get(RequestIdHeader),
+	}
+
+	for _, field := range append([]string{LogFieldMethod, LogFieldPath, LogFieldRemoteAddr}, self.Fields...) {
+		entry[field] = logFieldValue(request, field)
+	}
+
+	if len(self.Headers) != 0 {
+		var headers = make(map[string]string, len(self.Headers))
+		for _, header := range self.Headers {
+			headers[http.CanonicalHeaderKey(header)] = request.Header.Get(header)
+		}
+		entry["headers"] = headers
+	}
+
+	// slow requests are logged as warnings with the time spent in each phase rather than on a line of their own
+	if result.slow {
+		entry["level"] = "warning"
+		entry["slow"] = true
+		entry["read_body_ms"] = durationMilliseconds(result.phases.ReadBody)
+		entry["handler_ms"] = durationMilliseconds(result.phases.Handler)
+		entry["write_response_ms"] = durationMilliseconds(result.phases.WriteResponse)
+		entry["dominant_phase"] = result.phases.dominant()
+	}
+
+	// the entry only holds strings and numbers so it always marshals
+	var d, _ = json.Marshal(entry)
+	self.Logger.Println(string(d))
+}
+
+// get a duration in milliseconds with a fractional part
+func durationMilliseconds(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
+}
@@ -0,0 +1,73 @@
+package mux
+
+import (
+	"net/http"
+	"strings"
+)
+
+// value of AllowedOrigins that allows requests from any origin
+const AnyOrigin = "*"
+
+// http handler that lets browsers on other origins (i.e. a dashboard) call another http handler
+// preflight requests are answered with the allowed methods and headers without calling the handler
+// so they do not need to be authenticated
+// requests from origins that are not allowed are passed on without any cors headers
+// so the browser blocks the response
+type CorsMiddleware struct {
+	// origins (i.e. https://dashboard.example.com) that can make requests
+	// AnyOrigin allows every origin
+	AllowedOrigins []string
+	// http methods browsers can use for cross origin requests
+	AllowedMethods []string
+	// request headers (i.e. Authorization) browsers can send with cross origin requests
+	AllowedHeaders []string
+	Handler        http.Handler
+}
+
+// answer preflight requests or add the allowed origin to the response and call the wrapped handler
+func (self CorsMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var origin = request.Header.Get("Origin")
+	// the allowed origin depends on the request so caches must not share responses between origins
+	writer.Header().Add("Vary", "Origin")
+
+	var allowedOrigin, ok = self.allowedOrigin(origin)
+	if !ok {
+		self.Handler.ServeHTTP(writer, request)
+		return
+	}
+
+	writer.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+
+	// a preflight asks which method will be used so it can be told apart from an actual OPTIONS request
+	if request.Method == http.MethodOptions && len(request.Header.Get("Access-Control-Request-Method")) != 0 {
+		writer.Header().Set("Access-Control-Allow-Methods", strings.Join(self.AllowedMethods, ", "))
+		if len(self.AllowedHeaders) != 0 {
+			writer.Header().Set("Access-Control-Allow-Headers", strings.Join(self.AllowedHeaders, ", "))
+		}
+
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	self.Handler.ServeHTTP(writer, request)
+}
+
+// get the value of the Access-Control-Allow-Origin header for an origin
+// ok is false if the request is not a cross origin request or the origin is not allowed
+func (self CorsMiddleware) allowedOrigin(origin string) (string, bool) {
+	if len(origin) == 0 {
+		return "", false
+	}
+
+	for _, allowedOrigin := range self.AllowedOrigins {
+		if allowedOrigin == AnyOrigin {
+			return AnyOrigin, true
+		}
+
+		if strings.EqualFold(allowedOrigin, origin) {
+			return origin, true
+		}
+	}
+
+	return "", false
+}
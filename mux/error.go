@@ -2,6 +2,7 @@ package mux
 
 import (
 	"net/http"
+	"time"
 )
 
 type HttpError struct {
@@ -19,3 +20,21 @@ func DefaultHttpError(statusCode int) HttpError {
 		Description: http.StatusText(statusCode),
 	}
 }
+
+// RetryAfterError is an HttpError for a request the client can try again later (i.e. a 429 or 503)
+// WriteJsonResponse sends how long the client should wait in a Retry-After header
+type RetryAfterError struct {
+	HttpError
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (self RetryAfterError) StatusCode() int {
+	return self.Code
+}
+
+// StatusCoder can be implemented by error types that carry more detail than an HttpError
+// or by response values that should not be sent with a 200
+// WriteJsonResponse will marshal the value as is and send it with the status code it provides
+type StatusCoder interface {
+	StatusCode() int
+}
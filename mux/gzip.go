@@ -0,0 +1,150 @@
+package mux
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// http handler that compresses responses using gzip when the user accepts it
+// before calling another http handler
+// create it using NewGzipMiddleware so the compression level is validated
+type GzipMiddleware struct {
+	// http handler whose responses are compressed
+	Handler http.Handler
+	// gzip writers are reused between requests since creating one allocates several hundred KiB
+	writers *sync.Pool
+}
+
+// create a gzip middleware that compresses responses using the compression level provided
+// the level can be anything from gzip.HuffmanOnly to gzip.BestCompression (i.e. gzip.BestSpeed or gzip.DefaultCompression)
+func NewGzipMiddleware(level int, handler http.Handler) (GzipMiddleware, error) {
+	// creating a writer is the only way to validate the level against the gzip package
+	var _, err = gzip.NewWriterLevel(ioutil.Discard, level)
+	if err != nil {
+		return GzipMiddleware{}, fmt.Errorf("The gzip compression level must be between %d and %d", gzip.HuffmanOnly, gzip.BestCompression)
+	}
+
+	return GzipMiddleware{
+		Handler: handler,
+		writers: &sync.Pool{
+			New: func() interface{} {
+				// the level was already validated so this can not fail
+				var writer, _ = gzip.NewWriterLevel(ioutil.Discard, level)
+				return writer
+			},
+		},
+	}, nil
+}
+
+// check if the request lists gzip in its Accept-Encoding header
+// gzip;q=0 means the user does not accept gzip
+func acceptsGzip(request *http.Request) bool {
+	for _, accept := range request.Header.Values("Accept-Encoding") {
+		for _, encoding := range strings.Split(accept, ",") {
+			var parts = strings.Split(encoding, ";")
+
+			if strings.EqualFold(strings.TrimSpace(parts[0]), "gzip") {
+				return len(parts) == 1 || strings.TrimSpace(parts[1]) != "q=0"
+			}
+		}
+	}
+
+	return false
+}
+
+// compress the response of the wrapped handler if the user accepts gzip
+func (self GzipMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	// the response depends on the Accept-Encoding header so caches need to know that
+	writer.Header().Add("Vary", "Accept-Encoding")
+
+	if !acceptsGzip(request) {
+		self.Handler.ServeHTTP(writer, request)
+		return
+	}
+
+	var gzipWriter = &gzipResponseWriter{
+		ResponseWriter: writer,
+		writers:        self.writers,
+	}
+	defer gzipWriter.close()
+
+	self.Handler.ServeHTTP(gzipWriter, request)
+}
+
+// response writer that compresses the body written to it
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	writers *sync.Pool
+	// set once the status code has been written
+	wroteHeader bool
+	// set if the response has a body that is being compressed
+	compress bool
+	// taken from the pool the first time the body is written to
+	gzipWriter *gzip.Writer
+}
+
+func (self *gzipResponseWriter) WriteHeader(statusCode int) {
+	if self.wroteHeader {
+		return
+	}
+	self.wroteHeader = true
+
+	// responses without a body are sent as is so they do not get an empty gzip stream
+	if statusCode != http.StatusNoContent && statusCode != http.StatusNotModified {
+		self.compress = true
+
+		self.Header().Set("Content-Encoding", "gzip")
+		// the length of the compressed body is not known upfront
+		self.Header().Del("Content-Length")
+	}
+
+	self.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (self *gzipResponseWriter) Write(d []byte) (int, error) {
+	if !self.wroteHeader {
+		self.WriteHeader(http.StatusOK)
+	}
+
+	if !self.compress {
+		return self.ResponseWriter.Write(d)
+	}
+
+	if self.gzipWriter == nil {
+		self.gzipWriter = self.writers.Get().(*gzip.Writer)
+		self.gzipWriter.Reset(self.ResponseWriter)
+	}
+
+	return self.gzipWriter.Write(d)
+}
+
+// send any compressed data that has been buffered so streamed responses reach the user
+func (self *gzipResponseWriter) Flush() {
+	if self.gzipWriter != nil {
+		self.gzipWriter.Flush()
+	}
+
+	var flusher, ok = self.ResponseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
+
+func (self *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return self.ResponseWriter
+}
+
+// finish the compressed body and return the gzip writer to the pool
+func (self *gzipResponseWriter) close() {
+	if self.gzipWriter == nil {
+		return
+	}
+
+	self.gzipWriter.Close()
+	self.writers.Put(self.gzipWriter)
+	self.gzipWriter = nil
+}
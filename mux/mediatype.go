@@ -0,0 +1,54 @@
+package mux
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// http handler that only calls another http handler if the request body has one of the allowed media types
+// requests with any other Content-Type (or none at all) get a 415 before their body is read
+// so a client sending the wrong kind of body (i.e. form data) gets a clear error rather than a validation error
+// an optional charset parameter is allowed as long as it is utf-8
+type ContentTypeMiddleware struct {
+	// media types (i.e. application/json) the body can have
+	MediaTypes []string
+	Handler    http.Handler
+}
+
+// call the wrapped handler if the request has an allowed Content-Type
+func (self ContentTypeMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if !self.allowed(request.Header.Get("Content-Type")) {
+		WriteJsonResponse(writer, HttpError{
+			Code:        http.StatusUnsupportedMediaType,
+			Description: fmt.Sprintf("The Content-Type of the request must be %s", strings.Join(self.MediaTypes, " or ")),
+		})
+		return
+	}
+
+	self.Handler.ServeHTTP(writer, request)
+}
+
+// check if a Content-Type header value is one of the allowed media types
+func (self ContentTypeMiddleware) allowed(contentType string) bool {
+	var mediaType, params, err = mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for name, value := range params {
+		if name != "charset" || !strings.EqualFold(value, "utf-8") {
+			return false
+		}
+	}
+
+	for _, allowedMediaType := range self.MediaTypes {
+		// ParseMediaType lower cases the media type
+		if strings.EqualFold(allowedMediaType, mediaType) {
+			return true
+		}
+	}
+
+	return false
+}
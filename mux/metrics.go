@@ -0,0 +1,270 @@
+package mux
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// bucket upper bounds suited to request and response body sizes in bytes (256B to 16MiB)
+var ByteSizeBuckets = []float64{256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216}
+
+// Histogram counts observed values in buckets with fixed upper bounds
+// it implements expvar.Var so it can be published using expvar.Publish
+type Histogram struct {
+	lock sync.Mutex
+	// upper bounds of the buckets in increasing order
+	bounds []float64
+	// number of observations in each bucket
+	// the last bucket holds observations larger than every bound
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+// create a histogram using the bucket upper bounds provided
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)+1),
+	}
+}
+
+// add a value to the histogram
+func (self *Histogram) Observe(value float64) {
+	// the first bucket whose bound is not smaller than the value
+	var bucket = sort.SearchFloat64s(self.bounds, value)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.counts[bucket]++
+	self.count++
+	self.sum += value
+}
+
+// histogramBucket is the json representation of one histogram bucket
+// the count is cumulative so it includes every observation less than or equal to the bound
+type histogramBucket struct {
+	Le    string `json:"le"`
+	Count int64  `json:"count"`
+}
+
+// histogramSnapshot is the json representation of a histogram
+type histogramSnapshot struct {
+	Count   int64             `json:"count"`
+	Sum     float64           `json:"sum"`
+	Buckets []histogramBucket `json:"buckets"`
+}
+
+func (self *Histogram) snapshot() histogramSnapshot {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var snapshot = histogramSnapshot{
+		Count:   self.count,
+		Sum:     self.sum,
+		Buckets: make([]histogramBucket, 0, len(self.counts)),
+	}
+
+	var cumulative int64
+	for i, count := range self.counts {
+		cumulative += count
+
+		var le = "+Inf"
+		if i < len(self.bounds) {
+			le = strconv.FormatFloat(self.bounds[i], 'f', -1, 64)
+		}
+
+		snapshot.Buckets = append(snapshot.Buckets, histogramBucket{Le: le, Count: cumulative})
+	}
+
+	return snapshot
+}
+
+// create a json representation of the histogram
+func (self *Histogram) String() string {
+	var d, _ = json.Marshal(self.snapshot())
+
+	return string(d)
+}
+
+// HistogramVec is a set of histograms with the same buckets that are labeled by a value (i.e. the route)
+// it implements expvar.Var so it can be published using expvar.Publish
+type HistogramVec struct {
+	lock       sync.Mutex
+	bounds     []float64
+	histograms map[string]*Histogram
+}
+
+// create a histogram vec whose histograms use the bucket upper bounds provided
+func NewHistogramVec(bounds []float64) *HistogramVec {
+	return &HistogramVec{
+		bounds:     bounds,
+		histograms: make(map[string]*Histogram),
+	}
+}
+
+// get the histogram for a label creating it if it does not exist
+func (self *HistogramVec) With(label string) *Histogram {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var histogram, ok = self.histograms[label]
+	if !ok {
+		histogram = NewHistogram(self.bounds)
+		self.histograms[label] = histogram
+	}
+
+	return histogram
+}
+
+// create a json representation of every histogram keyed by its label
+func (self *HistogramVec) String() string {
+	self.lock.Lock()
+	var snapshots = make(map[string]histogramSnapshot, len(self.histograms))
+	for label, histogram := range self.histograms {
+		snapshots[label] = histogram.snapshot()
+	}
+	self.lock.Unlock()
+
+	var d, _ = json.Marshal(snapshots)
+
+	return string(d)
+}
+
+// label used for requests that do not match a route
+const unmatchedRoute = "unmatched"
+
+// http handler that records metrics about each request before calling another http handler
+type MetricsMiddleware struct {
+	// get the route a request matches
+	// it should return a template (i.e. /events) rather than the path so the number of labels stays small
+	// when Route is nil the template from a RouteRegistry wrapped by the middleware is used
+	// requests are labeled unmatched if there is no route
+	Route func(*http.Request) string
+	// sizes of the request bodies read by the wrapped handler labeled by route
+	// nothing is recorded if it is nil
+	RequestBytes *HistogramVec
+	// sizes of the response bodies written by the wrapped handler labeled by route
+	// nothing is recorded if it is nil
+	ResponseBytes *HistogramVec
+	// http handler whose requests are measured
+	Handler http.Handler
+}
+
+// call the wrapped handler and record the metrics once it has finished
+func (self MetricsMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	// the route is only known once a route registry has handled the request
+	request = withRouteMatch(request)
+
+	// count the body bytes the handler reads
+	var body = &countingReader{ReadCloser: request.Body}
+	request.Body = body
+
+	var capture = &responseCapture{ResponseWriter: writer}
+
+	self.Handler.ServeHTTP(capture, request)
+
+	var route string
+	if self.Route != nil {
+		route = self.Route(request)
+	} else {
+		route = RouteTemplate(request)
+	}
+	if len(route) == 0 {
+		route = unmatchedRoute
+	}
+
+	if self.RequestBytes != nil {
+		self.RequestBytes.With(route).Observe(float64(body.bytesRead))
+	}
+	if self.ResponseBytes != nil {
+		self.ResponseBytes.With(route).Observe(float64(capture.bytesWritten))
+	}
+}
+
+// request body that counts the bytes read from it
+type countingReader struct {
+	io.ReadCloser
+	bytesRead int64
+}
+
+func (self *countingReader) Read(p []byte) (int, error) {
+	var n, err = self.ReadCloser.Read(p)
+	self.bytesRead += int64(n)
+
+	return n, err
+}
+
+// response writer that captures the status code and the number of body bytes written
+// so they can be used after the handler has finished
+type responseCapture struct {
+	http.ResponseWriter
+	statusCode   int
+	bytesWritten int64
+}
+
+func (self *responseCapture) WriteHeader(statusCode int) {
+	if self.statusCode == 0 {
+		self.statusCode = statusCode
+	}
+
+	self.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (self *responseCapture) Write(d []byte) (int, error) {
+	if self.statusCode == 0 {
+		self.statusCode = http.StatusOK
+	}
+
+	var n, err = self.ResponseWriter.Write(d)
+	self.bytesWritten += int64(n)
+
+	return n, err
+}
+
+// pass flushes through so streamed responses still reach the user
+func (self *responseCapture) Flush() {
+	var flusher, ok = self.ResponseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
+
+func (self *responseCapture) Unwrap() http.ResponseWriter {
+	return self.ResponseWriter
+}
+
+// vars expvar publishes about the process itself that MetricsHandler leaves out
+// cmdline can hold secrets passed as arguments and memstats describes the internals of the process
+var processMetricVars = map[string]struct{}{"cmdline": {}, "memstats": {}}
+
+// MetricsHandler serves the vars published using expvar.Publish as a json object the same way expvar.Handler does
+// except the vars expvar publishes about the process are left out so the endpoint can be served without authentication
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		io.WriteString(writer, "{\n")
+		var first = true
+		expvar.Do(func(kv expvar.KeyValue) {
+			if _, ok := processMetricVars[kv.Key]; ok {
+				return
+			}
+
+			if !first {
+				io.WriteString(writer, ",\n")
+			}
+			first = false
+
+			fmt.Fprintf(writer, "%q: %s", kv.Key, kv.Value)
+		})
+		io.WriteString(writer, "\n}\n")
+	})
+}
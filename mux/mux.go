@@ -1,20 +1,28 @@
 package mux
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
+	"math/rand"
 	"net/http"
+	"path"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
 )
 
 // WriteJsonResponse is a generic way of writing an http response with a json body
 // the function determines what http status code to write based on the type of v
 // if v is nil then the status code will be 204
-// if v is an error the status code will either be HttpError.Code
-// of a 500 if the the error is not of type HttpError
+// if v is an error the status code will either be HttpError.Code, StatusCoder.StatusCode()
+// or a 500 if the the error is neither of those types
 // if v is any non error value the function will attempt to marshal it to json
-// and send a 200 and the json body to the user
+// and send a 200 (or StatusCoder.StatusCode() if v implements it) and the json body to the user
 func WriteJsonResponse(writer http.ResponseWriter, v interface{}) {
 	var statusCode int
 	var responseBytes []byte
@@ -25,17 +33,30 @@ func WriteJsonResponse(writer http.ResponseWriter, v interface{}) {
 
 		if ok {
 			// narrow the error down further to determine if it is an HttpError
-			httpErr, ok := e.(HttpError)
+			// or another error type that knows which status code it should be sent with
+			httpErr, isHttpErr := e.(HttpError)
+			statusErr, isStatusErr := e.(StatusCoder)
+
+			// Retry-After is a whole number of seconds so it is rounded up to make sure clients wait long enough
+			if retryErr, ok := e.(RetryAfterError); ok {
+				var seconds = int64((retryErr.RetryAfter + time.Second - 1) / time.Second)
+				writer.Header().Set("Retry-After", fmt.Sprintf("%d", seconds))
+			}
 			// if the error was not an http error then we have an internal server error
-			if !ok {
+			if isHttpErr {
+				statusCode = httpErr.Code
+			} else if isStatusErr {
+				statusCode = statusErr.StatusCode()
+			} else {
 				v = HttpError{
 					Description: e.Error(),
 				}
 
 				statusCode = 500
-			} else {
-				statusCode = httpErr.Code
 			}
+		} else if statusValue, ok := v.(StatusCoder); ok {
+			// non error values can also choose their status code (i.e. 207 Multi-Status)
+			statusCode = statusValue.StatusCode()
 		}
 
 		var err error
@@ -66,15 +87,117 @@ func WriteJsonResponse(writer http.ResponseWriter, v interface{}) {
 	writer.Write(responseBytes)
 }
 
+// check if the request lists the media type (i.e. text/csv) in its Accept header
+// parameters like q=0.5 are ignored
+func Accepts(request *http.Request, mediaType string) bool {
+	for _, accept := range request.Header.Values("Accept") {
+		for _, acceptedType := range strings.Split(accept, ",") {
+			// remove any parameters from the media type
+			acceptedType = strings.Split(acceptedType, ";")[0]
+
+			if strings.EqualFold(strings.TrimSpace(acceptedType), mediaType) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// http handler that lets clients that can not set an Accept header pick the response format
+// using an extension on the path (i.e. /events.csv) before calling another http handler
+// the extension is removed from the path and replaced with the matching Accept header
+// paths with an extension that is not in Formats get a 404
+type FormatExtensionMiddleware struct {
+	// extensions (without the dot) mapped to the media type they stand for (i.e. csv: text/csv)
+	Formats map[string]string
+	Handler http.Handler
+}
+
+// swap the path extension for an Accept header and call the wrapped handler
+func (self FormatExtensionMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var extension = path.Ext(request.URL.Path)
+	if len(extension) == 0 {
+		self.Handler.ServeHTTP(writer, request)
+		return
+	}
+
+	var mediaType, ok = self.Formats[strings.TrimPrefix(extension, ".")]
+	if !ok {
+		WriteJsonResponse(writer, DefaultHttpError(http.StatusNotFound))
+		return
+	}
+
+	// the wrapped handler gets a copy so the original request is left unchanged
+	request = request.Clone(request.Context())
+	request.URL.Path = strings.TrimSuffix(request.URL.Path, extension)
+	request.URL.RawPath = ""
+	request.Header.Set("Accept", mediaType)
+
+	self.Handler.ServeHTTP(writer, request)
+}
+
 // http handler that authenticates a request and calls another http handler
 // if authentication is successful
 type AuthenticationMiddleware struct {
 	// token to use when authenticating requests
 	Token string
+	// store holding a token that can be changed while the server is running
+	// it is used instead of Token if it is not nil
+	TokenStore *TokenStore
+	// more tokens that are accepted the same way as Token (i.e. one for each producer)
+	// authentication is only turned off if Token and Tokens are both empty
+	Tokens []string
+	// name of the token that handlers can get using TokenName (i.e. to record who added an event)
+	// the name is not added to the request if it is empty
+	Name string
+	// tokens that are only allowed to make requests using some http methods
+	// (i.e. an ingest token that can only add events)
+	RestrictedTokens []RestrictedToken
+	// name of a request header holding the identity of the caller (i.e. X-Forwarded-User)
+	// that handlers and the logging middleware can get using Identity
+	// it is only read when authentication is disabled so a deployment behind an external auth gateway
+	// can still record who made a request
+	// the gateway must overwrite the header since it is trusted as it is sent
+	IdentityHeader string
 	// http handler to call if authentication succeeds
 	Handler http.Handler
 }
 
+// RestrictedToken is a token that can only be used with the http methods listed
+// requests using any other method get a 403 response
+type RestrictedToken struct {
+	Token string
+	// name of the token that handlers can get using TokenName
+	Name    string
+	Methods []string
+}
+
+// scopes a token can be given to limit the requests it can make
+const (
+	// the token can only read (i.e. query events)
+	ScopeRead = "read"
+	// the token can read and change (i.e. add events)
+	ScopeWrite = "write"
+)
+
+// the http methods each scope allows
+var ScopeMethods = map[string][]string{
+	ScopeRead:  {http.MethodGet, http.MethodHead},
+	ScopeWrite: {http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+}
+
+// create a restricted token that can only make the requests its scope allows
+// the scope is used as the name of the token so handlers can get it using TokenName
+func ScopedToken(token string, scope string) (RestrictedToken, error) {
+	var methods, ok = ScopeMethods[scope]
+	if !ok {
+		return RestrictedToken{}, fmt.Errorf("The scope %q is not recognized. Valid scopes are %s, %s", scope, ScopeRead, ScopeWrite)
+	}
+
+	return RestrictedToken{Token: token, Name: scope, Methods: methods}, nil
+}
+
 // authenticate a request and call the wrapped handler if authentication is successful
 // if an empty authentication token was provided then we will not do any authenticaion
 // TODO using a single api token is not a very secure authentication method
@@ -104,32 +227,560 @@ func (self AuthenticationMiddleware) ServeHTTP(writer http.ResponseWriter, reque
 
 	// if authentication was successful then call the next http handler
 	// if authentication was not successful then send back a 401 response
-	if userToken == self.Token {
-		self.Handler.ServeHTTP(writer, request)
-	} else {
-		var err = DefaultHttpError(http.StatusUnauthorized)
+	if self.authenticates(userToken) {
+		self.serveAuthenticated(writer, request, self.Name)
+		return
+	}
+
+	// restricted tokens are valid credentials so using one with the wrong method is forbidden rather than unauthorized
+	for _, restrictedToken := range self.RestrictedTokens {
+		if len(restrictedToken.Token) == 0 || userToken != restrictedToken.Token {
+			continue
+		}
+
+		for _, method := range restrictedToken.Methods {
+			if request.Method == method {
+				self.serveAuthenticated(writer, request, restrictedToken.Name)
+				return
+			}
+		}
+
+		WriteJsonResponse(writer, DefaultHttpError(http.StatusForbidden))
+		return
+	}
+
+	var err = DefaultHttpError(http.StatusUnauthorized)
+
+	WriteJsonResponse(writer, err)
+}
+
+// get the token requests are authenticated with
+func (self AuthenticationMiddleware) token() string {
+	if self.TokenStore != nil {
+		return self.TokenStore.Get()
+	}
+
+	return self.Token
+}
+
+// check if a user token matches the token or any of the other tokens
+func (self AuthenticationMiddleware) authenticates(userToken string) bool {
+	// an empty token would match requests without a token so it is only used when there are no other tokens
+	// including the restricted tokens
+	var token = self.token()
+	if (len(token) != 0 || self.disabled()) && userToken == token {
+		return true
+	}
+
+	for _, token := range self.Tokens {
+		if len(token) != 0 && userToken == token {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidToken checks if a token is one of the tokens requests can be made with
+// including the restricted tokens
+// an empty token is never valid
+func (self AuthenticationMiddleware) ValidToken(token string) bool {
+	if len(token) == 0 {
+		return false
+	}
+
+	if self.authenticates(token) {
+		return true
+	}
+
+	for _, restrictedToken := range self.RestrictedTokens {
+		if restrictedToken.Token == token {
+			return true
+		}
+	}
+
+	return false
+}
+
+// check if authentication is turned off because there are no tokens
+func (self AuthenticationMiddleware) disabled() bool {
+	if len(self.token()) != 0 {
+		return false
+	}
+
+	for _, token := range self.Tokens {
+		if len(token) != 0 {
+			return false
+		}
+	}
+
+	// a restricted token (i.e. only an ingest token) still turns authentication on
+	for _, restrictedToken := range self.RestrictedTokens {
+		if len(restrictedToken.Token) != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// add the name of the token to the request and call the wrapped handler
+func (self AuthenticationMiddleware) serveAuthenticated(writer http.ResponseWriter, request *http.Request, name string) {
+	if len(name) != 0 {
+		request = request.WithContext(context.WithValue(request.Context(), tokenNameKey{}, name))
+	}
+
+	// the header is only trusted when there is no token since it would otherwise let
+	// any caller with a token claim to be someone else
+	if len(self.IdentityHeader) != 0 && self.disabled() {
+		var identity = request.Header.Get(self.IdentityHeader)
+		if len(identity) != 0 {
+			request = request.WithContext(context.WithValue(request.Context(), identityKey{}, identity))
+		}
+	}
+
+	self.Handler.ServeHTTP(writer, request)
+}
+
+// TokenStore holds an authentication token that can be rotated without restarting the server
+type TokenStore struct {
+	lock  sync.RWMutex
+	token string
+}
+
+// create a token store holding the initial token
+func NewTokenStore(token string) *TokenStore {
+	return &TokenStore{token: token}
+}
+
+// get the current token
+func (self *TokenStore) Get() string {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	return self.token
+}
+
+// replace the current token
+// requests that have already been authenticated are not affected
+func (self *TokenStore) Set(token string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.token = token
+}
+
+// TokenRotateHandler creates an http handler that replaces the token in a token store
+// the body is a json object with the new token (i.e. {"token":"<new token>"})
+// the old token stops working as soon as the response is sent
+func TokenRotateHandler(store *TokenStore) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var body struct {
+			Token string `json:"token"`
+		}
+
+		var err = json.NewDecoder(request.Body).Decode(&body)
+		// an empty token would turn authentication off so it is never accepted
+		if err != nil || len(body.Token) == 0 {
+			err = HttpError{
+				Code:        http.StatusBadRequest,
+				Description: "The request body must be a json object with a non empty token",
+			}
+		} else {
+			store.Set(body.Token)
+		}
 
 		WriteJsonResponse(writer, err)
+	})
+}
+
+// key used to store the name of the token a request was authenticated with in the request context
+type tokenNameKey struct{}
+
+// TokenName gets the name of the token a request was authenticated with
+// an empty string is returned if the request was not authenticated using a named token
+func TokenName(request *http.Request) string {
+	var name, _ = request.Context().Value(tokenNameKey{}).(string)
+
+	return name
+}
+
+// key used to store the identity of the caller in the request context
+type identityKey struct{}
+
+// Identity gets the identity of the caller taken from the identity header of an AuthenticationMiddleware
+// an empty string is returned if authentication is enabled or the request did not have the header
+func Identity(request *http.Request) string {
+	var identity, _ = request.Context().Value(identityKey{}).(string)
+
+	return identity
+}
+
+// http handler that rejects requests with too many headers or oversized header values
+// before calling another http handler
+// this complements the http.Server MaxHeaderBytes setting which only limits the total header size
+type HeaderLimitMiddleware struct {
+	// maximum number of header values a request can have
+	// 0 means there is no limit
+	MaxHeaders int
+	// maximum size in bytes of any single header value
+	// 0 means there is no limit
+	MaxHeaderValueBytes int
+	// http handler to call if the headers are within the limits
+	Handler http.Handler
+}
+
+// check the request headers against the limits and call the wrapped handler if they are within them
+// requests that exceed a limit get a 431 response
+func (self HeaderLimitMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var headerCount int
+	var headersTooLarge bool
+
+	for _, values := range request.Header {
+		headerCount += len(values)
+
+		for _, value := range values {
+			if self.MaxHeaderValueBytes > 0 && len(value) > self.MaxHeaderValueBytes {
+				headersTooLarge = true
+			}
+		}
+	}
+
+	if self.MaxHeaders > 0 && headerCount > self.MaxHeaders {
+		headersTooLarge = true
+	}
+
+	if headersTooLarge {
+		var err = DefaultHttpError(http.StatusRequestHeaderFieldsTooLarge)
+
+		WriteJsonResponse(writer, err)
+	} else {
+		self.Handler.ServeHTTP(writer, request)
 	}
 }
 
-// logging middleware to log each time there is a new request
+// http handler that adds a fixed set of headers to every response before calling another http handler
+// (i.e. X-Service-Name or Cache-Control for proxies and CDNs)
+type HeaderMiddleware struct {
+	// header names mapped to the value sent in every response
+	Headers map[string]string
+	// when Override is false headers set by the wrapped handler (i.e. Content-Type) are kept
+	// when it is true the configured values replace them
+	Override bool
+	// http handler whose responses get the headers
+	Handler http.Handler
+}
+
+// add the headers and call the wrapped handler
+func (self HeaderMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if !self.Override {
+		// the handler can replace any of these since they are set before it runs
+		for name, value := range self.Headers {
+			writer.Header().Set(name, value)
+		}
+
+		self.Handler.ServeHTTP(writer, request)
+		return
+	}
+
+	// the headers have to be set after the handler sets its own but before they are sent
+	self.Handler.ServeHTTP(&headerOverrideWriter{ResponseWriter: writer, headers: self.Headers}, request)
+}
+
+// response writer that sets headers right before they are sent
+type headerOverrideWriter struct {
+	http.ResponseWriter
+	headers     map[string]string
+	wroteHeader bool
+}
+
+func (self *headerOverrideWriter) WriteHeader(statusCode int) {
+	if !self.wroteHeader {
+		self.wroteHeader = true
+
+		for name, value := range self.headers {
+			self.Header().Set(name, value)
+		}
+	}
+
+	self.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (self *headerOverrideWriter) Write(d []byte) (int, error) {
+	if !self.wroteHeader {
+		self.WriteHeader(http.StatusOK)
+	}
+
+	return self.ResponseWriter.Write(d)
+}
+
+// pass flushes through so streamed responses still reach the user
+func (self *headerOverrideWriter) Flush() {
+	var flusher, ok = self.ResponseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
+
+func (self *headerOverrideWriter) Unwrap() http.ResponseWriter {
+	return self.ResponseWriter
+}
+
+// request attributes the LoggingMiddleware can include in its log line
+const (
+	LogFieldMethod     = "method"
+	LogFieldPath       = "path"
+	LogFieldQuery      = "query"
+	LogFieldRemoteAddr = "remote_addr"
+	// the identity of the caller (see AuthenticationMiddleware.IdentityHeader)
+	LogFieldIdentity = "identity"
+)
+
+// the request attributes that can be logged
+// the query is not logged by default since filter values may contain sensitive data
+var LogFields = []string{LogFieldMethod, LogFieldPath, LogFieldQuery, LogFieldRemoteAddr, LogFieldIdentity}
+
+// the privacy safe request attributes logged when none are configured
+var DefaultLogFields = []string{LogFieldMethod, LogFieldPath}
+
+// logging middleware to log each request once it has finished along with its status and how long it took
 type LoggingMiddleware struct {
-	Logger  *log.Logger
+	Logger *log.Logger
+	// request attributes to include in the log line (see LogFields)
+	// DefaultLogFields are used if this is empty
+	Fields []string
+	// names of the request headers whose values are included in the log line
+	Headers []string
+	// requests that take longer than this from start to finish are logged as a warning
+	// along with the time spent in each phase of the request
+	// slow requests are not logged if this is 0
+	SlowRequestThreshold time.Duration
+	// fraction (between 0 and 1) of successful requests that are logged
+	// requests that fail with a 400 or above and slow requests are always logged
+	// every request is logged when it is 0
+	SampleRate float64
+	// name of a request header (i.e. X-Request-Id) whose value decides if a request is sampled
+	// so requests sharing a value are either all logged or all left out
+	// requests without the header are sampled at random
+	SampleHeader string
+	// how each request is logged (see LogFormats)
+	// an empty string means LogFormatText
+	Format  string
 	Handler http.Handler
 }
 
-// log that a new request was made then call the next http handler
+// get the value of a request attribute (see LogFields)
+func logFieldValue(request *http.Request, field string) string {
+	switch field {
+	case LogFieldMethod:
+		return request.Method
+	case LogFieldPath:
+		if request.URL != nil {
+			return request.URL.Path
+		}
+	case LogFieldQuery:
+		if request.URL != nil {
+			return request.URL.RawQuery
+		}
+	case LogFieldRemoteAddr:
+		return request.RemoteAddr
+	case LogFieldIdentity:
+		return Identity(request)
+	}
+
+	return ""
+}
+
+// call the next http handler then log the request along with its status and duration
 func (self LoggingMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	self.Logger.Println("New Request")
+	if self.Format == LogFormatJson {
+		self.serveJson(writer, request)
+		return
+	}
 
-	// TODO ideally we would wrap the response writer so we can read
-	// the response before it gets sent back to the user
-	// this would allow us to swap 500 level error descriptions for default 500 level errors
-	// so that no sensitive info gets sent to the user
-	// we could also log the descriptive 500 level error at this time
+	var fields = self.Fields
+	if len(fields) == 0 {
+		fields = DefaultLogFields
+	}
 
-	self.Handler.ServeHTTP(writer, request)
+	var requestAttributes string
+
+	for _, field := range fields {
+		requestAttributes = fmt.Sprintf("%s %s=%q", requestAttributes, field, logFieldValue(request, field))
+	}
+
+	for _, header := range self.Headers {
+		requestAttributes = fmt.Sprintf("%s %s=%q", requestAttributes, http.CanonicalHeaderKey(header), request.Header.Get(header))
+	}
+
+	// requests are logged once they finish so the log line includes how they went and how long they took
+	var result = self.serveTimed(writer, request)
+	request = result.request
+
+	// when sampling the status decides if the request is logged
+	var sampling = self.SampleRate > 0 && self.SampleRate < 1
+	if !sampling || self.logged(result) {
+		self.Logger.Printf("New Request%s status=%d duration_ms=%.3f\n",
+			requestAttributes, result.statusCode, durationMilliseconds(result.phases.Duration))
+	}
+
+	if !result.slow {
+		return
+	}
+
+	var phases = result.phases
+	self.Logger.Printf("WARNING Slow Request%s route=%q status=%d duration=%s read_body=%s handler=%s write_response=%s dominant_phase=%s\n",
+		requestAttributes, RouteTemplate(request), result.statusCode, phases.Duration, phases.ReadBody, phases.Handler, phases.WriteResponse, phases.dominant())
+}
+
+// what happened to a request handled by serveTimed
+type timedRequest struct {
+	// the request the handler was called with which includes the matched route
+	request    *http.Request
+	statusCode int
+	// size of the response body in bytes
+	bytesWritten int64
+	phases       requestPhases
+	// the request took longer than the slow request threshold
+	slow bool
+}
+
+// call the wrapped handler and measure the response and how long each phase of the request took
+func (self LoggingMiddleware) serveTimed(writer http.ResponseWriter, request *http.Request) timedRequest {
+	var start = time.Now()
+
+	// the route is added to the slow request warning once a route registry has handled the request
+	request = withRouteMatch(request)
+
+	// time spent reading the body and writing the response is measured separately
+	// so slow clients can be told apart from slow handlers
+	var body *timedReader
+	if request.Body != nil {
+		body = &timedReader{ReadCloser: request.Body}
+		request.Body = body
+	}
+	var timedWriter = &timedResponseWriter{responseCapture: responseCapture{ResponseWriter: writer}}
+
+	self.Handler.ServeHTTP(timedWriter, request)
+
+	var duration = time.Since(start)
+
+	// net/http sends a 200 if the handler did not write anything
+	var statusCode = timedWriter.statusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+
+	var phases = requestPhases{
+		Duration:      duration,
+		ReadBody:      body.elapsed(),
+		WriteResponse: timedWriter.writeTime,
+	}
+	phases.Handler = duration - phases.ReadBody - phases.WriteResponse
+
+	return timedRequest{
+		request:      request,
+		statusCode:   statusCode,
+		bytesWritten: timedWriter.bytesWritten,
+		phases:       phases,
+		slow:         self.SlowRequestThreshold > 0 && duration > self.SlowRequestThreshold,
+	}
+}
+
+// check if a finished request is logged when sampling
+// failed and slow requests are always logged
+func (self LoggingMiddleware) logged(result timedRequest) bool {
+	return result.statusCode >= http.StatusBadRequest || result.slow || self.sampled(result.request)
+}
+
+// number of buckets request keys are hashed into when sampling
+// the sample rate decides how many of the buckets are logged
+const sampleBuckets = 10000
+
+// check if a successful request is one of the sampled requests that are logged
+func (self LoggingMiddleware) sampled(request *http.Request) bool {
+	var key string
+	if len(self.SampleHeader) != 0 {
+		key = request.Header.Get(self.SampleHeader)
+	}
+
+	if len(key) == 0 {
+		return rand.Float64() < self.SampleRate
+	}
+
+	// the hash of the key spreads the keys evenly between the buckets
+	var hash = fnv.New64a()
+	hash.Write([]byte(key))
+
+	return hash.Sum64()%sampleBuckets < uint64(self.SampleRate*sampleBuckets)
+}
+
+// time spent in each phase of a request
+type requestPhases struct {
+	Duration time.Duration
+	// time spent waiting on the client to send the body
+	ReadBody time.Duration
+	// time spent in the handler that was not spent reading or writing
+	Handler time.Duration
+	// time spent waiting on the client to receive the response
+	WriteResponse time.Duration
+}
+
+// get the name of the phase that took the longest
+func (self requestPhases) dominant() string {
+	var name, longest = "handler", self.Handler
+
+	if self.ReadBody > longest {
+		name, longest = "read_body", self.ReadBody
+	}
+	if self.WriteResponse > longest {
+		name = "write_response"
+	}
+
+	return name
+}
+
+// request body that records the time spent reading from it
+type timedReader struct {
+	io.ReadCloser
+	readTime time.Duration
+}
+
+func (self *timedReader) Read(p []byte) (int, error) {
+	var start = time.Now()
+	var n, err = self.ReadCloser.Read(p)
+	self.readTime += time.Since(start)
+
+	return n, err
+}
+
+// get the time spent reading the body or 0 if the request did not have one
+func (self *timedReader) elapsed() time.Duration {
+	if self == nil {
+		return 0
+	}
+
+	return self.readTime
+}
+
+// response writer that records the time spent writing and flushing the response
+type timedResponseWriter struct {
+	responseCapture
+	writeTime time.Duration
+}
+
+func (self *timedResponseWriter) Write(d []byte) (int, error) {
+	var start = time.Now()
+	var n, err = self.responseCapture.Write(d)
+	self.writeTime += time.Since(start)
+
+	return n, err
+}
+
+func (self *timedResponseWriter) Flush() {
+	var start = time.Now()
+	self.responseCapture.Flush()
+	self.writeTime += time.Since(start)
 }
 
 // http handler router that can be used to register (and dispatch to) handlers for specific http methods
@@ -0,0 +1,145 @@
+package mux
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// how often the limiters of clients that have stopped making requests are removed
+const rateLimitCleanupInterval = time.Minute
+
+// regular expression for matching a bearer token
+var bearerTokenRegex = regexp.MustCompile("^[Bb]earer (.+)$")
+
+// RateLimitMiddleware is an http handler that limits how many requests each client can make
+// before calling another http handler
+// every client gets its own token bucket so one client flooding the service does not limit the others
+// clients are told apart by their bearer token or by their ip address if they do not send a valid one
+// requests over the limit get a 429 with a Retry-After header
+type RateLimitMiddleware struct {
+	// check if a bearer token is one the service accepts (i.e. AuthenticationMiddleware.ValidToken)
+	// requests with any other token are limited by their ip address so made up tokens
+	// can not get around the limit or fill the clients map
+	// nil means every token is treated as valid
+	ValidToken func(token string) bool
+
+	// requests per second each client can make on average
+	limit rate.Limit
+	// requests a client can make at once before it is limited
+	burst   int
+	handler http.Handler
+
+	lock    sync.Mutex
+	clients map[string]*rateLimitClient
+	// when the limiters of idle clients were last removed
+	lastCleanup time.Time
+}
+
+// the token bucket of a client
+type rateLimitClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// create a rate limit middleware that lets each client make requestsPerSecond requests per second
+// with bursts of up to burst requests
+// a burst less than 1 is treated as 1 since a client could not make any requests otherwise
+func NewRateLimitMiddleware(requestsPerSecond float64, burst int, handler http.Handler) *RateLimitMiddleware {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RateLimitMiddleware{
+		limit:       rate.Limit(requestsPerSecond),
+		burst:       burst,
+		handler:     handler,
+		clients:     make(map[string]*rateLimitClient),
+		lastCleanup: time.Now(),
+	}
+}
+
+// call the wrapped handler if the client has not made too many requests
+func (self *RateLimitMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var now = time.Now()
+	var limiter = self.limiter(self.key(request), now)
+
+	// a reservation tells us how long the client has to wait if the request is over the limit
+	var reservation = limiter.ReserveN(now, 1)
+	var delay = reservation.DelayFrom(now)
+	if delay > 0 {
+		// the request is not made so it should not use up a later request
+		reservation.CancelAt(now)
+
+		WriteJsonResponse(writer, RetryAfterError{
+			HttpError:  DefaultHttpError(http.StatusTooManyRequests),
+			RetryAfter: delay,
+		})
+		return
+	}
+
+	self.handler.ServeHTTP(writer, request)
+}
+
+// get the limiter of a client creating it if the client has not made a request recently
+func (self *RateLimitMiddleware) limiter(key string, now time.Time) *rate.Limiter {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if now.Sub(self.lastCleanup) >= rateLimitCleanupInterval {
+		self.cleanup(now)
+	}
+
+	var client, ok = self.clients[key]
+	if !ok {
+		client = &rateLimitClient{limiter: rate.NewLimiter(self.limit, self.burst)}
+		self.clients[key] = client
+	}
+	client.lastSeen = now
+
+	return client.limiter
+}
+
+// remove the limiters of clients that have not made a request since their bucket filled up again
+// a new limiter starts with a full bucket so removing them does not change what the clients can do
+// the lock must be held
+func (self *RateLimitMiddleware) cleanup(now time.Time) {
+	var idle = rateLimitCleanupInterval
+	if self.limit > 0 {
+		var refill = time.Duration(math.Ceil(float64(self.burst) / float64(self.limit) * float64(time.Second)))
+		if refill > idle {
+			idle = refill
+		}
+	}
+
+	for key, client := range self.clients {
+		if now.Sub(client.lastSeen) >= idle {
+			delete(self.clients, key)
+		}
+	}
+
+	self.lastCleanup = now
+}
+
+// get the key a request is rate limited by
+// the bearer token is used if the request has a valid one and the ip address of the client otherwise
+// the keys are prefixed so a token can never share a bucket with an ip address
+func (self *RateLimitMiddleware) key(request *http.Request) string {
+	var matches = bearerTokenRegex.FindStringSubmatch(request.Header.Get("Authorization"))
+	if len(matches) > 0 && (self.ValidToken == nil || self.ValidToken(matches[1])) {
+		return "token:" + matches[1]
+	}
+
+	// RemoteAddr is host:port but tests and some servers only set the host
+	var host, _, err = net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+
+	return "ip:" + host
+}
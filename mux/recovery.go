@@ -0,0 +1,46 @@
+package mux
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// http handler that recovers from panics in another http handler
+// the panic and stack trace are logged and the client gets a generic 500
+// so the details of the panic are never sent to the client
+type RecoveryMiddleware struct {
+	Logger  *log.Logger
+	Handler http.Handler
+}
+
+// call the wrapped handler and send a 500 if it panics
+func (self RecoveryMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var capture = &responseCapture{ResponseWriter: writer}
+
+	defer func() {
+		var r = recover()
+		if r == nil {
+			return
+		}
+
+		// net/http uses this panic to abort a response on purpose so it is passed on
+		if r == http.ErrAbortHandler {
+			panic(r)
+		}
+
+		if self.Logger != nil {
+			self.Logger.Printf("A panic occured while handling %s %s: %v\n%s", request.Method, request.URL.Path, r, debug.Stack())
+		}
+
+		// a response that has already started can not be replaced so the connection is aborted instead
+		// the client then knows the response is incomplete
+		if capture.statusCode != 0 {
+			panic(http.ErrAbortHandler)
+		}
+
+		WriteJsonResponse(writer, DefaultHttpError(http.StatusInternalServerError))
+	}()
+
+	self.Handler.ServeHTTP(capture, request)
+}
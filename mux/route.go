@@ -0,0 +1,126 @@
+package mux
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// RouteRegistry is an http multiplexer that knows the template of the route each request matches
+// (i.e. /events/{id}/context rather than /events/5f1d7f3e8a9b1c2d3e4f5a6b/context)
+// templates let metrics, logs and rate limits be grouped by route without a label for every id
+type RouteRegistry struct {
+	serveMux *http.ServeMux
+	// the patterns handlers were registered with mapped to the templates of the paths under them
+	templates map[string][]string
+}
+
+// create an empty route registry
+func NewRouteRegistry() *RouteRegistry {
+	return &RouteRegistry{
+		serveMux:  http.NewServeMux(),
+		templates: make(map[string][]string),
+	}
+}
+
+// register a handler for a pattern the same way http.ServeMux does
+// a pattern ending in a slash matches every path under it so the templates of those paths can be listed
+// a template segment in braces (i.e. {id}) matches any single path segment
+// paths that do not match any of the templates use the pattern as their template
+func (self *RouteRegistry) Handle(pattern string, handler http.Handler, templates ...string) {
+	self.serveMux.Handle(pattern, handler)
+	self.templates[pattern] = templates
+}
+
+// get the template of the route a request matches
+// an empty string is returned if the request does not match a route
+func (self *RouteRegistry) Template(request *http.Request) string {
+	var _, pattern = self.serveMux.Handler(request)
+
+	for _, template := range self.templates[pattern] {
+		if templateMatches(template, request.URL.Path) {
+			return template
+		}
+	}
+
+	return pattern
+}
+
+// get the templates of every registered route in sorted order
+// patterns that list templates are represented by their templates
+func (self *RouteRegistry) Templates() []string {
+	var templates []string
+	for pattern, patternTemplates := range self.templates {
+		if len(patternTemplates) == 0 {
+			templates = append(templates, pattern)
+		} else {
+			templates = append(templates, patternTemplates...)
+		}
+	}
+	sort.Strings(templates)
+
+	return templates
+}
+
+// check if a path matches a template segment by segment
+func templateMatches(template string, path string) bool {
+	var templateSegments = strings.Split(template, "/")
+	var pathSegments = strings.Split(path, "/")
+
+	if len(templateSegments) != len(pathSegments) {
+		return false
+	}
+
+	for i, segment := range templateSegments {
+		var isParameter = strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+
+		if isParameter && len(pathSegments[i]) == 0 {
+			return false
+		} else if !isParameter && segment != pathSegments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// add the template of the matched route to the request and call its handler
+func (self *RouteRegistry) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	request = withRouteMatch(request)
+	request.Context().Value(routeMatchKey{}).(*routeMatch).template = self.Template(request)
+
+	self.serveMux.ServeHTTP(writer, request)
+}
+
+// key used to store the route a request matched in the request context
+type routeMatchKey struct{}
+
+// the route a request matched
+// it is stored as a pointer so middleware wrapping the registry can see the template
+// once the wrapped handler has returned
+type routeMatch struct {
+	template string
+}
+
+// add an empty route match to a request if it does not have one
+// the registry fills it in when the request is routed
+func withRouteMatch(request *http.Request) *http.Request {
+	if _, ok := request.Context().Value(routeMatchKey{}).(*routeMatch); ok {
+		return request
+	}
+
+	return request.WithContext(context.WithValue(request.Context(), routeMatchKey{}, &routeMatch{}))
+}
+
+// RouteTemplate gets the template of the route a request matched in a RouteRegistry
+// an empty string is returned if the request has not been routed by a registry
+// or did not match a route
+func RouteTemplate(request *http.Request) string {
+	var match, _ = request.Context().Value(routeMatchKey{}).(*routeMatch)
+	if match == nil {
+		return ""
+	}
+
+	return match.template
+}
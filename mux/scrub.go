@@ -0,0 +1,100 @@
+package mux
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+)
+
+// largest number of bytes of a 5xx response body that are kept to be logged
+const maxScrubbedBodyBytes = 64 * 1024
+
+// http handler that replaces the body of 5xx responses from another http handler
+// with the default error for the status (i.e. {"description":"Internal Server Error"})
+// so internal details like database error messages never reach the user
+// the original body is logged so the cause of the error is not lost
+// responses below 500 are passed through as they are written so streamed responses are not held back
+type ErrorScrubMiddleware struct {
+	Logger  *log.Logger
+	Handler http.Handler
+}
+
+// call the wrapped handler and replace the body of the response if it is a 5xx
+func (self ErrorScrubMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var scrubWriter = &scrubResponseWriter{ResponseWriter: writer}
+
+	self.Handler.ServeHTTP(scrubWriter, request)
+
+	if scrubWriter.statusCode < http.StatusInternalServerError {
+		return
+	}
+
+	if self.Logger != nil {
+		self.Logger.Printf("Replaced the body of a %d response to %s %s: %s\n",
+			scrubWriter.statusCode, request.Method, request.URL.Path, bytes.TrimSpace(scrubWriter.body.Bytes()))
+	}
+
+	// headers set by the handler (i.e. Retry-After) are kept
+	WriteJsonResponse(writer, DefaultHttpError(scrubWriter.statusCode))
+}
+
+// response writer that holds back the body of 5xx responses and passes every other response through
+type scrubResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	// the start of the body of a 5xx response
+	body bytes.Buffer
+}
+
+func (self *scrubResponseWriter) WriteHeader(statusCode int) {
+	if self.statusCode != 0 {
+		return
+	}
+
+	self.statusCode = statusCode
+	if statusCode < http.StatusInternalServerError {
+		self.ResponseWriter.WriteHeader(statusCode)
+	}
+}
+
+func (self *scrubResponseWriter) Write(d []byte) (int, error) {
+	if self.statusCode == 0 {
+		self.WriteHeader(http.StatusOK)
+	}
+
+	if self.statusCode < http.StatusInternalServerError {
+		return self.ResponseWriter.Write(d)
+	}
+
+	var remaining = maxScrubbedBodyBytes - self.body.Len()
+	if remaining > len(d) {
+		remaining = len(d)
+	}
+	if remaining > 0 {
+		self.body.Write(d[:remaining])
+	}
+
+	// the whole body is reported as written so the handler carries on as normal
+	return len(d), nil
+}
+
+// pass flushes through so streamed responses still reach the user
+// a 5xx response is not flushed since it is replaced once the handler returns
+func (self *scrubResponseWriter) Flush() {
+	if self.statusCode == 0 {
+		self.WriteHeader(http.StatusOK)
+	}
+
+	if self.statusCode >= http.StatusInternalServerError {
+		return
+	}
+
+	var flusher, ok = self.ResponseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
+
+func (self *scrubResponseWriter) Unwrap() http.ResponseWriter {
+	return self.ResponseWriter
+}
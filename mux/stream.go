@@ -0,0 +1,156 @@
+package mux
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// media type of newline delimited json
+const NdjsonMediaType = "application/x-ndjson"
+
+// EnableFullDuplex lets a handler keep reading the request body after it has started writing the response
+// without it an http/1 server discards or stops reading the rest of the body once the response is sent
+// http/2 requests are always full duplex so an error is only returned if the server does not support
+// it for http/1 (servers built with Go older than 1.21)
+// response writers wrapped by middlewares are unwrapped using their Unwrap method
+func EnableFullDuplex(writer http.ResponseWriter) error {
+	for {
+		switch w := writer.(type) {
+		case interface{ EnableFullDuplex() error }:
+			return w.EnableFullDuplex()
+		case interface{ Unwrap() http.ResponseWriter }:
+			writer = w.Unwrap()
+		default:
+			return errors.New("The response writer does not support full duplex")
+		}
+	}
+}
+
+// SetReadDeadline sets the time after which reading the request body fails
+// a zero deadline means reading never times out
+// an error is returned if the server does not support read deadlines (servers built with Go older than 1.20)
+// response writers wrapped by middlewares are unwrapped using their Unwrap method
+func SetReadDeadline(writer http.ResponseWriter, deadline time.Time) error {
+	for {
+		switch w := writer.(type) {
+		case interface{ SetReadDeadline(time.Time) error }:
+			return w.SetReadDeadline(deadline)
+		case interface{ Unwrap() http.ResponseWriter }:
+			writer = w.Unwrap()
+		default:
+			return errors.New("The response writer does not support read deadlines")
+		}
+	}
+}
+
+// JsonStream writes a json response whose size is not known upfront
+// unlike WriteJsonResponse it never sets a Content-Length header so the response
+// is sent to the user using chunked transfer encoding as values are written
+type JsonStream struct {
+	writer  http.ResponseWriter
+	flusher http.Flusher
+	// bytes written before the first value, between values and after the last value
+	open      []byte
+	separator []byte
+	close     []byte
+	// number of values written so far
+	count int
+	// set once the status code and headers have been sent
+	started bool
+}
+
+// create a stream that writes each value as a line of newline delimited json
+func NewNdjsonStream(writer http.ResponseWriter) *JsonStream {
+	return newJsonStream(writer, NdjsonMediaType, nil, []byte{'\n'}, nil)
+}
+
+// create a stream that writes the values as the elements of a single json array
+func NewJsonArrayStream(writer http.ResponseWriter) *JsonStream {
+	return newJsonStream(writer, "application/json", []byte{'['}, []byte{','}, []byte{']'})
+}
+
+func newJsonStream(writer http.ResponseWriter, contentType string, open, separator, close []byte) *JsonStream {
+	writer.Header().Set("Content-Type", contentType)
+	// make sure a Content-Length set by an earlier handler does not conflict with chunked encoding
+	writer.Header().Del("Content-Length")
+
+	// not every response writer supports flushing (i.e. testing writers)
+	var flusher, _ = writer.(http.Flusher)
+
+	return &JsonStream{
+		writer:    writer,
+		flusher:   flusher,
+		open:      open,
+		separator: separator,
+		close:     close,
+	}
+}
+
+// send the status code and headers to the user
+// this is done automatically with a 200 the first time a value is written
+// so it only needs to be called to send a different status code
+func (self *JsonStream) WriteHeader(statusCode int) {
+	if !self.started {
+		self.started = true
+		self.writer.WriteHeader(statusCode)
+		self.writer.Write(self.open)
+	}
+}
+
+// marshal a value to json and write it to the stream
+func (self *JsonStream) Write(v interface{}) error {
+	var d, err = json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	self.WriteHeader(http.StatusOK)
+
+	// newline delimited json ends every value with the separator
+	// json arrays only put the separator between values
+	if self.close == nil {
+		d = append(d, self.separator...)
+	} else if self.count > 0 {
+		self.writer.Write(self.separator)
+	}
+
+	_, err = self.writer.Write(d)
+	self.count++
+
+	return err
+}
+
+// send any buffered data to the user
+func (self *JsonStream) Flush() {
+	if self.flusher != nil {
+		self.flusher.Flush()
+	}
+}
+
+// finish the stream by writing any closing bytes and flushing the remaining data
+// an empty json array is written if no values were written to an array stream
+func (self *JsonStream) Close() {
+	self.WriteHeader(http.StatusOK)
+	self.writer.Write(self.close)
+	self.Flush()
+}
+
+// Abort stops a stream that can not be finished because of an error
+// if nothing has been sent yet the error is sent using WriteJsonResponse
+// otherwise the status code has already been sent so the connection is closed without finishing the response
+// which lets the user tell that the results are incomplete
+// Abort never returns once the stream has started
+func (self *JsonStream) Abort(err error) {
+	if !self.started {
+		self.started = true
+		WriteJsonResponse(self.writer, err)
+		return
+	}
+
+	self.Flush()
+
+	// the http server closes the connection without logging when a handler panics with ErrAbortHandler
+	panic(http.ErrAbortHandler)
+}
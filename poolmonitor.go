@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// PoolMetrics keeps track of the db connection pool using the events the driver sends to a pool monitor
+// so connection storms and pool exhaustion can be seen in the metrics
+// it implements expvar.Var so it can be published using expvar.Publish
+type PoolMetrics struct {
+	lock sync.Mutex
+	// used to log connections being created and closed and failed checkouts
+	// nothing is logged if logger is nil
+	logger *log.Logger
+	// number of connections created and closed since the service started
+	created int64
+	closed  int64
+	// number of times a connection could not be checked out of the pool
+	checkoutFailures int64
+	// number of connections currently in use
+	checkedOut int64
+}
+
+// poolMetricsSnapshot is the json representation of the pool metrics
+type poolMetricsSnapshot struct {
+	Created          int64 `json:"connections_created"`
+	Closed           int64 `json:"connections_closed"`
+	CheckoutFailures int64 `json:"checkout_failures"`
+	CheckedOut       int64 `json:"checked_out"`
+	Available        int64 `json:"available"`
+}
+
+func NewPoolMetrics(logger *log.Logger) *PoolMetrics {
+	return &PoolMetrics{logger: logger}
+}
+
+// create a pool monitor that updates the metrics
+// it can be given to the client options using SetPoolMonitor
+func (self *PoolMetrics) Monitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: self.handle,
+	}
+}
+
+func (self *PoolMetrics) handle(e *event.PoolEvent) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	switch e.Type {
+	case event.ConnectionCreated:
+		self.created++
+		self.logf("Db connection created address=%s id=%d\n", e.Address, e.ConnectionID)
+	case event.ConnectionClosed:
+		self.closed++
+		self.logf("Db connection closed address=%s id=%d reason=%s\n", e.Address, e.ConnectionID, e.Reason)
+	case event.GetFailed:
+		self.checkoutFailures++
+		self.logf("Db connection checkout failed address=%s reason=%s\n", e.Address, e.Reason)
+	case event.GetSucceeded:
+		self.checkedOut++
+	case event.ConnectionReturned:
+		self.checkedOut--
+	}
+}
+
+func (self *PoolMetrics) logf(format string, v ...interface{}) {
+	if self.logger != nil {
+		self.logger.Printf(format, v...)
+	}
+}
+
+func (self *PoolMetrics) snapshot() poolMetricsSnapshot {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	// connections that are open but not in use are available to be checked out
+	var available = self.created - self.closed - self.checkedOut
+	if available < 0 {
+		available = 0
+	}
+
+	return poolMetricsSnapshot{
+		Created:          self.created,
+		Closed:           self.closed,
+		CheckoutFailures: self.checkoutFailures,
+		CheckedOut:       self.checkedOut,
+		Available:        available,
+	}
+}
+
+// String returns the metrics as json so they can be served by expvar
+func (self *PoolMetrics) String() string {
+	var d, _ = json.Marshal(self.snapshot())
+
+	return string(d)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+func TestPoolMetricsMonitorUpdatesMetrics(t *testing.T) {
+	var logs bytes.Buffer
+	var metrics = NewPoolMetrics(log.New(&logs, "", 0))
+	var monitor = metrics.Monitor()
+
+	for _, eventType := range []string{
+		event.ConnectionCreated,
+		event.ConnectionCreated,
+		event.ConnectionCreated,
+		event.GetSucceeded,
+		event.GetSucceeded,
+		event.ConnectionReturned,
+		event.GetFailed,
+		event.ConnectionClosed,
+	} {
+		monitor.Event(&event.PoolEvent{Type: eventType, Address: "localhost:27017"})
+	}
+
+	var snapshot poolMetricsSnapshot
+	var err = json.Unmarshal([]byte(metrics.String()), &snapshot)
+	if err != nil {
+		t.Fatalf("The pool metrics are not valid json: %s", err)
+	}
+
+	var expected = poolMetricsSnapshot{
+		Created:          3,
+		Closed:           1,
+		CheckoutFailures: 1,
+		CheckedOut:       1,
+		Available:        1,
+	}
+	if snapshot != expected {
+		t.Errorf("Expected the pool metrics %+v but got %+v", expected, snapshot)
+	}
+
+	for _, message := range []string{"Db connection created", "Db connection closed", "Db connection checkout failed"} {
+		if !strings.Contains(logs.String(), message) {
+			t.Errorf("The pool monitor did not log %q", message)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mitchellkelly/auditlog/mux"
+)
+
+// name the service identifies itself with
+const serviceName = "auditlog"
+
+// Version is the version of the service
+// it can be set when the service is built (i.e. go build -ldflags "-X main.Version=1.2.0")
+var Version = "dev"
+
+// ServiceInfo identifies the service to anyone requesting the root path
+type ServiceInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// templates of the api routes (i.e. /events/{id}/context)
+	Endpoints []string `json:"endpoints"`
+}
+
+// http handler that responds to requests for the root path with the service info
+// and calls another http handler for every other path
+// the root path does not require authentication so operators can identify the service without a token
+type RootMiddleware struct {
+	Info    ServiceInfo
+	Handler http.Handler
+}
+
+// send the service info for the root path or call the wrapped handler
+func (self RootMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path != "/" {
+		self.Handler.ServeHTTP(writer, request)
+		return
+	}
+
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		mux.WriteJsonResponse(writer, mux.DefaultHttpError(http.StatusMethodNotAllowed))
+		return
+	}
+
+	mux.WriteJsonResponse(writer, self.Info)
+}
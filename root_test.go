@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRootMiddlewareServiceInfo(t *testing.T) {
+	var middleware = RootMiddleware{
+		Info: ServiceInfo{
+			Name:      serviceName,
+			Version:   "1.2.0",
+			Endpoints: []string{"/events", "/events/{id}/context"},
+		},
+		Handler: http.NotFoundHandler(),
+	}
+
+	var writer = httptest.NewRecorder()
+	middleware.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if writer.Code != http.StatusOK {
+		t.Fatalf("An unexpected status code was returned for the root path Expected: %d, Got: %d", http.StatusOK, writer.Code)
+	}
+
+	var info ServiceInfo
+	var err = json.Unmarshal(writer.Body.Bytes(), &info)
+	if err != nil {
+		t.Fatalf("The root path did not return json: %s", err)
+	}
+
+	if info.Name != "auditlog" || info.Version != "1.2.0" || len(info.Endpoints) != 2 || info.Endpoints[1] != "/events/{id}/context" {
+		t.Errorf("An unexpected service info was returned for the root path Got: %s", writer.Body.String())
+	}
+
+	// every other path is passed on
+	writer = httptest.NewRecorder()
+	middleware.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+
+	if writer.Code != http.StatusNotFound {
+		t.Errorf("A path other than the root path was not passed to the wrapped handler Expected: %d, Got: %d", http.StatusNotFound, writer.Code)
+	}
+}
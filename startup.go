@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// StartupStep is one named part of the startup sequence
+type StartupStep struct {
+	Name string
+	Run  func() error
+}
+
+// RunStartup runs the startup steps in order
+// if any step fails the whole sequence is started again from the first step after waiting for the retry delay
+// this lets the service ride out transient environment issues (i.e. the schema file being mounted late or dns not being ready)
+// an error naming the failed step is returned once all of the attempts have been used
+func RunStartup(logger *log.Logger, steps []StartupStep, attempts int, retryDelay time.Duration) error {
+	// the sequence is always run at least once
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = runStartupSteps(steps)
+		if err == nil {
+			return nil
+		}
+
+		logger.Printf("Startup attempt %d of %d failed: %s\n", attempt, attempts, err)
+
+		if attempt < attempts {
+			time.Sleep(retryDelay)
+		}
+	}
+
+	return err
+}
+
+// run each startup step stopping at the first one that fails
+func runStartupSteps(steps []StartupStep) error {
+	for _, step := range steps {
+		var err = step.Run()
+		if err != nil {
+			return fmt.Errorf("The startup step %q failed: %s", step.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"testing"
+)
+
+func TestRunStartupRetriesFailedStep(t *testing.T) {
+	var schemaAttempts, dbAttempts int
+
+	var steps = []StartupStep{
+		{
+			Name: "read schema",
+			Run: func() error {
+				schemaAttempts++
+				return nil
+			},
+		},
+		{
+			Name: "connect to db",
+			Run: func() error {
+				dbAttempts++
+				// fail the first time the step runs
+				if dbAttempts == 1 {
+					return fmt.Errorf("no such host")
+				}
+				return nil
+			},
+		},
+	}
+
+	var err = RunStartup(log.New(ioutil.Discard, "", 0), steps, 3, 0)
+	if err != nil {
+		t.Fatalf("An unexpected error occured while running the startup steps: %s", err)
+	}
+
+	// the whole sequence is retried so every step runs again
+	if schemaAttempts != 2 || dbAttempts != 2 {
+		t.Errorf("The startup sequence was not retried as a unit Expected: 2 runs of each step, Got: %d and %d", schemaAttempts, dbAttempts)
+	}
+}
+
+func TestRunStartupGivesUp(t *testing.T) {
+	var attempts int
+
+	var steps = []StartupStep{
+		{
+			Name: "connect to db",
+			Run: func() error {
+				attempts++
+				return fmt.Errorf("no such host")
+			},
+		},
+	}
+
+	var err = RunStartup(log.New(ioutil.Discard, "", 0), steps, 3, 0)
+	if err == nil {
+		t.Fatal("A startup sequence that always fails did not return an error")
+	}
+
+	if attempts != 3 {
+		t.Errorf("An unexpected number of startup attempts were made Expected: %d, Got: %d", 3, attempts)
+	}
+
+	var expectedError = `The startup step "connect to db" failed: no such host`
+	if err.Error() != expectedError {
+		t.Errorf("The startup error did not name the failed step Expected: %s, Got: %s", expectedError, err)
+	}
+}